@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// * POST /v2/batch
+// Runs several get/set/delete operations in one request, to save round trips.
+// Body: {"ops": [{"op": "set", "key": "a", "value": 1, "ttl": 60}, {"op": "get", "key": "a"}, {"op": "delete", "key": "b"}]}
+// Operations run in order, each on its own (the batch is not atomic). Every operation gets a result at the same index.
+
+// Most operations accepted in a single batch
+const maxBatchOps = 1000
+
+type batchRequest struct {
+	Ops []batchOp `json:"ops"`
+}
+
+type batchOp struct {
+	Op       string            `json:"op"`
+	Key      string            `json:"key"`
+	Value    interface{}       `json:"value,omitempty"`
+	TTL      json.RawMessage   `json:"ttl,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type batchResponse struct {
+	Results []batchResult `json:"results"`
+}
+
+type batchResult struct {
+	Key   string      `json:"key"`
+	Found bool        `json:"found,omitempty"` // Only for get
+	Value interface{} `json:"value,omitempty"` // Only for get
+	Error string      `json:"error,omitempty"`
+}
+
+func (h *Handler) BatchHandler(w http.ResponseWriter, r *http.Request) {
+	var req batchRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValueSize)).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(req.Ops) > maxBatchOps {
+		WriteError(w, http.StatusRequestEntityTooLarge, "Too many operations")
+		return
+	}
+
+	resp := batchResponse{Results: make([]batchResult, len(req.Ops))}
+	for i, op := range req.Ops {
+		resp.Results[i] = h.runBatchOp(op)
+	}
+	WriteJSON(w, http.StatusOK, resp)
+}
+
+// Will run a single batch operation, errors are reported in the result instead of failing the batch
+func (h *Handler) runBatchOp(op batchOp) batchResult {
+	result := batchResult{Key: op.Key}
+	if op.Key == "" {
+		result.Error = "missing key"
+		return result
+	}
+
+	switch op.Op {
+	case "get":
+		result.Value, result.Found = h.Cache.Get(op.Key)
+	case "set":
+		if op.Value == nil {
+			result.Error = "missing value" // A null value would be stored as nil, which reads as a miss
+			return result
+		}
+		ttlStr, err := ttlFieldString(op.TTL)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		ttl, err := ParseTTL(ttlStr)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		h.Cache.SetWithMetadata(op.Key, op.Value, ttl, op.Metadata)
+	case "delete":
+		h.Cache.Delete(op.Key)
+	default:
+		result.Error = "unknown op " + op.Op
+	}
+	return result
+}
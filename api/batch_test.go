@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBatchHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("old", "value", time.Minute)
+
+	body := `{"ops": [
+		{"op": "set", "key": "a", "value": 1, "ttl": 60},
+		{"op": "get", "key": "a"},
+		{"op": "get", "key": "missing"},
+		{"op": "delete", "key": "old"},
+		{"op": "set", "key": "b", "value": 2, "ttl": "soon"},
+		{"op": "rename", "key": "a"}
+	]}`
+	rr := serveV2(h, "POST", "/v2/batch", "application/json", body, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+
+	var resp batchResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 6 {
+		t.Fatalf("expected 6 results, got %d", len(resp.Results))
+	}
+	if r := resp.Results[1]; !r.Found || r.Value != float64(1) {
+		t.Errorf("expected get to find a=1, got %+v", r)
+	}
+	if r := resp.Results[2]; r.Found {
+		t.Errorf("expected missing key not to be found, got %+v", r)
+	}
+	if resp.Results[4].Error == "" || resp.Results[5].Error == "" {
+		t.Errorf("expected errors for invalid ttl and unknown op, got %+v", resp.Results)
+	}
+	if _, found := c.Get("old"); found {
+		t.Error("expected old to be deleted")
+	}
+	if _, found := c.Get("b"); found {
+		t.Error("expected b not to be set with an invalid ttl")
+	}
+
+	// A set without a value, or with a null one, is rejected instead of storing nil
+	body = `{"ops": [{"op": "set", "key": "c"}, {"op": "set", "key": "d", "value": null}]}`
+	rr = serveV2(h, "POST", "/v2/batch", "application/json", body, nil)
+	resp = batchResponse{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range resp.Results {
+		if r.Error != "missing value" {
+			t.Errorf("expected a missing value error for %s, got %+v", r.Key, r)
+		}
+		if _, found := c.Peek(r.Key); found {
+			t.Errorf("expected %s not to be set", r.Key)
+		}
+	}
+
+	rr = serveV2(h, "POST", "/v2/batch", "application/json", "not json", nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusBadRequest)
+	}
+}
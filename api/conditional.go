@@ -0,0 +1,62 @@
+package api
+
+import (
+	"golang-memory-cache/cache"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// HTTP conditional requests, built on the version every CacheItem carries.
+// The ETag of an item is its version in quotes, so it changes on every Set.
+
+// Will return the strong ETag for an item
+func etagFor(item cache.CacheItem) string {
+	return `"` + strconv.FormatUint(item.Version, 10) + `"`
+}
+
+// Will report whether etag is in a comma separated If-Match / If-None-Match header value.
+// "*" matches any etag. With weak comparison, W/"1" and "1" are considered the same.
+func etagListMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue // Weak tags never match strongly
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Will set the ETag header and report whether the client already has this version (If-None-Match).
+// When it does, a 304 Not Modified has been written and the handler should return.
+func notModified(w http.ResponseWriter, r *http.Request, item cache.CacheItem) bool {
+	etag := etagFor(item)
+	w.Header().Set("ETag", etag)
+
+	header := r.Header.Get("If-None-Match")
+	if header == "" || !etagListMatches(header, etag, true) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// Will turn an If-Match header into a cache.Condition, so the check and the write happen atomically.
+// Returns nil when the request has no If-Match header.
+func ifMatchCondition(r *http.Request) cache.Condition {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil
+	}
+	return func(item cache.CacheItem, found bool) bool {
+		return found && etagListMatches(header, etagFor(item), false)
+	}
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// Will test that GET returns an ETag and answers If-None-Match with 304 until the value changes
+func TestIfNoneMatch(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("key", "value", time.Minute)
+
+	for _, target := range []string{"/get?key=key", "/v2/keys/key"} {
+		rr := serveV2(h, "GET", target, "", "", nil)
+		etag := rr.Header().Get("ETag")
+		if etag == "" {
+			t.Fatalf("%s: expected an ETag header", target)
+		}
+
+		rr = serveV2(h, "GET", target, "", "", map[string]string{"If-None-Match": `"other", ` + etag})
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("%s: got status %v, expected %v", target, rr.Code, http.StatusNotModified)
+		}
+		if rr.Body.Len() != 0 {
+			t.Errorf("%s: expected empty body for 304, got %q", target, rr.Body.String())
+		}
+
+		// Weak comparison is allowed for If-None-Match
+		rr = serveV2(h, "GET", target, "", "", map[string]string{"If-None-Match": "W/" + etag})
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("%s: weak etag got status %v, expected %v", target, rr.Code, http.StatusNotModified)
+		}
+	}
+
+	// A new Set changes the ETag, so the old one no longer matches
+	rr := serveV2(h, "GET", "/v2/keys/key", "", "", nil)
+	etag := rr.Header().Get("ETag")
+	c.Set("key", "new value", time.Minute)
+	rr = serveV2(h, "GET", "/v2/keys/key", "", "", map[string]string{"If-None-Match": etag})
+	if rr.Code != http.StatusOK {
+		t.Errorf("got status %v, expected %v after the value changed", rr.Code, http.StatusOK)
+	}
+}
+
+// Will test optimistic concurrency with If-Match on PUT and DELETE
+func TestIfMatch(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	// If-Match on a missing key always fails
+	rr := serveV2(h, "PUT", "/v2/keys/key", "text/plain", "v1", map[string]string{"If-Match": "*"})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	rr = serveV2(h, "PUT", "/v2/keys/key", "text/plain", "v1", nil)
+	etag := rr.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("expected PUT to return an ETag")
+	}
+
+	rr = serveV2(h, "PUT", "/v2/keys/key", "text/plain", "v2", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("got status %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+
+	// The first writer changed the version, so a second writer using the old ETag loses
+	rr = serveV2(h, "PUT", "/v2/keys/key", "text/plain", "v3", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	rr = serveV2(h, "DELETE", "/v2/keys/key", "", "", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	// Weak tags never match for If-Match
+	current := serveV2(h, "GET", "/v2/keys/key", "", "", nil).Header().Get("ETag")
+	rr = serveV2(h, "DELETE", "/v2/keys/key", "", "", map[string]string{"If-Match": "W/" + current})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+
+	rr = serveV2(h, "DELETE", "/v2/keys/key", "", "", map[string]string{"If-Match": current})
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+	if _, found := c.Get("key"); found {
+		t.Error("item was not deleted from cache")
+	}
+}
+
+// Will test If-Match on the v1 set handler
+func TestSetHandlerIfMatch(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("key", "value", time.Minute)
+
+	req := httptest.NewRequest("POST", "/set?key=key&value=new&duration=60", nil)
+	req.Header.Set("If-Match", `"12345"`)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.SetHandler).ServeHTTP(rr, req)
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("got status %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	if v, _ := c.Get("key"); v != "value" {
+		t.Errorf("value should not have changed, got %v", v)
+	}
+}
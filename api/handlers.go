@@ -9,6 +9,18 @@ import (
 
 type Handler struct {
 	Cache *cache.Cache
+	// Extra counters merged into /stats, i.e. a replication.Primary or Follower
+	StatsSources []StatsSource
+	// Largest snapshot accepted by PUT /v2/snapshot, in bytes. DefaultMaxRestoreSize when 0.
+	MaxRestoreSize int64
+}
+
+// Largest snapshot PUT /v2/snapshot accepts by default. It is decoded in memory before being loaded.
+const DefaultMaxRestoreSize = 1 << 30
+
+// Anything with counters to show on /stats
+type StatsSource interface {
+	GetStats() map[string]uint64
 }
 
 
@@ -34,10 +46,17 @@ func (h *Handler) SetHandler(w http.ResponseWriter, r *http.Request) {
 	duration, err := time.ParseDuration(durationStr + "s")
 	if err != nil {
 		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
 	}
 
 	// Will set the cache to hold a new CacheItem with the arguments
-	h.Cache.Set(key, value, duration)
+	// With an If-Match header, the write only happens if the current ETag matches
+	item, ok := h.Cache.SetIf(key, value, duration, nil, ifMatchCondition(r))
+	if !ok {
+		http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+		return
+	}
+	w.Header().Set("ETag", etagFor(item))
 	w.WriteHeader(http.StatusCreated)
 }
 
@@ -53,18 +72,25 @@ func (h *Handler) GetHandler(w http.ResponseWriter, r *http.Request) {
 	// Check if key is provided
 	if key == "" {
 		http.Error(w, "Missing key parameter", http.StatusBadRequest)
+		return
 	}
 
 	// Attempt to get the value from the cache
-	value, found := h.Cache.Get(key)
+	item, found := h.Cache.GetItem(key)
 	if !found {
 		http.Error(w, "Key not found", http.StatusNotFound)
+		return
+	}
+
+	// If the client sent If-None-Match with the current ETag, it already has the value (304 Not Modified)
+	if notModified(w, r, item) {
+		return
 	}
 
 	// Prep response data
 		// A map of potential key value pairs, where key is a string and the value is any type
 	response := map[string]interface{}{
-		"value":value,
+		"value":item.Value,
 	}
 
 	// Set content-type header to application/json (json format)
@@ -99,7 +125,15 @@ func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete the key from the cache
-	h.Cache.Delete(key)
+	// With an If-Match header, only delete if the current ETag matches
+	if cond := ifMatchCondition(r); cond != nil {
+		if !h.Cache.DeleteIf(key, cond) {
+			http.Error(w, "Precondition failed", http.StatusPreconditionFailed)
+			return
+		}
+	} else {
+		h.Cache.Delete(key)
+	}
 
 	// Write success message
 	w.WriteHeader(http.StatusOK)
@@ -107,8 +141,13 @@ func (h *Handler) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 
 }
 func (h *Handler) StatsHandler(w http.ResponseWriter, r *http.Request) {
-	// Get the stats from the cache
+	// Get the stats from the cache, plus the extra sources
 	stats := h.Cache.GetStats()
+	for _, source := range h.StatsSources {
+		for name, value := range source.GetStats() {
+			stats[name] = value
+		}
+	}
 
 	// Set the content-type header
 	w.Header().Set("Content-Type", "application/json")
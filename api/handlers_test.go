@@ -180,4 +180,29 @@ func TestStatsHandler(t *testing.T) {
 			t.Errorf("Unexpected value for %s: got %d, expected %d", key, value, expectedValue)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// A StatsSource made from a map
+type fixedStats map[string]uint64
+
+func (s fixedStats) GetStats() map[string]uint64 { return s }
+
+func TestStatsHandlerSources(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c, StatsSources: []StatsSource{fixedStats{"replication_lag": 7}}}
+
+	rr := httptest.NewRecorder()
+	h.StatsHandler(rr, httptest.NewRequest("GET", "/stats", nil))
+
+	var stats map[string]uint64
+	if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("Failed to parse response body: %v", err)
+	}
+	if stats["replication_lag"] != 7 {
+		t.Errorf("Expected the extra stats to be merged, got %v", stats)
+	}
+	if _, exists := stats["hits"]; !exists {
+		t.Errorf("Expected the cache stats to be kept, got %v", stats)
+	}
+}
@@ -0,0 +1,57 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Keyspace-wide operations for operators and tools like cachectl
+
+// * GET /v2/keys?match=user:*
+// Lists the keys matching the 'match' glob (every key when empty), sorted
+func (h *Handler) KeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys := h.Cache.Keys(r.URL.Query().Get("match"))
+	WriteJSON(w, http.StatusOK, map[string][]string{"keys": keys})
+}
+
+// * DELETE /v2/keys
+// Removes every key, and returns how many there were
+func (h *Handler) FlushHandler(w http.ResponseWriter, r *http.Request) {
+	n := h.Cache.Flush()
+	WriteJSON(w, http.StatusOK, map[string]int{"flushed": n})
+}
+
+// * GET /v2/snapshot
+// Streams a snapshot of the cache in the same format as the snapshot file, to back it up or copy it to another server
+func (h *Handler) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="cache.snapshot"`)
+	// Once the body started, an error can only be reported by cutting the response short
+	h.Cache.SaveSnapshot(w)
+}
+
+// * PUT /v2/snapshot
+// * PUT /v2/snapshot?existing=keep
+// Loads a snapshot written by GET /v2/snapshot, replacing keys that already exist (or keeping them with existing=keep),
+// and returns how many items were loaded. Snapshots larger than MaxRestoreSize get 413, and nothing is loaded.
+func (h *Handler) RestoreHandler(w http.ResponseWriter, r *http.Request) {
+	load := h.Cache.LoadSnapshot
+	if r.URL.Query().Get("existing") == "keep" {
+		load = h.Cache.MergeSnapshot
+	}
+	limit := h.MaxRestoreSize
+	if limit == 0 {
+		limit = DefaultMaxRestoreSize
+	}
+	n, err := load(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			WriteError(w, http.StatusRequestEntityTooLarge, "Snapshot too large")
+			return
+		}
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]int{"loaded": n})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestKeysHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	c.Set("user:2", 1, cache.NoExpiration)
+	c.Set("user:1", 1, cache.NoExpiration)
+	c.Set("order:1", 1, cache.NoExpiration)
+
+	rr := serveV2(h, "GET", "/v2/keys?match=user:*", "", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	var got struct {
+		Keys []string `json:"keys"`
+	}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if !reflect.DeepEqual(got.Keys, []string{"user:1", "user:2"}) {
+		t.Errorf("wrong keys: got %v", got.Keys)
+	}
+
+	// An empty cache gives an empty list, not null
+	c.Flush()
+	rr = serveV2(h, "GET", "/v2/keys", "", "", nil)
+	if body := rr.Body.String(); body != "{\"keys\":[]}\n" {
+		t.Errorf("wrong body for an empty cache: %s", body)
+	}
+}
+
+func TestFlushHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	c.Set("a", 1, cache.NoExpiration)
+	c.Set("b", 2, cache.NoExpiration)
+
+	rr := serveV2(h, "DELETE", "/v2/keys", "", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); body != "{\"flushed\":2}\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+	if c.Len() != 0 {
+		t.Errorf("expected an empty cache, got %d keys", c.Len())
+	}
+}
+
+// Will test that a snapshot downloaded from one server can be restored on another
+func TestSnapshotRestore(t *testing.T) {
+	src := cache.NewCache()
+	defer src.Stop()
+	src.Set("a", "hello", cache.NoExpiration)
+	src.Set("b", int64(42), cache.NoExpiration)
+
+	rr := serveV2(&Handler{Cache: src}, "GET", "/v2/snapshot", "", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	snapshot := rr.Body.Bytes()
+
+	dst := cache.NewCache()
+	defer dst.Stop()
+	h := &Handler{Cache: dst}
+	rr = serveV2(h, "PUT", "/v2/snapshot", "application/octet-stream", string(snapshot), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if body := rr.Body.String(); body != "{\"loaded\":2}\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+	if v, _ := dst.Get("b"); v != int64(42) {
+		t.Errorf("expected restored value 42, got %v", v)
+	}
+
+	// With existing=keep only missing keys are added
+	dst.Set("a", "newer", cache.NoExpiration)
+	dst.Delete("b")
+	rr = serveV2(h, "PUT", "/v2/snapshot?existing=keep", "application/octet-stream", string(snapshot), nil)
+	if body := rr.Body.String(); body != "{\"loaded\":1}\n" {
+		t.Errorf("wrong body: %s", body)
+	}
+	if v, _ := dst.Get("a"); v != "newer" {
+		t.Errorf("expected the existing value to be kept, got %v", v)
+	}
+
+	// Snapshots over the limit are rejected without loading anything
+	dst.Flush()
+	small := &Handler{Cache: dst, MaxRestoreSize: int64(len(snapshot) - 1)}
+	rr = serveV2(small, "PUT", "/v2/snapshot", "application/octet-stream", string(snapshot), nil)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusRequestEntityTooLarge)
+	}
+	if dst.Len() != 0 {
+		t.Errorf("expected nothing to be loaded, got %d items", dst.Len())
+	}
+
+	// A key restored over an existing one gets a new version, so an ETag of the old value no longer matches,
+	// even when the snapshot's item had the same version
+	other := cache.NewCache()
+	defer other.Stop()
+	other.Set("a", "mine", cache.NoExpiration)
+	oh := &Handler{Cache: other}
+	etag := serveV2(oh, "GET", "/v2/keys/a", "", "", nil).Header().Get("ETag")
+	if srcEtag := serveV2(&Handler{Cache: src}, "GET", "/v2/keys/a", "", "", nil).Header().Get("ETag"); srcEtag != etag {
+		t.Fatalf("expected both caches to start with the same version, got %s and %s", etag, srcEtag)
+	}
+	rr = serveV2(oh, "PUT", "/v2/snapshot", "application/octet-stream", string(snapshot), nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	rr = serveV2(oh, "PUT", "/v2/keys/a", "text/plain", "overwrite", map[string]string{"If-Match": etag})
+	if rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	if v, _ := other.Get("a"); v != "hello" {
+		t.Errorf("expected the restored value, got %v", v)
+	}
+
+	// Anything that isn't a snapshot is rejected
+	rr = serveV2(h, "PUT", "/v2/snapshot", "", string(bytes.Repeat([]byte("x"), 10)), nil)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,50 @@
+package api
+
+import "net/http"
+
+// Will mount every handler on mux, using Go 1.22 method + path patterns.
+// The handlers still check the method themselves, so they keep working when mounted without a pattern method.
+func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /set", h.SetHandler)
+	mux.HandleFunc("GET /get", h.GetHandler)
+	mux.HandleFunc("DELETE /delete", h.DeleteHandler)
+	mux.HandleFunc("GET /stats", h.StatsHandler)
+	mux.HandleFunc("GET /watch", h.WatchHandler)
+
+	// v2, keys in the path and values in the body
+	mux.HandleFunc("PUT /v2/keys/{key}", h.PutKeyHandler)
+	mux.HandleFunc("GET /v2/keys/{key}", h.GetKeyHandler)
+	mux.HandleFunc("DELETE /v2/keys/{key}", h.DeleteKeyHandler)
+	mux.HandleFunc("POST /v2/batch", h.BatchHandler)
+	mux.HandleFunc("POST /v2/txn", h.TxnHandler)
+
+	// Lists, hashes, sets and sorted sets
+	mux.HandleFunc("GET /v2/lists/{key}", h.ListRangeHandler)
+	mux.HandleFunc("POST /v2/lists/{key}", h.ListPushHandler)
+	mux.HandleFunc("POST /v2/lists/{key}/pop", h.ListPopHandler)
+	mux.HandleFunc("GET /v2/hashes/{key}", h.HashGetAllHandler)
+	mux.HandleFunc("PUT /v2/hashes/{key}", h.HashSetHandler)
+	mux.HandleFunc("GET /v2/hashes/{key}/{field}", h.HashGetHandler)
+	mux.HandleFunc("DELETE /v2/hashes/{key}/{field}", h.HashDeleteHandler)
+	mux.HandleFunc("GET /v2/sets/{key}", h.SetMembersHandler)
+	mux.HandleFunc("PUT /v2/sets/{key}", h.SetAddHandler)
+	mux.HandleFunc("GET /v2/sets/{key}/{member}", h.SetIsMemberHandler)
+	mux.HandleFunc("DELETE /v2/sets/{key}/{member}", h.SetRemoveHandler)
+	mux.HandleFunc("GET /v2/zsets/{key}", h.SortedSetRangeHandler)
+	mux.HandleFunc("PUT /v2/zsets/{key}", h.SortedSetAddHandler)
+	mux.HandleFunc("GET /v2/zsets/{key}/{member}", h.SortedSetScoreHandler)
+	mux.HandleFunc("DELETE /v2/zsets/{key}/{member}", h.SortedSetRemoveHandler)
+
+	// Keyspace-wide operations
+	mux.HandleFunc("GET /v2/keys", h.KeysHandler)
+	mux.HandleFunc("DELETE /v2/keys", h.FlushHandler)
+	mux.HandleFunc("GET /v2/snapshot", h.SnapshotHandler)
+	mux.HandleFunc("PUT /v2/snapshot", h.RestoreHandler)
+}
+
+// Creates a new ServeMux with every route registered
+func (h *Handler) Routes() *http.ServeMux {
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	return mux
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Will test that requests are routed by method and path
+func TestRoutes(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	mux := (&Handler{Cache: c}).Routes()
+
+	tests := []struct {
+		method string
+		target string
+		status int
+	}{
+		{"POST", "/set?key=k&value=v&duration=60", http.StatusCreated},
+		{"GET", "/get?key=k", http.StatusOK},
+		{"GET", "/stats", http.StatusOK},
+		{"DELETE", "/delete?key=k", http.StatusOK},
+		{"GET", "/get?key=k", http.StatusNotFound},
+		{"GET", "/set?key=k&value=v&duration=60", http.StatusMethodNotAllowed},
+		{"GET", "/unknown", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req, _ := http.NewRequest(tt.method, tt.target, nil)
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != tt.status {
+			t.Errorf("%s %s: got status %v, expected %v", tt.method, tt.target, rr.Code, tt.status)
+		}
+	}
+}
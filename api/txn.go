@@ -0,0 +1,113 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-memory-cache/cache"
+	"net/http"
+)
+
+// * POST /v2/txn
+// Runs get/set/delete operations as one transaction: the writes are applied all together or not at all.
+// Body: {"watch": {"a": 12, "b": 0}, "ops": [{"op": "get", "key": "a"}, {"op": "set", "key": "b", "value": 1, "ttl": 60}]}
+// "watch" maps keys to the version the client read earlier (the ETag of GET /v2/keys/{key}, 0 for a key that must
+// not exist). Keys read by "get" are watched too, so the values returned are the ones current at commit.
+// Answers 409 when a watched key changed, and 400 without applying anything when an operation is invalid.
+
+// How many times a transaction runs again when a key it read changed before it could commit
+const txnRetries = 3
+
+type txnRequest struct {
+	Watch map[string]uint64 `json:"watch"`
+	Ops   []batchOp         `json:"ops"`
+}
+
+type txnResponse struct {
+	Results []txnResult `json:"results"`
+}
+
+type txnResult struct {
+	Key     string      `json:"key"`
+	Found   bool        `json:"found,omitempty"`   // Only for get
+	Value   interface{} `json:"value,omitempty"`   // Only for get
+	Version uint64      `json:"version,omitempty"` // The version of the key, for get and set
+}
+
+func (h *Handler) TxnHandler(w http.ResponseWriter, r *http.Request) {
+	var req txnRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValueSize)).Decode(&req); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid JSON body")
+		return
+	}
+	if len(req.Ops) > maxBatchOps {
+		WriteError(w, http.StatusRequestEntityTooLarge, "Too many operations")
+		return
+	}
+
+	var results []txnResult
+	var tx *cache.Tx
+	opts := cache.TxnOptions{WatchReads: true, Retries: txnRetries}
+	err := h.Cache.TxnWithOptions(opts, func(t *cache.Tx) error {
+		tx = t
+		for key, version := range req.Watch {
+			tx.WatchVersion(key, version)
+		}
+		results = make([]txnResult, len(req.Ops))
+		for i, op := range req.Ops {
+			result, err := runTxnOp(tx, op)
+			if err != nil {
+				return fmt.Errorf("op %d: %w", i, err)
+			}
+			results[i] = result
+		}
+		return nil
+	})
+	switch {
+	case errors.Is(err, cache.ErrTxnConflict):
+		WriteError(w, http.StatusConflict, "Transaction aborted, a watched key changed")
+		return
+	case err != nil:
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// Versions of the keys written are only known once the transaction committed
+	for i, op := range req.Ops {
+		if op.Op == "set" {
+			item, _ := tx.GetItem(op.Key)
+			results[i].Version = item.Version
+		}
+	}
+	WriteJSON(w, http.StatusOK, txnResponse{Results: results})
+}
+
+// Will run a single operation of a transaction, any error aborts it
+func runTxnOp(tx *cache.Tx, op batchOp) (txnResult, error) {
+	result := txnResult{Key: op.Key}
+	if op.Key == "" {
+		return result, errors.New("missing key")
+	}
+
+	switch op.Op {
+	case "get":
+		var item cache.CacheItem
+		item, result.Found = tx.GetItem(op.Key)
+		result.Value, result.Version = item.Value, item.Version
+	case "set":
+		ttlStr, err := ttlFieldString(op.TTL)
+		if err != nil {
+			return result, err
+		}
+		ttl, err := ParseTTL(ttlStr)
+		if err != nil {
+			return result, err
+		}
+		tx.SetWithMetadata(op.Key, op.Value, ttl, op.Metadata)
+	case "delete":
+		tx.Delete(op.Key)
+	default:
+		return result, errors.New("unknown op " + op.Op)
+	}
+	return result, nil
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/cache"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTxnHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("old", "value", time.Minute)
+	balance, _ := c.SetIf("balance", float64(100), time.Minute, nil, nil)
+
+	body := fmt.Sprintf(`{"watch": {"balance": %d, "new": 0}, "ops": [
+		{"op": "get", "key": "balance"},
+		{"op": "set", "key": "balance", "value": 70, "ttl": 60},
+		{"op": "set", "key": "new", "value": 30},
+		{"op": "delete", "key": "old"}
+	]}`, balance.Version)
+	rr := serveV2(h, "POST", "/v2/txn", "application/json", body, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+	var resp txnResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Results) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(resp.Results))
+	}
+	if r := resp.Results[0]; !r.Found || r.Value != float64(100) || r.Version != balance.Version {
+		t.Errorf("expected get to read balance=100, got %+v", r)
+	}
+	item, _ := c.GetItem("balance")
+	if item.Value != float64(70) || resp.Results[1].Version != item.Version {
+		t.Errorf("expected set to report the new version %d, got %+v", item.Version, resp.Results[1])
+	}
+	if _, found := c.Get("old"); found {
+		t.Error("expected old to be deleted")
+	}
+
+	// The same watch is now stale
+	rr = serveV2(h, "POST", "/v2/txn", "application/json", body, nil)
+	if rr.Code != http.StatusConflict {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusConflict)
+	}
+	if v, _ := c.Get("balance"); v != float64(70) {
+		t.Errorf("expected the conflicting transaction not to write, got balance=%v", v)
+	}
+}
+
+func TestTxnHandlerInvalid(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	for _, body := range []string{
+		"not json",
+		`{"ops": [{"op": "set", "key": "a", "value": 1}, {"op": "rename", "key": "a"}]}`,
+		`{"ops": [{"op": "set", "key": "a", "value": 1}, {"op": "set", "key": "b", "value": 2, "ttl": "soon"}]}`,
+		`{"ops": [{"op": "set", "key": "a", "value": 1}, {"op": "get"}]}`,
+	} {
+		rr := serveV2(h, "POST", "/v2/txn", "application/json", body, nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code for %s: got %v, expected %v", body, rr.Code, http.StatusBadRequest)
+		}
+	}
+	if _, found := c.Get("a"); found {
+		t.Error("expected nothing to be written by an invalid transaction")
+	}
+}
@@ -0,0 +1,314 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"golang-memory-cache/cache"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Routes for the lists, hashes, sets and sorted sets of cache/types.go. Members, fields and list values are strings.
+// Writes take an optional ttl (query parameter or Cache-TTL header) that sets the key's expiration, otherwise
+// the key keeps the expiration it had. Using a key holding another type fails with 409.
+
+// Will decode the JSON body of a write into v
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxValueSize)).Decode(v); err != nil {
+		WriteError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	return true
+}
+
+// Will read the optional ttl of a write, before anything is written. Without one the key keeps its expiration.
+func writeTTL(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	s := r.Header.Get(ttlHeader)
+	if s == "" {
+		s = r.URL.Query().Get("ttl")
+	}
+	if s == "" {
+		return cache.KeepTTL, true
+	}
+	ttl, err := ParseTTL(s)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return 0, false
+	}
+	return ttl, true
+}
+
+// Will answer a write with response, or with the error of the write
+func finishWrite(w http.ResponseWriter, key string, err error, response map[string]interface{}) {
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	response["key"] = key
+	WriteJSON(w, http.StatusOK, response)
+}
+
+func writeTypeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, cache.ErrWrongType) {
+		WriteError(w, http.StatusConflict, "Key holds another type")
+		return
+	}
+	WriteError(w, http.StatusInternalServerError, err.Error())
+}
+
+// Will read the start and stop query parameters of a range, 0 and -1 (everything) by default
+func rangeParams(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	bounds := []int{0, -1}
+	for i, name := range []string{"start", "stop"} {
+		if s := r.URL.Query().Get(name); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				WriteError(w, http.StatusBadRequest, name+" must be an integer")
+				return 0, 0, false
+			}
+			bounds[i] = n
+		}
+	}
+	return bounds[0], bounds[1], true
+}
+
+// * GET /v2/lists/{key}?start=0&stop=-1
+// Returns the values from index start to stop, both included. Negative indexes count from the end.
+func (h *Handler) ListRangeHandler(w http.ResponseWriter, r *http.Request) {
+	start, stop, ok := rangeParams(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	values, err := h.Cache.LRange(key, start, stop)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "values": values})
+}
+
+// * POST /v2/lists/{key}?side=left
+// Pushes the values of a JSON array body to the tail of the list, or to its head with side=left
+func (h *Handler) ListPushHandler(w http.ResponseWriter, r *http.Request) {
+	ttl, ok := writeTTL(w, r)
+	if !ok {
+		return
+	}
+	var values []string
+	if !decodeBody(w, r, &values) {
+		return
+	}
+	key := r.PathValue("key")
+	push := h.Cache.RPushWithTTL
+	if r.URL.Query().Get("side") == "left" {
+		push = h.Cache.LPushWithTTL
+	}
+	length, err := push(key, ttl, values...)
+	finishWrite(w, key, err, map[string]interface{}{"length": length})
+}
+
+// * POST /v2/lists/{key}/pop?side=right
+// Removes and returns the first value of the list, or the last one with side=right. 404 when the list is empty.
+func (h *Handler) ListPopHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	pop := h.Cache.LPop
+	if r.URL.Query().Get("side") == "right" {
+		pop = h.Cache.RPop
+	}
+	value, found, err := pop(key)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, http.StatusNotFound, "List is empty")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+}
+
+// * GET /v2/hashes/{key}
+func (h *Handler) HashGetAllHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	fields, err := h.Cache.HGetAll(key)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "fields": fields})
+}
+
+// * PUT /v2/hashes/{key}
+// Sets the fields of a JSON object body ({"name": "alice"}), other fields are kept
+func (h *Handler) HashSetHandler(w http.ResponseWriter, r *http.Request) {
+	ttl, ok := writeTTL(w, r)
+	if !ok {
+		return
+	}
+	var fields map[string]string
+	if !decodeBody(w, r, &fields) {
+		return
+	}
+	key := r.PathValue("key")
+	added, err := h.Cache.HSetWithTTL(key, fields, ttl)
+	finishWrite(w, key, err, map[string]interface{}{"added": added})
+}
+
+// * GET /v2/hashes/{key}/{field}
+func (h *Handler) HashGetHandler(w http.ResponseWriter, r *http.Request) {
+	key, field := r.PathValue("key"), r.PathValue("field")
+	value, found, err := h.Cache.HGet(key, field)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, http.StatusNotFound, "Field not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "field": field, "value": value})
+}
+
+// * DELETE /v2/hashes/{key}/{field}
+func (h *Handler) HashDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	removed, err := h.Cache.HDel(key, r.PathValue("field"))
+	finishWrite(w, key, err, map[string]interface{}{"removed": removed})
+}
+
+// * GET /v2/sets/{key}
+// * GET /v2/sets/{key}?intersect=other&intersect=another
+// Returns the members, sorted. With intersect, only the members that are in every one of the other sets too.
+func (h *Handler) SetMembersHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	var members []string
+	var err error
+	if others := r.URL.Query()["intersect"]; len(others) > 0 {
+		members, err = h.Cache.SInter(append([]string{key}, others...)...)
+	} else {
+		members, err = h.Cache.SMembers(key)
+	}
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "members": members})
+}
+
+// * PUT /v2/sets/{key}
+// Adds the members of a JSON array body
+func (h *Handler) SetAddHandler(w http.ResponseWriter, r *http.Request) {
+	ttl, ok := writeTTL(w, r)
+	if !ok {
+		return
+	}
+	var members []string
+	if !decodeBody(w, r, &members) {
+		return
+	}
+	key := r.PathValue("key")
+	added, err := h.Cache.SAddWithTTL(key, ttl, members...)
+	finishWrite(w, key, err, map[string]interface{}{"added": added})
+}
+
+// * GET /v2/sets/{key}/{member}
+// 200 when member is in the set, 404 otherwise
+func (h *Handler) SetIsMemberHandler(w http.ResponseWriter, r *http.Request) {
+	key, member := r.PathValue("key"), r.PathValue("member")
+	found, err := h.Cache.SIsMember(key, member)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "member": member})
+}
+
+// * DELETE /v2/sets/{key}/{member}
+func (h *Handler) SetRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	removed, err := h.Cache.SRem(key, r.PathValue("member"))
+	finishWrite(w, key, err, map[string]interface{}{"removed": removed})
+}
+
+// * GET /v2/zsets/{key}?start=0&stop=-1
+// * GET /v2/zsets/{key}?min=10&max=+inf
+// Returns the members with their scores, lowest score first: ranked start to stop (negative ranks count from
+// the end), or with a score between min and max when either is given
+func (h *Handler) SortedSetRangeHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	query := r.URL.Query()
+	var members []cache.ZMember
+	var err error
+	if query.Has("min") || query.Has("max") {
+		bounds := []float64{math.Inf(-1), math.Inf(1)}
+		for i, name := range []string{"min", "max"} {
+			if s := query.Get(name); s != "" {
+				if bounds[i], err = strconv.ParseFloat(s, 64); err != nil || math.IsNaN(bounds[i]) {
+					WriteError(w, http.StatusBadRequest, name+" must be a number")
+					return
+				}
+			}
+		}
+		members, err = h.Cache.ZRangeByScore(key, bounds[0], bounds[1])
+	} else {
+		start, stop, ok := rangeParams(w, r)
+		if !ok {
+			return
+		}
+		members, err = h.Cache.ZRange(key, start, stop)
+	}
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "members": members})
+}
+
+// * PUT /v2/zsets/{key}
+// Adds the members of a JSON object body with their scores ({"alice": 10, "bob": 20}), or updates their scores
+func (h *Handler) SortedSetAddHandler(w http.ResponseWriter, r *http.Request) {
+	ttl, ok := writeTTL(w, r)
+	if !ok {
+		return
+	}
+	var scores map[string]float64
+	if !decodeBody(w, r, &scores) {
+		return
+	}
+	members := make([]cache.ZMember, 0, len(scores))
+	for member, score := range scores {
+		members = append(members, cache.ZMember{Member: member, Score: score})
+	}
+	key := r.PathValue("key")
+	added, err := h.Cache.ZAddWithTTL(key, ttl, members...)
+	finishWrite(w, key, err, map[string]interface{}{"added": added})
+}
+
+// * GET /v2/zsets/{key}/{member}
+func (h *Handler) SortedSetScoreHandler(w http.ResponseWriter, r *http.Request) {
+	key, member := r.PathValue("key"), r.PathValue("member")
+	score, found, err := h.Cache.ZScore(key, member)
+	if err != nil {
+		writeTypeError(w, err)
+		return
+	}
+	if !found {
+		WriteError(w, http.StatusNotFound, "Member not found")
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "member": member, "score": score})
+}
+
+// * DELETE /v2/zsets/{key}/{member}
+func (h *Handler) SortedSetRemoveHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	removed, err := h.Cache.ZRem(key, r.PathValue("member"))
+	finishWrite(w, key, err, map[string]interface{}{"removed": removed})
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+// Will send a request and decode the JSON response into a map
+func serveTypes(t *testing.T, h *Handler, method, target, body string) (int, map[string]interface{}) {
+	t.Helper()
+	rr := serveV2(h, method, target, "application/json", body, nil)
+	var got map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("%s %s: invalid JSON response %q", method, target, rr.Body.String())
+	}
+	return rr.Code, got
+}
+
+func TestListHandlers(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	if code, got := serveTypes(t, h, "POST", "/v2/lists/jobs?ttl=60", `["b", "c"]`); code != http.StatusOK || got["length"] != float64(2) {
+		t.Fatalf("Unexpected response to a push: %d %v", code, got)
+	}
+	serveTypes(t, h, "POST", "/v2/lists/jobs?side=left", `["a"]`)
+	if item, _ := c.Peek("jobs"); item.Expiration == 0 {
+		t.Error("Expected the ttl to set an expiration")
+	}
+
+	if _, got := serveTypes(t, h, "GET", "/v2/lists/jobs?start=1", ""); !reflect.DeepEqual(got["values"], []interface{}{"b", "c"}) {
+		t.Errorf("Unexpected range: %v", got)
+	}
+	if _, got := serveTypes(t, h, "POST", "/v2/lists/jobs/pop?side=right", ""); got["value"] != "c" {
+		t.Errorf("Expected c from the tail, got %v", got)
+	}
+	serveTypes(t, h, "POST", "/v2/lists/jobs/pop", "")
+	serveTypes(t, h, "POST", "/v2/lists/jobs/pop", "")
+	if code, _ := serveTypes(t, h, "POST", "/v2/lists/jobs/pop", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 when popping an empty list, got %d", code)
+	}
+	if code, _ := serveTypes(t, h, "POST", "/v2/lists/jobs", `{"not": "an array"}`); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid body, got %d", code)
+	}
+}
+
+func TestHashHandlers(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	if code, got := serveTypes(t, h, "PUT", "/v2/hashes/user:1", `{"name": "alice", "role": "admin"}`); code != http.StatusOK || got["added"] != float64(2) {
+		t.Fatalf("Unexpected response to a set: %d %v", code, got)
+	}
+	if _, got := serveTypes(t, h, "GET", "/v2/hashes/user:1/name", ""); got["value"] != "alice" {
+		t.Errorf("Unexpected field: %v", got)
+	}
+	if _, got := serveTypes(t, h, "DELETE", "/v2/hashes/user:1/role", ""); got["removed"] != float64(1) {
+		t.Errorf("Unexpected response to a delete: %v", got)
+	}
+	if _, got := serveTypes(t, h, "GET", "/v2/hashes/user:1", ""); !reflect.DeepEqual(got["fields"], map[string]interface{}{"name": "alice"}) {
+		t.Errorf("Unexpected fields: %v", got)
+	}
+	if code, _ := serveTypes(t, h, "GET", "/v2/hashes/user:1/role", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing field, got %d", code)
+	}
+}
+
+func TestSetHandlers(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	serveTypes(t, h, "PUT", "/v2/sets/a", `["1", "2", "3"]`)
+	if _, got := serveTypes(t, h, "PUT", "/v2/sets/b", `["2", "3", "4"]`); got["added"] != float64(3) {
+		t.Errorf("Unexpected response to an add: %v", got)
+	}
+	if _, got := serveTypes(t, h, "GET", "/v2/sets/a?intersect=b", ""); !reflect.DeepEqual(got["members"], []interface{}{"2", "3"}) {
+		t.Errorf("Unexpected intersection: %v", got)
+	}
+	if code, _ := serveTypes(t, h, "GET", "/v2/sets/a/1", ""); code != http.StatusOK {
+		t.Errorf("Expected 200 for a member, got %d", code)
+	}
+	serveTypes(t, h, "DELETE", "/v2/sets/a/1", "")
+	if code, _ := serveTypes(t, h, "GET", "/v2/sets/a/1", ""); code != http.StatusNotFound {
+		t.Errorf("Expected 404 for a removed member, got %d", code)
+	}
+}
+
+func TestSortedSetHandlers(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	if _, got := serveTypes(t, h, "PUT", "/v2/zsets/board", `{"alice": 30, "bob": 10, "carol": 20}`); got["added"] != float64(3) {
+		t.Fatalf("Unexpected response to an add: %v", got)
+	}
+	_, got := serveTypes(t, h, "GET", "/v2/zsets/board?start=-2", "")
+	want := []interface{}{
+		map[string]interface{}{"member": "carol", "score": float64(20)},
+		map[string]interface{}{"member": "alice", "score": float64(30)},
+	}
+	if !reflect.DeepEqual(got["members"], want) {
+		t.Errorf("Expected the top 2, got %v", got)
+	}
+	if _, got := serveTypes(t, h, "GET", "/v2/zsets/board?max=20", ""); len(got["members"].([]interface{})) != 2 {
+		t.Errorf("Expected 2 members up to 20, got %v", got)
+	}
+	if _, got := serveTypes(t, h, "GET", "/v2/zsets/board/bob", ""); got["score"] != float64(10) {
+		t.Errorf("Unexpected score: %v", got)
+	}
+	if code, _ := serveTypes(t, h, "GET", "/v2/zsets/board?min=abc", ""); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid min, got %d", code)
+	}
+}
+
+func TestTypeHandlersWrongType(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("plain", "value", cache.NoExpiration)
+
+	for _, req := range []struct{ method, target, body string }{
+		{"POST", "/v2/lists/plain", `["a"]`},
+		{"GET", "/v2/hashes/plain", ""},
+		{"PUT", "/v2/sets/plain", `["a"]`},
+		{"GET", "/v2/zsets/plain/m", ""},
+	} {
+		if code, _ := serveTypes(t, h, req.method, req.target, req.body); code != http.StatusConflict {
+			t.Errorf("%s %s: expected 409, got %d", req.method, req.target, code)
+		}
+	}
+}
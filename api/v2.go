@@ -0,0 +1,286 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"golang-memory-cache/cache"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The v2 API addresses keys by path (/v2/keys/{key}) and takes values from the request body,
+// so keys can contain any character and values are not limited to URL-safe strings.
+
+// Header that can carry the TTL of a PUT, as seconds ("60") or a Go duration ("1m30s")
+const ttlHeader = "Cache-TTL"
+
+// Largest request body accepted by PUT
+const maxValueSize = 10 << 20
+
+// Longest TTL accepted. Anything longer is as good as no expiration, and would overflow once added to the current time.
+const maxTTL = 100 * 365 * 24 * time.Hour
+
+// Metadata keys used to remember the headers of a raw PUT, so GET can send the bytes back the same way
+const (
+	metaContentType     = "content-type"
+	metaContentEncoding = "content-encoding"
+)
+
+// JSON body of PUT /v2/keys/{key} when sent with Content-Type: application/json
+type putRequest struct {
+	Value    interface{}       `json:"value"`
+	TTL      json.RawMessage   `json:"ttl,omitempty"` // Number of seconds or a duration string
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// JSON body returned by GET /v2/keys/{key}
+type keyResponse struct {
+	Key       string            `json:"key"`
+	Value     interface{}       `json:"value"`
+	TTL       *float64          `json:"ttl"`        // Seconds until the key expires, null when it never expires
+	ExpiresAt *time.Time        `json:"expires_at"` // null when it never expires
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Version   uint64            `json:"version"`
+	Encoding  string            `json:"encoding,omitempty"` // "base64" when value holds raw bytes, so clients can tell them from strings
+}
+
+// Every v2 error is returned as {"error": {"status": 404, "message": "Key not found"}}
+type errorResponse struct {
+	Error errorBody `json:"error"`
+}
+
+type errorBody struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Will write a JSON error object with the given status code. Every JSON route of the server, including the
+// ones of other packages, answers errors this way.
+func WriteError(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, errorResponse{Error: errorBody{Status: status, Message: message}})
+}
+
+// Will encode v as the JSON response body
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Will parse a TTL given as a number of seconds ("60", "1.5") or as a Go duration ("1m30s"), up to maxTTL.
+// An empty string means no expiration. The routes of other packages parse their ttls with it too.
+func ParseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return cache.NoExpiration, nil
+	}
+	var d time.Duration
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		if math.IsNaN(seconds) || math.IsInf(seconds, 0) {
+			return 0, errors.New("invalid ttl")
+		}
+		if seconds > maxTTL.Seconds() {
+			return 0, errors.New("ttl too long")
+		}
+		d = time.Duration(seconds * float64(time.Second))
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return 0, errors.New("invalid ttl")
+	}
+	if d <= 0 {
+		return 0, errors.New("ttl must be positive")
+	}
+	if d > maxTTL {
+		return 0, errors.New("ttl too long")
+	}
+	return d, nil
+}
+
+// The ttl field of a JSON body can be a number or a string, this turns either into the string ParseTTL expects
+func ttlFieldString(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		return s, nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err != nil {
+		return "", errors.New("invalid ttl")
+	}
+	return n.String(), nil
+}
+
+// Will report whether the request body is JSON, based on its Content-Type
+func isJSONRequest(r *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return mediaType == "application/json"
+}
+
+// * PUT /v2/keys/{key}
+// With Content-Type: application/json the body is {"value": ..., "ttl": 60, "metadata": {...}}, and the value keeps its JSON type.
+// A missing or null value is rejected.
+// Any other body (images, protobuf, gzip...) is stored as raw bytes, together with its Content-Type and Content-Encoding.
+// The Cache-TTL header sets the TTL for both and wins over the ttl field.
+// Without a TTL the key never expires.
+func (h *Handler) PutKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if key == "" {
+		WriteError(w, http.StatusBadRequest, "Missing key")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			WriteError(w, http.StatusRequestEntityTooLarge, "Value too large")
+			return
+		}
+		WriteError(w, http.StatusBadRequest, "Could not read body")
+		return
+	}
+
+	var (
+		value    interface{}
+		ttlStr   = r.Header.Get(ttlHeader)
+		metadata map[string]string
+	)
+
+	if isJSONRequest(r) {
+		var req putRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			WriteError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if req.Value == nil {
+			WriteError(w, http.StatusBadRequest, "Missing value")
+			return
+		}
+		value = req.Value
+		metadata = req.Metadata
+		if ttlStr == "" {
+			if ttlStr, err = ttlFieldString(req.TTL); err != nil {
+				WriteError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+		}
+	} else {
+		contentType := r.Header.Get("Content-Type")
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		value = body
+		metadata = map[string]string{metaContentType: contentType}
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" {
+			metadata[metaContentEncoding] = encoding
+		}
+	}
+
+	ttl, err := ParseTTL(ttlStr)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// With an If-Match header, the write only happens if the current ETag matches (optimistic concurrency)
+	item, ok := h.Cache.SetIf(key, value, ttl, metadata, ifMatchCondition(r))
+	if !ok {
+		WriteError(w, http.StatusPreconditionFailed, "Precondition failed")
+		return
+	}
+	w.Header().Set("ETag", etagFor(item))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// * GET /v2/keys/{key}
+// Values stored from a raw body are returned as the exact bytes, with their original Content-Type and Content-Encoding.
+// Everything else, or any request with Accept: application/json, gets the JSON view with value, TTL and metadata.
+// In the JSON view raw bytes are base64 encoded.
+// Every response carries an ETag, and If-None-Match with the current ETag gives 304 Not Modified.
+func (h *Handler) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	item, found := h.Cache.GetItem(key)
+	if !found {
+		WriteError(w, http.StatusNotFound, "Key not found")
+		return
+	}
+
+	if notModified(w, r, item) {
+		return
+	}
+
+	if raw, ok := item.Value.([]byte); ok && !acceptsJSON(r) {
+		writeRaw(w, raw, item.Metadata)
+		return
+	}
+
+	WriteJSON(w, http.StatusOK, newKeyResponse(key, item))
+}
+
+// Will write raw bytes with the headers they were stored with
+func writeRaw(w http.ResponseWriter, raw []byte, metadata map[string]string) {
+	contentType := metadata[metaContentType]
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if encoding := metadata[metaContentEncoding]; encoding != "" {
+		w.Header().Set("Content-Encoding", encoding)
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(raw)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(raw)
+}
+
+// Will report whether the client explicitly asked for JSON in its Accept header.
+// A missing Accept header or */* does not count, those get the stored bytes.
+func acceptsJSON(r *http.Request) bool {
+	for _, value := range r.Header.Values("Accept") {
+		for _, part := range strings.Split(value, ",") {
+			mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(part))
+			if err == nil && mediaType == "application/json" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func newKeyResponse(key string, item cache.CacheItem) keyResponse {
+	resp := keyResponse{
+		Key:      key,
+		Value:    item.Value,
+		Metadata: item.Metadata,
+		Version:  item.Version,
+	}
+	if _, raw := item.Value.([]byte); raw {
+		resp.Encoding = "base64"
+	}
+	if ttl, expires := item.TTL(); expires {
+		seconds := ttl.Seconds()
+		expiresAt := time.Unix(0, item.Expiration).UTC()
+		resp.TTL = &seconds
+		resp.ExpiresAt = &expiresAt
+	}
+	return resp
+}
+
+// * DELETE /v2/keys/{key}
+// Deleting a key that does not exist is not an error, unless If-Match is sent and the ETag does not match
+func (h *Handler) DeleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	if cond := ifMatchCondition(r); cond != nil {
+		if !h.Cache.DeleteIf(key, cond) {
+			WriteError(w, http.StatusPreconditionFailed, "Precondition failed")
+			return
+		}
+	} else {
+		h.Cache.Delete(key)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,219 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Will send a request through the full router, so path values are filled in
+func serveV2(h *Handler, method, target, contentType, body string, headers map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rr := httptest.NewRecorder()
+	h.Routes().ServeHTTP(rr, req)
+	return rr
+}
+
+func TestPutKeyHandlerJSON(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	body := `{"value": {"name": "alice", "age": 30}, "ttl": 60, "metadata": {"source": "test"}}`
+	rr := serveV2(h, "PUT", "/v2/keys/user%2F1&2", "application/json", body, nil)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+
+	// Keys can contain characters that are special in query strings
+	item, found := c.GetItem("user/1&2")
+	if !found {
+		t.Fatal("item was not set in cache")
+	}
+	value, ok := item.Value.(map[string]interface{})
+	if !ok || value["name"] != "alice" || value["age"] != float64(30) {
+		t.Errorf("wrong value was set in cache: got %v", item.Value)
+	}
+	if item.Metadata["source"] != "test" {
+		t.Errorf("wrong metadata: got %v", item.Metadata)
+	}
+	if ttl, expires := item.TTL(); !expires || ttl > time.Minute {
+		t.Errorf("wrong ttl: got %v", ttl)
+	}
+}
+
+func TestPutKeyHandlerRawBody(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	rr := serveV2(h, "PUT", "/v2/keys/greeting", "text/plain", "hello world", map[string]string{ttlHeader: "1m"})
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+	item, _ := c.GetItem("greeting")
+	if v, ok := item.Value.([]byte); !ok || string(v) != "hello world" {
+		t.Errorf("wrong value was set in cache: got %v", item.Value)
+	}
+	if item.Metadata[metaContentType] != "text/plain" {
+		t.Errorf("wrong content type stored: got %v", item.Metadata)
+	}
+
+	// Invalid TTLs are rejected with a JSON error
+	rr = serveV2(h, "PUT", "/v2/keys/greeting", "text/plain", "x", map[string]string{ttlHeader: "-5"})
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusBadRequest)
+	}
+	var errResp errorResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &errResp); err != nil || errResp.Error.Status != http.StatusBadRequest {
+		t.Errorf("expected JSON error object, got %s", rr.Body.String())
+	}
+}
+
+// Will test that a JSON body without a value is rejected instead of storing nil
+func TestPutKeyHandlerMissingValue(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	for _, body := range []string{`{}`, `{"value": null, "ttl": 60}`} {
+		rr := serveV2(h, "PUT", "/v2/keys/empty", "application/json", body, nil)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected %v, got %v", body, http.StatusBadRequest, rr.Code)
+		}
+	}
+	if _, found := c.Get("empty"); found {
+		t.Error("expected nothing to be stored")
+	}
+}
+
+// Will test that binary values come back byte for byte with their headers, and as base64 in the JSON view
+func TestBinaryValues(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+
+	payload := string([]byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0x00})
+	headers := map[string]string{"Content-Encoding": "gzip"}
+	rr := serveV2(h, "PUT", "/v2/keys/blob", "application/x-protobuf", payload, headers)
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+
+	rr = serveV2(h, "GET", "/v2/keys/blob", "", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	if rr.Body.String() != payload {
+		t.Errorf("wrong body: got %v, expected %v", rr.Body.Bytes(), []byte(payload))
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/x-protobuf" {
+		t.Errorf("wrong content type: got %v", ct)
+	}
+	if ce := rr.Header().Get("Content-Encoding"); ce != "gzip" {
+		t.Errorf("wrong content encoding: got %v", ce)
+	}
+
+	rr = serveV2(h, "GET", "/v2/keys/blob", "", "", map[string]string{"Accept": "text/html, application/json;q=0.9"})
+	var got keyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("expected JSON view, got %s", rr.Body.String())
+	}
+	if got.Value != base64.StdEncoding.EncodeToString([]byte(payload)) {
+		t.Errorf("expected base64 value, got %v", got.Value)
+	}
+	if got.Encoding != "base64" {
+		t.Errorf("expected encoding base64, got %q", got.Encoding)
+	}
+	if got.Metadata[metaContentType] != "application/x-protobuf" {
+		t.Errorf("expected content type in metadata, got %v", got.Metadata)
+	}
+}
+
+func TestGetKeyHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.SetWithMetadata("expiring", "value", time.Minute, map[string]string{"a": "b"})
+	c.Set("forever", "value", cache.NoExpiration)
+
+	rr := serveV2(h, "GET", "/v2/keys/expiring", "", "", nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusOK)
+	}
+	var got keyResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Key != "expiring" || got.Value != "value" || got.Metadata["a"] != "b" {
+		t.Errorf("unexpected body: %s", rr.Body.String())
+	}
+	if got.TTL == nil || *got.TTL <= 0 || *got.TTL > 60 || got.ExpiresAt == nil {
+		t.Errorf("expected ttl within a minute, got %s", rr.Body.String())
+	}
+
+	rr = serveV2(h, "GET", "/v2/keys/forever", "", "", nil)
+	got = keyResponse{}
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if got.TTL != nil || got.ExpiresAt != nil {
+		t.Errorf("expected null ttl for key without expiration, got %s", rr.Body.String())
+	}
+
+	rr = serveV2(h, "GET", "/v2/keys/missing", "", "", nil)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusNotFound)
+	}
+}
+
+func TestDeleteKeyHandler(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	c.Set("key", "value", time.Minute)
+
+	rr := serveV2(h, "DELETE", "/v2/keys/key", "", "", nil)
+	if rr.Code != http.StatusNoContent {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", rr.Code, http.StatusNoContent)
+	}
+	if _, found := c.Get("key"); found {
+		t.Error("item was not deleted from cache")
+	}
+}
+
+func TestParseTTL(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"", cache.NoExpiration, false},
+		{"60", time.Minute, false},
+		{"1.5", 1500 * time.Millisecond, false},
+		{"1m30s", 90 * time.Second, false},
+		{"0", 0, true},
+		{"soon", 0, true},
+		{"NaN", 0, true},
+		{"+Inf", 0, true},
+		{"1e300", 0, true},
+		{"1e-12", 0, true},
+		{"1000000h", 0, true},
+		{"876000h", 876000 * time.Hour, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseTTL(tt.in)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("ParseTTL(%q) = %v, %v", tt.in, got, err)
+		}
+	}
+}
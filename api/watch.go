@@ -0,0 +1,165 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/cache"
+	"net/http"
+	"time"
+)
+
+// How often an idle SSE stream sends a comment line, so proxies don't close the connection
+var sseKeepAlive = 15 * time.Second
+
+// Longest a write to an SSE stream may take, so a client that stopped reading can't hold the handler forever
+var sseWriteTimeout = 10 * time.Second
+
+// Subscriptions of watch streams. A stream that falls behind is closed rather than skipping events, so the
+// client knows it missed some (i.e. a NearCache drops its local copies) and can subscribe again.
+var watchOptions = cache.WatchOptions{
+	BufferSize:   cache.DefaultWatchOptions.BufferSize,
+	Policy:       cache.PolicyDisconnect,
+	IncludeValue: true,
+}
+
+// JSON shape of a keyspace event sent to watch clients
+type watchEvent struct {
+	Type  string      `json:"type"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+func newWatchEvent(ev cache.Event) watchEvent {
+	return watchEvent{
+		Type:  ev.Type.String(),
+		Key:   ev.Key,
+		Value: ev.Value,
+	}
+}
+
+// * url params /watch?match=user:*
+// Streams keyspace events for keys matching the 'match' glob (every key when empty).
+// Requests with an "Upgrade: websocket" header get a WebSocket, everything else gets Server-Sent Events.
+func (h *Handler) WatchHandler(w http.ResponseWriter, r *http.Request) {
+	// If request method is not a GET, will error
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	pattern := r.URL.Query().Get("match")
+
+	if isWebSocketUpgrade(r) {
+		h.watchWebSocket(w, r, pattern)
+		return
+	}
+	h.watchSSE(w, r, pattern)
+}
+
+// Will write every event as an SSE message ("event: <type>" and "data: <json>") until the client goes away
+func (h *Handler) watchSSE(w http.ResponseWriter, r *http.Request, pattern string) {
+	// Flushing is needed to push every event to the client right away instead of buffering the response
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	events, cancel := h.Cache.WatchWithOptions(pattern, watchOptions)
+	defer cancel()
+
+	// Not every ResponseWriter supports deadlines (i.e. in tests), streaming works without them
+	rc := http.NewResponseController(w)
+	rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		// The request context is cancelled when the client disconnects or the server shuts down
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case ev, ok := <-events:
+			if !ok {
+				return // The cache disconnected us for being too slow
+			}
+			data, err := json.Marshal(newWatchEvent(ev))
+			if err != nil {
+				continue
+			}
+			rc.SetWriteDeadline(time.Now().Add(sseWriteTimeout))
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Will send every event as a JSON text message until the client closes the socket
+func (h *Handler) watchWebSocket(w http.ResponseWriter, r *http.Request, pattern string) {
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	events, cancel := h.Cache.WatchWithOptions(pattern, watchOptions)
+	defer cancel()
+
+	// Shutdown does not close hijacked connections. Once the server's base context is cancelled, the client gets a
+	// close frame and the connection is closed, even when the loop below is stuck writing to a client that stopped
+	// reading.
+	stop := context.AfterFunc(r.Context(), func() {
+		conn.conn.SetWriteDeadline(time.Now()) // Ends a stuck write right away
+		conn.WriteClose(wsCloseGoingAway)
+		conn.Close()
+	})
+	defer stop()
+
+	// After hijacking, the only way to notice the client leaving is reading from the socket.
+	// The reader goroutine answers pings and closes 'gone' once the client sends a close frame or the connection drops.
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-gone:
+			return
+		case <-r.Context().Done():
+			return // Closed by the AfterFunc above
+		case ev, ok := <-events:
+			if !ok {
+				conn.WriteClose(wsCloseGoingAway)
+				return
+			}
+			data, err := json.Marshal(newWatchEvent(ev))
+			if err != nil {
+				continue
+			}
+			if err := conn.WriteText(data); err != nil {
+				return
+			}
+		}
+	}
+}
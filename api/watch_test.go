@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/cache"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Will start a test server running WatchHandler. The returned channel is closed once a handler call returns,
+// which lets tests check that disconnects actually end the stream.
+func newWatchServer(t *testing.T) (*cache.Cache, *httptest.Server, chan struct{}) {
+	t.Helper()
+	return newWatchServerWithContext(t, context.Background())
+}
+
+// Same as newWatchServer, with request contexts derived from base like the server's BaseContext does
+func newWatchServerWithContext(t *testing.T, base context.Context) (*cache.Cache, *httptest.Server, chan struct{}) {
+	t.Helper()
+	c := cache.NewCache()
+	h := &Handler{Cache: c}
+	finished := make(chan struct{})
+
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer close(finished)
+		h.WatchHandler(w, r)
+	}))
+	srv.Config.BaseContext = func(net.Listener) context.Context { return base }
+	srv.Start()
+	t.Cleanup(func() {
+		srv.Close()
+		c.Stop()
+	})
+	return c, srv, finished
+}
+
+func waitFinished(t *testing.T, finished chan struct{}) {
+	t.Helper()
+	select {
+	case <-finished:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected watch handler to return after client disconnected")
+	}
+}
+
+func TestWatchHandlerSSE(t *testing.T) {
+	c, srv, finished := newWatchServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/watch?match=user:*", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong content type: got %v, expected text/event-stream", ct)
+	}
+
+	// The subscription exists once headers were sent, so these events will be streamed
+	c.Set("other", "ignored", time.Minute)
+	c.Set("user:1", "alice", time.Minute)
+
+	reader := bufio.NewReader(resp.Body)
+	eventLine, _ := reader.ReadString('\n')
+	dataLine, _ := reader.ReadString('\n')
+
+	if eventLine != "event: set\n" {
+		t.Errorf("unexpected event line: %q", eventLine)
+	}
+	var got watchEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(dataLine, "data: ")), &got); err != nil {
+		t.Fatalf("Failed to parse event data %q: %v", dataLine, err)
+	}
+	if got.Type != "set" || got.Key != "user:1" || got.Value != "alice" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+
+	// Cancelling the request context disconnects the client
+	cancel()
+	waitFinished(t, finished)
+}
+
+func TestWatchHandlerMethodNotAllowed(t *testing.T) {
+	h := &Handler{Cache: cache.NewCache()}
+	req, _ := http.NewRequest("POST", "/watch", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(h.WatchHandler).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusMethodNotAllowed {
+		t.Errorf("handler returned wrong status code: got %v, expected %v", status, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestWatchHandlerWebSocket(t *testing.T) {
+	c, srv, finished := newWatchServer(t)
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Opening handshake, written by hand since the standard library has no WebSocket client
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	handshake := "GET /watch?match=user:* HTTP/1.1\r\n" +
+		"Host: " + conn.RemoteAddr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: " + key + "\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong status code: got %v, expected %v", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	// Value from the example in RFC 6455
+	if accept := resp.Header.Get("Sec-WebSocket-Accept"); accept != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("wrong Sec-WebSocket-Accept: got %v", accept)
+	}
+
+	ws := newWSConn(conn, br, true)
+	c.Set("user:1", "alice", time.Minute)
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	op, payload, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wsOpText {
+		t.Errorf("wrong opcode: got %v, expected %v", op, wsOpText)
+	}
+	var got watchEvent
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != "set" || got.Key != "user:1" {
+		t.Errorf("unexpected event: %+v", got)
+	}
+
+	// Closing from the client side must end the handler
+	if err := ws.WriteClose(wsCloseNormal); err != nil {
+		t.Fatal(err)
+	}
+	waitFinished(t, finished)
+}
+
+// Blocks every write of an event until release is closed, like a client that stopped reading
+type stalledWriter struct {
+	*httptest.ResponseRecorder
+	subscribed chan struct{}
+	release    chan struct{}
+	once       sync.Once
+}
+
+func (w *stalledWriter) Flush() {
+	w.once.Do(func() { close(w.subscribed) })
+}
+
+func (w *stalledWriter) Write(b []byte) (int, error) {
+	<-w.release
+	return w.ResponseRecorder.Write(b)
+}
+
+// Will test that a stream falling behind is closed instead of silently skipping events
+func TestWatchHandlerSlowClient(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	h := &Handler{Cache: c}
+	w := &stalledWriter{ResponseRecorder: httptest.NewRecorder(), subscribed: make(chan struct{}), release: make(chan struct{})}
+	req, _ := http.NewRequest("GET", "/watch", nil)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		h.WatchHandler(w, req)
+	}()
+	<-w.subscribed
+	for i := 0; i < watchOptions.BufferSize+2; i++ {
+		c.Set(fmt.Sprint("key-", i), i, time.Minute)
+	}
+	close(w.release)
+	waitFinished(t, finished)
+}
+
+// Will open a WebSocket watch stream on srv
+func dialWatchWebSocket(t *testing.T, srv *httptest.Server, match string) (*wsConn, net.Conn) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	handshake := "GET /watch?match=" + match + " HTTP/1.1\r\n" +
+		"Host: " + conn.RemoteAddr().String() + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("wrong status code: got %v, expected %v", resp.StatusCode, http.StatusSwitchingProtocols)
+	}
+	return newWSConn(conn, br, true), conn
+}
+
+// Will fill the socket buffers of a client that does not read, until the server blocks writing to it
+func fillStream(c *cache.Cache) {
+	value := strings.Repeat("x", 1<<20)
+	for i := 0; i < watchOptions.BufferSize; i++ {
+		c.Set(fmt.Sprint("key-", i), value, time.Minute)
+	}
+}
+
+// Will test that cancelling the server's base context closes a WebSocket stream, even one stuck writing to a
+// client that stopped reading, since Shutdown does not close hijacked connections
+func TestWatchWebSocketShutdown(t *testing.T) {
+	base, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	c, srv, finished := newWatchServerWithContext(t, base)
+
+	ws, conn := dialWatchWebSocket(t, srv, "")
+	c.Set("key", "value", time.Minute)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, _, err := ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+
+	fillStream(c)
+	time.Sleep(100 * time.Millisecond) // Lets the handler block writing
+	cancelBase()
+	waitFinished(t, finished)
+}
+
+// Will test that an SSE stream stuck writing to a client that stopped reading ends once the write deadline passes
+func TestWatchSSEWriteTimeout(t *testing.T) {
+	defer func(timeout time.Duration) { sseWriteTimeout = timeout }(sseWriteTimeout)
+	sseWriteTimeout = 100 * time.Millisecond
+	c, srv, finished := newWatchServer(t)
+
+	resp, err := http.Get(srv.URL + "/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	fillStream(c)
+	waitFinished(t, finished)
+}
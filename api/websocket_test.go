@@ -0,0 +1,66 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"net/http"
+	"testing"
+)
+
+// Will test that messages of every length encoding (7 bit, 16 bit and 64 bit) survive a client -> server round trip
+func TestWebSocketFrames(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := newWSConn(clientConn, bufio.NewReader(clientConn), true)
+	server := newWSConn(serverConn, bufio.NewReader(serverConn), false)
+
+	for _, size := range []int{0, 125, 126, 70000} {
+		payload := bytes.Repeat([]byte("x"), size)
+		go client.WriteText(payload)
+
+		op, got, err := server.ReadMessage()
+		if err != nil {
+			t.Fatalf("size %d: %v", size, err)
+		}
+		if op != wsOpText || !bytes.Equal(got, payload) {
+			t.Errorf("size %d: message did not round trip", size)
+		}
+	}
+}
+
+// Will test that pings are answered with a pong carrying the same payload
+func TestWebSocketPing(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := newWSConn(clientConn, bufio.NewReader(clientConn), true)
+	server := newWSConn(serverConn, bufio.NewReader(serverConn), false)
+
+	go server.ReadMessage()
+	go client.writeFrame(wsOpPing, []byte("hello"))
+
+	_, op, payload, err := client.readFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op != wsOpPong || string(payload) != "hello" {
+		t.Errorf("Expected pong with payload hello, got opcode %v payload %q", op, payload)
+	}
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/watch", nil)
+	if isWebSocketUpgrade(req) {
+		t.Error("Expected plain request not to be an upgrade")
+	}
+
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	if !isWebSocketUpgrade(req) {
+		t.Error("Expected request to be an upgrade")
+	}
+}
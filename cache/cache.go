@@ -5,10 +5,32 @@ import (
 	"time"
 )
 
+// Pass as the duration to Set when an item should never expire
+const NoExpiration time.Duration = -1
+
 // Represents a single item in the cache
 type CacheItem struct {
 	Value      interface{}
-	Expiration int64
+	Expiration int64             // Unix nanoseconds, 0 means the item never expires
+	Metadata   map[string]string // Optional extra information stored with the value, nil for plain Sets
+	Version    uint64            // Changes on every write of the key, used for ETags and optimistic concurrency
+}
+
+// Will report whether the item's expiration is before now (unix nanoseconds). Items without expiration never expire.
+func (item CacheItem) Expired(now int64) bool {
+	return item.Expiration != 0 && now > item.Expiration
+}
+
+// Will return how long until the item expires, and false if it never expires
+func (item CacheItem) TTL() (time.Duration, bool) {
+	if item.Expiration == 0 {
+		return 0, false
+	}
+	ttl := time.Duration(item.Expiration - time.Now().UnixNano())
+	if ttl < 0 {
+		ttl = 0
+	}
+	return ttl, true
 }
 
 // The main structure that holds all cached items and statistics
@@ -19,34 +41,67 @@ type Cache struct {
 	stats          *Stats // Pointer to a Stats object for tracking
 	stopJanitor    chan bool
 	janitorRunning bool
+	watchers       *watchHub // Subscribers that get notified when keys change
+	options        Options
+	lastVersion    uint64  // Version given to the most recent write, guarded by mu
+	seq            uint64  // Sequence number of the most recent change, guarded by mu
+	journal        Journal // Gets every change when set, guarded by mu
+}
+
+// Settings used when creating a Cache
+type Options struct {
+	CleanupInterval time.Duration // How often the janitor removes expired items
+}
+
+// Options used by NewCache
+var DefaultOptions = Options{
+	CleanupInterval: time.Second,
 }
 
 // Creates and initializes a new Cache instance
 func NewCache() *Cache {
+	return NewCacheWithOptions(DefaultOptions)
+}
+
+// Creates a new Cache with custom options. Zero values fall back to DefaultOptions.
+func NewCacheWithOptions(opts Options) *Cache {
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = DefaultOptions.CleanupInterval
+	}
+
+	stats := NewStats() // New Stats object to track cache perations.
 	c := &Cache{
 		items:          make(map[string]CacheItem), // Initialize an empty map for cache items
-		stats:          NewStats(),                 // New Stats object to track cache perations.
+		stats:          stats,
 		stopJanitor:    make(chan bool),
 		janitorRunning: true,
+		watchers:       newWatchHub(stats),
+		options:        opts,
 	}
 	go c.janitor()
 	return c
 }
 
 // Will set
-// expiration, to time.Now() plus incoming duration
+// expiration, to time.Now() plus incoming duration (or no expiration at all when duration is NoExpiration)
 // add a new key to c.items and assign it to a new CacheItem, which has fields to hold incoming value and expiration.
 // ? interface{} type is like "any" type in TS, it is used when the type can be anything.
-// Then will increment stats
+// Then will increment stats and notify watchers
 func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
-	expiration := time.Now().Add(duration).UnixNano()
+	c.SetWithMetadata(key, value, duration, nil)
+}
 
-	c.items[key] = CacheItem{
-		Value:      value,
-		Expiration: expiration,
-	}
+// Same as Set, but also stores metadata with the value (i.e. where it came from, or its content type)
+func (c *Cache) SetWithMetadata(key string, value interface{}, duration time.Duration, metadata map[string]string) {
+	c.SetIf(key, value, duration, metadata, nil)
+}
 
-	c.stats.IncrementSets()
+// Will turn a duration into an absolute expiration in unix nanoseconds
+func expirationFor(duration time.Duration) int64 {
+	if duration == NoExpiration {
+		return 0
+	}
+	return time.Now().Add(duration).UnixNano()
 }
 
 // Retireve from cache.items the value for the incoming key and whether it was found. If not found will give (nil, false)
@@ -54,52 +109,90 @@ func (c *Cache) Set(key string, value interface{}, duration time.Duration) {
 // when something is found, will increment hits
 // If item is expired, will return (nil,false) AND count as a miss
 func (c *Cache) Get(key string) (interface{}, bool) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	item, found := c.items[key]
+	item, found := c.GetItem(key)
 	if !found {
-		c.stats.IncrementMisses()
 		return nil, false
 	}
+	return item.Value, true
+}
 
-	if c.janitorRunning && time.Now().UnixNano() > item.Expiration {
+// Same as Get, but returns the whole CacheItem so callers can see the expiration, metadata and version
+func (c *Cache) GetItem(key string) (CacheItem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	item, found := c.lookup(key)
+	if !found {
 		c.stats.IncrementMisses()
-		return nil, false
+		return CacheItem{}, false
 	}
 
 	c.stats.IncrementHits()
-	return item.Value, true
+	return item, true
+}
+
+// Will find a key that exists and has not expired. Does not count hits or misses.
+// Must be called while holding c.mu (read or write).
+func (c *Cache) lookup(key string) (CacheItem, bool) {
+	item, found := c.items[key]
+	if !found {
+		return CacheItem{}, false
+	}
+	if c.janitorRunning && item.Expired(time.Now().UnixNano()) {
+		return CacheItem{}, false
+	}
+	return item, true
 }
 
 // Will delete key from map and increment deletes
+// Watchers are only notified if the key actually existed
 func (c *Cache) Delete(key string) {
 	// Will delete a specified key from a map
+	c.mu.Lock()
+	_, found := c.items[key]
 	delete(c.items, key)
+	var turn uint64
+	if found {
+		c.record(ChangeDelete, key, CacheItem{})
+		turn = c.watchers.reserve()
+	}
+	c.mu.Unlock()
+
 	c.stats.IncrementDeletes()
+	if found {
+		c.watchers.publish(turn, Event{Type: EventDelete, Key: key})
+	}
 }
 
 // Will loop through Cache items, if now > expiration, then delete item and increment "Expirations" in stats
+// Expire events are collected while holding the lock and published after it is released
 func (c *Cache) deleteExpired() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	if !c.janitorRunning {
+		c.mu.Unlock()
 		return // dont delete expired item if janitor is not running.
 	}
+	var events []Event
 	now := time.Now().UnixNano()
 	for key, item := range c.items {
-		if now > item.Expiration {
+		if item.Expired(now) {
 			delete(c.items, key)
 			c.stats.IncrementExpirations()
+			c.record(ChangeExpire, key, CacheItem{})
+			events = append(events, Event{Type: EventExpire, Key: key})
 		}
 	}
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.watchers.publish(turn, events...)
 }
 
 // Will call periodically remove items from the cache that are expired, to free up memory.
 func (c *Cache) janitor() {
-	ticker := time.NewTicker(time.Second) // will run every second
-	defer ticker.Stop()                   // Will make sure ticker stops at the end of the logic
+	ticker := time.NewTicker(c.options.CleanupInterval) // will run every CleanupInterval (a second by default)
+	defer ticker.Stop()                                 // Will make sure ticker stops at the end of the logic
 
 	for {
 		select {
@@ -114,13 +207,15 @@ func (c *Cache) janitor() {
 	}
 }
 
+// Will stop the janitor. The lock is released before signalling, because the janitor may be waiting on it inside deleteExpired.
 func (c *Cache) Stop() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	running := c.janitorRunning
+	c.janitorRunning = false
+	c.mu.Unlock()
 
-	if c.janitorRunning {
+	if running {
 		c.stopJanitor <- true
-		c.janitorRunning = false
 	}
 }
 
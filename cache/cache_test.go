@@ -214,4 +214,54 @@ func TestStopJanitor(t *testing.T) {
 	if _, found := c.Get("key"); !found {
 		t.Error("Expected key to still exist after manual deletion")
 	}
-}
\ No newline at end of file
+}
+
+// Will test that the janitor follows CleanupInterval
+func TestCleanupInterval(t *testing.T) {
+	c := NewCacheWithOptions(Options{CleanupInterval: 10 * time.Millisecond})
+	defer c.Stop()
+	c.Set("key", "value", time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	if got := c.GetStats()["expirations"]; got != 1 {
+		t.Errorf("Expected expirations stat to be 1, got %d", got)
+	}
+}
+
+// Will test that items set with NoExpiration are never removed by deleteExpired
+func TestNoExpiration(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("forever", "value", NoExpiration)
+
+	c.deleteExpired()
+
+	item, found := c.GetItem("forever")
+	if !found {
+		t.Fatal("Expected item without expiration to still exist")
+	}
+	if _, expires := item.TTL(); expires {
+		t.Error("Expected item not to have a TTL")
+	}
+}
+
+// Will test that metadata is stored with the item and returned by GetItem
+func TestSetWithMetadata(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.SetWithMetadata("key", "value", time.Minute, map[string]string{"owner": "tests"})
+
+	item, found := c.GetItem("key")
+	if !found {
+		t.Fatal("Expected item to be found")
+	}
+	if item.Metadata["owner"] != "tests" {
+		t.Errorf("Expected metadata to be stored, got %v", item.Metadata)
+	}
+	if ttl, expires := item.TTL(); !expires || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected TTL within a minute, got %v", ttl)
+	}
+	if c.GetStats()["hits"] != 1 {
+		t.Errorf("Expected hits stat to be 1, got %d", c.GetStats()["hits"])
+	}
+}
@@ -0,0 +1,91 @@
+package cache
+
+import "time"
+
+// Checked by SetIf and DeleteIf while the cache is locked, so nothing can change the key in between.
+// item is the current item and found is false when the key is missing or expired.
+type Condition func(item CacheItem, found bool) bool
+
+// Only passes when the key does not exist (like SET NX)
+func IfMissing(item CacheItem, found bool) bool { return !found }
+
+// Only passes when the key exists (like SET XX)
+func IfExists(item CacheItem, found bool) bool { return found }
+
+// Only passes when the key exists and still has the given version
+func IfVersion(version uint64) Condition {
+	return func(item CacheItem, found bool) bool {
+		return found && item.Version == version
+	}
+}
+
+// Will store the value only if cond passes (a nil cond always passes).
+// Returns the stored item with its new version and true, or the current item and false when cond failed.
+func (c *Cache) SetIf(key string, value interface{}, duration time.Duration, metadata map[string]string, cond Condition) (CacheItem, bool) {
+	c.mu.Lock()
+	if cond != nil {
+		current, found := c.lookup(key)
+		if !cond(current, found) {
+			c.mu.Unlock()
+			return current, false
+		}
+	}
+	c.lastVersion++
+	item := CacheItem{
+		Value:      value,
+		Expiration: expirationFor(duration),
+		Metadata:   metadata,
+		Version:    c.lastVersion,
+	}
+	c.items[key] = item
+	c.record(ChangeSet, key, item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.stats.IncrementSets()
+	c.watchers.publish(turn, Event{Type: EventSet, Key: key, Value: value})
+	return item, true
+}
+
+// Will atomically read, modify and write a key. fn gets the current item (found is false when the key is missing or expired)
+// and returns the item to store and true, or false to leave the key unchanged. The cache stays locked while fn runs,
+// so fn must be quick and must not call back into the cache.
+// The stored item gets a new version. Returns the stored item and true, or the current item and false.
+func (c *Cache) Update(key string, fn func(item CacheItem, found bool) (CacheItem, bool)) (CacheItem, bool) {
+	c.mu.Lock()
+	current, found := c.lookup(key)
+	item, ok := fn(current, found)
+	if !ok {
+		c.mu.Unlock()
+		return current, false
+	}
+	c.lastVersion++
+	item.Version = c.lastVersion
+	c.items[key] = item
+	c.record(ChangeSet, key, item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.stats.IncrementSets()
+	c.watchers.publish(turn, Event{Type: EventSet, Key: key, Value: item.Value})
+	return item, true
+}
+
+// Will delete the key only if it exists and cond passes (a nil cond always passes).
+// Returns whether the key was deleted.
+func (c *Cache) DeleteIf(key string, cond Condition) bool {
+	c.mu.Lock()
+	current, found := c.lookup(key)
+	if !found || (cond != nil && !cond(current, found)) {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.items, key)
+	c.record(ChangeDelete, key, CacheItem{})
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.stats.IncrementDeletes()
+	c.watchers.publish(turn, Event{Type: EventDelete, Key: key})
+	return true
+}
@@ -0,0 +1,105 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// Will test that every write gets a new, increasing version
+func TestVersions(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	c.Set("key", "v1", time.Minute)
+	first, _ := c.GetItem("key")
+	c.Set("key", "v2", time.Minute)
+	second, _ := c.GetItem("key")
+
+	if first.Version == 0 || second.Version <= first.Version {
+		t.Errorf("Expected versions to increase, got %d then %d", first.Version, second.Version)
+	}
+}
+
+// Will test SetIf with the built-in conditions
+func TestSetIf(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	if _, ok := c.SetIf("key", "v1", time.Minute, nil, IfExists); ok {
+		t.Error("Expected IfExists to fail for a missing key")
+	}
+	item, ok := c.SetIf("key", "v1", time.Minute, nil, IfMissing)
+	if !ok {
+		t.Fatal("Expected IfMissing to pass for a missing key")
+	}
+	if _, ok := c.SetIf("key", "v2", time.Minute, nil, IfMissing); ok {
+		t.Error("Expected IfMissing to fail for an existing key")
+	}
+
+	// Only the writer holding the current version wins
+	if _, ok := c.SetIf("key", "v2", time.Minute, nil, IfVersion(item.Version+100)); ok {
+		t.Error("Expected IfVersion to fail for a stale version")
+	}
+	updated, ok := c.SetIf("key", "v2", time.Minute, nil, IfVersion(item.Version))
+	if !ok {
+		t.Fatal("Expected IfVersion to pass for the current version")
+	}
+	if v, _ := c.Get("key"); v != "v2" || updated.Version == item.Version {
+		t.Errorf("Expected v2 with a new version, got %v (version %d)", v, updated.Version)
+	}
+
+	if sets := c.GetStats()["sets"]; sets != 2 {
+		t.Errorf("Expected sets stat to be 2, got %d", sets)
+	}
+}
+
+// Will test that DeleteIf only deletes existing keys that pass the condition
+func TestDeleteIf(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("key", "value", time.Minute)
+	item, _ := c.GetItem("key")
+
+	if c.DeleteIf("missing", nil) {
+		t.Error("Expected DeleteIf to report false for a missing key")
+	}
+	if c.DeleteIf("key", IfVersion(item.Version+1)) {
+		t.Error("Expected DeleteIf to fail for a stale version")
+	}
+	if !c.DeleteIf("key", IfVersion(item.Version)) {
+		t.Error("Expected DeleteIf to delete the key")
+	}
+	if deletes := c.GetStats()["deletes"]; deletes != 1 {
+		t.Errorf("Expected deletes stat to be 1, got %d", deletes)
+	}
+}
+
+// Will test that Update can keep or skip a write based on the current item
+func TestUpdate(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("key", "hello", time.Minute)
+	before, _ := c.Peek("key")
+
+	appendWorld := func(item CacheItem, found bool) (CacheItem, bool) {
+		if !found {
+			return item, false
+		}
+		item.Value = item.Value.(string) + " world"
+		return item, true
+	}
+
+	item, ok := c.Update("key", appendWorld)
+	if !ok || item.Value != "hello world" {
+		t.Errorf("Expected hello world, got %v", item.Value)
+	}
+	if item.Version == before.Version || item.Expiration != before.Expiration {
+		t.Error("Expected a new version and the same expiration")
+	}
+	if _, ok := c.Update("missing", appendWorld); ok {
+		t.Error("Expected Update to skip a missing key")
+	}
+	if _, found := c.Peek("missing"); found {
+		t.Error("Expected missing key not to be created")
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import "time"
+
+// A map of fields to string values, stored by HSet. See types.go for how it is updated.
+type Hash map[string]string
+
+// Will return the hash at key, and ErrWrongType if key holds something else. Missing keys give a nil Hash.
+func asHash(value interface{}) (Hash, error) {
+	if value == nil {
+		return nil, nil
+	}
+	hash, ok := value.(Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return hash, nil
+}
+
+func (h Hash) clone(extra int) Hash {
+	copied := make(Hash, len(h)+extra)
+	for field, value := range h {
+		copied[field] = value
+	}
+	return copied
+}
+
+// Will set fields of the hash at key, and return how many of them were new
+func (c *Cache) HSet(key string, fields map[string]string) (int, error) {
+	return c.HSetWithTTL(key, fields, KeepTTL)
+}
+
+// Same as HSet, also setting the expiration of the key to duration (or none with NoExpiration)
+func (c *Cache) HSetWithTTL(key string, fields map[string]string, duration time.Duration) (int, error) {
+	var added int
+	var err error
+	c.modify(key, duration, func(value interface{}) (interface{}, bool) {
+		var hash Hash
+		if hash, err = asHash(value); err != nil || len(fields) == 0 {
+			return nil, false
+		}
+		next := hash.clone(len(fields))
+		for field, v := range fields {
+			if _, exists := next[field]; !exists {
+				added++
+			}
+			next[field] = v
+		}
+		return next, true
+	})
+	return added, err
+}
+
+// Will return the value of a field of the hash at key, and false when there is no such field
+func (c *Cache) HGet(key, field string) (string, bool, error) {
+	hash, err := asHash(c.view(key))
+	if err != nil {
+		return "", false, err
+	}
+	value, found := hash[field]
+	return value, found, nil
+}
+
+// Will remove fields from the hash at key, and return how many existed
+func (c *Cache) HDel(key string, fields ...string) (int, error) {
+	var removed int
+	var err error
+	c.modify(key, KeepTTL, func(value interface{}) (interface{}, bool) {
+		var hash Hash
+		if hash, err = asHash(value); err != nil {
+			return nil, false
+		}
+		next := hash.clone(0)
+		for _, field := range fields {
+			if _, exists := next[field]; exists {
+				delete(next, field)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return nil, false
+		}
+		if len(next) == 0 {
+			return nil, true
+		}
+		return next, true
+	})
+	return removed, err
+}
+
+// Will return a copy of every field of the hash at key
+func (c *Cache) HGetAll(key string) (map[string]string, error) {
+	hash, err := asHash(c.view(key))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string(hash.clone(0)), nil
+}
@@ -0,0 +1,37 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHash(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	if n, err := c.HSet("h", map[string]string{"name": "alice", "role": "admin"}); err != nil || n != 2 {
+		t.Fatalf("Unexpected HSet result: %d %v", n, err)
+	}
+	if n, _ := c.HSet("h", map[string]string{"role": "user", "team": "core"}); n != 1 {
+		t.Errorf("Expected 1 new field, got %d", n)
+	}
+	if v, found, _ := c.HGet("h", "role"); !found || v != "user" {
+		t.Errorf("Expected the field to be updated, got %q", v)
+	}
+	if _, found, _ := c.HGet("h", "missing"); found {
+		t.Error("Expected a missing field not to be found")
+	}
+
+	all, _ := c.HGetAll("h")
+	all["name"] = "changed"
+	if want := map[string]string{"name": "alice", "role": "user", "team": "core"}; !reflect.DeepEqual(map[string]string(c.view("h").(Hash)), want) {
+		t.Errorf("Expected HGetAll to return a copy, got %v", c.view("h"))
+	}
+
+	if n, _ := c.HDel("h", "name", "missing"); n != 1 {
+		t.Errorf("Expected 1 field removed, got %d", n)
+	}
+	c.HDel("h", "role", "team")
+	if all, _ := c.HGetAll("h"); len(all) != 0 || c.Len() != 0 {
+		t.Errorf("Expected the emptied hash to be deleted, got %v", all)
+	}
+}
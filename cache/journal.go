@@ -0,0 +1,91 @@
+package cache
+
+// Kind of change recorded in a Journal
+type ChangeType uint8
+
+const (
+	ChangeSet    ChangeType = iota + 1 // A key was written, or its expiration changed
+	ChangeDelete                       // A key was deleted
+	ChangeExpire                       // An expired key was removed by the janitor
+	ChangeFlush                        // Every key was removed
+)
+
+// One change to the cache. Set changes carry the whole item (value, expiration, metadata and version),
+// so applying the same changes to another cache gives an exact copy.
+type Change struct {
+	Seq  uint64 // Position in the cache's change sequence, starting at 1
+	Type ChangeType
+	Key  string // Empty for ChangeFlush
+	Item CacheItem
+}
+
+// Receives every change to a cache. Record is called while the cache is locked, so changes arrive in exactly
+// the order they were applied, but it must be quick and must not call back into the cache.
+type Journal interface {
+	Record(ch Change)
+}
+
+// Will send every change from now on to j (nil detaches the current journal).
+// Returns the sequence number of the last change before j was attached, the first change j gets is the one after it.
+func (c *Cache) SetJournal(j Journal) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.journal = j
+	return c.seq
+}
+
+// Will return the sequence number of the most recent change
+func (c *Cache) Seq() uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.seq
+}
+
+// Will give a change the next sequence number and pass it to the journal. Must be called while holding c.mu for writing.
+func (c *Cache) record(t ChangeType, key string, item CacheItem) {
+	c.seq++
+	if c.journal != nil {
+		c.journal.Record(Change{Seq: c.seq, Type: t, Key: key, Item: item})
+	}
+}
+
+// Will apply a change recorded by another cache (i.e. on a replication primary). Set changes store the item as is,
+// keeping its version and expiration. Watchers are notified and the change is recorded again, so a replica
+// can have replicas of its own. ch.Seq is ignored, the change gets the next sequence number of this cache.
+func (c *Cache) Apply(ch Change) {
+	var event Event
+	c.mu.Lock()
+	switch ch.Type {
+	case ChangeSet:
+		c.items[ch.Key] = ch.Item
+		if ch.Item.Version > c.lastVersion {
+			c.lastVersion = ch.Item.Version
+		}
+		event = Event{Type: EventSet, Key: ch.Key, Value: ch.Item.Value}
+		c.stats.IncrementSets()
+	case ChangeDelete, ChangeExpire:
+		if _, found := c.items[ch.Key]; !found {
+			c.mu.Unlock()
+			return // Already gone, i.e. removed by this cache's own janitor
+		}
+		delete(c.items, ch.Key)
+		if ch.Type == ChangeDelete {
+			event = Event{Type: EventDelete, Key: ch.Key}
+			c.stats.IncrementDeletes()
+		} else {
+			event = Event{Type: EventExpire, Key: ch.Key}
+			c.stats.IncrementExpirations()
+		}
+	case ChangeFlush:
+		c.items = make(map[string]CacheItem)
+		event = Event{Type: EventFlush}
+	default:
+		c.mu.Unlock()
+		return
+	}
+	c.record(ch.Type, ch.Key, ch.Item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.watchers.publish(turn, event)
+}
@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"bytes"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Collects every change it gets
+type testJournal struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+func (j *testJournal) Record(ch Change) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.changes = append(j.changes, ch)
+}
+
+func (j *testJournal) recorded() []Change {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return append([]Change(nil), j.changes...)
+}
+
+func TestJournal(t *testing.T) {
+	c := NewCacheWithOptions(Options{CleanupInterval: 10 * time.Millisecond})
+	defer c.Stop()
+	c.Set("before", 1, NoExpiration) // Not recorded, the journal is attached after it
+
+	j := &testJournal{}
+	if seq := c.SetJournal(j); seq != 1 {
+		t.Errorf("Expected SetJournal to return 1, got %d", seq)
+	}
+
+	c.Set("a", "x", NoExpiration)
+	c.Expire("a", time.Hour)
+	c.Persist("a")
+	c.Increment("n", 2)
+	c.Delete("a")
+	c.Delete("missing") // Not a change
+	c.Set("short", 1, 5*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	c.Flush()
+
+	want := []ChangeType{ChangeSet, ChangeSet, ChangeSet, ChangeSet, ChangeDelete, ChangeSet, ChangeExpire, ChangeFlush}
+	changes := j.recorded()
+	if len(changes) != len(want) {
+		t.Fatalf("Expected %d changes, got %+v", len(want), changes)
+	}
+	for i, ch := range changes {
+		if ch.Type != want[i] || ch.Seq != uint64(i+2) {
+			t.Errorf("Change %d: expected type %d seq %d, got %+v", i, want[i], i+2, ch)
+		}
+	}
+	if changes[1].Item.Expiration == 0 || changes[2].Item.Expiration != 0 {
+		t.Errorf("Expected Expire and Persist to record the new expiration, got %+v and %+v", changes[1], changes[2])
+	}
+	if changes[3].Key != "n" || changes[3].Item.Value != int64(2) {
+		t.Errorf("Expected Increment to record the new value, got %+v", changes[3])
+	}
+	if c.Seq() != 9 {
+		t.Errorf("Expected Seq 9, got %d", c.Seq())
+	}
+}
+
+// Will test that applying the changes of one cache to another gives an exact copy
+func TestApply(t *testing.T) {
+	primary, replica := NewCache(), NewCache()
+	defer primary.Stop()
+	defer replica.Stop()
+	j := &testJournal{}
+	primary.SetJournal(j)
+
+	primary.SetWithMetadata("a", "x", time.Hour, map[string]string{"source": "test"})
+	primary.Set("b", 1, NoExpiration)
+	primary.Set("c", 2, NoExpiration)
+	primary.Delete("c")
+	replica.Set("stale", true, NoExpiration)
+
+	events, cancel := replica.Watch("")
+	defer cancel()
+	for _, ch := range j.recorded() {
+		replica.Apply(ch)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		want, _ := primary.GetItem(key)
+		got, found := replica.GetItem(key)
+		if !found || !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %+v on the replica, got %+v", key, want, got)
+		}
+	}
+	if _, found := replica.Get("c"); found {
+		t.Errorf("Expected the delete to be applied")
+	}
+	if ev := <-events; ev.Type != EventSet || ev.Key != "a" {
+		t.Errorf("Expected watchers of the replica to be notified, got %+v", ev)
+	}
+
+	// New writes on the replica never reuse a version it got from the primary
+	item, _ := replica.SetIf("d", 1, NoExpiration, nil, nil)
+	b, _ := primary.GetItem("b")
+	if item.Version <= b.Version {
+		t.Errorf("Expected a version above %d, got %d", b.Version, item.Version)
+	}
+}
+
+func TestReplaceWithSnapshot(t *testing.T) {
+	src := NewCache()
+	defer src.Stop()
+	src.Set("a", 1, NoExpiration)
+	src.Set("b", 2, NoExpiration)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewCache()
+	defer dst.Stop()
+	dst.Set("stale", 1, NoExpiration)
+	loaded, seq, err := dst.ReplaceWithSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 2 || seq != 2 {
+		t.Errorf("Expected 2 items at seq 2, got %d at %d", loaded, seq)
+	}
+	if _, found := dst.Get("stale"); found {
+		t.Errorf("Expected existing keys to be removed")
+	}
+	if dst.Len() != 2 {
+		t.Errorf("Expected 2 keys, got %d", dst.Len())
+	}
+}
@@ -0,0 +1,222 @@
+package cache
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Operations on the keyspace as a whole, and on single keys without reading their value.
+// None of these count as hits or misses.
+
+// Returned by Increment when the current value is not an integer
+var ErrNotInteger = errors.New("value is not an integer")
+
+// Returned by Increment when the result would not fit in an int64
+var ErrOverflow = errors.New("increment or decrement would overflow")
+
+// Will return the item for key without counting a hit or miss
+func (c *Cache) Peek(key string) (CacheItem, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.lookup(key)
+}
+
+// Will return every key matching pattern (see MatchPattern), sorted. Expired keys are left out.
+func (c *Cache) Keys(pattern string) []string {
+	c.mu.RLock()
+	keys := make([]string, 0, len(c.items))
+	for key := range c.items {
+		if _, found := c.lookup(key); found && MatchPattern(pattern, key) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.RUnlock()
+
+	sort.Strings(keys)
+	return keys
+}
+
+// Will return up to count keys, starting at cursor (0 for the first call), and the cursor of the next call,
+// 0 once every key was returned. Keys are visited in the order of their hash, so a key that exists during
+// the whole scan is returned exactly once, even when other keys are added or removed in between.
+// Each call looks at every key but only keeps count of them, nothing is sorted or copied as a whole.
+// Expired keys are left out.
+func (c *Cache) Scan(cursor uint64, count int) ([]string, uint64) {
+	if count < 1 {
+		count = 1
+	}
+	// The count smallest hashes from cursor on, with their keys. Keys sharing a hash always go together.
+	hashes := make(maxHeap, 0, count)
+	byHash := make(map[uint64][]string, count)
+
+	c.mu.RLock()
+	for key := range c.items {
+		h := scanHash(key)
+		if h < cursor {
+			continue
+		}
+		if _, found := c.lookup(key); !found {
+			continue
+		}
+		if keys, ok := byHash[h]; ok {
+			byHash[h] = append(keys, key)
+			continue
+		}
+		if len(hashes) == count {
+			if h > hashes[0] {
+				continue
+			}
+			delete(byHash, heap.Pop(&hashes).(uint64))
+		}
+		heap.Push(&hashes, h)
+		byHash[h] = []string{key}
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	keys := make([]string, 0, len(byHash))
+	for _, h := range hashes {
+		keys = append(keys, byHash[h]...)
+	}
+	var next uint64
+	if len(hashes) == count && hashes[count-1] != math.MaxUint64 {
+		next = hashes[count-1] + 1
+	}
+	return keys, next
+}
+
+// Will return the position of key in the order of Scan (64-bit FNV-1a)
+func scanHash(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return h
+}
+
+// Hashes, the largest on top
+type maxHeap []uint64
+
+func (h maxHeap) Len() int           { return len(h) }
+func (h maxHeap) Less(i, j int) bool { return h[i] > h[j] }
+func (h maxHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *maxHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+
+func (h *maxHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// Will return how many keys are stored, including expired keys the janitor has not removed yet
+func (c *Cache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.items)
+}
+
+// Will give an existing key a new time to live (NoExpiration removes its expiration).
+// The value and version stay the same. Returns false if the key does not exist.
+func (c *Cache) Expire(key string, duration time.Duration) bool {
+	c.mu.Lock()
+	item, found := c.lookup(key)
+	if !found {
+		c.mu.Unlock()
+		return false
+	}
+	item.Expiration = expirationFor(duration)
+	c.items[key] = item
+	c.record(ChangeSet, key, item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	// Reported as a set, like replicas applying the change do
+	c.watchers.publish(turn, Event{Type: EventSet, Key: key, Value: item.Value})
+	return true
+}
+
+// Will remove the expiration of a key. Returns false if the key does not exist or had no expiration.
+func (c *Cache) Persist(key string) bool {
+	c.mu.Lock()
+	item, found := c.lookup(key)
+	if !found || item.Expiration == 0 {
+		c.mu.Unlock()
+		return false
+	}
+	item.Expiration = 0
+	c.items[key] = item
+	c.record(ChangeSet, key, item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.watchers.publish(turn, Event{Type: EventSet, Key: key, Value: item.Value})
+	return true
+}
+
+// Will atomically add delta to the integer stored at key and return the new value.
+// A missing key starts at 0 and never expires, an existing key keeps its expiration.
+// Integers stored as int, int64 or as a decimal string (i.e. from the RESP server) can be incremented.
+func (c *Cache) Increment(key string, delta int64) (int64, error) {
+	var err error
+	item, _ := c.Update(key, func(item CacheItem, found bool) (CacheItem, bool) {
+		var current int64
+		if found {
+			n, ok := toInt64(item.Value)
+			if !ok {
+				err = ErrNotInteger
+				return item, false
+			}
+			current = n
+		}
+
+		if (delta > 0 && current > math.MaxInt64-delta) || (delta < 0 && current < math.MinInt64-delta) {
+			err = ErrOverflow
+			return item, false
+		}
+		item.Value = current + delta
+		return item, true
+	})
+	if err != nil {
+		return 0, err
+	}
+	return item.Value.(int64), nil
+}
+
+// Will convert the integer types Increment understands to an int64
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case int64:
+		return v, true
+	case int:
+		return int64(v), true
+	case string:
+		n, err := strconv.ParseInt(v, 10, 64)
+		return n, err == nil
+	case []byte:
+		n, err := strconv.ParseInt(string(v), 10, 64)
+		return n, err == nil
+	default:
+		return 0, false
+	}
+}
+
+// Will remove every key. Watchers get a single flush event instead of one delete per key.
+// Returns how many keys were removed.
+func (c *Cache) Flush() int {
+	c.mu.Lock()
+	removed := len(c.items)
+	c.items = make(map[string]CacheItem)
+	c.record(ChangeFlush, "", CacheItem{})
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.watchers.publish(turn, Event{Type: EventFlush})
+	return removed
+}
@@ -0,0 +1,152 @@
+package cache
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeys(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("user:2", "b", time.Minute)
+	c.Set("user:1", "a", time.Minute)
+	c.Set("session:1", "s", time.Minute)
+	c.Set("user:expired", "x", -time.Second)
+
+	if got := c.Keys("user:*"); !reflect.DeepEqual(got, []string{"user:1", "user:2"}) {
+		t.Errorf("Expected sorted user keys, got %v", got)
+	}
+	if got := c.Keys(""); len(got) != 3 {
+		t.Errorf("Expected 3 keys, got %v", got)
+	}
+	if hits, misses := c.GetStats()["hits"], c.GetStats()["misses"]; hits != 0 || misses != 0 {
+		t.Errorf("Expected Keys not to count hits or misses, got %d/%d", hits, misses)
+	}
+}
+
+// Will test that a scan returns every key once, including the keys that stay while others come and go
+func TestScan(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	for i := 0; i < 100; i++ {
+		c.Set(fmt.Sprint("key:", i), i, NoExpiration)
+	}
+	c.Set("expired", "x", -time.Second)
+
+	seen := make(map[string]int)
+	var cursor uint64
+	for calls := 0; ; calls++ {
+		if calls > 100 {
+			t.Fatal("Expected the scan to end")
+		}
+		var keys []string
+		keys, cursor = c.Scan(cursor, 7)
+		if len(keys) > 7 {
+			t.Errorf("Expected at most 7 keys, got %d", len(keys))
+		}
+		for _, key := range keys {
+			seen[key]++
+		}
+		// Keys added or removed during the scan may or may not be returned
+		c.Set(fmt.Sprint("new:", calls), calls, NoExpiration)
+		c.Delete(fmt.Sprint("key:", 99-calls))
+		if cursor == 0 {
+			break
+		}
+	}
+	for i := 0; i < 80; i++ { // The scan ends before the deletes reach these
+		if key := fmt.Sprint("key:", i); seen[key] != 1 {
+			t.Errorf("Expected %s once, got %d", key, seen[key])
+		}
+	}
+	for key, n := range seen {
+		if n != 1 || key == "expired" {
+			t.Errorf("Unexpected %s returned %d times", key, n)
+		}
+	}
+}
+
+func TestExpireAndPersist(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("key", "value", NoExpiration)
+	before, _ := c.Peek("key")
+
+	if c.Expire("missing", time.Minute) {
+		t.Error("Expected Expire to fail for a missing key")
+	}
+	if !c.Expire("key", time.Minute) {
+		t.Fatal("Expected Expire to succeed")
+	}
+	item, _ := c.Peek("key")
+	if ttl, expires := item.TTL(); !expires || ttl > time.Minute {
+		t.Errorf("Expected a TTL within a minute, got %v", ttl)
+	}
+	if item.Version != before.Version {
+		t.Error("Expected Expire not to change the version")
+	}
+
+	if !c.Persist("key") {
+		t.Fatal("Expected Persist to succeed")
+	}
+	if c.Persist("key") {
+		t.Error("Expected Persist to report false when there is no expiration")
+	}
+	item, _ = c.Peek("key")
+	if _, expires := item.TTL(); expires {
+		t.Error("Expected key to have no expiration after Persist")
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	if n, err := c.Increment("counter", 5); err != nil || n != 5 {
+		t.Errorf("Expected 5, got %d (%v)", n, err)
+	}
+	if n, err := c.Increment("counter", -7); err != nil || n != -2 {
+		t.Errorf("Expected -2, got %d (%v)", n, err)
+	}
+
+	// Numeric strings can be incremented, and the TTL is kept
+	c.Set("string", "41", time.Minute)
+	if n, err := c.Increment("string", 1); err != nil || n != 42 {
+		t.Errorf("Expected 42, got %d (%v)", n, err)
+	}
+	if item, _ := c.Peek("string"); item.Expiration == 0 {
+		t.Error("Expected Increment to keep the expiration")
+	}
+
+	c.Set("text", "hello", time.Minute)
+	if _, err := c.Increment("text", 1); err != ErrNotInteger {
+		t.Errorf("Expected ErrNotInteger, got %v", err)
+	}
+
+	c.Set("big", int64(9223372036854775807), time.Minute)
+	if _, err := c.Increment("big", 1); err != ErrOverflow {
+		t.Errorf("Expected ErrOverflow, got %v", err)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("a", 1, time.Minute)
+	c.Set("b", 2, time.Minute)
+
+	events, cancel := c.Watch("a")
+	defer cancel()
+
+	if removed := c.Flush(); removed != 2 {
+		t.Errorf("Expected 2 keys to be removed, got %d", removed)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected empty cache, got %d keys", c.Len())
+	}
+	if ev := nextEvent(t, events); ev.Type != EventFlush {
+		t.Errorf("Expected flush event regardless of pattern, got %+v", ev)
+	}
+}
@@ -0,0 +1,135 @@
+package cache
+
+import "time"
+
+// A list of strings, stored by LPush and RPush. See types.go for how it is updated.
+type List []string
+
+// Will return the list at key, and ErrWrongType if key holds something else. Missing keys give a nil List.
+func asList(value interface{}) (List, error) {
+	if value == nil {
+		return nil, nil
+	}
+	list, ok := value.(List)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return list, nil
+}
+
+// Will add values to the head of the list at key, one after the other (so the last value ends up first),
+// and return the new length of the list
+func (c *Cache) LPush(key string, values ...string) (int, error) {
+	return c.push(key, values, true, KeepTTL)
+}
+
+// Same as LPush, also setting the expiration of the key to duration (or none with NoExpiration)
+func (c *Cache) LPushWithTTL(key string, duration time.Duration, values ...string) (int, error) {
+	return c.push(key, values, true, duration)
+}
+
+// Will add values to the tail of the list at key, and return the new length of the list
+func (c *Cache) RPush(key string, values ...string) (int, error) {
+	return c.push(key, values, false, KeepTTL)
+}
+
+// Same as RPush, also setting the expiration of the key to duration (or none with NoExpiration)
+func (c *Cache) RPushWithTTL(key string, duration time.Duration, values ...string) (int, error) {
+	return c.push(key, values, false, duration)
+}
+
+func (c *Cache) push(key string, values []string, head bool, ttl time.Duration) (int, error) {
+	var length int
+	var err error
+	c.modify(key, ttl, func(value interface{}) (interface{}, bool) {
+		var list List
+		if list, err = asList(value); err != nil || len(values) == 0 {
+			length = len(list)
+			return nil, false
+		}
+		next := make(List, 0, len(list)+len(values))
+		if head {
+			for i := len(values) - 1; i >= 0; i-- {
+				next = append(next, values[i])
+			}
+			next = append(next, list...)
+		} else {
+			next = append(append(next, list...), values...)
+		}
+		length = len(next)
+		return next, true
+	})
+	return length, err
+}
+
+// Will remove and return the first value of the list at key. Returns false when the list is empty.
+func (c *Cache) LPop(key string) (string, bool, error) {
+	return c.pop(key, true)
+}
+
+// Will remove and return the last value of the list at key. Returns false when the list is empty.
+func (c *Cache) RPop(key string) (string, bool, error) {
+	return c.pop(key, false)
+}
+
+func (c *Cache) pop(key string, head bool) (string, bool, error) {
+	var popped string
+	var found bool
+	var err error
+	c.modify(key, KeepTTL, func(value interface{}) (interface{}, bool) {
+		var list List
+		if list, err = asList(value); err != nil || len(list) == 0 {
+			return nil, false
+		}
+		found = true
+		if head {
+			popped, list = list[0], list[1:]
+		} else {
+			popped, list = list[len(list)-1], list[:len(list)-1]
+		}
+		if len(list) == 0 {
+			return nil, true
+		}
+		// A copy, so the stored slice does not keep the popped value alive
+		return append(List(nil), list...), true
+	})
+	return popped, found, err
+}
+
+// Will return the values of the list at key from index start to stop, both included.
+// Negative indexes count from the end, -1 being the last value, so LRange(key, 0, -1) returns the whole list.
+func (c *Cache) LRange(key string, start, stop int) ([]string, error) {
+	list, err := asList(c.view(key))
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := rangeIndexes(len(list), start, stop)
+	if !ok {
+		return []string{}, nil
+	}
+	return append([]string{}, list[start:stop+1]...), nil
+}
+
+// Will return the number of values in the list at key
+func (c *Cache) LLen(key string) (int, error) {
+	list, err := asList(c.view(key))
+	return len(list), err
+}
+
+// Will turn start and stop, where negative indexes count from the end, into valid indexes of a sequence of
+// length n. Returns false when the range is empty.
+func rangeIndexes(n, start, stop int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+	if stop < 0 {
+		stop += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= n {
+		stop = n - 1
+	}
+	return start, stop, start <= stop
+}
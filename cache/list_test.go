@@ -0,0 +1,48 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestList(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	if n, err := c.RPush("l", "c", "d"); err != nil || n != 2 {
+		t.Fatalf("Unexpected RPush result: %d %v", n, err)
+	}
+	if n, _ := c.LPush("l", "b", "a"); n != 4 {
+		t.Errorf("Expected 4 values, got %d", n)
+	}
+
+	for _, tc := range []struct {
+		start, stop int
+		want        []string
+	}{
+		{0, -1, []string{"a", "b", "c", "d"}},
+		{1, 2, []string{"b", "c"}},
+		{-2, -1, []string{"c", "d"}},
+		{2, 100, []string{"c", "d"}},
+		{-100, 0, []string{"a"}},
+		{3, 1, []string{}},
+	} {
+		if got, _ := c.LRange("l", tc.start, tc.stop); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("LRange(%d, %d): expected %v, got %v", tc.start, tc.stop, tc.want, got)
+		}
+	}
+
+	if v, ok, _ := c.LPop("l"); !ok || v != "a" {
+		t.Errorf("Expected a from the head, got %q", v)
+	}
+	if v, ok, _ := c.RPop("l"); !ok || v != "d" {
+		t.Errorf("Expected d from the tail, got %q", v)
+	}
+	c.LPop("l")
+	c.LPop("l")
+	if _, ok, err := c.LPop("l"); ok || err != nil {
+		t.Errorf("Expected nothing to pop from an empty list, got %v %v", ok, err)
+	}
+	if n, _ := c.LLen("l"); n != 0 || c.Len() != 0 {
+		t.Errorf("Expected the emptied list to be deleted, got %d values and %d keys", n, c.Len())
+	}
+}
@@ -0,0 +1,115 @@
+package cache
+
+// MatchPattern reports whether key matches a glob-style pattern.
+// Supported syntax:
+//   - '*' matches any sequence of characters (including none, and including '/')
+//   - '?' matches exactly one character
+//   - '[abc]', '[a-z]' and '[^abc]' match one character from (or not from) a set
+//   - '\' escapes the next character so it is matched literally
+//
+// An empty pattern matches every key. Unlike path.Match, '*' is not stopped by '/', because cache keys
+// are plain strings and not file paths.
+func MatchPattern(pattern, key string) bool {
+	if pattern == "" {
+		return true
+	}
+	return matchRunes([]rune(pattern), []rune(key))
+}
+
+// Iterative matcher over runes, so multi-byte characters count as a single character for '?'.
+// On a mismatch, the last '*' swallows one more character and matching starts over right after it. Earlier
+// stars never need to be revisited, so a match costs at most len(pattern)*len(key) steps, however many stars.
+func matchRunes(pattern, key []rune) bool {
+	p, k := 0, 0
+	star, starKey := -1, 0 // Pattern position right after the last '*', and where in key its match ends
+	for k < len(key) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				p++
+				star, starKey = p, k
+				continue
+			case '?':
+				p++
+				k++
+				continue
+			case '[':
+				matched, rest, ok := matchClass(pattern[p+1:], key[k])
+				if !ok && key[k] == '[' {
+					// Unterminated class, '[' is a literal character
+					p++
+					k++
+					continue
+				}
+				if ok && matched {
+					p = len(pattern) - len(rest)
+					k++
+					continue
+				}
+			case '\\':
+				literal := p
+				if p+1 < len(pattern) {
+					literal = p + 1
+				}
+				if pattern[literal] == key[k] {
+					p = literal + 1
+					k++
+					continue
+				}
+			default:
+				if pattern[p] == key[k] {
+					p++
+					k++
+					continue
+				}
+			}
+		}
+		if star < 0 {
+			return false
+		}
+		starKey++
+		p, k = star, starKey
+	}
+	// The whole key matched, only stars may be left in the pattern
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// Will check a single character against a "[...]" class. The pattern passed in starts right after the '['.
+// Returns whether the character matched, the remaining pattern after the closing ']', and false if the class was never closed.
+func matchClass(pattern []rune, c rune) (bool, []rune, bool) {
+	negate := false
+	if len(pattern) > 0 && pattern[0] == '^' {
+		negate = true
+		pattern = pattern[1:]
+	}
+
+	matched := false
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case pattern[i] == ']' && i > 0:
+			return matched != negate, pattern[i+1:], true
+		case pattern[i] == '\\' && i+1 < len(pattern):
+			i++
+			if pattern[i] == c {
+				matched = true
+			}
+		case i+2 < len(pattern) && pattern[i+1] == '-' && pattern[i+2] != ']':
+			lo, hi := pattern[i], pattern[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			if c >= lo && c <= hi {
+				matched = true
+			}
+			i += 2
+		default:
+			if pattern[i] == c {
+				matched = true
+			}
+		}
+	}
+	return false, nil, false
+}
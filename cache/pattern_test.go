@@ -0,0 +1,60 @@
+package cache
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Will test the glob matcher used by Watch against a table of patterns and keys
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{"", "anything", true},
+		{"*", "", true},
+		{"user:*", "user:42", true},
+		{"user:*", "session:42", false},
+		{"*/avatar", "users/1/avatar", true}, // '*' crosses '/'
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{`h\*llo`, "h*llo", true},
+		{`h\*llo`, "hello", false},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+		{"[unterminated", "[unterminated", true},
+		{"*c", "abcbc", true},
+		{"a*", "a", true},
+		{"a**", "abc", true},
+		{"*a*", "bbb", false},
+		{"?", "é", true}, // One character, two bytes
+		{`a\`, `a\`, true},
+		{"a*[0-9]", "ab12x", false},
+	}
+
+	for _, tt := range tests {
+		if got := MatchPattern(tt.pattern, tt.key); got != tt.want {
+			t.Errorf("MatchPattern(%q, %q) = %v, expected %v", tt.pattern, tt.key, got, tt.want)
+		}
+	}
+}
+
+// Will test that patterns with many stars don't backtrack exponentially
+func TestMatchPatternManyStars(t *testing.T) {
+	pattern := strings.Repeat("a*", 30) + "b"
+	key := strings.Repeat("a", 100)
+	start := time.Now()
+	if MatchPattern(pattern, key) {
+		t.Error("Expected no match without a b")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Expected the match to be quick, took %v", d)
+	}
+}
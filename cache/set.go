@@ -0,0 +1,148 @@
+package cache
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+)
+
+// A set of strings, stored by SAdd. See types.go for how it is updated.
+type Set map[string]bool
+
+// Will return the set at key, and ErrWrongType if key holds something else. Missing keys give a nil Set.
+func asSet(value interface{}) (Set, error) {
+	if value == nil {
+		return nil, nil
+	}
+	set, ok := value.(Set)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return set, nil
+}
+
+func (s Set) clone(extra int) Set {
+	copied := make(Set, len(s)+extra)
+	for member := range s {
+		copied[member] = true
+	}
+	return copied
+}
+
+// Will return the members, sorted
+func (s Set) Members() []string {
+	members := make([]string, 0, len(s))
+	for member := range s {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Encodes the set as a sorted array of its members (i.e. for GET /v2/keys/{key})
+func (s Set) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.Members())
+}
+
+// Will add members to the set at key, and return how many were not in it yet
+func (c *Cache) SAdd(key string, members ...string) (int, error) {
+	return c.SAddWithTTL(key, KeepTTL, members...)
+}
+
+// Same as SAdd, also setting the expiration of the key to duration (or none with NoExpiration)
+func (c *Cache) SAddWithTTL(key string, duration time.Duration, members ...string) (int, error) {
+	var added int
+	var err error
+	c.modify(key, duration, func(value interface{}) (interface{}, bool) {
+		var set Set
+		if set, err = asSet(value); err != nil {
+			return nil, false
+		}
+		next := set.clone(len(members))
+		for _, member := range members {
+			if !next[member] {
+				next[member] = true
+				added++
+			}
+		}
+		return next, added > 0
+	})
+	return added, err
+}
+
+// Will remove members from the set at key, and return how many were in it
+func (c *Cache) SRem(key string, members ...string) (int, error) {
+	var removed int
+	var err error
+	c.modify(key, KeepTTL, func(value interface{}) (interface{}, bool) {
+		var set Set
+		if set, err = asSet(value); err != nil {
+			return nil, false
+		}
+		next := set.clone(0)
+		for _, member := range members {
+			if next[member] {
+				delete(next, member)
+				removed++
+			}
+		}
+		if removed == 0 {
+			return nil, false
+		}
+		if len(next) == 0 {
+			return nil, true
+		}
+		return next, true
+	})
+	return removed, err
+}
+
+// Will return the members of the set at key, sorted
+func (c *Cache) SMembers(key string) ([]string, error) {
+	set, err := asSet(c.view(key))
+	if err != nil {
+		return nil, err
+	}
+	return set.Members(), nil
+}
+
+// Will report whether member is in the set at key
+func (c *Cache) SIsMember(key, member string) (bool, error) {
+	set, err := asSet(c.view(key))
+	return set[member], err
+}
+
+// Will return the members found in every one of the sets at keys, sorted. A missing key is an empty set.
+func (c *Cache) SInter(keys ...string) ([]string, error) {
+	// Read under one lock, so the sets are seen at the same point in time
+	c.mu.RLock()
+	sets := make([]Set, len(keys))
+	var err error
+	for i, key := range keys {
+		item, _ := c.lookup(key)
+		if sets[i], err = asSet(item.Value); err != nil {
+			break
+		}
+	}
+	c.mu.RUnlock()
+	if err != nil || len(sets) == 0 {
+		return []string{}, err
+	}
+
+	// Start from the smallest set
+	sort.Slice(sets, func(i, j int) bool { return len(sets[i]) < len(sets[j]) })
+	result := []string{}
+	for _, member := range sets[0].Members() {
+		inAll := true
+		for _, other := range sets[1:] {
+			if !other[member] {
+				inAll = false
+				break
+			}
+		}
+		if inAll {
+			result = append(result, member)
+		}
+	}
+	return result, nil
+}
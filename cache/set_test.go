@@ -0,0 +1,50 @@
+package cache
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSetType(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	if n, err := c.SAdd("s", "b", "a", "b"); err != nil || n != 2 {
+		t.Fatalf("Unexpected SAdd result: %d %v", n, err)
+	}
+	if n, _ := c.SAdd("s", "a", "c"); n != 1 {
+		t.Errorf("Expected 1 new member, got %d", n)
+	}
+	if members, _ := c.SMembers("s"); !reflect.DeepEqual(members, []string{"a", "b", "c"}) {
+		t.Errorf("Expected sorted members, got %v", members)
+	}
+	if ok, _ := c.SIsMember("s", "b"); !ok {
+		t.Error("Expected b to be a member")
+	}
+	if n, _ := c.SRem("s", "b", "missing"); n != 1 {
+		t.Errorf("Expected 1 member removed, got %d", n)
+	}
+	value, _ := c.Get("s")
+	if encoded, _ := json.Marshal(value); string(encoded) != `["a","c"]` {
+		t.Errorf("Expected sets to encode as sorted arrays, got %s", encoded)
+	}
+}
+
+func TestSInter(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.SAdd("a", "1", "2", "3", "4")
+	c.SAdd("b", "2", "3", "4")
+	c.SAdd("c", "3", "4", "5")
+
+	if got, _ := c.SInter("a", "b", "c"); !reflect.DeepEqual(got, []string{"3", "4"}) {
+		t.Errorf("Expected [3 4], got %v", got)
+	}
+	if got, _ := c.SInter("a", "missing"); len(got) != 0 {
+		t.Errorf("Expected a missing key to empty the intersection, got %v", got)
+	}
+	c.Set("string", "x", NoExpiration)
+	if _, err := c.SInter("a", "string"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
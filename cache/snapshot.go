@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version of the snapshot format, bumped whenever the layout of snapshotFile changes
+const snapshotVersion = 1
+
+// What gets written to disk. Encoded with encoding/gob, so CacheItem values keep their Go types.
+type snapshotFile struct {
+	Version   int
+	CreatedAt int64
+	Seq       uint64 // Sequence number of the last change included, 0 in snapshots written before it was added
+	Items     map[string]CacheItem
+}
+
+// Gob has to know every concrete type stored behind an interface{}.
+// The common ones are registered here, applications that cache their own structs must call gob.Register for them.
+func init() {
+	gob.Register("")
+	gob.Register([]byte(nil))
+	gob.Register(int(0))
+	gob.Register(int64(0))
+	gob.Register(uint64(0))
+	gob.Register(float64(0))
+	gob.Register(false)
+	gob.Register(map[string]interface{}(nil))
+	gob.Register([]interface{}(nil))
+	gob.Register(List(nil))
+	gob.Register(Hash(nil))
+	gob.Register(Set(nil))
+	gob.Register(SortedSet(nil))
+}
+
+// Will write every item that has not expired yet to w
+func (c *Cache) SaveSnapshot(w io.Writer) error {
+	return c.SaveSnapshotKeys(w, nil)
+}
+
+// Same as SaveSnapshot, but only the keys match accepts are written (every key when match is nil)
+func (c *Cache) SaveSnapshotKeys(w io.Writer, match func(key string) bool) error {
+	now := time.Now().UnixNano()
+
+	c.mu.RLock()
+	items := make(map[string]CacheItem, len(c.items))
+	for key, item := range c.items {
+		if !item.Expired(now) && (match == nil || match(key)) {
+			items[key] = item
+		}
+	}
+	seq := c.seq
+	c.mu.RUnlock()
+
+	return gob.NewEncoder(w).Encode(snapshotFile{
+		Version:   snapshotVersion,
+		CreatedAt: now,
+		Seq:       seq,
+		Items:     items,
+	})
+}
+
+// Will read a snapshot written by SaveSnapshot and add its items to the cache, replacing existing keys.
+// Items that expired while the snapshot was on disk are skipped. Loaded items get new versions, higher than any version
+// in the cache or the snapshot. Loading does not count as sets and does not notify watchers.
+// Returns how many items were loaded.
+func (c *Cache) LoadSnapshot(r io.Reader) (int, error) {
+	loaded, _, err := c.loadSnapshot(r, loadOverwrite)
+	return loaded, err
+}
+
+// Same as LoadSnapshot, but keys the cache already has are kept instead of replaced.
+// Returns how many items were added.
+func (c *Cache) MergeSnapshot(r io.Reader) (int, error) {
+	loaded, _, err := c.loadSnapshot(r, loadMissing)
+	return loaded, err
+}
+
+// Same as LoadSnapshot, but every existing key is removed first, so the cache ends up an exact copy of the snapshot.
+// Also returns the sequence number of the last change the snapshot includes (see Seq), which is where a
+// replica continues from.
+func (c *Cache) ReplaceWithSnapshot(r io.Reader) (int, uint64, error) {
+	return c.loadSnapshot(r, loadReplace)
+}
+
+// What happens to the keys a cache already has when a snapshot is loaded
+type loadMode int
+
+const (
+	loadOverwrite loadMode = iota // Keys in the snapshot replace existing keys, other keys stay
+	loadMissing                   // Only keys the cache does not have are added
+	loadReplace                   // Every existing key is removed first
+)
+
+func (c *Cache) loadSnapshot(r io.Reader, mode loadMode) (int, uint64, error) {
+	var snap snapshotFile
+	if err := gob.NewDecoder(r).Decode(&snap); err != nil {
+		return 0, 0, fmt.Errorf("decoding snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return 0, 0, fmt.Errorf("unsupported snapshot version %d", snap.Version)
+	}
+
+	now := time.Now().UnixNano()
+	loaded := 0
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// Keep versions increasing, so the next write never reuses a version from the snapshot
+	for _, item := range snap.Items {
+		if item.Version > c.lastVersion {
+			c.lastVersion = item.Version
+		}
+	}
+	if mode == loadReplace {
+		c.items = make(map[string]CacheItem, len(snap.Items))
+		c.record(ChangeFlush, "", CacheItem{})
+	}
+	for key, item := range snap.Items {
+		if item.Expired(now) {
+			continue
+		}
+		if _, found := c.lookup(key); found && mode == loadMissing {
+			continue
+		}
+		// A replaced cache is a copy of the cache the snapshot came from (i.e. a replica of a primary) and keeps its
+		// versions. Otherwise the version was given by another cache, or before a restart, and an If-Match holding
+		// it must not match the loaded item, so it gets a version never handed out before.
+		if mode != loadReplace {
+			c.lastVersion++
+			item.Version = c.lastVersion
+		}
+		c.items[key] = item
+		c.record(ChangeSet, key, item)
+		loaded++
+	}
+	return loaded, snap.Seq, nil
+}
+
+// Will save a snapshot to path. The data is written to a temporary file first and then renamed,
+// so a crash in the middle never leaves a half written snapshot behind.
+func (c *Cache) SaveSnapshotFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // No-op once the rename succeeded
+
+	if err := c.SaveSnapshot(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Will load a snapshot from path. See LoadSnapshot.
+func (c *Cache) LoadSnapshotFile(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return c.LoadSnapshot(f)
+}
@@ -0,0 +1,109 @@
+package cache
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Will test that a snapshot round trips values with their Go types and skips expired items
+func TestSnapshotRoundTrip(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("string", "value", time.Minute)
+	c.Set("bytes", []byte{1, 2, 3}, time.Minute)
+	c.Set("int", 42, time.Minute)
+	c.Set("expired", "gone", -time.Second)
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCache()
+	defer restored.Stop()
+	loaded, err := restored.LoadSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 3 {
+		t.Errorf("Expected 3 items to be loaded, got %d", loaded)
+	}
+
+	if v, _ := restored.Get("string"); v != "value" {
+		t.Errorf("Expected value, got %v", v)
+	}
+	if v, _ := restored.Get("bytes"); !bytes.Equal(v.([]byte), []byte{1, 2, 3}) {
+		t.Errorf("Expected bytes to round trip, got %v", v)
+	}
+	if v, _ := restored.Get("int"); v != 42 {
+		t.Errorf("Expected 42, got %v (%T)", v, v)
+	}
+	if _, found := restored.Get("expired"); found {
+		t.Error("Expected expired item not to be restored")
+	}
+
+	// Loading is not a Set
+	if sets := restored.GetStats()["sets"]; sets != 0 {
+		t.Errorf("Expected sets stat to be 0, got %d", sets)
+	}
+}
+
+// Will test saving to and loading from a file
+func TestSnapshotFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	c := NewCache()
+	defer c.Stop()
+	c.Set("key", "value", time.Minute)
+	if err := c.SaveSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewCache()
+	defer restored.Stop()
+	if _, err := restored.LoadSnapshotFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := restored.Get("key"); !found || v != "value" {
+		t.Errorf("Expected key to be restored, got %v", v)
+	}
+
+	if _, err := restored.LoadSnapshotFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("Expected error for missing snapshot file")
+	}
+}
+
+func TestSnapshotKeysAndMerge(t *testing.T) {
+	src := NewCache()
+	defer src.Stop()
+	src.Set("user:1", "a", NoExpiration)
+	src.Set("user:2", "b", NoExpiration)
+	src.Set("order:1", "c", NoExpiration)
+
+	var buf bytes.Buffer
+	if err := src.SaveSnapshotKeys(&buf, func(key string) bool { return MatchPattern("user:*", key) }); err != nil {
+		t.Fatal(err)
+	}
+
+	dst := NewCache()
+	defer dst.Stop()
+	dst.Set("user:1", "mine", NoExpiration)
+	loaded, err := dst.MergeSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded != 1 {
+		t.Errorf("Expected 1 item to be added, got %d", loaded)
+	}
+	if v, _ := dst.Get("user:1"); v != "mine" {
+		t.Errorf("Expected the existing key to be kept, got %v", v)
+	}
+	if v, _ := dst.Get("user:2"); v != "b" {
+		t.Errorf("Expected user:2 to be added, got %v", v)
+	}
+	if _, found := dst.Get("order:1"); found {
+		t.Errorf("Expected keys left out by match not to be in the snapshot")
+	}
+}
@@ -0,0 +1,154 @@
+package cache
+
+import (
+	"sort"
+	"time"
+)
+
+// A member of a sorted set with its score
+type ZMember struct {
+	Member string  `json:"member"`
+	Score  float64 `json:"score"`
+}
+
+// A set of strings ordered by score, then by member for equal scores. Stored by ZAdd, see types.go for how it is updated.
+type SortedSet []ZMember
+
+// Will return the sorted set at key, and ErrWrongType if key holds something else. Missing keys give a nil SortedSet.
+func asSortedSet(value interface{}) (SortedSet, error) {
+	if value == nil {
+		return nil, nil
+	}
+	zset, ok := value.(SortedSet)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return zset, nil
+}
+
+func (a ZMember) less(b ZMember) bool {
+	return a.Score < b.Score || (a.Score == b.Score && a.Member < b.Member)
+}
+
+// Will return the index of member, or -1
+func (z SortedSet) index(member string) int {
+	for i, m := range z {
+		if m.Member == member {
+			return i
+		}
+	}
+	return -1
+}
+
+// Will add members to the sorted set at key, or update their score when they are in it already.
+// Returns how many members were new.
+func (c *Cache) ZAdd(key string, members ...ZMember) (int, error) {
+	return c.ZAddWithTTL(key, KeepTTL, members...)
+}
+
+// Same as ZAdd, also setting the expiration of the key to duration (or none with NoExpiration)
+func (c *Cache) ZAddWithTTL(key string, duration time.Duration, members ...ZMember) (int, error) {
+	var added int
+	var err error
+	c.modify(key, duration, func(value interface{}) (interface{}, bool) {
+		var zset SortedSet
+		if zset, err = asSortedSet(value); err != nil || len(members) == 0 {
+			return nil, false
+		}
+		next := append(make(SortedSet, 0, len(zset)+len(members)), zset...)
+		indexes := make(map[string]int, len(next))
+		for i, m := range next {
+			indexes[m.Member] = i
+		}
+		for _, m := range members {
+			if i, exists := indexes[m.Member]; exists {
+				next[i].Score = m.Score
+			} else {
+				indexes[m.Member] = len(next)
+				next = append(next, m)
+				added++
+			}
+		}
+		sort.Slice(next, func(i, j int) bool { return next[i].less(next[j]) })
+		return next, true
+	})
+	return added, err
+}
+
+// Will remove members from the sorted set at key, and return how many were in it
+func (c *Cache) ZRem(key string, members ...string) (int, error) {
+	var removed int
+	var err error
+	c.modify(key, KeepTTL, func(value interface{}) (interface{}, bool) {
+		var zset SortedSet
+		if zset, err = asSortedSet(value); err != nil {
+			return nil, false
+		}
+		remove := make(map[string]bool, len(members))
+		for _, member := range members {
+			remove[member] = true
+		}
+		next := make(SortedSet, 0, len(zset))
+		for _, m := range zset {
+			if remove[m.Member] {
+				removed++
+			} else {
+				next = append(next, m)
+			}
+		}
+		if removed == 0 {
+			return nil, false
+		}
+		if len(next) == 0 {
+			return nil, true
+		}
+		return next, true
+	})
+	return removed, err
+}
+
+// Will return the score of member in the sorted set at key, and false when it is not in it
+func (c *Cache) ZScore(key, member string) (float64, bool, error) {
+	zset, err := asSortedSet(c.view(key))
+	if err != nil {
+		return 0, false, err
+	}
+	if i := zset.index(member); i >= 0 {
+		return zset[i].Score, true, nil
+	}
+	return 0, false, nil
+}
+
+// Will return the members of the sorted set at key ranked start to stop (lowest score first), both included.
+// Negative ranks count from the end, so ZRange(key, 0, -1) returns every member and ZRange(key, -3, -1) the top 3.
+func (c *Cache) ZRange(key string, start, stop int) ([]ZMember, error) {
+	zset, err := asSortedSet(c.view(key))
+	if err != nil {
+		return nil, err
+	}
+	start, stop, ok := rangeIndexes(len(zset), start, stop)
+	if !ok {
+		return []ZMember{}, nil
+	}
+	return append([]ZMember{}, zset[start:stop+1]...), nil
+}
+
+// Will return the members of the sorted set at key with a score between min and max, both included, lowest first
+func (c *Cache) ZRangeByScore(key string, min, max float64) ([]ZMember, error) {
+	zset, err := asSortedSet(c.view(key))
+	if err != nil {
+		return nil, err
+	}
+	from := sort.Search(len(zset), func(i int) bool { return zset[i].Score >= min })
+	to := sort.Search(len(zset), func(i int) bool { return zset[i].Score > max })
+	if from >= to {
+		return []ZMember{}, nil
+	}
+	return append([]ZMember{}, zset[from:to]...), nil
+}
+
+// Will return the number of members in the sorted set at key
+func (c *Cache) ZCard(key string) (int, error) {
+	zset, err := asSortedSet(c.view(key))
+	return len(zset), err
+}
@@ -0,0 +1,43 @@
+package cache
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortedSet(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	n, err := c.ZAdd("board", ZMember{"carol", 30}, ZMember{"alice", 10}, ZMember{"bob", 20}, ZMember{"dave", 20})
+	if err != nil || n != 4 {
+		t.Fatalf("Unexpected ZAdd result: %d %v", n, err)
+	}
+	if n, _ := c.ZAdd("board", ZMember{"alice", 40}); n != 0 {
+		t.Errorf("Expected a score update not to count as new, got %d", n)
+	}
+
+	all, _ := c.ZRange("board", 0, -1)
+	want := []ZMember{{"bob", 20}, {"dave", 20}, {"carol", 30}, {"alice", 40}}
+	if !reflect.DeepEqual(all, want) {
+		t.Errorf("Expected members by score then name, got %v", all)
+	}
+	if top, _ := c.ZRange("board", -2, -1); !reflect.DeepEqual(top, want[2:]) {
+		t.Errorf("Expected the top 2, got %v", top)
+	}
+	if got, _ := c.ZRangeByScore("board", 20, 30); !reflect.DeepEqual(got, want[:3]) {
+		t.Errorf("Expected scores 20 to 30 included, got %v", got)
+	}
+	if got, _ := c.ZRangeByScore("board", 50, 60); len(got) != 0 {
+		t.Errorf("Expected no members above 50, got %v", got)
+	}
+	if score, found, _ := c.ZScore("board", "alice"); !found || score != 40 {
+		t.Errorf("Expected 40, got %v", score)
+	}
+
+	if n, _ := c.ZRem("board", "bob", "missing"); n != 1 {
+		t.Errorf("Expected 1 member removed, got %d", n)
+	}
+	if n, _ := c.ZCard("board"); n != 3 {
+		t.Errorf("Expected 3 members, got %d", n)
+	}
+}
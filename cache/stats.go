@@ -12,6 +12,7 @@ type Stats struct {
 	Sets		uint64
 	Deletes		uint64
 	Expirations	uint64
+	WatchDrops	uint64 // Events that could not be delivered to a slow watcher
 }
 
 // Make a new Stats struct with zero-values
@@ -32,6 +33,7 @@ func (s *Stats) IncrementMisses() 			{ atomic.AddUint64(&s.Misses, 1) }
 func (s *Stats) IncrementSets() 			{ atomic.AddUint64(&s.Sets, 1) }
 func (s *Stats) IncrementDeletes() 			{ atomic.AddUint64(&s.Deletes, 1) }
 func (s *Stats) IncrementExpirations() 		{ atomic.AddUint64(&s.Expirations, 1) }
+func (s *Stats) IncrementWatchDrops() 		{ atomic.AddUint64(&s.WatchDrops, 1) }
 
 // Will return a map of Stats for the struct, using atomic package to read from struct
 func (s *Stats) GetStats() map[string]uint64 {
@@ -41,5 +43,6 @@ func (s *Stats) GetStats() map[string]uint64 {
 		"sets":			atomic.LoadUint64(&s.Sets),
 		"deletes":		atomic.LoadUint64(&s.Deletes),
 		"expirations":	atomic.LoadUint64(&s.Expirations),
+		"watch_drops":	atomic.LoadUint64(&s.WatchDrops),
 	}
 }
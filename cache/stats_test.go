@@ -10,7 +10,7 @@ func TestNewStats(t *testing.T) {
 		t.Fatal("Expected NewStats to return a non-nil value")
 	}
 
-	if stats.Hits != 0 || stats.Misses != 0 || stats.Sets != 0 || stats.Deletes != 0 || stats.Expirations != 0 {
+	if stats.Hits != 0 || stats.Misses != 0 || stats.Sets != 0 || stats.Deletes != 0 || stats.Expirations != 0 || stats.WatchDrops != 0 {
 		t.Error("Expected all stats to be initialized to 0")
 	}
 }
@@ -39,6 +39,10 @@ func TestStatsIncrement(t *testing.T) {
 	if stats.Expirations != 1 {
 		t.Errorf("Expected Expirations to be 1, got %d", stats.Expirations)
 	}
+	stats.IncrementWatchDrops()
+	if stats.WatchDrops != 1 {
+		t.Errorf("Expected WatchDrops to be 1, got %d", stats.WatchDrops)
+	}
 }
 
 func TestGetStats(t *testing.T) {
@@ -49,6 +53,7 @@ func TestGetStats(t *testing.T) {
 	stats.IncrementSets()
 	stats.IncrementDeletes()
 	stats.IncrementExpirations()
+	stats.IncrementWatchDrops()
 
 	result := stats.GetStats()
 
@@ -58,6 +63,7 @@ func TestGetStats(t *testing.T) {
 		"sets":			1,
 		"deletes":		1,
 		"expirations":	1,
+		"watch_drops":	1,
 	}
 
 	for key, value := range expected {
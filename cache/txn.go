@@ -0,0 +1,192 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// Transactions apply several writes all-or-nothing, like MULTI/EXEC.
+//
+// Writes made through a Tx are buffered, and only applied once fn returns nil: all at once, while the cache is
+// locked, so nobody ever sees part of them. Reads go to the cache (and see the transaction's own writes) without
+// locking anything. Like Redis WATCH, the keys passed to Watch are checked on commit, and the transaction aborts
+// with ErrTxnConflict when one of them was written, deleted or expired since it was watched. With
+// TxnOptions.WatchReads every key read is watched, so a transaction that commits acted on values that were
+// still current (optimistic concurrency).
+//
+// Watching compares versions, so changing only the expiration of a watched key (Expire, Persist) is not a conflict.
+
+// Returned by Txn when a watched key changed before the transaction could commit
+var ErrTxnConflict = errors.New("transaction aborted, a watched key changed")
+
+// Settings of a transaction
+type TxnOptions struct {
+	WatchReads bool // Watch every key read through the Tx, as if passed to Watch
+	Retries    int  // How many times fn runs again after a conflict before Txn gives up with ErrTxnConflict
+}
+
+// Options used by Txn: only keys passed to Watch are checked, and a conflict is returned right away
+var DefaultTxnOptions = TxnOptions{}
+
+// The version a watched key had, and whether it existed
+type watchedKey struct {
+	version uint64
+	found   bool
+}
+
+// A buffered write, deleted is true for a Delete
+type txWrite struct {
+	item    CacheItem
+	deleted bool
+}
+
+// Reads and buffers writes for a transaction, see Txn. A Tx is not safe for concurrent use.
+type Tx struct {
+	c       *Cache
+	opts    TxnOptions
+	watched map[string]watchedKey
+	writes  map[string]txWrite
+	order   []string // Keys in the order they were first written, changes are recorded in that order
+}
+
+// Will run fn and apply its writes atomically when it returns nil, with DefaultTxnOptions.
+// Returns the error of fn (nothing is applied then), ErrTxnConflict, or nil once the writes are applied.
+func (c *Cache) Txn(fn func(tx *Tx) error) error {
+	return c.TxnWithOptions(DefaultTxnOptions, fn)
+}
+
+// Same as Txn with custom options. On a conflict, fn runs again with a new Tx up to opts.Retries times, so it
+// must not have side effects outside of the Tx.
+func (c *Cache) TxnWithOptions(opts TxnOptions, fn func(tx *Tx) error) error {
+	for attempt := 0; ; attempt++ {
+		tx := &Tx{
+			c:       c,
+			opts:    opts,
+			watched: make(map[string]watchedKey),
+			writes:  make(map[string]txWrite),
+		}
+		if err := fn(tx); err != nil {
+			return err
+		}
+		err := tx.commit()
+		if !errors.Is(err, ErrTxnConflict) || attempt >= opts.Retries {
+			return err
+		}
+	}
+}
+
+// Will return the value of key, seeing the writes made earlier in the transaction
+func (tx *Tx) Get(key string) (interface{}, bool) {
+	item, found := tx.GetItem(key)
+	if !found {
+		return nil, false
+	}
+	return item.Value, true
+}
+
+// Same as Get, but returns the whole CacheItem. Items written in the transaction have version 0 until it
+// commits, and their new version after.
+func (tx *Tx) GetItem(key string) (CacheItem, bool) {
+	if w, ok := tx.writes[key]; ok {
+		if w.deleted {
+			return CacheItem{}, false
+		}
+		return w.item, true
+	}
+	item, found := tx.c.GetItem(key)
+	if tx.opts.WatchReads {
+		tx.watch(key, watchedKey{version: item.Version, found: found})
+	}
+	return item, found
+}
+
+// Will make the transaction abort if any of keys changes before it commits
+func (tx *Tx) Watch(keys ...string) {
+	for _, key := range keys {
+		item, found := tx.c.Peek(key)
+		tx.watch(key, watchedKey{version: item.Version, found: found})
+	}
+}
+
+// Same as Watch, but with a version read earlier (i.e. from an ETag): the transaction aborts unless key still
+// has it. Version 0 means the key must not exist.
+func (tx *Tx) WatchVersion(key string, version uint64) {
+	tx.watch(key, watchedKey{version: version, found: version != 0})
+}
+
+// Will keep the first version seen for key, later reads must not hide a change that happened in between
+func (tx *Tx) watch(key string, w watchedKey) {
+	if _, ok := tx.watched[key]; !ok {
+		tx.watched[key] = w
+	}
+}
+
+// Will buffer a Set, applied on commit
+func (tx *Tx) Set(key string, value interface{}, duration time.Duration) {
+	tx.SetWithMetadata(key, value, duration, nil)
+}
+
+// Will buffer a SetWithMetadata, applied on commit. The expiration counts from now, not from the commit.
+func (tx *Tx) SetWithMetadata(key string, value interface{}, duration time.Duration, metadata map[string]string) {
+	tx.write(key, txWrite{item: CacheItem{Value: value, Expiration: expirationFor(duration), Metadata: metadata}})
+}
+
+// Will buffer a Delete, applied on commit
+func (tx *Tx) Delete(key string) {
+	tx.write(key, txWrite{deleted: true})
+}
+
+func (tx *Tx) write(key string, w txWrite) {
+	if _, ok := tx.writes[key]; !ok {
+		tx.order = append(tx.order, key)
+	}
+	tx.writes[key] = w
+}
+
+// Will check the watched keys and apply the writes, all under the cache lock.
+// Every write is recorded as its own change, so journals and replicas get them one by one.
+func (tx *Tx) commit() error {
+	c := tx.c
+	var events []Event
+	sets, deletes := 0, 0
+
+	c.mu.Lock()
+	for key, watched := range tx.watched {
+		current, found := c.lookup(key)
+		if found != watched.found || current.Version != watched.version {
+			c.mu.Unlock()
+			return ErrTxnConflict
+		}
+	}
+	for _, key := range tx.order {
+		w := tx.writes[key]
+		if w.deleted {
+			deletes++
+			if _, found := c.items[key]; !found {
+				continue
+			}
+			delete(c.items, key)
+			c.record(ChangeDelete, key, CacheItem{})
+			events = append(events, Event{Type: EventDelete, Key: key})
+			continue
+		}
+		c.lastVersion++
+		w.item.Version = c.lastVersion
+		tx.writes[key] = w
+		c.items[key] = w.item
+		c.record(ChangeSet, key, w.item)
+		sets++
+		events = append(events, Event{Type: EventSet, Key: key, Value: w.item.Value})
+	}
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	for ; sets > 0; sets-- {
+		c.stats.IncrementSets()
+	}
+	for ; deletes > 0; deletes-- {
+		c.stats.IncrementDeletes()
+	}
+	c.watchers.publish(turn, events...)
+	return nil
+}
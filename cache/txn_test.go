@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Will test that writes are only visible once the transaction commits, and all together
+func TestTxnCommit(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("a", 10, time.Minute)
+	c.Set("gone", "x", time.Minute)
+
+	err := c.Txn(func(tx *Tx) error {
+		tx.Set("a", 5, time.Minute)
+		tx.Set("b", 5, NoExpiration)
+		tx.Delete("gone")
+		if v, _ := tx.Get("a"); v != 5 {
+			t.Errorf("Expected the transaction to see its own write, got %v", v)
+		}
+		if _, found := tx.Get("gone"); found {
+			t.Error("Expected the transaction to see its own delete")
+		}
+		if v, _ := c.Get("a"); v != 10 {
+			t.Errorf("Expected the write to stay buffered until commit, got %v", v)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, _ := c.GetItem("a")
+	b, _ := c.GetItem("b")
+	if a.Value != 5 || b.Value != 5 {
+		t.Errorf("Unexpected values after commit: %v, %v", a.Value, b.Value)
+	}
+	if a.Version == 0 || b.Version <= a.Version {
+		t.Errorf("Expected new increasing versions, got %d and %d", a.Version, b.Version)
+	}
+	if _, found := c.Get("gone"); found {
+		t.Error("Expected gone to be deleted")
+	}
+}
+
+// Will test that an error from fn discards every write
+func TestTxnRollback(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	boom := errors.New("boom")
+
+	err := c.Txn(func(tx *Tx) error {
+		tx.Set("a", 1, time.Minute)
+		return boom
+	})
+	if err != boom {
+		t.Errorf("Expected the error of fn, got %v", err)
+	}
+	if _, found := c.Get("a"); found {
+		t.Error("Expected nothing to be written")
+	}
+}
+
+// Will test that a watched key changing, appearing or disappearing aborts the commit
+func TestTxnWatchConflict(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("watched", 1, time.Minute)
+
+	changes := map[string]func(){
+		"set":    func() { c.Set("watched", 2, time.Minute) },
+		"delete": func() { c.Delete("watched") },
+		"create": func() { c.Set("new", 1, time.Minute) },
+	}
+	for name, change := range changes {
+		err := c.Txn(func(tx *Tx) error {
+			tx.Watch("watched", "new")
+			change()
+			tx.Set("result", name, time.Minute)
+			return nil
+		})
+		if !errors.Is(err, ErrTxnConflict) {
+			t.Errorf("%s: expected a conflict, got %v", name, err)
+		}
+		if _, found := c.Get("result"); found {
+			t.Errorf("%s: expected nothing to be written", name)
+		}
+		c.Delete("new")
+		c.Set("watched", 1, time.Minute)
+	}
+
+	// Expiration changes are not conflicts
+	err := c.Txn(func(tx *Tx) error {
+		tx.Watch("watched")
+		c.Expire("watched", time.Hour)
+		tx.Set("result", "ok", time.Minute)
+		return nil
+	})
+	if err != nil {
+		t.Errorf("Expected the commit to pass, got %v", err)
+	}
+}
+
+// Will test WatchVersion with a version read earlier, and 0 for a key that must be missing
+func TestTxnWatchVersion(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	item, _ := c.SetIf("key", "v1", time.Minute, nil, nil)
+
+	if err := c.Txn(func(tx *Tx) error {
+		tx.WatchVersion("key", item.Version+1)
+		return nil
+	}); !errors.Is(err, ErrTxnConflict) {
+		t.Errorf("Expected a stale version to conflict, got %v", err)
+	}
+	if err := c.Txn(func(tx *Tx) error {
+		tx.WatchVersion("key", item.Version)
+		tx.WatchVersion("missing", 0)
+		tx.Set("key", "v2", time.Minute)
+		return nil
+	}); err != nil {
+		t.Errorf("Expected the current versions to pass, got %v", err)
+	}
+}
+
+// Will test that optimistic transactions retry on conflict, so concurrent increments are never lost
+func TestTxnWatchReadsRetries(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("counter", 0, NoExpiration)
+	opts := TxnOptions{WatchReads: true, Retries: 1000}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				err := c.TxnWithOptions(opts, func(tx *Tx) error {
+					v, _ := tx.Get("counter")
+					tx.Set("counter", v.(int)+1, NoExpiration)
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := c.Get("counter"); v != 200 {
+		t.Errorf("Expected 200 increments, got %v", v)
+	}
+}
+
+// Will test that a committed transaction notifies watchers and reaches the journal
+func TestTxnEvents(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("old", 1, time.Minute)
+	journal := &testJournal{}
+	c.SetJournal(journal)
+	events, cancel := c.Watch("")
+	defer cancel()
+
+	c.Txn(func(tx *Tx) error {
+		tx.Set("new", 1, time.Minute)
+		tx.Delete("old")
+		return nil
+	})
+
+	for _, want := range []Event{{Type: EventSet, Key: "new", Value: 1}, {Type: EventDelete, Key: "old"}} {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Errorf("Expected %+v, got %+v", want, ev)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for an event")
+		}
+	}
+	if changes := journal.recorded(); len(changes) != 2 || changes[0].Type != ChangeSet || changes[1].Type != ChangeDelete {
+		t.Errorf("Unexpected journal: %+v", changes)
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"errors"
+	"time"
+)
+
+// Lists, hashes, sets and sorted sets stored as the value of a key, changed one element at a time.
+//
+// The structures are never changed in place: every write stores a changed copy, under the cache lock. A value
+// returned by Get, sent to watchers or recorded in the journal therefore never changes after the fact, like any
+// other value. The cost is that a write copies the whole structure, which suits collections of up to a few
+// thousand elements.
+//
+// A write on a missing key creates it without expiration, a write on an existing key keeps its expiration
+// (use Expire to change it, or the WithTTL variant of the write to set it in the same write). A key whose last element is removed is deleted. Using a key holding another type
+// fails with ErrWrongType, reading a missing key gives an empty result.
+
+// Passed as the ttl of a write to keep the expiration the key already has
+const KeepTTL time.Duration = -2
+
+// Returned when a key holds a value of another type than the operation works on
+var ErrWrongType = errors.New("value holds another type")
+
+// Will atomically replace the value at key with the result of fn. fn gets the current value (nil when the key
+// is missing) and returns the new value, or nil to delete the key, and false to leave the key unchanged.
+// The new value expires after ttl, or keeps the current expiration with KeepTTL.
+func (c *Cache) modify(key string, ttl time.Duration, fn func(value interface{}) (interface{}, bool)) {
+	c.mu.Lock()
+	current, found := c.lookup(key)
+	var value interface{}
+	if found {
+		value = current.Value
+	}
+	next, changed := fn(value)
+	if !changed {
+		c.mu.Unlock()
+		return
+	}
+
+	if next == nil {
+		if !found {
+			c.mu.Unlock()
+			return
+		}
+		delete(c.items, key)
+		c.record(ChangeDelete, key, CacheItem{})
+		turn := c.watchers.reserve()
+		c.mu.Unlock()
+
+		c.stats.IncrementDeletes()
+		c.watchers.publish(turn, Event{Type: EventDelete, Key: key})
+		return
+	}
+
+	expiration := current.Expiration
+	if ttl != KeepTTL {
+		expiration = expirationFor(ttl)
+	}
+	c.lastVersion++
+	item := CacheItem{
+		Value:      next,
+		Expiration: expiration,
+		Metadata:   current.Metadata,
+		Version:    c.lastVersion,
+	}
+	c.items[key] = item
+	c.record(ChangeSet, key, item)
+	turn := c.watchers.reserve()
+	c.mu.Unlock()
+
+	c.stats.IncrementSets()
+	c.watchers.publish(turn, Event{Type: EventSet, Key: key, Value: next})
+}
+
+// Will return the value at key without copying it, for reads that only look at it. Counts a hit or miss.
+func (c *Cache) view(key string) interface{} {
+	value, _ := c.Get(key)
+	return value
+}
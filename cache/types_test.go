@@ -0,0 +1,114 @@
+package cache
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Will test what every structure shares: expirations are kept, emptied keys are deleted, stored values never
+// change after the fact, and the types survive a snapshot
+func TestTypes(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	events, cancel := c.WatchWithOptions("*", WatchOptions{IncludeValue: true, BufferSize: 16})
+	defer cancel()
+
+	c.RPush("list", "a", "b")
+	c.Expire("list", time.Minute)
+	before, _ := c.Get("list")
+	c.RPush("list", "c")
+	if !reflect.DeepEqual(before, List{"a", "b"}) {
+		t.Errorf("Expected a value read earlier not to change, got %v", before)
+	}
+	item, _ := c.Peek("list")
+	if _, expires := item.TTL(); !expires {
+		t.Error("Expected a write to keep the expiration")
+	}
+	if ev := <-events; ev.Type != EventSet || !reflect.DeepEqual(ev.Value, List{"a", "b"}) {
+		t.Errorf("Unexpected event: %+v", ev)
+	}
+
+	c.SAdd("set", "x")
+	c.SRem("set", "x")
+	if _, found := c.Peek("set"); found {
+		t.Error("Expected the key to be deleted with its last member")
+	}
+
+	c.HSet("hash", map[string]string{"f": "v"})
+	c.ZAdd("zset", ZMember{Member: "m", Score: 1})
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	restored := NewCache()
+	defer restored.Stop()
+	if _, err := restored.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := restored.LRange("list", 0, -1); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("Expected the list to be restored, got %v", got)
+	}
+	if score, _, _ := restored.ZScore("zset", "m"); score != 1 {
+		t.Errorf("Expected the sorted set to be restored, got %v", score)
+	}
+	if v, _, _ := restored.HGet("hash", "f"); v != "v" {
+		t.Errorf("Expected the hash to be restored, got %q", v)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("string", "value", NoExpiration)
+	c.RPush("list", "a")
+
+	if _, err := c.LPush("string", "a"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.HSet("list", map[string]string{"f": "v"}); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.SMembers("list"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := c.ZRange("list", 0, -1); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if v, _ := c.Get("string"); v != "value" {
+		t.Errorf("Expected a failed write to leave the key alone, got %v", v)
+	}
+}
+
+// Will test that the WithTTL writes set the expiration in the write itself, with a single change recorded
+func TestWriteWithTTL(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	j := &testJournal{}
+	c.SetJournal(j)
+
+	c.RPushWithTTL("list", time.Minute, "a")
+	c.HSetWithTTL("hash", map[string]string{"f": "v"}, time.Minute)
+	c.SAddWithTTL("set", time.Minute, "x")
+	c.ZAddWithTTL("zset", time.Minute, ZMember{Member: "m", Score: 1})
+	changes := j.recorded()
+	if len(changes) != 4 {
+		t.Fatalf("Expected one change per write, got %+v", changes)
+	}
+	for _, ch := range changes {
+		if ch.Type != ChangeSet || ch.Item.Expiration == 0 {
+			t.Errorf("Expected the change to carry the expiration, got %+v", ch)
+		}
+	}
+
+	c.LPushWithTTL("list", NoExpiration, "b")
+	if item, _ := c.Peek("list"); item.Expiration != 0 {
+		t.Error("Expected NoExpiration to remove the expiration")
+	}
+	c.RPushWithTTL("list", time.Minute, "c")
+	c.RPush("list", "d")
+	if item, _ := c.Peek("list"); item.Expiration == 0 {
+		t.Error("Expected a write without ttl to keep the expiration")
+	}
+}
@@ -0,0 +1,249 @@
+package cache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// The kind of change that happened to a key
+type EventType int
+
+const (
+	EventSet    EventType = iota + 1 // Key was written with Set, or its expiration changed with Expire or Persist
+	EventDelete                      // Key was removed with Delete
+	EventExpire                      // Key was removed by the janitor because its expiration passed
+	EventFlush                       // Every key was removed with Flush, Key is empty
+)
+
+// Will return the lowercase name of the event type, used when events are encoded (i.e. "set", "expire")
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventFlush:
+		return "flush"
+	default:
+		return "unknown"
+	}
+}
+
+// Will turn a name returned by String back into an EventType, used by clients reading encoded events.
+// Returns false for unknown names.
+func ParseEventType(name string) (EventType, bool) {
+	for t := EventSet; t <= EventFlush; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, false
+}
+
+// A single keyspace change delivered to watchers.
+// Value is only filled in for set events, and only when the watcher asked for values (WatchOptions.IncludeValue).
+type Event struct {
+	Type  EventType
+	Key   string
+	Value interface{}
+}
+
+// What the cache should do when a watcher's buffer is full and a new event arrives
+type SlowConsumerPolicy int
+
+const (
+	PolicyDrop       SlowConsumerPolicy = iota // Drop the new event and count it in stats
+	PolicyBlock                                // Wait until the watcher has room. This slows down writers to the cache, and the watcher must not write to it!
+	PolicyDisconnect                           // Close the watcher's channel, the watcher has to subscribe again
+)
+
+// Settings for a single Watch subscription
+type WatchOptions struct {
+	BufferSize   int                // How many events can be queued before the policy kicks in
+	Policy       SlowConsumerPolicy // What to do when the buffer is full
+	IncludeValue bool               // Whether set events carry the value that was written
+}
+
+// Options used by Watch
+var DefaultWatchOptions = WatchOptions{
+	BufferSize:   64,
+	Policy:       PolicyDrop,
+	IncludeValue: true,
+}
+
+// A single subscriber
+type watcher struct {
+	pattern   string
+	opts      WatchOptions
+	ch        chan Event
+	done      chan struct{} // Closed when the watcher is cancelled, unblocks any sender waiting on ch
+	closeOnce sync.Once
+}
+
+// Holds every active watcher. It has its own lock so that publishing never has to hold the cache lock.
+//
+// Events are still published in the order the changes were made: a writer reserves a turn while it holds the
+// cache lock, and waits for the writers with earlier turns to publish before it does.
+type watchHub struct {
+	mu       sync.RWMutex
+	watchers map[uint64]*watcher
+	nextID   uint64
+	stats    *Stats
+
+	nextTurn  uint64 // Next turn to reserve, atomic
+	order     sync.Mutex
+	published uint64     // Turns published so far, guarded by order
+	turnDone  *sync.Cond // Broadcast each time published moves on
+}
+
+func newWatchHub(stats *Stats) *watchHub {
+	h := &watchHub{
+		watchers: make(map[uint64]*watcher),
+		stats:    stats,
+	}
+	h.turnDone = sync.NewCond(&h.order)
+	return h
+}
+
+// Watch subscribes to changes of keys matching pattern (see MatchPattern, an empty pattern matches every key)
+// using DefaultWatchOptions.
+// Returns a channel that receives the events and a cancel func that ends the subscription and closes the channel.
+func (c *Cache) Watch(pattern string) (<-chan Event, func()) {
+	return c.WatchWithOptions(pattern, DefaultWatchOptions)
+}
+
+// Same as Watch, but with custom buffering and slow consumer behaviour.
+func (c *Cache) WatchWithOptions(pattern string, opts WatchOptions) (<-chan Event, func()) {
+	return c.watchers.subscribe(pattern, opts)
+}
+
+func (h *watchHub) subscribe(pattern string, opts WatchOptions) (<-chan Event, func()) {
+	if opts.BufferSize < 0 {
+		opts.BufferSize = 0
+	}
+	w := &watcher{
+		pattern: pattern,
+		opts:    opts,
+		ch:      make(chan Event, opts.BufferSize),
+		done:    make(chan struct{}),
+	}
+
+	h.mu.Lock()
+	id := h.nextID
+	h.nextID++
+	h.watchers[id] = w
+	h.mu.Unlock()
+
+	return w.ch, func() { h.unsubscribe(id, w) }
+}
+
+// Will remove the watcher and close its channel. Safe to call more than once.
+func (h *watchHub) unsubscribe(id uint64, w *watcher) {
+	w.closeOnce.Do(func() {
+		// Closing done first releases a publisher that is blocked sending to this watcher,
+		// otherwise it would hold the read lock forever and we could never take the write lock below.
+		close(w.done)
+
+		h.mu.Lock()
+		delete(h.watchers, id)
+		h.mu.Unlock()
+
+		// Every send happens while holding the read lock, so once we got the write lock nobody can be sending anymore.
+		close(w.ch)
+	})
+}
+
+// Will reserve the turn of a change to publish its events. Must be called while holding the cache lock, and every
+// turn must be published (with no events when there is nothing to report), or later turns would wait forever.
+func (h *watchHub) reserve() uint64 {
+	return atomic.AddUint64(&h.nextTurn, 1) - 1
+}
+
+// Will wait for the earlier turns to be published, then deliver the events of turn.
+// Must not be called while holding the cache lock, since a blocking watcher could be waiting on the cache itself.
+func (h *watchHub) publish(turn uint64, events ...Event) {
+	h.order.Lock()
+	for h.published != turn {
+		h.turnDone.Wait()
+	}
+	h.order.Unlock()
+
+	h.deliver(events)
+
+	h.order.Lock()
+	h.published++
+	h.turnDone.Broadcast()
+	h.order.Unlock()
+}
+
+// Will deliver the events to every matching watcher, applying each watcher's slow consumer policy
+func (h *watchHub) deliver(events []Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	var disconnect []uint64
+
+	h.mu.RLock()
+	for id, w := range h.watchers {
+		for _, ev := range events {
+			// A flush affects every key, so every watcher gets it regardless of the pattern
+			if ev.Type != EventFlush && !MatchPattern(w.pattern, ev.Key) {
+				continue
+			}
+			if !w.opts.IncludeValue {
+				ev.Value = nil
+			}
+			if !h.send(w, ev) {
+				disconnect = append(disconnect, id)
+				break
+			}
+		}
+	}
+	h.mu.RUnlock()
+
+	// Disconnecting takes the write lock, so it has to happen after we let go of the read lock
+	for _, id := range disconnect {
+		h.mu.RLock()
+		w, ok := h.watchers[id]
+		h.mu.RUnlock()
+		if ok {
+			h.unsubscribe(id, w)
+		}
+	}
+}
+
+// Will send one event to a watcher. Returns false if the watcher should be disconnected.
+func (h *watchHub) send(w *watcher, ev Event) bool {
+	select {
+	case <-w.done:
+		return true // Already cancelled, nothing to do
+	default:
+	}
+
+	switch w.opts.Policy {
+	case PolicyBlock:
+		select {
+		case w.ch <- ev:
+		case <-w.done:
+		}
+		return true
+	case PolicyDisconnect:
+		select {
+		case w.ch <- ev:
+			return true
+		default:
+			h.stats.IncrementWatchDrops()
+			return false
+		}
+	default:
+		select {
+		case w.ch <- ev:
+		default:
+			h.stats.IncrementWatchDrops()
+		}
+		return true
+	}
+}
@@ -0,0 +1,216 @@
+package cache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Will read a single event from the channel, or fail the test if nothing arrives in time
+func nextEvent(t *testing.T, ch <-chan Event) Event {
+	t.Helper()
+	select {
+	case ev, ok := <-ch:
+		if !ok {
+			t.Fatal("Expected an event, but the channel was closed")
+		}
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for event")
+	}
+	return Event{}
+}
+
+// Will test that set, delete and expire events reach a watcher with a matching pattern
+func TestWatchEvents(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	events, cancel := c.Watch("user:*")
+	defer cancel()
+
+	c.Set("user:1", "alice", time.Minute)
+	c.Set("session:1", "ignored", time.Minute) // Does not match the pattern
+	c.Delete("user:1")
+	c.Delete("user:missing") // Never existed, so no event
+	c.Set("user:2", "bob", -time.Second)
+	c.deleteExpired()
+
+	ev := nextEvent(t, events)
+	if ev.Type != EventSet || ev.Key != "user:1" || ev.Value != "alice" {
+		t.Errorf("Expected set event for user:1, got %+v", ev)
+	}
+	ev = nextEvent(t, events)
+	if ev.Type != EventDelete || ev.Key != "user:1" {
+		t.Errorf("Expected delete event for user:1, got %+v", ev)
+	}
+	ev = nextEvent(t, events)
+	if ev.Type != EventSet || ev.Key != "user:2" {
+		t.Errorf("Expected set event for user:2, got %+v", ev)
+	}
+	ev = nextEvent(t, events)
+	if ev.Type != EventExpire || ev.Key != "user:2" {
+		t.Errorf("Expected expire event for user:2, got %+v", ev)
+	}
+
+	select {
+	case ev := <-events:
+		t.Errorf("Expected no more events, got %+v", ev)
+	default:
+	}
+}
+
+// Will test that changing a key's expiration is reported as a set, so watchers notice the new TTL
+func TestWatchExpireAndPersist(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	c.Set("key", "value", time.Minute)
+
+	events, cancel := c.Watch("")
+	defer cancel()
+	c.Expire("key", time.Hour)
+	c.Persist("key")
+	c.Persist("key") // No expiration left, nothing changes
+	c.Expire("missing", time.Hour)
+
+	for i := 0; i < 2; i++ {
+		if ev := nextEvent(t, events); ev.Type != EventSet || ev.Key != "key" || ev.Value != "value" {
+			t.Errorf("Expected a set event for key, got %+v", ev)
+		}
+	}
+	select {
+	case ev := <-events:
+		t.Errorf("Expected no more events, got %+v", ev)
+	default:
+	}
+}
+
+// Will test that values are left out when IncludeValue is false
+func TestWatchWithoutValue(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	events, cancel := c.WatchWithOptions("", WatchOptions{BufferSize: 1})
+	defer cancel()
+
+	c.Set("key", "value", time.Minute)
+	if ev := nextEvent(t, events); ev.Value != nil {
+		t.Errorf("Expected no value, got %v", ev.Value)
+	}
+}
+
+// Will test that the drop policy discards events once the buffer is full and counts them
+func TestWatchDropPolicy(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	events, cancel := c.WatchWithOptions("", WatchOptions{BufferSize: 2, Policy: PolicyDrop, IncludeValue: true})
+	defer cancel()
+
+	for i := 0; i < 5; i++ {
+		c.Set("key", i, time.Minute)
+	}
+
+	if got := c.GetStats()["watch_drops"]; got != 3 {
+		t.Errorf("Expected watch_drops stat to be 3, got %d", got)
+	}
+	if ev := nextEvent(t, events); ev.Value != 0 {
+		t.Errorf("Expected first buffered event to have value 0, got %v", ev.Value)
+	}
+}
+
+// Will test that the disconnect policy closes the channel of a watcher that falls behind
+func TestWatchDisconnectPolicy(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	events, cancel := c.WatchWithOptions("", WatchOptions{BufferSize: 1, Policy: PolicyDisconnect})
+	defer cancel() // Calling cancel after a disconnect must be safe
+
+	c.Set("key1", "value", time.Minute)
+	c.Set("key2", "value", time.Minute) // Buffer is full, watcher gets disconnected
+
+	nextEvent(t, events)
+	if _, ok := <-events; ok {
+		t.Error("Expected channel to be closed after disconnect")
+	}
+	if got := c.GetStats()["watch_drops"]; got != 1 {
+		t.Errorf("Expected watch_drops stat to be 1, got %d", got)
+	}
+}
+
+// Will test that the block policy makes the writer wait for the watcher, and that cancel releases a blocked writer
+func TestWatchBlockPolicy(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+
+	events, cancel := c.WatchWithOptions("", WatchOptions{BufferSize: 0, Policy: PolicyBlock})
+
+	done := make(chan struct{})
+	go func() {
+		c.Set("key", "value", time.Minute)
+		c.Set("key", "value2", time.Minute)
+		close(done)
+	}()
+
+	nextEvent(t, events)
+	select {
+	case <-done:
+		t.Fatal("Expected second Set to block until the watcher reads")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Expected cancel to release the blocked writer")
+	}
+
+	if got := c.GetStats()["watch_drops"]; got != 0 {
+		t.Errorf("Expected watch_drops stat to be 0, got %d", got)
+	}
+}
+
+// Will test that every event type survives String and ParseEventType
+// Will test that concurrent writers publish their events in the order their writes were made
+func TestWatchOrder(t *testing.T) {
+	c := NewCache()
+	defer c.Stop()
+	const writers, writes = 8, 500
+	events, cancel := c.WatchWithOptions("counter", WatchOptions{BufferSize: writers * writes, Policy: PolicyDrop, IncludeValue: true})
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < writes; j++ {
+				c.Update("counter", func(item CacheItem, found bool) (CacheItem, bool) {
+					n, _ := item.Value.(int)
+					item.Value = n + 1
+					return item, true
+				})
+			}
+		}()
+	}
+	wg.Wait()
+
+	for want := 1; want <= writers*writes; want++ {
+		if ev := nextEvent(t, events); ev.Value != want {
+			t.Fatalf("Expected the event of write %d, got %v", want, ev.Value)
+		}
+	}
+}
+
+func TestParseEventType(t *testing.T) {
+	for _, typ := range []EventType{EventSet, EventDelete, EventExpire, EventFlush} {
+		if got, ok := ParseEventType(typ.String()); !ok || got != typ {
+			t.Errorf("ParseEventType(%q) = %v, %v", typ.String(), got, ok)
+		}
+	}
+	if _, ok := ParseEventType("nope"); ok {
+		t.Error("Expected unknown name not to parse")
+	}
+}
@@ -0,0 +1,425 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-memory-cache/cache"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client talks to a cache server over the HTTP API. Method names mirror cache.Cache, with a context added
+// for deadlines and an error for network and server failures.
+// Values are sent as JSON, so they come back with their JSON types (i.e. numbers as float64).
+
+// Settings used when creating a Client
+type Options struct {
+	// When set, this client is used as-is and the connection pool settings below are ignored
+	HTTPClient *http.Client
+
+	Timeout time.Duration // Deadline for each request (Watch streams excepted), 0 means only the context's deadline
+
+	// Requests that fail with a network error or a 5xx status are retried with exponential backoff and jitter.
+	// Only idempotent requests are retried, a POST may have been applied before it failed.
+	MaxRetries      int
+	RetryBackoff    time.Duration // Wait before the first retry, doubled for each following retry
+	MaxRetryBackoff time.Duration
+
+	// Connection pool settings for the default transport
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+}
+
+// Options used by NewClient
+var DefaultOptions = Options{
+	Timeout:             5 * time.Second,
+	MaxRetries:          3,
+	RetryBackoff:        50 * time.Millisecond,
+	MaxRetryBackoff:     2 * time.Second,
+	MaxIdleConns:        100,
+	MaxIdleConnsPerHost: 100,
+	IdleConnTimeout:     90 * time.Second,
+}
+
+type Client struct {
+	baseURL string
+	http    *http.Client
+	opts    Options
+}
+
+// Returned for every response with an error status, with the message from the server's JSON error object
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("cache server: %d %s", e.StatusCode, e.Message)
+}
+
+// Creates a client for the server at baseURL (i.e. "http://localhost:8080") with DefaultOptions
+func NewClient(baseURL string) (*Client, error) {
+	return NewClientWithOptions(baseURL, DefaultOptions)
+}
+
+// Creates a client with custom options
+func NewClientWithOptions(baseURL string, opts Options) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("client: unsupported scheme %q", u.Scheme)
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.MaxIdleConns = opts.MaxIdleConns
+		transport.MaxIdleConnsPerHost = opts.MaxIdleConnsPerHost
+		transport.IdleConnTimeout = opts.IdleConnTimeout
+		httpClient = &http.Client{Transport: transport}
+	}
+
+	return &Client{
+		baseURL: strings.TrimRight(u.String(), "/"),
+		http:    httpClient,
+		opts:    opts,
+	}, nil
+}
+
+// Path of a key in the v2 API. PathEscape also escapes '/', so any key is a single path segment.
+func keyPath(key string) string {
+	return "/v2/keys/" + url.PathEscape(key)
+}
+
+// Will send a request, retrying network errors and 5xx responses of idempotent methods. body may be nil.
+// The caller must close the response body.
+func (c *Client) do(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	retries := c.opts.MaxRetries
+	if method == http.MethodPost {
+		retries = 0
+	}
+	var lastErr error
+	for attempt := 0; attempt <= retries; attempt++ {
+		if attempt > 0 {
+			if err := sleep(ctx, c.backoff(attempt)); err != nil {
+				return nil, err
+			}
+		}
+
+		resp, err := c.send(ctx, method, path, body, header)
+		if err != nil {
+			// Don't retry once the caller gave up
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			lastErr = err
+			continue
+		}
+		if resp.StatusCode >= 500 && attempt < retries {
+			lastErr = readError(resp)
+			continue
+		}
+		return resp, nil
+	}
+	return nil, lastErr
+}
+
+// Will send a single attempt of a request
+func (c *Client) send(ctx context.Context, method, path string, body []byte, header http.Header) (*http.Response, error) {
+	var cancel context.CancelFunc = func() {}
+	if c.opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+	}
+
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	// The timeout has to stay active while the caller reads the body, so it is cancelled when the body is closed
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// Will return the wait before a retry: RetryBackoff doubled for each attempt, capped at MaxRetryBackoff,
+// with up to 50% random jitter so many clients don't retry in lockstep
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.RetryBackoff << (attempt - 1)
+	if c.opts.MaxRetryBackoff > 0 && (d > c.opts.MaxRetryBackoff || d <= 0) {
+		d = c.opts.MaxRetryBackoff
+	}
+	if d <= 0 {
+		return 0
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Will read the server's JSON error object (or plain text for the v1 handlers) into an *Error, and close the body
+func readError(resp *http.Response) error {
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+
+	var body struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	message := strings.TrimSpace(string(data))
+	if json.Unmarshal(data, &body) == nil && body.Error.Message != "" {
+		message = body.Error.Message
+	}
+	return &Error{StatusCode: resp.StatusCode, Message: message}
+}
+
+// Will decode a successful JSON response into v, or turn an error status into an *Error
+func decodeResponse(resp *http.Response, v interface{}) error {
+	if resp.StatusCode >= 400 {
+		return readError(resp)
+	}
+	defer resp.Body.Close()
+	if v == nil {
+		io.Copy(io.Discard, resp.Body) // Drain, so the connection goes back to the pool
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+var jsonHeader = http.Header{
+	"Content-Type": {"application/json"},
+	"Accept":       {"application/json"},
+}
+
+// Will turn a TTL into what the server expects: seconds, or nothing for a key that never expires
+func ttlValue(ttl time.Duration) interface{} {
+	if noTTL(ttl) {
+		return nil
+	}
+	return ttl.Seconds()
+}
+
+// The server has no TTL for keys that never expire, which the client asks for with cache.NoExpiration or 0
+func noTTL(ttl time.Duration) bool {
+	return ttl == cache.NoExpiration || ttl == 0
+}
+
+// Will store value (encoded as JSON) under key. Use cache.NoExpiration (or 0) for a key that never expires.
+func (c *Client) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	return c.SetWithMetadata(ctx, key, value, ttl, nil)
+}
+
+// Same as Set, but also stores metadata with the value
+func (c *Client) SetWithMetadata(ctx context.Context, key string, value interface{}, ttl time.Duration, metadata map[string]string) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"value":    value,
+		"ttl":      ttlValue(ttl),
+		"metadata": metadata,
+	})
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(ctx, "PUT", keyPath(key), body, jsonHeader)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, nil)
+}
+
+// Will store data as raw bytes, the way the server stores any non JSON body. contentType is kept and
+// returned with the value, an empty one means application/octet-stream.
+func (c *Client) SetBytes(ctx context.Context, key string, data []byte, contentType string, ttl time.Duration) error {
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	header := http.Header{"Content-Type": {contentType}}
+	if !noTTL(ttl) {
+		header.Set("Cache-TTL", ttl.String())
+	}
+	resp, err := c.do(ctx, "PUT", keyPath(key), data, header)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, nil)
+}
+
+// The JSON view of a key returned by GET /v2/keys/{key}
+type keyResponse struct {
+	Value     interface{}       `json:"value"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	Metadata  map[string]string `json:"metadata"`
+	Version   uint64            `json:"version"`
+	Encoding  string            `json:"encoding"`
+}
+
+// Will return the value stored under key and whether it was found
+func (c *Client) Get(ctx context.Context, key string) (interface{}, bool, error) {
+	item, found, err := c.GetItem(ctx, key)
+	return item.Value, found, err
+}
+
+// Same as Get, but returns the whole item with its expiration, metadata and version.
+// Values stored as raw bytes (i.e. with SetBytes) come back as []byte.
+func (c *Client) GetItem(ctx context.Context, key string) (cache.CacheItem, bool, error) {
+	resp, err := c.do(ctx, "GET", keyPath(key), nil, jsonHeader)
+	if err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return cache.CacheItem{}, false, nil
+	}
+
+	var body keyResponse
+	if err := decodeResponse(resp, &body); err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	if s, ok := body.Value.(string); ok && body.Encoding == "base64" {
+		raw, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return cache.CacheItem{}, false, err
+		}
+		body.Value = raw
+	}
+	item := cache.CacheItem{
+		Value:    body.Value,
+		Metadata: body.Metadata,
+		Version:  body.Version,
+	}
+	if body.ExpiresAt != nil {
+		item.Expiration = body.ExpiresAt.UnixNano()
+	}
+	return item, true, nil
+}
+
+// Will delete key. Deleting a key that does not exist is not an error.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	resp, err := c.do(ctx, "DELETE", keyPath(key), nil, nil)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, nil)
+}
+
+// Will return the server's cache statistics
+func (c *Client) GetStats(ctx context.Context) (map[string]uint64, error) {
+	resp, err := c.do(ctx, "GET", "/stats", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var stats map[string]uint64
+	if err := decodeResponse(resp, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// A single operation of a Batch: "get", "set" or "delete"
+type BatchOp struct {
+	Op       string            `json:"op"`
+	Key      string            `json:"key"`
+	Value    interface{}       `json:"value,omitempty"`
+	TTL      interface{}       `json:"ttl,omitempty"` // Set with BatchSet, which converts the duration
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// Helpers to build batch operations
+func BatchGet(key string) BatchOp    { return BatchOp{Op: "get", Key: key} }
+func BatchDelete(key string) BatchOp { return BatchOp{Op: "delete", Key: key} }
+func BatchSet(key string, value interface{}, ttl time.Duration) BatchOp {
+	return BatchOp{Op: "set", Key: key, Value: value, TTL: ttlValue(ttl)}
+}
+
+// Result of a single batch operation. Err is set when only this operation failed.
+type BatchResult struct {
+	Key   string
+	Found bool
+	Value interface{}
+	Err   error
+}
+
+// Will run several operations in one request. They run in order on the server, but not atomically.
+// The returned error is for the request as a whole, per-operation errors are in the results.
+func (c *Client) Batch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	body, err := json.Marshal(map[string]interface{}{"ops": ops})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, "POST", "/v2/batch", body, jsonHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded struct {
+		Results []struct {
+			Key   string      `json:"key"`
+			Found bool        `json:"found"`
+			Value interface{} `json:"value"`
+			Error string      `json:"error"`
+		} `json:"results"`
+	}
+	if err := decodeResponse(resp, &decoded); err != nil {
+		return nil, err
+	}
+
+	results := make([]BatchResult, len(decoded.Results))
+	for i, r := range decoded.Results {
+		results[i] = BatchResult{Key: r.Key, Found: r.Found, Value: r.Value}
+		if r.Error != "" {
+			results[i].Err = errors.New(r.Error)
+		}
+	}
+	return results, nil
+}
+
+// Will report whether err is a network problem, as opposed to an error status from the server
+func IsNetworkError(err error) bool {
+	var netErr net.Error
+	var urlErr *url.Error
+	return errors.As(err, &netErr) || errors.As(err, &urlErr)
+}
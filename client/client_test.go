@@ -0,0 +1,248 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Will start a real server with the full router, so the client is tested end to end
+func newTestServer(t *testing.T) (*Client, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	srv := httptest.NewServer((&api.Handler{Cache: c}).Routes())
+	t.Cleanup(func() {
+		srv.Close()
+		c.Stop()
+	})
+
+	cl, err := NewClient(srv.URL)
+	if err != nil {
+		t.Fatalf("NewClient returned an error: %v", err)
+	}
+	return cl, c
+}
+
+func TestNewClient(t *testing.T) {
+	if _, err := NewClient("localhost:8080"); err == nil {
+		t.Errorf("Expected an error for a URL without scheme")
+	}
+	if _, err := NewClient("http://localhost:8080/"); err != nil {
+		t.Errorf("NewClient returned an error: %v", err)
+	}
+}
+
+func TestSetGetDelete(t *testing.T) {
+	cl, c := newTestServer(t)
+	ctx := context.Background()
+
+	// Keys with '/' and query characters must survive the round trip
+	key := "user/1?x=&y"
+	if err := cl.Set(ctx, key, map[string]interface{}{"name": "alice"}, time.Minute); err != nil {
+		t.Fatalf("Set returned an error: %v", err)
+	}
+	if _, found := c.Get(key); !found {
+		t.Fatalf("Expected %q to be stored in the cache", key)
+	}
+
+	value, found, err := cl.Get(ctx, key)
+	if err != nil || !found {
+		t.Fatalf("Get returned found=%v, err=%v", found, err)
+	}
+	if m, ok := value.(map[string]interface{}); !ok || m["name"] != "alice" {
+		t.Errorf("Expected value {name: alice}, got %v", value)
+	}
+
+	if err := cl.Delete(ctx, key); err != nil {
+		t.Fatalf("Delete returned an error: %v", err)
+	}
+	if _, found, err := cl.Get(ctx, key); err != nil || found {
+		t.Errorf("Expected key to be gone after Delete, got found=%v, err=%v", found, err)
+	}
+
+	// Deleting a missing key is not an error
+	if err := cl.Delete(ctx, "missing"); err != nil {
+		t.Errorf("Delete of a missing key returned an error: %v", err)
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	cl, c := newTestServer(t)
+	ctx := context.Background()
+
+	if err := cl.SetWithMetadata(ctx, "a", 42, time.Minute, map[string]string{"source": "test"}); err != nil {
+		t.Fatalf("SetWithMetadata returned an error: %v", err)
+	}
+	item, found, err := cl.GetItem(ctx, "a")
+	if err != nil || !found {
+		t.Fatalf("GetItem returned found=%v, err=%v", found, err)
+	}
+	if item.Value != float64(42) {
+		t.Errorf("Expected value 42, got %v (%T)", item.Value, item.Value)
+	}
+	if item.Metadata["source"] != "test" {
+		t.Errorf("Expected metadata to be returned, got %v", item.Metadata)
+	}
+	stored, _ := c.GetItem("a")
+	if item.Version != stored.Version {
+		t.Errorf("Expected version %d, got %d", stored.Version, item.Version)
+	}
+	if ttl, ok := item.TTL(); !ok || ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expected a TTL of up to a minute, got %v", ttl)
+	}
+
+	// NoExpiration is sent as no ttl at all
+	if err := cl.Set(ctx, "b", "forever", cache.NoExpiration); err != nil {
+		t.Fatalf("Set returned an error: %v", err)
+	}
+	item, _, _ = cl.GetItem(ctx, "b")
+	if item.Expiration != 0 {
+		t.Errorf("Expected no expiration, got %d", item.Expiration)
+	}
+
+	// So is 0
+	if err := cl.Set(ctx, "c", "forever", 0); err != nil {
+		t.Fatalf("Set with a ttl of 0 returned an error: %v", err)
+	}
+	if err := cl.SetBytes(ctx, "d", []byte("forever"), "", 0); err != nil {
+		t.Fatalf("SetBytes with a ttl of 0 returned an error: %v", err)
+	}
+	for _, key := range []string{"c", "d"} {
+		if item, found := c.GetItem(key); !found || item.Expiration != 0 {
+			t.Errorf("Expected %s to be stored without expiration, got %+v %v", key, item, found)
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	cl, _ := newTestServer(t)
+
+	// The server rejects non-positive TTLs with a 400, which must not be retried
+	err := cl.Set(context.Background(), "a", 1, -time.Second)
+	var apiErr *Error
+	if !errors.As(err, &apiErr) {
+		t.Fatalf("Expected an *Error, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusBadRequest || apiErr.Message == "" {
+		t.Errorf("Expected a 400 with a message, got %d %q", apiErr.StatusCode, apiErr.Message)
+	}
+	if IsNetworkError(err) {
+		t.Errorf("Expected an error status not to be a network error")
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	cl, _ := newTestServer(t)
+	ctx := context.Background()
+
+	cl.Set(ctx, "a", 1, cache.NoExpiration)
+	cl.Get(ctx, "a")
+	cl.Get(ctx, "missing")
+
+	stats, err := cl.GetStats(ctx)
+	if err != nil {
+		t.Fatalf("GetStats returned an error: %v", err)
+	}
+	if stats["sets"] != 1 || stats["hits"] != 1 || stats["misses"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	cl, _ := newTestServer(t)
+
+	results, err := cl.Batch(context.Background(), []BatchOp{
+		BatchSet("a", "1", time.Minute),
+		BatchGet("a"),
+		BatchDelete("a"),
+		BatchGet("a"),
+		{Op: "unknown", Key: "a"},
+	})
+	if err != nil {
+		t.Fatalf("Batch returned an error: %v", err)
+	}
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+	if !results[1].Found || results[1].Value != "1" {
+		t.Errorf("Expected get to find \"1\", got %+v", results[1])
+	}
+	if results[3].Found {
+		t.Errorf("Expected get after delete to miss, got %+v", results[3])
+	}
+	if results[4].Err == nil {
+		t.Errorf("Expected an error for an unknown operation")
+	}
+}
+
+func TestRetry(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	routes := (&api.Handler{Cache: c}).Routes()
+
+	// Fails the first two requests with a 503, then passes through
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= 2 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		routes.ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	opts := DefaultOptions
+	opts.RetryBackoff = time.Millisecond
+	cl, _ := NewClientWithOptions(srv.URL, opts)
+
+	if err := cl.Set(context.Background(), "a", 1, cache.NoExpiration); err != nil {
+		t.Fatalf("Expected Set to succeed after retries, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	// Once retries run out, the last error status is returned
+	atomic.StoreInt32(&calls, 0)
+	opts.MaxRetries = 1
+	cl, _ = NewClientWithOptions(srv.URL, opts)
+	var apiErr *Error
+	if err := cl.Set(context.Background(), "a", 1, cache.NoExpiration); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 error, got %v", err)
+	}
+
+	// A POST is sent once, it may have been applied before failing
+	atomic.StoreInt32(&calls, 0)
+	if _, err := cl.Batch(context.Background(), []BatchOp{BatchGet("a")}); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected a 503 error, got %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected a single attempt for a POST, got %d", n)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cl, _ := NewClient(srv.URL)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, _, err := cl.Get(ctx, "a")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected Get to give up at the deadline, took %v", time.Since(start))
+	}
+}
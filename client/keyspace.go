@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Will return the keys matching the glob pattern (every key when empty), sorted
+func (c *Client) Keys(ctx context.Context, pattern string) ([]string, error) {
+	resp, err := c.do(ctx, "GET", "/v2/keys?match="+url.QueryEscape(pattern), nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	if err := decodeResponse(resp, &body); err != nil {
+		return nil, err
+	}
+	return body.Keys, nil
+}
+
+// Will remove every key from the server's cache, and return how many there were
+func (c *Client) Flush(ctx context.Context) (int, error) {
+	resp, err := c.do(ctx, "DELETE", "/v2/keys", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	var body struct {
+		Flushed int `json:"flushed"`
+	}
+	if err := decodeResponse(resp, &body); err != nil {
+		return 0, err
+	}
+	return body.Flushed, nil
+}
+
+// Will download a snapshot of the server's cache into w. The format is the one of cache.SaveSnapshot.
+// Retries only happen before the first byte is written to w.
+func (c *Client) SaveSnapshot(ctx context.Context, w io.Writer) error {
+	resp, err := c.do(ctx, "GET", "/v2/snapshot", nil, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return readError(resp)
+	}
+	defer resp.Body.Close()
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// Will upload a snapshot written by SaveSnapshot (or cache.SaveSnapshot) to the server, and return how many items were loaded.
+// The snapshot is read into memory first, so the upload can be retried.
+func (c *Client) LoadSnapshot(ctx context.Context, r io.Reader) (int, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return 0, err
+	}
+	resp, err := c.do(ctx, "PUT", "/v2/snapshot", data, http.Header{"Content-Type": {"application/octet-stream"}})
+	if err != nil {
+		return 0, err
+	}
+	var body struct {
+		Loaded int `json:"loaded"`
+	}
+	if err := decodeResponse(resp, &body); err != nil {
+		return 0, err
+	}
+	return body.Loaded, nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"golang-memory-cache/cache"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestKeysAndFlush(t *testing.T) {
+	cl, c := newTestServer(t)
+	ctx := context.Background()
+
+	c.Set("user:2", 1, cache.NoExpiration)
+	c.Set("user:1", 1, cache.NoExpiration)
+	c.Set("order:1", 1, cache.NoExpiration)
+
+	keys, err := cl.Keys(ctx, "user:*")
+	if err != nil {
+		t.Fatalf("Keys returned an error: %v", err)
+	}
+	if !reflect.DeepEqual(keys, []string{"user:1", "user:2"}) {
+		t.Errorf("Unexpected keys: %v", keys)
+	}
+
+	n, err := cl.Flush(ctx)
+	if err != nil || n != 3 {
+		t.Errorf("Expected Flush to remove 3 keys, got %d, err=%v", n, err)
+	}
+	if keys, _ := cl.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("Expected no keys after Flush, got %v", keys)
+	}
+}
+
+func TestSetBytes(t *testing.T) {
+	cl, c := newTestServer(t)
+	ctx := context.Background()
+
+	data := []byte{0x00, 0xff, 0x10, 'a'}
+	if err := cl.SetBytes(ctx, "blob", data, "image/png", time.Minute); err != nil {
+		t.Fatalf("SetBytes returned an error: %v", err)
+	}
+	if v, _ := c.Get("blob"); !bytes.Equal(v.([]byte), data) {
+		t.Errorf("Expected the raw bytes to be stored, got %v", v)
+	}
+
+	item, found, err := cl.GetItem(ctx, "blob")
+	if err != nil || !found {
+		t.Fatalf("GetItem returned found=%v, err=%v", found, err)
+	}
+	if raw, ok := item.Value.([]byte); !ok || !bytes.Equal(raw, data) {
+		t.Errorf("Expected []byte %v, got %v (%T)", data, item.Value, item.Value)
+	}
+	if item.Metadata["content-type"] != "image/png" {
+		t.Errorf("Expected the content type to be kept, got %v", item.Metadata)
+	}
+	if _, expires := item.TTL(); !expires {
+		t.Errorf("Expected the TTL from the Cache-TTL header")
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cl, c := newTestServer(t)
+	ctx := context.Background()
+	c.Set("a", "hello", cache.NoExpiration)
+
+	var buf bytes.Buffer
+	if err := cl.SaveSnapshot(ctx, &buf); err != nil {
+		t.Fatalf("SaveSnapshot returned an error: %v", err)
+	}
+
+	c.Flush()
+	n, err := cl.LoadSnapshot(ctx, &buf)
+	if err != nil || n != 1 {
+		t.Fatalf("Expected LoadSnapshot to load 1 item, got %d, err=%v", n, err)
+	}
+	if v, _ := c.Get("a"); v != "hello" {
+		t.Errorf("Expected restored value hello, got %v", v)
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Will stream keyspace events for keys matching the glob pattern (every key when empty) over Server-Sent Events.
+// The channel is closed when ctx is cancelled or the connection is lost; reconnecting is left to the caller,
+// since events that happened while disconnected can't be replayed anyway.
+func (c *Client) Watch(ctx context.Context, pattern string) (<-chan cache.Event, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.baseURL+"/watch?match="+url.QueryEscape(pattern), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/event-stream")
+
+	// No per-request Timeout here, the stream is meant to stay open until ctx is cancelled
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, readError(resp)
+	}
+
+	events := make(chan cache.Event, 64)
+	go func() {
+		defer close(events)
+		defer resp.Body.Close()
+		readEvents(ctx, bufio.NewScanner(resp.Body), events)
+	}()
+	return events, nil
+}
+
+// Will parse SSE messages until the stream ends. Comment lines (keep-alives) and unknown event types are skipped.
+func readEvents(ctx context.Context, scanner *bufio.Scanner, events chan<- cache.Event) {
+	scanner.Buffer(make([]byte, 64<<10), maxEventSize)
+
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			// A blank line ends a message
+			if data.Len() > 0 {
+				ev, ok := parseEvent(data.String())
+				data.Reset()
+				if !ok {
+					continue
+				}
+				select {
+				case events <- ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+		// "event:" lines are ignored, the type is also in the JSON data
+	}
+}
+
+// Largest single event accepted, values can be as large as the server allows
+const maxEventSize = 16 << 20
+
+func parseEvent(data string) (cache.Event, bool) {
+	var body struct {
+		Type  string      `json:"type"`
+		Key   string      `json:"key"`
+		Value interface{} `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(data), &body); err != nil {
+		return cache.Event{}, false
+	}
+	typ, ok := cache.ParseEventType(body.Type)
+	if !ok {
+		return cache.Event{}, false
+	}
+	return cache.Event{Type: typ, Key: body.Key, Value: body.Value}, true
+}
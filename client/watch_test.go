@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"golang-memory-cache/cache"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	cl, _ := newTestServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := cl.Watch(ctx, "user:*")
+	if err != nil {
+		t.Fatalf("Watch returned an error: %v", err)
+	}
+
+	cl.Set(ctx, "other", 1, cache.NoExpiration)
+	cl.Set(ctx, "user:1", "alice", cache.NoExpiration)
+	cl.Delete(ctx, "user:1")
+
+	expected := []cache.Event{
+		{Type: cache.EventSet, Key: "user:1", Value: "alice"},
+		{Type: cache.EventDelete, Key: "user:1"},
+	}
+	for _, want := range expected {
+		select {
+		case ev := <-events:
+			if ev != want {
+				t.Errorf("Expected event %+v, got %+v", want, ev)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Timed out waiting for %+v", want)
+		}
+	}
+
+	// Cancelling the context closes the channel
+	cancel()
+	select {
+	case _, ok := <-events:
+		for ok {
+			_, ok = <-events
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("Expected the channel to be closed after cancel")
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := ": keep-alive\n\n" +
+		"event: set\ndata: {\"type\":\"set\",\"key\":\"a\",\"value\":1}\n\n" +
+		"event: bogus\ndata: {\"type\":\"bogus\",\"key\":\"b\"}\n\n" +
+		"data: not json\n\n" +
+		"event: flush\ndata: {\"type\":\"flush\",\"key\":\"\"}\n\n"
+
+	events := make(chan cache.Event, 10)
+	readEvents(context.Background(), bufio.NewScanner(strings.NewReader(stream)), events)
+	close(events)
+
+	var got []cache.Event
+	for ev := range events {
+		got = append(got, ev)
+	}
+	if len(got) != 2 {
+		t.Fatalf("Expected 2 events, got %d: %+v", len(got), got)
+	}
+	if got[0] != (cache.Event{Type: cache.EventSet, Key: "a", Value: float64(1)}) {
+		t.Errorf("Unexpected first event: %+v", got[0])
+	}
+	if got[1].Type != cache.EventFlush {
+		t.Errorf("Expected a flush event, got %+v", got[1])
+	}
+}
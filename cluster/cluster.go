@@ -0,0 +1,400 @@
+package cluster
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/queue"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A Cluster spreads keys over several cache nodes. Every node runs the same api.Handler wrapped by
+// Cluster.Handler, which serves the keys this node owns and forwards every other key request to its owner,
+// so clients can talk to any node.
+//
+// Nodes are identified by the base URL other nodes reach them at (i.e. "http://10.0.0.1:8080").
+// Only requests that address a single key (and batches, split per owner) are forwarded.
+// Stats, watch, key listing, flush and snapshots stay local to the node that receives them.
+
+// Set on forwarded requests. A node never forwards a request that carries it, so nodes that briefly
+// disagree on membership (i.e. during a reload) serve the request instead of bouncing it around.
+const ForwardedHeader = "X-Cache-Forwarded-By"
+
+// Largest batch body split by the cluster, same as the limit of the v2 API
+const maxBatchBody = 10 << 20
+
+// Settings used when creating a Cluster
+type Options struct {
+	Replicas   int          // Virtual nodes per node on the hash ring, DefaultReplicas when 0
+	HTTPClient *http.Client // Used to forward requests, http.DefaultClient when nil
+}
+
+// Options used by NewCluster
+var DefaultOptions = Options{
+	Replicas: DefaultReplicas,
+}
+
+type Cluster struct {
+	self    string
+	ring    *Ring
+	client  *http.Client
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy // One per peer, reused so connections are pooled
+}
+
+// Creates a cluster node. self is this node's own URL, peers are the other nodes (self may be listed too).
+func NewCluster(self string, peers []string) (*Cluster, error) {
+	return NewClusterWithOptions(self, peers, DefaultOptions)
+}
+
+// Creates a cluster node with custom options
+func NewClusterWithOptions(self string, peers []string, opts Options) (*Cluster, error) {
+	self, err := normalizePeer(self)
+	if err != nil {
+		return nil, err
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	c := &Cluster{
+		self:    self,
+		ring:    NewRing(opts.Replicas),
+		client:  client,
+		proxies: make(map[string]*httputil.ReverseProxy),
+	}
+	if err := c.SetPeers(peers); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Will check that a peer is an http(s) URL, and drop trailing slashes so the same node always has the same name
+func normalizePeer(peer string) (string, error) {
+	peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+	u, err := url.Parse(peer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("cluster: invalid peer URL %q", peer)
+	}
+	return peer, nil
+}
+
+// Will replace the membership of the cluster. This node is always a member.
+// Keys move to their new owners right away; their values are not copied (see Rebalance), so moved keys start out as misses.
+func (c *Cluster) SetPeers(peers []string) error {
+	nodes := []string{c.self}
+	for _, peer := range peers {
+		peer, err := normalizePeer(peer)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, peer)
+	}
+	c.ring.Set(nodes)
+
+	// Drop the proxies of nodes that left
+	c.mu.Lock()
+	members := make(map[string]bool, len(nodes))
+	for _, node := range nodes {
+		members[node] = true
+	}
+	for peer := range c.proxies {
+		if !members[peer] {
+			delete(c.proxies, peer)
+		}
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Will return this node's URL
+func (c *Cluster) Self() string { return c.self }
+
+// Will return every node of the cluster, sorted
+func (c *Cluster) Peers() []string { return c.ring.Nodes() }
+
+// Will return the URL of the node that owns key
+func (c *Cluster) Owner(key string) string { return c.ring.Get(key) }
+
+// Will report whether this node owns key
+func (c *Cluster) IsLocal(key string) bool { return c.Owner(key) == c.self }
+
+// Will wrap next (normally api.Handler.Routes()) so requests for keys owned by other nodes are forwarded to them
+func (c *Cluster) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(ForwardedHeader) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Method == "POST" && r.URL.Path == "/v2/batch" {
+			c.serveBatch(w, r, next)
+			return
+		}
+		if r.Method == "POST" && r.URL.Path == "/v2/txn" {
+			c.serveTxn(w, r, next)
+			return
+		}
+
+		key, ok := requestKey(r)
+		if !ok || c.IsLocal(key) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		c.proxy(c.Owner(key)).ServeHTTP(w, r)
+	})
+}
+
+// Will return the key a request addresses, for the routes that address a single key
+func requestKey(r *http.Request) (string, bool) {
+	switch r.URL.Path {
+	case "/get", "/set", "/delete":
+		key := r.URL.Query().Get("key")
+		return key, key != ""
+	}
+
+	// The escaped path is used, so a key containing an encoded '/' is still a single segment
+	path := r.URL.EscapedPath()
+	var rest, prefix string
+	if after, ok := strings.CutPrefix(path, "/v2/keys/"); ok && !strings.Contains(after, "/") {
+		rest = after
+	} else {
+		// The routes of data types, sketches, streams, locks and queues may have a field, member or action after the key
+		for _, p := range typePrefixes {
+			if after, ok := strings.CutPrefix(path, p); ok {
+				rest, _, _ = strings.Cut(after, "/")
+				prefix = p
+				break
+			}
+		}
+	}
+	if rest == "" {
+		return "", false
+	}
+	key, err := url.PathUnescape(rest)
+	if err != nil {
+		return "", false
+	}
+	if prefix == "/v2/queues/" {
+		// Messages are dead-lettered on the node of their queue, so a dead-letter queue lives with its queue
+		key = strings.TrimSuffix(key, queue.DefaultOptions.DeadLetterSuffix)
+	}
+	return key, true
+}
+
+// Paths of the api routes for lists, hashes, sets and sorted sets, of the sketch and stream routes, followed by
+// the key, of the lock and queue routes, followed by the lock's or queue's name, and of the rate limit route, followed
+// by the limited key
+var typePrefixes = []string{
+	"/v2/lists/", "/v2/hashes/", "/v2/sets/", "/v2/zsets/",
+	"/v2/bloom/", "/v2/cms/", "/v2/hll/",
+	"/v2/streams/",
+	"/v2/locks/", "/v2/queues/", "/v2/ratelimit/",
+}
+
+// Will return the reverse proxy for a peer, creating it on first use
+func (c *Cluster) proxy(peer string) *httputil.ReverseProxy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.proxies[peer]; ok {
+		return p
+	}
+
+	target, _ := url.Parse(peer) // Validated by SetPeers
+	p := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.SetXForwarded()
+			pr.Out.Header.Set(ForwardedHeader, c.self)
+		},
+		Transport: c.client.Transport,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			api.WriteError(w, http.StatusBadGateway, "Owner node unavailable: "+peer)
+		},
+	}
+	c.proxies[peer] = p
+	return p
+}
+
+// Will split a batch by owner, run every part on its node (the local part through next), and put the
+// results back in the original order. A node that can't be reached only fails its own operations.
+func (c *Cluster) serveBatch(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+
+	var req struct {
+		Ops []json.RawMessage `json:"ops"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		// Let the API report the error the way it normally does
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+		return
+	}
+
+	// Indexes of the operations owned by each node
+	groups := make(map[string][]int)
+	keys := make([]string, len(req.Ops))
+	for i, op := range req.Ops {
+		var parsed struct {
+			Key string `json:"key"`
+		}
+		json.Unmarshal(op, &parsed)
+		keys[i] = parsed.Key
+		owner := c.self
+		if parsed.Key != "" {
+			owner = c.Owner(parsed.Key)
+		}
+		groups[owner] = append(groups[owner], i)
+	}
+
+	results := make([]json.RawMessage, len(req.Ops))
+	var wg sync.WaitGroup
+	for owner, indexes := range groups {
+		ops := make([]json.RawMessage, len(indexes))
+		for j, i := range indexes {
+			ops[j] = req.Ops[i]
+		}
+
+		wg.Add(1)
+		go func(owner string, indexes []int, ops []json.RawMessage) {
+			defer wg.Done()
+			part, err := c.runBatch(r, owner, ops, next)
+			for j, i := range indexes {
+				if err != nil || j >= len(part) {
+					results[i] = batchError(keys[i], err)
+					continue
+				}
+				results[i] = part[j]
+			}
+		}(owner, indexes, ops)
+	}
+	wg.Wait()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// Will run a transaction on the node owning its keys. A transaction can't span nodes, so one with keys owned by
+// several nodes is refused.
+func (c *Cluster) serveTxn(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBatchBody))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Request body too large")
+		return
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	var req struct {
+		Watch map[string]json.RawMessage `json:"watch"`
+		Ops   []struct {
+			Key string `json:"key"`
+		} `json:"ops"`
+	}
+	if err := json.Unmarshal(body, &req); err != nil {
+		// Let the API report the error the way it normally does
+		next.ServeHTTP(w, r)
+		return
+	}
+	keys := make([]string, 0, len(req.Watch)+len(req.Ops))
+	for key := range req.Watch {
+		keys = append(keys, key)
+	}
+	for _, op := range req.Ops {
+		keys = append(keys, op.Key)
+	}
+
+	owner := ""
+	for _, key := range keys {
+		if key == "" {
+			continue
+		}
+		if keyOwner := c.Owner(key); owner == "" {
+			owner = keyOwner
+		} else if keyOwner != owner {
+			api.WriteError(w, http.StatusBadRequest, "Keys of a transaction must all be owned by the same node")
+			return
+		}
+	}
+	if owner == "" || owner == c.self {
+		next.ServeHTTP(w, r)
+		return
+	}
+	c.proxy(owner).ServeHTTP(w, r)
+}
+
+// Will run part of a batch on owner, and return its results
+func (c *Cluster) runBatch(r *http.Request, owner string, ops []json.RawMessage, next http.Handler) ([]json.RawMessage, error) {
+	body, err := json.Marshal(map[string]interface{}{"ops": ops})
+	if err != nil {
+		return nil, err
+	}
+
+	var status int
+	var respBody []byte
+	if owner == c.self {
+		req := r.Clone(r.Context())
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+		buf := &responseBuffer{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(buf, req)
+		status, respBody = buf.status, buf.body.Bytes()
+	} else {
+		req, err := http.NewRequestWithContext(r.Context(), "POST", owner+"/v2/batch", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(ForwardedHeader, c.self)
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("owner node unavailable: %s", owner)
+		}
+		defer resp.Body.Close()
+		if respBody, err = io.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+		status = resp.StatusCode
+	}
+
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("owner node %s returned status %d", owner, status)
+	}
+	var decoded struct {
+		Results []json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(respBody, &decoded); err != nil {
+		return nil, err
+	}
+	return decoded.Results, nil
+}
+
+func batchError(key string, err error) json.RawMessage {
+	message := "missing result"
+	if err != nil {
+		message = err.Error()
+	}
+	data, _ := json.Marshal(map[string]string{"key": key, "error": message})
+	return data
+}
+
+// Collects a response in memory, used to run the local part of a batch through the normal handler
+type responseBuffer struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header         { return b.header }
+func (b *responseBuffer) Write(p []byte) (int, error) { return b.body.Write(p) }
+func (b *responseBuffer) WriteHeader(status int)      { b.status = status }
@@ -0,0 +1,402 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"golang-memory-cache/client"
+	"golang-memory-cache/locks"
+	"golang-memory-cache/queue"
+	"golang-memory-cache/ratelimit"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A cluster node running in-process on a loopback port
+type testNode struct {
+	url     string
+	cache   *cache.Cache
+	cluster *Cluster
+	server  *httptest.Server
+}
+
+// Will start n nodes that all know each other
+func newTestCluster(t *testing.T, n int) []*testNode {
+	t.Helper()
+	return newTestClusterWithRoutes(t, n, nil)
+}
+
+// Will start n nodes that all know each other, register mounts more routes on the mux of each node
+func newTestClusterWithRoutes(t *testing.T, n int, register func(node *testNode, mux *http.ServeMux)) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, n)
+	urls := make([]string, n)
+
+	// The servers are started first, since every node needs the URLs of the others
+	for i := range nodes {
+		node := &testNode{cache: cache.NewCache()}
+		mux := (&api.Handler{Cache: node.cache}).Routes()
+		if register != nil {
+			register(node, mux)
+		}
+		node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.cluster.Handler(mux).ServeHTTP(w, r)
+		}))
+		node.url = node.server.URL
+		urls[i] = node.url
+		nodes[i] = node
+		t.Cleanup(func() {
+			node.server.Close()
+			node.cache.Stop()
+		})
+	}
+	for _, node := range nodes {
+		c, err := NewCluster(node.url, urls)
+		if err != nil {
+			t.Fatalf("NewCluster returned an error: %v", err)
+		}
+		node.cluster = c
+	}
+	return nodes
+}
+
+func (n *testNode) client(t *testing.T) *client.Client {
+	t.Helper()
+	cl, err := client.NewClient(n.url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cl
+}
+
+func nodeByURL(nodes []*testNode, url string) *testNode {
+	for _, n := range nodes {
+		if n.url == url {
+			return n
+		}
+	}
+	return nil
+}
+
+func TestNewCluster(t *testing.T) {
+	c, err := NewCluster("http://a:8080/", []string{"http://b:8080", "http://a:8080"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Self() != "http://a:8080" {
+		t.Errorf("Expected the trailing slash to be dropped, got %q", c.Self())
+	}
+	if peers := c.Peers(); len(peers) != 2 {
+		t.Errorf("Expected 2 distinct nodes, got %v", peers)
+	}
+	if _, err := NewCluster("http://a:8080", []string{"b:8080"}); err == nil {
+		t.Errorf("Expected an error for a peer without scheme")
+	}
+}
+
+// Will test that keys written through any node end up on their owner only, and can be read from any node
+func TestForwarding(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	ctx := context.Background()
+	entry := nodes[0].client(t)
+
+	for i := 0; i < 30; i++ {
+		key := fmt.Sprintf("user/%d", i) // '/' must survive forwarding
+		if err := entry.Set(ctx, key, i, time.Minute); err != nil {
+			t.Fatalf("Set %s returned an error: %v", key, err)
+		}
+
+		owner := nodeByURL(nodes, nodes[0].cluster.Owner(key))
+		for _, n := range nodes {
+			_, found := n.cache.Get(key)
+			if found != (n == owner) {
+				t.Errorf("%s: found on %s = %v, owner is %s", key, n.url, found, owner.url)
+			}
+		}
+
+		// Any node answers for any key
+		reader := nodes[i%3].client(t)
+		if value, found, err := reader.Get(ctx, key); err != nil || !found || value != float64(i) {
+			t.Errorf("Get %s through %s returned %v, %v, %v", key, nodes[i%3].url, value, found, err)
+		}
+	}
+
+	// Every node got some keys
+	for _, n := range nodes {
+		if n.cache.Len() == 0 {
+			t.Errorf("Node %s owns no keys", n.url)
+		}
+	}
+
+	// The v1 routes are forwarded too
+	resp, err := http.Post(nodes[1].url+"/set?key=v1key&value=hello&duration=60", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	owner := nodeByURL(nodes, nodes[1].cluster.Owner("v1key"))
+	if _, found := owner.cache.Get("v1key"); !found {
+		t.Errorf("Expected the v1 set to land on the owner %s", owner.url)
+	}
+
+	// And the routes of lists, hashes, sets and sorted sets, with a member after the key
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("set/%d", i)
+		req, _ := http.NewRequest("PUT", nodes[2].url+"/v2/sets/"+strings.ReplaceAll(key, "/", "%2F"), strings.NewReader(`["a"]`))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		owner := nodeByURL(nodes, nodes[2].cluster.Owner(key))
+		if ok, _ := owner.cache.SIsMember(key, "a"); !ok {
+			t.Errorf("Expected %s to land on the owner %s", key, owner.url)
+		}
+		resp, err = http.Get(nodes[i%3].url + "/v2/sets/" + strings.ReplaceAll(key, "/", "%2F") + "/a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected the member of %s to be found through any node, got %d", key, resp.StatusCode)
+		}
+	}
+}
+
+// Will test that two workers contending for a lock through different nodes are served by the lock's owner,
+// so only one of them gets it
+func TestLockForwarding(t *testing.T) {
+	nodes := newTestClusterWithRoutes(t, 2, func(node *testNode, mux *http.ServeMux) {
+		locks.NewLocker(node.cache).RegisterRoutes(mux)
+	})
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("report-%d", i)
+		statuses := make([]int, len(nodes))
+		for j, n := range nodes {
+			resp, err := http.Post(fmt.Sprintf("%s/v2/locks/%s?owner=worker-%d&ttl=1m", n.url, name, j), "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			statuses[j] = resp.StatusCode
+		}
+		if statuses[0] != http.StatusOK || statuses[1] != http.StatusConflict {
+			t.Errorf("%s: expected the first worker to get the lock and the second one a conflict, got %v", name, statuses)
+		}
+
+		owner := nodeByURL(nodes, nodes[0].cluster.Owner(name))
+		for _, n := range nodes {
+			_, found := n.cache.Get(locks.DefaultOptions.Prefix + name)
+			if found != (n == owner) {
+				t.Errorf("%s: found on %s = %v, owner is %s", name, n.url, found, owner.url)
+			}
+		}
+	}
+}
+
+// Will test that messages enqueued through one node are dequeued through another, and that a dead-letter queue
+// is served by the node of its queue, where messages are dead-lettered
+func TestQueueForwarding(t *testing.T) {
+	nodes := newTestClusterWithRoutes(t, 2, func(node *testNode, mux *http.ServeMux) {
+		queue.NewQueues(node.cache).RegisterRoutes(mux)
+	})
+
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("jobs-%d", i)
+		resp, err := http.Post(nodes[0].url+"/v2/queues/"+name, "text/plain", strings.NewReader("hello"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		resp, err = http.Post(nodes[1].url+"/v2/queues/"+name+"/dequeue", "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("%s: expected the message enqueued through another node, got %d", name, resp.StatusCode)
+		}
+	}
+
+	r := httptest.NewRequest("POST", "/v2/queues/jobs"+queue.DefaultOptions.DeadLetterSuffix+"/dequeue", nil)
+	if key, ok := requestKey(r); !ok || key != "jobs" {
+		t.Errorf("Expected the dead-letter queue to be routed with its queue, got %q, %v", key, ok)
+	}
+}
+
+// Will test that requests for a rate limited key count against the same state whatever node they go through
+func TestRateLimitForwarding(t *testing.T) {
+	nodes := newTestClusterWithRoutes(t, 2, func(node *testNode, mux *http.ServeMux) {
+		ratelimit.NewLimiter(node.cache).RegisterRoutes(mux)
+	})
+
+	for i := 0; i < 10; i++ {
+		key := fmt.Sprintf("client-%d", i)
+		statuses := make([]int, len(nodes))
+		for j, n := range nodes {
+			resp, err := http.Post(n.url+"/v2/ratelimit/"+key+"?limit=1&window=1m", "", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			statuses[j] = resp.StatusCode
+		}
+		if statuses[0] != http.StatusOK || statuses[1] != http.StatusTooManyRequests {
+			t.Errorf("%s: expected the second request to be over the limit, got %v", key, statuses)
+		}
+
+		owner := nodeByURL(nodes, nodes[0].cluster.Owner(key))
+		for _, n := range nodes {
+			_, found := n.cache.Get(ratelimit.DefaultOptions.Prefix + key)
+			if found != (n == owner) {
+				t.Errorf("%s: found on %s = %v, owner is %s", key, n.url, found, owner.url)
+			}
+		}
+	}
+}
+
+func TestBatchSplit(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+	ctx := context.Background()
+
+	var ops []client.BatchOp
+	for i := 0; i < 20; i++ {
+		ops = append(ops, client.BatchSet(fmt.Sprintf("k%d", i), i, time.Minute))
+	}
+	for i := 0; i < 20; i++ {
+		ops = append(ops, client.BatchGet(fmt.Sprintf("k%d", i)))
+	}
+
+	results, err := nodes[2].client(t).Batch(ctx, ops)
+	if err != nil {
+		t.Fatalf("Batch returned an error: %v", err)
+	}
+	if len(results) != len(ops) {
+		t.Fatalf("Expected %d results, got %d", len(ops), len(results))
+	}
+	for i := 20; i < 40; i++ {
+		r := results[i]
+		if r.Key != fmt.Sprintf("k%d", i-20) || !r.Found || r.Value != float64(i-20) || r.Err != nil {
+			t.Errorf("Result %d out of order or wrong: %+v", i, r)
+		}
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("k%d", i)
+		owner := nodeByURL(nodes, nodes[2].cluster.Owner(key))
+		if _, found := owner.cache.Get(key); !found {
+			t.Errorf("Expected %s on its owner %s", key, owner.url)
+		}
+	}
+}
+
+// Will test that a transaction runs on the owner of its keys, and is refused when they live on several nodes
+func TestTxnForwarding(t *testing.T) {
+	nodes := newTestCluster(t, 3)
+
+	// Find a key owned by another node than the one receiving the request, and one owned by a third node
+	entry := nodes[0]
+	var remote, other string
+	for i := 0; remote == "" || other == ""; i++ {
+		key := fmt.Sprintf("k%d", i)
+		switch owner := entry.cluster.Owner(key); {
+		case owner == entry.url:
+		case remote == "":
+			remote = key
+		case owner != entry.cluster.Owner(remote):
+			other = key
+		}
+	}
+
+	txn := func(body string) int {
+		t.Helper()
+		resp, err := http.Post(entry.url+"/v2/txn", "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if code := txn(fmt.Sprintf(`{"ops": [{"op": "set", "key": %q, "value": 1}]}`, remote)); code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", code)
+	}
+	owner := nodeByURL(nodes, entry.cluster.Owner(remote))
+	if _, found := owner.cache.Get(remote); !found {
+		t.Errorf("Expected %s to be written on its owner %s", remote, owner.url)
+	}
+
+	body := fmt.Sprintf(`{"watch": {%q: 0}, "ops": [{"op": "set", "key": %q, "value": 1}]}`, other, remote)
+	if code := txn(body); code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for keys on several nodes, got %d", code)
+	}
+}
+
+// Will test that an unreachable owner gives a 502 for its keys, and only fails its own batch operations
+func TestOwnerDown(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	ctx := context.Background()
+	nodes[1].server.Close()
+
+	// Find a key on each node
+	var localKey, remoteKey string
+	for i := 0; localKey == "" || remoteKey == ""; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if nodes[0].cluster.IsLocal(key) {
+			localKey = key
+		} else {
+			remoteKey = key
+		}
+	}
+
+	entry := nodes[0].client(t)
+	err := entry.Set(ctx, remoteKey, 1, cache.NoExpiration)
+	if err == nil || !strings.Contains(err.Error(), "unavailable") {
+		t.Errorf("Expected an unavailable error for a key on the down node, got %v", err)
+	}
+	if err := entry.Set(ctx, localKey, 1, cache.NoExpiration); err != nil {
+		t.Errorf("Expected local keys to keep working, got %v", err)
+	}
+
+	results, err := entry.Batch(ctx, []client.BatchOp{client.BatchGet(localKey), client.BatchGet(remoteKey)})
+	if err != nil {
+		t.Fatalf("Batch returned an error: %v", err)
+	}
+	if results[0].Err != nil || !results[0].Found {
+		t.Errorf("Expected the local op to succeed, got %+v", results[0])
+	}
+	if results[1].Err == nil {
+		t.Errorf("Expected the op on the down node to fail")
+	}
+}
+
+// Will test that a forwarded request is served where it lands, even if that node believes another node owns the key
+// (i.e. while membership is being reloaded), instead of being forwarded again
+func TestNoForwardingLoops(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+
+	var key string
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key-%d", i); !nodes[0].cluster.IsLocal(k) {
+			key = k
+		}
+	}
+
+	req, _ := http.NewRequest("PUT", nodes[0].url+"/v2/keys/"+key, strings.NewReader("x"))
+	req.Header.Set(ForwardedHeader, nodes[1].url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if _, found := nodes[0].cache.Get(key); !found {
+		t.Errorf("Expected the forwarded request to be served by the node it was sent to")
+	}
+	if _, found := nodes[1].cache.Get(key); found {
+		t.Errorf("Expected the forwarded request not to be forwarded again")
+	}
+}
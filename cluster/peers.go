@@ -0,0 +1,74 @@
+package cluster
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Will read a peers file: one node URL per line. Blank lines and lines starting with # are skipped.
+func ReadPeersFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parsePeers(data), nil
+}
+
+func parsePeers(data []byte) []string {
+	var peers []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	return peers
+}
+
+// Will load the peers from path, then check the file every interval and apply it again whenever it changed,
+// until ctx is cancelled. Only the first load returns an error; later bad versions are logged and the
+// previous membership is kept.
+func (c *Cluster) WatchPeersFile(ctx context.Context, path string, interval time.Duration) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := c.SetPeers(parsePeers(data)); err != nil {
+		return err
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			current, err := os.ReadFile(path)
+			if err != nil {
+				log.Printf("cluster: reading peers file: %v", err)
+				continue
+			}
+			if bytes.Equal(current, data) {
+				continue
+			}
+			data = current // Also for a bad version, so its error is only logged once
+			if err := c.SetPeers(parsePeers(current)); err != nil {
+				log.Printf("cluster: %v, keeping the previous peers", err)
+				continue
+			}
+			log.Printf("cluster: peers reloaded from %s: %s", path, strings.Join(c.Peers(), ", "))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,60 @@
+package cluster
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestReadPeersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	os.WriteFile(path, []byte("# cache nodes\nhttp://a:8080\n\n  http://b:8080  \n"), 0o644)
+
+	peers, err := ReadPeersFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(peers, []string{"http://a:8080", "http://b:8080"}) {
+		t.Errorf("Unexpected peers: %v", peers)
+	}
+	if _, err := ReadPeersFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Errorf("Expected an error for a missing file")
+	}
+}
+
+func TestWatchPeersFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers")
+	os.WriteFile(path, []byte("http://b:8080\n"), 0o644)
+
+	c, err := NewCluster("http://a:8080", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := c.WatchPeersFile(ctx, path, 5*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if peers := c.Peers(); !reflect.DeepEqual(peers, []string{"http://a:8080", "http://b:8080"}) {
+		t.Fatalf("Unexpected peers after the first load: %v", peers)
+	}
+
+	// A bad version is ignored, the previous peers stay
+	os.WriteFile(path, []byte("not a url\n"), 0o644)
+	time.Sleep(30 * time.Millisecond)
+	if peers := c.Peers(); len(peers) != 2 {
+		t.Errorf("Expected the previous peers to be kept, got %v", peers)
+	}
+
+	os.WriteFile(path, []byte("http://b:8080\nhttp://c:8080\n"), 0o644)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(c.Peers()) != 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Peers file was not reloaded, peers: %v", c.Peers())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,71 @@
+package cluster
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"golang-memory-cache/cache"
+	"net/http"
+)
+
+// Will hand the keys of c that this node no longer owns (i.e. after a node joined) to their owners, and delete them here.
+// Keys are sent as one snapshot per owner with PUT /v2/snapshot?existing=keep, so a key the owner already has
+// (because it was written there after the membership change) is not overwritten with the older value.
+// A key is only deleted here if it did not change while it was being sent.
+// Returns how many keys were handed off. An owner that can't be reached keeps its keys here for the next call.
+func (cl *Cluster) Rebalance(ctx context.Context, c *cache.Cache) (int, error) {
+	// Version of every key to move, per owner
+	moves := make(map[string]map[string]uint64)
+	for _, key := range c.Keys("") {
+		owner := cl.Owner(key)
+		if owner == cl.self {
+			continue
+		}
+		item, found := c.Peek(key)
+		if !found {
+			continue
+		}
+		if moves[owner] == nil {
+			moves[owner] = make(map[string]uint64)
+		}
+		moves[owner][key] = item.Version
+	}
+
+	moved := 0
+	var errs []error
+	for owner, versions := range moves {
+		var buf bytes.Buffer
+		if err := c.SaveSnapshotKeys(&buf, func(key string) bool { _, ok := versions[key]; return ok }); err != nil {
+			return moved, err
+		}
+		if err := cl.sendSnapshot(ctx, owner, &buf); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for key, version := range versions {
+			if c.DeleteIf(key, cache.IfVersion(version)) {
+				moved++
+			}
+		}
+	}
+	return moved, errors.Join(errs...)
+}
+
+func (cl *Cluster) sendSnapshot(ctx context.Context, owner string, body *bytes.Buffer) error {
+	req, err := http.NewRequestWithContext(ctx, "PUT", owner+"/v2/snapshot?existing=keep", body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(ForwardedHeader, cl.self)
+	resp, err := cl.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("owner node unavailable: %s", owner)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("owner node %s returned status %d", owner, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,58 @@
+package cluster
+
+import (
+	"context"
+	"fmt"
+	"golang-memory-cache/cache"
+	"testing"
+)
+
+// Will test that keys held by a node that no longer owns them are moved to their owner
+func TestRebalance(t *testing.T) {
+	nodes := newTestCluster(t, 2)
+	from, to := nodes[0], nodes[1]
+
+	// Written before the membership change, when nodes[0] owned everything
+	var remote []string
+	for i := 0; i < 50; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		from.cache.Set(key, i, cache.NoExpiration)
+		if !from.cluster.IsLocal(key) {
+			remote = append(remote, key)
+		}
+	}
+	// Written on the new owner after the change, must not be overwritten
+	to.cache.Set(remote[0], "newer", cache.NoExpiration)
+
+	moved, err := from.cluster.Rebalance(context.Background(), from.cache)
+	if err != nil {
+		t.Fatalf("Rebalance returned an error: %v", err)
+	}
+	if moved != len(remote) {
+		t.Errorf("Expected %d keys to move, got %d", len(remote), moved)
+	}
+	for _, key := range remote {
+		if _, found := from.cache.Get(key); found {
+			t.Errorf("Expected %s to be deleted from the old node", key)
+		}
+		if _, found := to.cache.Get(key); !found {
+			t.Errorf("Expected %s on its owner", key)
+		}
+	}
+	if v, _ := to.cache.Get(remote[0]); v != "newer" {
+		t.Errorf("Expected the owner's newer value to be kept, got %v", v)
+	}
+	if from.cache.Len() != 50-len(remote) {
+		t.Errorf("Expected the local keys to stay, got %d keys", from.cache.Len())
+	}
+
+	// An unreachable owner keeps the keys here
+	from.cache.Set(remote[1], "again", cache.NoExpiration)
+	to.server.Close()
+	if _, err := from.cluster.Rebalance(context.Background(), from.cache); err == nil {
+		t.Errorf("Expected an error for an unreachable owner")
+	}
+	if _, found := from.cache.Get(remote[1]); !found {
+		t.Errorf("Expected the key to stay when its owner is unreachable")
+	}
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// A consistent hash ring. Every node is placed on the ring many times (virtual nodes), and a key belongs to
+// the first virtual node at or after the key's hash. Adding or removing a node only moves the keys next to
+// its virtual nodes, about 1/N of all keys, instead of reshuffling everything like hash(key) % N would.
+type Ring struct {
+	mu       sync.RWMutex
+	replicas int               // Virtual nodes per node, more gives a more even spread
+	hashes   []uint32          // Sorted positions of every virtual node
+	owners   map[uint32]string // Position -> node
+	nodes    []string
+}
+
+// Virtual nodes per node used by NewRing when replicas is 0
+const DefaultReplicas = 128
+
+func NewRing(replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	return &Ring{replicas: replicas, owners: make(map[uint32]string)}
+}
+
+func hashKey(key string) uint32 {
+	return crc32.ChecksumIEEE([]byte(key))
+}
+
+// Will replace the nodes of the ring. Duplicates are ignored.
+func (r *Ring) Set(nodes []string) {
+	hashes := make([]uint32, 0, len(nodes)*r.replicas)
+	owners := make(map[uint32]string, len(nodes)*r.replicas)
+	unique := make([]string, 0, len(nodes))
+	seen := make(map[string]bool, len(nodes))
+
+	for _, node := range nodes {
+		if seen[node] {
+			continue
+		}
+		seen[node] = true
+		unique = append(unique, node)
+
+		for i := 0; i < r.replicas; i++ {
+			h := hashKey(strconv.Itoa(i) + "#" + node)
+			// On the rare collision the smallest node name wins, so every process builds the same ring
+			if existing, ok := owners[h]; ok {
+				if node < existing {
+					owners[h] = node
+				}
+				continue
+			}
+			owners[h] = node
+			hashes = append(hashes, h)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	sort.Strings(unique)
+
+	r.mu.Lock()
+	r.hashes, r.owners, r.nodes = hashes, owners, unique
+	r.mu.Unlock()
+}
+
+// Will return the node that owns key, or "" when the ring is empty
+func (r *Ring) Get(key string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if len(r.hashes) == 0 {
+		return ""
+	}
+
+	h := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= h })
+	if i == len(r.hashes) {
+		i = 0 // Past the last virtual node, wrap around to the first
+	}
+	return r.owners[r.hashes[i]]
+}
+
+// Will return the nodes of the ring, sorted
+func (r *Ring) Nodes() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]string(nil), r.nodes...)
+}
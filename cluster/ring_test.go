@@ -0,0 +1,76 @@
+package cluster
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestRingEmpty(t *testing.T) {
+	r := NewRing(0)
+	if owner := r.Get("a"); owner != "" {
+		t.Errorf("Expected no owner on an empty ring, got %q", owner)
+	}
+}
+
+func TestRingDeterministic(t *testing.T) {
+	a, b := NewRing(64), NewRing(64)
+	a.Set([]string{"n1", "n2", "n3"})
+	b.Set([]string{"n3", "n1", "n2", "n1"}) // Order and duplicates don't matter
+
+	if !reflect.DeepEqual(b.Nodes(), []string{"n1", "n2", "n3"}) {
+		t.Errorf("Unexpected nodes: %v", b.Nodes())
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		if a.Get(key) != b.Get(key) {
+			t.Fatalf("Rings built from the same nodes disagree on %q", key)
+		}
+	}
+}
+
+// Will test that keys are spread roughly evenly over the nodes
+func TestRingBalance(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	nodes := []string{"http://a", "http://b", "http://c", "http://d"}
+	r.Set(nodes)
+
+	counts := make(map[string]int)
+	const keys = 20000
+	for i := 0; i < keys; i++ {
+		counts[r.Get(fmt.Sprintf("user:%d", i))]++
+	}
+	for _, node := range nodes {
+		share := float64(counts[node]) / keys
+		if share < 0.15 || share > 0.35 {
+			t.Errorf("Node %s owns %.1f%% of the keys, expected about 25%%", node, share*100)
+		}
+	}
+}
+
+// Will test that adding a node only moves the keys it takes over
+func TestRingMinimalMovement(t *testing.T) {
+	r := NewRing(DefaultReplicas)
+	r.Set([]string{"http://a", "http://b", "http://c"})
+
+	const keys = 10000
+	before := make([]string, keys)
+	for i := range before {
+		before[i] = r.Get(fmt.Sprintf("key-%d", i))
+	}
+
+	r.Set([]string{"http://a", "http://b", "http://c", "http://d"})
+	moved := 0
+	for i := range before {
+		owner := r.Get(fmt.Sprintf("key-%d", i))
+		if owner != before[i] {
+			moved++
+			if owner != "http://d" {
+				t.Fatalf("key-%d moved from %s to %s, keys should only move to the new node", i, before[i], owner)
+			}
+		}
+	}
+	if share := float64(moved) / keys; share > 0.4 {
+		t.Errorf("%.1f%% of the keys moved, expected about 25%%", share*100)
+	}
+}
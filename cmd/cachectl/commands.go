@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"golang-memory-cache/cache"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type command struct {
+	usage   string // Arguments, shown after the command name
+	summary string
+	run     func(c *cli, ctx context.Context, args []string) error
+}
+
+var commands map[string]command
+
+// Filled in init, because the help command reads the table itself
+func init() {
+	commands = map[string]command{
+		"get":     {"KEY", "print the value of a key", (*cli).get},
+		"set":     {"[-ttl D] [-json] [-file PATH] [-type TYPE] KEY [VALUE]", "store a value, read from stdin or a file when VALUE is missing", (*cli).set},
+		"del":     {"KEY...", "delete keys", (*cli).del},
+		"ttl":     {"KEY", "print the time left before a key expires", (*cli).ttl},
+		"keys":    {"[PATTERN]", "list the keys matching a glob pattern, i.e. 'user:*'", (*cli).keys},
+		"stats":   {"", "print the cache statistics", (*cli).stats},
+		"watch":   {"[PATTERN]", "stream changes to keys matching a glob pattern until ctrl+c", (*cli).watch},
+		"dump":    {"[FILE]", "save a snapshot of the cache to FILE or stdout", (*cli).dump},
+		"restore": {"[FILE]", "load a snapshot from FILE or stdin, replacing existing keys", (*cli).restore},
+		"flush":   {"", "delete every key", (*cli).flush},
+		"help":    {"[COMMAND]", "show the commands, or the usage of one command", (*cli).help},
+	}
+}
+
+// Will print every command with its summary
+func printCommands(w io.Writer) {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-8s %s\n", name, commands[name].summary)
+	}
+}
+
+// Will parse the flags of a command. -h prints the usage of the command, any other flag error becomes a usageError.
+func (c *cli) parseFlags(fs *flag.FlagSet, args []string) error {
+	fs.SetOutput(io.Discard)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintf(c.stdout, "Usage: cachectl %s %s\n", fs.Name(), commands[fs.Name()].usage)
+			fs.SetOutput(c.stdout)
+			fs.PrintDefaults()
+			return flag.ErrHelp
+		}
+		return usagef("%v", err)
+	}
+	return nil
+}
+
+// Will return a context with the request timeout, for commands that make a single request
+func (c *cli) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c *cli) get(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usagef("expected a key")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	item, found, err := c.client.GetItem(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("key not found: %s", args[0])
+	}
+
+	if c.format == formatJSON {
+		return c.printJSON(itemJSON(args[0], item))
+	}
+	return c.printValue(item.Value)
+}
+
+func (c *cli) set(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("set", flag.ContinueOnError)
+	ttl := fs.Duration("ttl", 0, "time to live, the key never expires when 0")
+	asJSON := fs.Bool("json", false, "parse the value as JSON instead of storing it as a string or bytes")
+	file := fs.String("file", "", "read the value from this file, - for stdin")
+	contentType := fs.String("type", "", "content type stored with a value read from stdin or a file")
+	if err := c.parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *ttl < 0 {
+		return usagef("ttl must not be negative")
+	}
+	expiration := *ttl
+	if expiration == 0 {
+		expiration = cache.NoExpiration
+	}
+
+	var (
+		key      string
+		data     []byte
+		fromArgs bool
+	)
+	switch {
+	case fs.NArg() == 2 && *file == "":
+		key, data, fromArgs = fs.Arg(0), []byte(fs.Arg(1)), true
+	case fs.NArg() == 1:
+		// No value argument, read it from the file or stdin
+		key = fs.Arg(0)
+		var err error
+		if data, err = c.readInput(*file); err != nil {
+			return err
+		}
+	default:
+		return usagef("expected a key and a value, or a key and -file")
+	}
+
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	var err error
+	switch {
+	case *asJSON:
+		var value interface{}
+		if err := json.Unmarshal(data, &value); err != nil {
+			return fmt.Errorf("invalid JSON value: %w", err)
+		}
+		err = c.client.Set(ctx, key, value, expiration)
+	case fromArgs:
+		err = c.client.Set(ctx, key, string(data), expiration)
+	default:
+		// Files and stdin can hold anything, so they are stored as raw bytes
+		err = c.client.SetBytes(ctx, key, data, *contentType, expiration)
+	}
+	if err != nil {
+		return err
+	}
+	return c.printOK()
+}
+
+// Will read a value from path, or from stdin when path is empty or "-"
+func (c *cli) readInput(path string) ([]byte, error) {
+	if path == "" || path == "-" {
+		return io.ReadAll(c.stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func (c *cli) del(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return usagef("expected at least one key")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	for _, key := range args {
+		if err := c.client.Delete(ctx, key); err != nil {
+			return err
+		}
+	}
+	return c.printOK()
+}
+
+func (c *cli) ttl(ctx context.Context, args []string) error {
+	if len(args) != 1 {
+		return usagef("expected a key")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	item, found, err := c.client.GetItem(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("key not found: %s", args[0])
+	}
+
+	ttl, expires := item.TTL()
+	if c.format == formatJSON {
+		var seconds *float64
+		if expires {
+			s := ttl.Seconds()
+			seconds = &s
+		}
+		return c.printJSON(map[string]interface{}{"key": args[0], "ttl": seconds})
+	}
+	if !expires {
+		fmt.Fprintln(c.stdout, "no expiration")
+		return nil
+	}
+	fmt.Fprintln(c.stdout, ttl.Round(time.Millisecond))
+	return nil
+}
+
+func (c *cli) keys(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return usagef("expected at most one pattern")
+	}
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	keys, err := c.client.Keys(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.printJSON(keys)
+	}
+	for _, key := range keys {
+		fmt.Fprintln(c.stdout, key)
+	}
+	return nil
+}
+
+func (c *cli) stats(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return usagef("expected no arguments")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	stats, err := c.client.GetStats(ctx)
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.printJSON(stats)
+	}
+
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name, fmt.Sprint(stats[name])}
+	}
+	return c.printTable([]string{"STAT", "VALUE"}, rows)
+}
+
+// Runs until ctrl+c or until the server closes the stream, so it has no request timeout
+func (c *cli) watch(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return usagef("expected at most one pattern")
+	}
+	pattern := ""
+	if len(args) == 1 {
+		pattern = args[0]
+	}
+
+	events, err := c.client.Watch(ctx, pattern)
+	if err != nil {
+		return err
+	}
+	for ev := range events {
+		if err := c.printEvent(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Snapshots can be large, so dump and restore are not limited by the request timeout either
+func (c *cli) dump(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return usagef("expected at most one file")
+	}
+	if len(args) == 0 || args[0] == "-" {
+		return c.client.SaveSnapshot(ctx, c.stdout)
+	}
+
+	// Written next to the target and renamed, so a failed download never replaces a good dump
+	path := args[0]
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cachectl-dump-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := c.client.SaveSnapshot(ctx, tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	if c.format == formatJSON {
+		return c.printJSON(map[string]string{"file": path})
+	}
+	fmt.Fprintf(c.stdout, "Saved snapshot to %s\n", path)
+	return nil
+}
+
+func (c *cli) restore(ctx context.Context, args []string) error {
+	if len(args) > 1 {
+		return usagef("expected at most one file")
+	}
+	path := ""
+	if len(args) == 1 {
+		path = args[0]
+	}
+	data, err := c.readInput(path)
+	if err != nil {
+		return err
+	}
+
+	loaded, err := c.client.LoadSnapshot(ctx, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.printJSON(map[string]int{"loaded": loaded})
+	}
+	fmt.Fprintf(c.stdout, "Loaded %d items\n", loaded)
+	return nil
+}
+
+func (c *cli) flush(ctx context.Context, args []string) error {
+	if len(args) != 0 {
+		return usagef("expected no arguments")
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+
+	n, err := c.client.Flush(ctx)
+	if err != nil {
+		return err
+	}
+	if c.format == formatJSON {
+		return c.printJSON(map[string]int{"flushed": n})
+	}
+	fmt.Fprintf(c.stdout, "Flushed %d keys\n", n)
+	return nil
+}
+
+func (c *cli) help(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		printCommands(c.stdout)
+		return nil
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return usagef("unknown command %q", args[0])
+	}
+	fmt.Fprintf(c.stdout, "Usage: cachectl %s %s\n  %s\n", args[0], cmd.usage, cmd.summary)
+	return nil
+}
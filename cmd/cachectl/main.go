@@ -0,0 +1,143 @@
+// Command cachectl operates a running cache server over its HTTP API.
+//
+//	cachectl [-addr URL] [-o table|json] [-timeout D] <command> [args]
+//
+// Without a command it starts an interactive shell. Run "cachectl help" for the list of commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"golang-memory-cache/client"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+)
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdin, os.Stdout, os.Stderr, os.Getenv))
+}
+
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1 // The command failed, i.e. the key does not exist or the server is down
+	exitUsage = 2 // The command line was wrong
+)
+
+// Output formats selected with -o
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// State shared by every command
+type cli struct {
+	client  *client.Client
+	format  string
+	timeout time.Duration
+	stdin   io.Reader
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// Returned by commands when their arguments are wrong, so the usage is printed and the exit code is exitUsage
+type usageError struct {
+	msg string
+}
+
+func (e *usageError) Error() string { return e.msg }
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// Will parse the global flags and run a single command, or the interactive shell when there is none.
+// Every global flag can also be given as an environment variable (i.e. -addr or CACHE_URL), flags win when both are set.
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer, getenv func(string) string) int {
+	addr := "http://localhost:8080"
+	if v := getenv("CACHE_URL"); v != "" {
+		addr = v
+	}
+	format := formatTable
+	if v := getenv("CACHECTL_OUTPUT"); v != "" {
+		format = v
+	}
+
+	fs := flag.NewFlagSet("cachectl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&addr, "addr", addr, "URL of the cache server (CACHE_URL)")
+	fs.StringVar(&format, "o", format, "output format, table or json (CACHECTL_OUTPUT)")
+	timeout := fs.Duration("timeout", 5*time.Second, "deadline for each request")
+	fs.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: cachectl [flags] <command> [args]")
+		fmt.Fprintln(stderr, "\nFlags:")
+		fs.PrintDefaults()
+		fmt.Fprintln(stderr)
+		printCommands(stderr)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if format != formatTable && format != formatJSON {
+		fmt.Fprintf(stderr, "cachectl: unknown output format %q, expected table or json\n", format)
+		return exitUsage
+	}
+
+	// The timeout is applied per command instead of per request, so dump, restore and watch can run longer
+	opts := client.DefaultOptions
+	opts.Timeout = 0
+	cl, err := client.NewClientWithOptions(addr, opts)
+	if err != nil {
+		fmt.Fprintf(stderr, "cachectl: %v\n", err)
+		return exitUsage
+	}
+
+	c := &cli{
+		client:  cl,
+		format:  format,
+		timeout: *timeout,
+		stdin:   stdin,
+		stdout:  stdout,
+		stderr:  stderr,
+	}
+
+	if fs.NArg() == 0 {
+		return c.repl(ctx, getenv)
+	}
+	return c.execute(ctx, fs.Args())
+}
+
+// Will run one command and report its error, returning the exit code
+func (c *cli) execute(ctx context.Context, args []string) int {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(c.stderr, "cachectl: unknown command %q, run \"cachectl help\" for the list of commands\n", args[0])
+		return exitUsage
+	}
+
+	// Ctrl+c stops the running command (i.e. watch) without leaving the interactive shell
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	defer stop()
+
+	err := cmd.run(c, ctx, args[1:])
+	var usage *usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &usage):
+		fmt.Fprintf(c.stderr, "cachectl %s: %s\nUsage: cachectl %s %s\n", args[0], usage.msg, args[0], cmd.usage)
+		return exitUsage
+	default:
+		fmt.Fprintf(c.stderr, "cachectl %s: %v\n", args[0], err)
+		return exitError
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Will start a real server and return a function that runs cachectl against it
+func newTestServer(t *testing.T) (*cache.Cache, func(stdin string, args ...string) (int, string, string)) {
+	t.Helper()
+	c := cache.NewCache()
+	srv := httptest.NewServer((&api.Handler{Cache: c}).Routes())
+	t.Cleanup(func() {
+		srv.Close()
+		c.Stop()
+	})
+
+	getenv := func(name string) string {
+		switch name {
+		case "CACHE_URL":
+			return srv.URL
+		case "CACHECTL_HISTORY":
+			return "-"
+		}
+		return ""
+	}
+	return c, func(stdin string, args ...string) (int, string, string) {
+		var stdout, stderr bytes.Buffer
+		code := run(context.Background(), args, strings.NewReader(stdin), &stdout, &stderr, getenv)
+		return code, stdout.String(), stderr.String()
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	c, cachectl := newTestServer(t)
+
+	if code, out, errOut := cachectl("", "set", "-ttl", "1m", "greeting", "hello world"); code != exitOK || out != "OK\n" {
+		t.Fatalf("set: got code %d, output %q, errors %q", code, out, errOut)
+	}
+	if v, _ := c.Get("greeting"); v != "hello world" {
+		t.Errorf("Expected the value to be stored as a string, got %v (%T)", v, v)
+	}
+
+	if code, out, _ := cachectl("", "get", "greeting"); code != exitOK || out != "hello world\n" {
+		t.Errorf("get: got code %d, output %q", code, out)
+	}
+
+	// -json parses the value, so it keeps its type
+	cachectl("", "set", "-json", "user", `{"name": "alice"}`)
+	if code, out, _ := cachectl("", "get", "user"); code != exitOK || out != "{\"name\":\"alice\"}\n" {
+		t.Errorf("get of a JSON value: got code %d, output %q", code, out)
+	}
+
+	code, out, _ := cachectl("", "-o", "json", "get", "greeting")
+	var item struct {
+		Key   string   `json:"key"`
+		Value string   `json:"value"`
+		TTL   *float64 `json:"ttl"`
+	}
+	if err := json.Unmarshal([]byte(out), &item); err != nil || code != exitOK {
+		t.Fatalf("get -o json: got code %d, output %q", code, out)
+	}
+	if item.Key != "greeting" || item.Value != "hello world" || item.TTL == nil {
+		t.Errorf("get -o json: unexpected item %+v", item)
+	}
+
+	if code, _, errOut := cachectl("", "get", "missing"); code != exitError || !strings.Contains(errOut, "key not found") {
+		t.Errorf("get of a missing key: got code %d, errors %q", code, errOut)
+	}
+}
+
+// Will test that values read from stdin or a file are stored and printed back byte for byte
+func TestSetFromInput(t *testing.T) {
+	c, cachectl := newTestServer(t)
+	binary := "\x00\xffbinary\n"
+
+	if code, _, errOut := cachectl(binary, "set", "-type", "image/png", "stdin-key"); code != exitOK {
+		t.Fatalf("set from stdin: got code %d, errors %q", code, errOut)
+	}
+	item, _ := c.GetItem("stdin-key")
+	if raw, ok := item.Value.([]byte); !ok || string(raw) != binary {
+		t.Errorf("Expected raw bytes to be stored, got %v (%T)", item.Value, item.Value)
+	}
+	if item.Metadata["content-type"] != "image/png" {
+		t.Errorf("Expected the content type to be stored, got %v", item.Metadata)
+	}
+	if _, out, _ := cachectl("", "get", "stdin-key"); out != binary {
+		t.Errorf("get: expected the exact bytes, got %q", out)
+	}
+
+	path := filepath.Join(t.TempDir(), "value.txt")
+	os.WriteFile(path, []byte("from a file"), 0o644)
+	if code, _, errOut := cachectl("", "set", "-file", path, "file-key"); code != exitOK {
+		t.Fatalf("set -file: got code %d, errors %q", code, errOut)
+	}
+	if _, out, _ := cachectl("", "get", "file-key"); out != "from a file" {
+		t.Errorf("get: got %q", out)
+	}
+}
+
+func TestDelTTLKeys(t *testing.T) {
+	c, cachectl := newTestServer(t)
+	c.Set("user:1", 1, time.Minute)
+	c.Set("user:2", 2, cache.NoExpiration)
+	c.Set("order:1", 3, cache.NoExpiration)
+
+	if _, out, _ := cachectl("", "keys", "user:*"); out != "user:1\nuser:2\n" {
+		t.Errorf("keys: got %q", out)
+	}
+	if _, out, _ := cachectl("", "-o", "json", "keys"); out != "[\n  \"order:1\",\n  \"user:1\",\n  \"user:2\"\n]\n" {
+		t.Errorf("keys -o json: got %q", out)
+	}
+
+	if _, out, _ := cachectl("", "ttl", "user:2"); out != "no expiration\n" {
+		t.Errorf("ttl of a key without expiration: got %q", out)
+	}
+	_, out, _ := cachectl("", "ttl", "user:1")
+	if d, err := time.ParseDuration(strings.TrimSpace(out)); err != nil || d <= 0 || d > time.Minute {
+		t.Errorf("ttl: expected a duration of up to a minute, got %q", out)
+	}
+
+	if code, out, _ := cachectl("", "del", "user:1", "user:2"); code != exitOK || out != "OK\n" {
+		t.Errorf("del: got code %d, output %q", code, out)
+	}
+	if keys := c.Keys(""); len(keys) != 1 || keys[0] != "order:1" {
+		t.Errorf("Expected only order:1 to be left, got %v", keys)
+	}
+}
+
+func TestStatsAndFlush(t *testing.T) {
+	c, cachectl := newTestServer(t)
+	c.Set("a", 1, cache.NoExpiration)
+	c.Set("b", 2, cache.NoExpiration)
+
+	_, out, _ := cachectl("", "stats")
+	if !strings.HasPrefix(out, "STAT") || !strings.Contains(out, "sets") {
+		t.Errorf("stats: expected a table, got %q", out)
+	}
+
+	if _, out, _ := cachectl("", "flush"); out != "Flushed 2 keys\n" {
+		t.Errorf("flush: got %q", out)
+	}
+	if c.Len() != 0 {
+		t.Errorf("Expected an empty cache after flush, got %d keys", c.Len())
+	}
+}
+
+func TestDumpRestore(t *testing.T) {
+	c, cachectl := newTestServer(t)
+	c.Set("a", "hello", cache.NoExpiration)
+	path := filepath.Join(t.TempDir(), "cache.snapshot")
+
+	if code, out, errOut := cachectl("", "dump", path); code != exitOK || !strings.Contains(out, path) {
+		t.Fatalf("dump: got code %d, output %q, errors %q", code, out, errOut)
+	}
+
+	c.Flush()
+	if code, out, errOut := cachectl("", "restore", path); code != exitOK || out != "Loaded 1 items\n" {
+		t.Fatalf("restore: got code %d, output %q, errors %q", code, out, errOut)
+	}
+	if v, _ := c.Get("a"); v != "hello" {
+		t.Errorf("Expected restored value hello, got %v", v)
+	}
+
+	// Without a file, the snapshot goes to stdout and comes back from stdin
+	_, snapshot, _ := cachectl("", "dump")
+	c.Flush()
+	if code, _, errOut := cachectl(snapshot, "restore"); code != exitOK {
+		t.Fatalf("restore from stdin: got code %d, errors %q", code, errOut)
+	}
+	if c.Len() != 1 {
+		t.Errorf("Expected 1 restored key, got %d", c.Len())
+	}
+}
+
+func TestUsageErrors(t *testing.T) {
+	_, cachectl := newTestServer(t)
+
+	tests := [][]string{
+		{"unknown"},
+		{"get"},
+		{"set", "-bogus", "k", "v"},
+		{"-o", "yaml", "keys"},
+	}
+	for _, args := range tests {
+		if code, _, _ := cachectl("", args...); code != exitUsage {
+			t.Errorf("%v: expected exit code %d, got %d", args, exitUsage, code)
+		}
+	}
+}
+
+// Will test that watch prints events until the command is interrupted
+func TestWatch(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	srv := httptest.NewServer((&api.Handler{Cache: c}).Routes())
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var stdout syncBuffer
+	done := make(chan int)
+	go func() {
+		getenv := func(name string) string {
+			if name == "CACHE_URL" {
+				return srv.URL
+			}
+			return ""
+		}
+		done <- run(ctx, []string{"watch", "user:*"}, strings.NewReader(""), &stdout, &bytes.Buffer{}, getenv)
+	}()
+
+	// Keep writing until the watcher is connected and has printed the event
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(stdout.String(), "user:1") && time.Now().Before(deadline) {
+		c.Set("user:1", "alice", cache.NoExpiration)
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case code := <-done:
+		if code != exitOK {
+			t.Errorf("Expected exit code %d, got %d", exitOK, code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("watch did not stop after the context was cancelled")
+	}
+	if out := stdout.String(); !strings.Contains(out, "set     user:1 \"alice\"") {
+		t.Errorf("Unexpected watch output: %q", out)
+	}
+}
+
+// A bytes.Buffer that can be written by the command while the test reads it
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/cache"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// JSON shape of a key, the same fields as the server's JSON view
+type itemOutput struct {
+	Key       string            `json:"key"`
+	Value     interface{}       `json:"value"`
+	TTL       *float64          `json:"ttl"`
+	ExpiresAt *time.Time        `json:"expires_at"`
+	Metadata  map[string]string `json:"metadata,omitempty"`
+	Version   uint64            `json:"version"`
+}
+
+func itemJSON(key string, item cache.CacheItem) itemOutput {
+	out := itemOutput{
+		Key:      key,
+		Value:    item.Value,
+		Metadata: item.Metadata,
+		Version:  item.Version,
+	}
+	if ttl, expires := item.TTL(); expires {
+		seconds := ttl.Seconds()
+		expiresAt := time.Unix(0, item.Expiration).UTC()
+		out.TTL = &seconds
+		out.ExpiresAt = &expiresAt
+	}
+	return out
+}
+
+func (c *cli) printJSON(v interface{}) error {
+	enc := json.NewEncoder(c.stdout)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// Will print a value for people: bytes as they are (so "cachectl get img > img.png" works), strings as-is, anything else as JSON
+func (c *cli) printValue(value interface{}) error {
+	switch v := value.(type) {
+	case []byte:
+		_, err := c.stdout.Write(v)
+		return err
+	case string:
+		_, err := fmt.Fprintln(c.stdout, v)
+		return err
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "%s\n", data)
+		return err
+	}
+}
+
+func (c *cli) printOK() error {
+	if c.format == formatJSON {
+		return c.printJSON(map[string]bool{"ok": true})
+	}
+	_, err := fmt.Fprintln(c.stdout, "OK")
+	return err
+}
+
+// Will print rows as aligned columns under a header
+func (c *cli) printTable(header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// Will print one keyspace event, as a line of text or as one JSON object per line (easy to pipe into jq)
+func (c *cli) printEvent(ev cache.Event) error {
+	if c.format == formatJSON {
+		data, err := json.Marshal(map[string]interface{}{
+			"type":  ev.Type.String(),
+			"key":   ev.Key,
+			"value": ev.Value,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(c.stdout, "%s\n", data)
+		return err
+	}
+
+	line := fmt.Sprintf("%-7s %s", ev.Type, ev.Key)
+	if ev.Value != nil {
+		value, _ := json.Marshal(ev.Value)
+		line += " " + string(value)
+	}
+	_, err := fmt.Fprintln(c.stdout, line)
+	return err
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Most history entries kept in the history file
+const maxHistory = 1000
+
+// Will read commands line by line until "exit" or end of input. Lines are split like a shell would
+// (quotes group words), and every line is appended to the history file so it survives restarts.
+// "history" lists previous lines, "!!" repeats the last one and "!N" repeats entry N.
+func (c *cli) repl(ctx context.Context, getenv func(string) string) int {
+	h := loadHistory(historyPath(getenv))
+	defer h.save()
+
+	fmt.Fprintln(c.stdout, `cachectl interactive mode, type "help" for commands and "exit" to quit`)
+	scanner := bufio.NewScanner(c.stdin)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+
+	status := exitOK
+	for {
+		fmt.Fprint(c.stdout, "cachectl> ")
+		if !scanner.Scan() {
+			fmt.Fprintln(c.stdout)
+			return status
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		// History expansion happens first, so the expanded line is what gets stored
+		if strings.HasPrefix(line, "!") {
+			expanded, err := h.expand(line)
+			if err != nil {
+				fmt.Fprintf(c.stderr, "cachectl: %v\n", err)
+				continue
+			}
+			line = expanded
+			fmt.Fprintln(c.stdout, line)
+		}
+		h.add(line)
+
+		args, err := splitArgs(line)
+		if err != nil {
+			fmt.Fprintf(c.stderr, "cachectl: %v\n", err)
+			continue
+		}
+
+		switch args[0] {
+		case "exit", "quit":
+			return status
+		case "history":
+			for i, entry := range h.entries {
+				fmt.Fprintf(c.stdout, "%5d  %s\n", i+1, entry)
+			}
+			continue
+		}
+
+		// Reading a value from stdin would eat the next commands, so values must be arguments or files here
+		if args[0] == "set" && !hasFileFlag(args) && len(args) < 3 {
+			fmt.Fprintln(c.stderr, "cachectl set: in interactive mode the value must be an argument or given with -file")
+			continue
+		}
+		status = c.execute(ctx, args)
+	}
+}
+
+func hasFileFlag(args []string) bool {
+	for _, arg := range args {
+		if arg == "-file" || arg == "--file" || strings.HasPrefix(arg, "-file=") || strings.HasPrefix(arg, "--file=") {
+			return true
+		}
+	}
+	return false
+}
+
+// Will split a command line into words. Single quotes keep everything literally, double quotes allow
+// \" and \\ escapes, and a backslash outside quotes escapes the next character.
+func splitArgs(line string) ([]string, error) {
+	var (
+		args    []string
+		current strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range line {
+		switch {
+		case escaped:
+			current.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				current.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, errors.New("unterminated quote or escape")
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+	return args, nil
+}
+
+// Will return where the history is kept: CACHECTL_HISTORY, or ~/.cachectl_history.
+// An empty string disables the history file.
+func historyPath(getenv func(string) string) string {
+	if path, ok := lookupEnv(getenv, "CACHECTL_HISTORY"); ok {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".cachectl_history")
+}
+
+// getenv can't tell an empty variable from a missing one, so "-" is accepted as an explicit "disabled" too
+func lookupEnv(getenv func(string) string, name string) (string, bool) {
+	v := getenv(name)
+	if v == "-" {
+		return "", true
+	}
+	return v, v != ""
+}
+
+type history struct {
+	path    string
+	entries []string
+}
+
+// Will read the history file, a missing or unreadable file just gives an empty history
+func loadHistory(path string) *history {
+	h := &history{path: path}
+	if path == "" {
+		return h
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return h
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			h.entries = append(h.entries, line)
+		}
+	}
+	return h
+}
+
+// Will add a line, skipping it when it repeats the previous one
+func (h *history) add(line string) {
+	if n := len(h.entries); n > 0 && h.entries[n-1] == line {
+		return
+	}
+	h.entries = append(h.entries, line)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[len(h.entries)-maxHistory:]
+	}
+}
+
+// Will write the history file. Errors are ignored, losing the history is not worth failing a command over.
+func (h *history) save() {
+	if h.path == "" {
+		return
+	}
+	os.WriteFile(h.path, []byte(strings.Join(h.entries, "\n")+"\n"), 0o600)
+}
+
+// Will resolve "!!" (the last line) and "!N" (entry N, as numbered by the history command)
+func (h *history) expand(line string) (string, error) {
+	if line == "!!" {
+		if len(h.entries) == 0 {
+			return "", errors.New("history is empty")
+		}
+		return h.entries[len(h.entries)-1], nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < 1 || n > len(h.entries) {
+		return "", fmt.Errorf("no history entry %s", line[1:])
+	}
+	return h.entries[n-1], nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected []string
+	}{
+		{"get key", []string{"get", "key"}},
+		{"  set   key\tvalue  ", []string{"set", "key", "value"}},
+		{`set key "hello world"`, []string{"set", "key", "hello world"}},
+		{`set key 'it''s'`, []string{"set", "key", "its"}},
+		{`set key 'say "hi"'`, []string{"set", "key", `say "hi"`}},
+		{`set key "a \"quoted\" \\ word"`, []string{"set", "key", `a "quoted" \ word`}},
+		{`set key hello\ world`, []string{"set", "key", "hello world"}},
+		{`set key ""`, []string{"set", "key", ""}},
+	}
+	for _, tt := range tests {
+		args, err := splitArgs(tt.line)
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(args, tt.expected) {
+			t.Errorf("%q: got %q, expected %q", tt.line, args, tt.expected)
+		}
+	}
+
+	for _, line := range []string{`set key "unterminated`, `set key 'open`, `trailing\`, "   "} {
+		if _, err := splitArgs(line); err == nil {
+			t.Errorf("%q: expected an error", line)
+		}
+	}
+}
+
+func TestHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	h := loadHistory(path)
+	h.add("keys")
+	h.add("get a")
+	h.add("get a") // Repeats are stored once
+	h.save()
+
+	h = loadHistory(path)
+	if !reflect.DeepEqual(h.entries, []string{"keys", "get a"}) {
+		t.Fatalf("Unexpected entries after reload: %q", h.entries)
+	}
+
+	if line, _ := h.expand("!!"); line != "get a" {
+		t.Errorf("!!: got %q", line)
+	}
+	if line, _ := h.expand("!1"); line != "keys" {
+		t.Errorf("!1: got %q", line)
+	}
+	if _, err := h.expand("!3"); err == nil {
+		t.Errorf("!3: expected an error")
+	}
+
+	for i := 0; i < maxHistory+10; i++ {
+		h.add(strings.Repeat("x", i%2+1))
+	}
+	if len(h.entries) != maxHistory {
+		t.Errorf("Expected history to be capped at %d, got %d", maxHistory, len(h.entries))
+	}
+}
+
+// Will test an interactive session, including history expansion and a history file that survives it
+func TestREPL(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	srv := httptest.NewServer((&api.Handler{Cache: c}).Routes())
+	defer srv.Close()
+
+	historyFile := filepath.Join(t.TempDir(), "history")
+	getenv := func(name string) string {
+		switch name {
+		case "CACHE_URL":
+			return srv.URL
+		case "CACHECTL_HISTORY":
+			return historyFile
+		}
+		return ""
+	}
+
+	session := strings.Join([]string{
+		`set greeting "hello world"`,
+		`get greeting`,
+		`!!`,
+		`set lonely`, // Would read stdin, refused
+		`bogus`,
+		`history`,
+		`exit`,
+		`get greeting`, // Never runs
+	}, "\n")
+
+	var stdout, stderr bytes.Buffer
+	code := run(context.Background(), nil, strings.NewReader(session), &stdout, &stderr, getenv)
+	if code != exitUsage {
+		t.Errorf("Expected the status of the last command (%d), got %d", exitUsage, code)
+	}
+
+	out := stdout.String()
+	if strings.Count(out, "hello world\n") != 2 || !strings.Contains(out, "> get greeting\n") {
+		t.Errorf("Expected get to run twice, output:\n%s", out)
+	}
+	if !strings.Contains(out, "    1  set greeting \"hello world\"") {
+		t.Errorf("Expected numbered history, output:\n%s", out)
+	}
+	if !strings.Contains(stderr.String(), "interactive mode") || !strings.Contains(stderr.String(), "unknown command") {
+		t.Errorf("Unexpected errors:\n%s", stderr.String())
+	}
+
+	data, _ := os.ReadFile(historyFile)
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != 6 || lines[len(lines)-1] != "exit" {
+		t.Errorf("Unexpected history file:\n%s", data)
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Everything main needs to start the server
+type Config struct {
+	Addr            string        // Address the HTTP server listens on, i.e. ":8080"
+	CleanupInterval time.Duration // How often the janitor removes expired items
+	SnapshotPath    string        // When set, the cache is loaded from this file on start and saved to it on shutdown
+	ShutdownTimeout time.Duration // How long in-flight requests get to finish after SIGINT/SIGTERM
+	RESPAddr        string        // When set, the cache is also served over the Redis protocol on this address
+	MemcacheAddr    string        // When set, the cache is also served over the memcached protocol on this address
+	MaxRestoreSize  int64         // Largest snapshot PUT /v2/snapshot accepts, in bytes
+
+	// Cluster mode, enabled by Peers or PeersFile. Keys are spread over the nodes and requests are forwarded to the owner.
+	Self      string   // URL the other nodes reach this node at, i.e. "http://10.0.0.1:8080"
+	Peers     []string // URLs of the other nodes
+	PeersFile string   // File with one node URL per line, reloaded when it changes. Replaces Peers.
+	// Gossip membership, replaces Peers as soon as the first members are found. Can't be combined with PeersFile.
+	GossipAddr string   // UDP address to gossip on, i.e. ":7946"
+	Join       []string // Gossip addresses of nodes to join, i.e. "10.0.0.2:7946"
+
+	// Replication. A node can be a primary, a follower (read-only replica) or both, to chain replicas.
+	Replicate bool   // Serve a change stream that followers replicate from
+	ReplicaOf string // URL of the primary to replicate from, makes this node a read-only follower
+
+	// Strongly consistent keys, replicated through a Raft log by a small group of nodes. Needs Self.
+	RaftPeers []string // URLs of the nodes of the Raft group
+	RaftDir   string   // Directory the node saves its term, vote, log and snapshot to
+
+	// Multi-primary replication of CRDT counters, registers and sets, written to the main cache. Needs Self.
+	CRDTPeers []string // URLs of the other replicas
+}
+
+// Will read the config from command line flags. Every flag can also be given as an environment variable
+// (i.e. -addr or CACHE_ADDR), flags win when both are set.
+func loadConfig(args []string, getenv func(string) string) (Config, error) {
+	cfg := Config{
+		Addr:            ":8080",
+		CleanupInterval: cache.DefaultOptions.CleanupInterval,
+		ShutdownTimeout: 10 * time.Second,
+		MaxRestoreSize:  api.DefaultMaxRestoreSize,
+	}
+
+	// Environment variables replace the built-in defaults first, so flags can override them after
+	if v := getenv("CACHE_ADDR"); v != "" {
+		cfg.Addr = v
+	}
+	if v := getenv("CACHE_SNAPSHOT"); v != "" {
+		cfg.SnapshotPath = v
+	}
+	if v := getenv("CACHE_RESP_ADDR"); v != "" {
+		cfg.RESPAddr = v
+	}
+	if v := getenv("CACHE_MEMCACHE_ADDR"); v != "" {
+		cfg.MemcacheAddr = v
+	}
+	if v := getenv("CACHE_MAX_RESTORE_SIZE"); v != "" {
+		size, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CACHE_MAX_RESTORE_SIZE: %w", err)
+		}
+		cfg.MaxRestoreSize = size
+	}
+	if v := getenv("CACHE_SELF"); v != "" {
+		cfg.Self = v
+	}
+	peers := getenv("CACHE_PEERS")
+	if v := getenv("CACHE_PEERS_FILE"); v != "" {
+		cfg.PeersFile = v
+	}
+	if v := getenv("CACHE_GOSSIP_ADDR"); v != "" {
+		cfg.GossipAddr = v
+	}
+	join := getenv("CACHE_JOIN")
+	if v := getenv("CACHE_REPLICATE"); v != "" {
+		replicate, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid CACHE_REPLICATE: %w", err)
+		}
+		cfg.Replicate = replicate
+	}
+	if v := getenv("CACHE_REPLICA_OF"); v != "" {
+		cfg.ReplicaOf = v
+	}
+	raftPeers := getenv("CACHE_RAFT_PEERS")
+	if v := getenv("CACHE_RAFT_DIR"); v != "" {
+		cfg.RaftDir = v
+	}
+	crdtPeers := getenv("CACHE_CRDT_PEERS")
+	if err := durationFromEnv(getenv, "CACHE_CLEANUP_INTERVAL", &cfg.CleanupInterval); err != nil {
+		return cfg, err
+	}
+	if err := durationFromEnv(getenv, "CACHE_SHUTDOWN_TIMEOUT", &cfg.ShutdownTimeout); err != nil {
+		return cfg, err
+	}
+
+	fs := flag.NewFlagSet("golang-memory-cache", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.StringVar(&cfg.Addr, "addr", cfg.Addr, "address to listen on (CACHE_ADDR)")
+	fs.DurationVar(&cfg.CleanupInterval, "cleanup-interval", cfg.CleanupInterval, "how often expired items are removed (CACHE_CLEANUP_INTERVAL)")
+	fs.StringVar(&cfg.SnapshotPath, "snapshot", cfg.SnapshotPath, "snapshot file loaded on start and saved on shutdown (CACHE_SNAPSHOT)")
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", cfg.ShutdownTimeout, "how long to wait for in-flight requests on shutdown (CACHE_SHUTDOWN_TIMEOUT)")
+	fs.StringVar(&cfg.RESPAddr, "resp-addr", cfg.RESPAddr, "address for the Redis protocol server, disabled when empty (CACHE_RESP_ADDR)")
+	fs.StringVar(&cfg.MemcacheAddr, "memcache-addr", cfg.MemcacheAddr, "address for the memcached protocol server, disabled when empty (CACHE_MEMCACHE_ADDR)")
+	fs.Int64Var(&cfg.MaxRestoreSize, "max-restore-size", cfg.MaxRestoreSize, "largest snapshot PUT /v2/snapshot accepts, in bytes (CACHE_MAX_RESTORE_SIZE)")
+	fs.StringVar(&cfg.Self, "self", cfg.Self, "URL other cluster nodes reach this node at (CACHE_SELF)")
+	fs.StringVar(&peers, "peers", peers, "comma separated URLs of the other cluster nodes (CACHE_PEERS)")
+	fs.StringVar(&cfg.PeersFile, "peers-file", cfg.PeersFile, "file listing the cluster nodes, reloaded when it changes (CACHE_PEERS_FILE)")
+	fs.StringVar(&cfg.GossipAddr, "gossip-addr", cfg.GossipAddr, "UDP address for gossip membership, disabled when empty (CACHE_GOSSIP_ADDR)")
+	fs.StringVar(&join, "join", join, "comma separated gossip addresses of nodes to join (CACHE_JOIN)")
+	fs.BoolVar(&cfg.Replicate, "replicate", cfg.Replicate, "serve a change stream for followers (CACHE_REPLICATE)")
+	fs.StringVar(&cfg.ReplicaOf, "replica-of", cfg.ReplicaOf, "URL of a primary to replicate from, as a read-only follower (CACHE_REPLICA_OF)")
+	fs.StringVar(&raftPeers, "raft-peers", raftPeers, "comma separated URLs of the nodes of the Raft group for consistent keys (CACHE_RAFT_PEERS)")
+	fs.StringVar(&cfg.RaftDir, "raft-dir", cfg.RaftDir, "directory the Raft node saves its state to (CACHE_RAFT_DIR)")
+	fs.StringVar(&crdtPeers, "crdt-peers", crdtPeers, "comma separated URLs of the replicas sharing CRDT keys (CACHE_CRDT_PEERS)")
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	cfg.Peers = splitList(peers)
+	cfg.Join = splitList(join)
+	cfg.RaftPeers = splitList(raftPeers)
+	cfg.CRDTPeers = splitList(crdtPeers)
+	if cfg.MaxRestoreSize <= 0 {
+		return cfg, errors.New("-max-restore-size must be positive")
+	}
+	if cfg.clusterMode() && cfg.Self == "" {
+		return cfg, errors.New("cluster mode needs -self, the URL other nodes reach this node at")
+	}
+	if cfg.GossipAddr != "" && cfg.PeersFile != "" {
+		return cfg, errors.New("-gossip-addr and -peers-file can't be used together")
+	}
+	if len(cfg.Join) > 0 && cfg.GossipAddr == "" {
+		return cfg, errors.New("-join needs -gossip-addr")
+	}
+	if len(cfg.RaftPeers) > 0 && cfg.Self == "" {
+		return cfg, errors.New("-raft-peers needs -self, the URL the other nodes of the group reach this node at")
+	}
+	if len(cfg.RaftPeers) > 0 && cfg.RaftDir == "" {
+		// A node that forgot its vote after a restart could vote twice in a term
+		return cfg, errors.New("-raft-peers needs -raft-dir, where the node saves its state")
+	}
+	if len(cfg.CRDTPeers) > 0 && cfg.Self == "" {
+		return cfg, errors.New("-crdt-peers needs -self, the URL the other replicas reach this node at")
+	}
+
+	return cfg, nil
+}
+
+// Will report whether the keys are spread over several nodes
+func (cfg Config) clusterMode() bool {
+	return len(cfg.Peers) > 0 || cfg.PeersFile != "" || cfg.GossipAddr != ""
+}
+
+// Will split a comma separated list, dropping blanks
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Will parse a duration environment variable into dst, leaving dst untouched when the variable is not set
+func durationFromEnv(getenv func(string) string, name string, dst *time.Duration) error {
+	v := getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", name, err)
+	}
+	*dst = d
+	return nil
+}
@@ -0,0 +1,239 @@
+package crdt
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-memory-cache/api"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Paths of the sync requests, under the URL of the peer
+const (
+	pushPath     = "/crdt/push"
+	exchangePath = "/crdt/exchange"
+)
+
+// Largest value or state accepted over HTTP, same as the limit of the v2 API
+const maxBodySize = 10 << 20
+
+// Sends states as gob encoded POST requests. Replica IDs are the base URLs the replicas serve their routes on
+// (see Replica.RegisterRoutes), i.e. "http://10.0.0.1:8080".
+type HTTPTransport struct {
+	client *http.Client
+}
+
+// Creates an HTTPTransport, http.DefaultClient is used when client is nil
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{client: client}
+}
+
+func (t *HTTPTransport) post(ctx context.Context, peer, path string, state State) (*http.Response, error) {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(state); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(peer, "/")+path, &body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("crdt: %s%s: %s", peer, path, resp.Status)
+	}
+	return resp, nil
+}
+
+func (t *HTTPTransport) Push(ctx context.Context, peer string, delta State) error {
+	resp, err := t.post(ctx, peer, pushPath, delta)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (t *HTTPTransport) Exchange(ctx context.Context, peer string, state State) (State, error) {
+	resp, err := t.post(ctx, peer, exchangePath, state)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var theirs State
+	if err := gob.NewDecoder(resp.Body).Decode(&theirs); err != nil {
+		return nil, err
+	}
+	return theirs, nil
+}
+
+// Will mount the sync routes the HTTPTransport of the other replicas calls, and the routes for clients:
+// * POST /crdt/push
+// * POST /crdt/exchange
+// * GET, POST /v2/crdt/counters/{key}
+// * GET, PUT /v2/crdt/registers/{key}
+// * GET /v2/crdt/sets/{key}
+// * PUT, DELETE /v2/crdt/sets/{key}/{member}
+func (r *Replica) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+pushPath, r.PushHandler)
+	mux.HandleFunc("POST "+exchangePath, r.ExchangeHandler)
+	mux.HandleFunc("GET /v2/crdt/counters/{key}", r.GetCounterHandler)
+	mux.HandleFunc("POST /v2/crdt/counters/{key}", r.IncrementHandler)
+	mux.HandleFunc("GET /v2/crdt/registers/{key}", r.GetRegisterHandler)
+	mux.HandleFunc("PUT /v2/crdt/registers/{key}", r.SetRegisterHandler)
+	mux.HandleFunc("GET /v2/crdt/sets/{key}", r.MembersHandler)
+	mux.HandleFunc("PUT /v2/crdt/sets/{key}/{member}", r.AddMemberHandler)
+	mux.HandleFunc("DELETE /v2/crdt/sets/{key}/{member}", r.RemoveMemberHandler)
+}
+
+// Will decode a state from the request body
+func readState(w http.ResponseWriter, req *http.Request) (State, bool) {
+	var state State
+	if err := gob.NewDecoder(http.MaxBytesReader(w, req.Body, maxBodySize)).Decode(&state); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid state")
+		return nil, false
+	}
+	return state, true
+}
+
+// * POST /crdt/push
+// Merges a delta from another replica
+func (r *Replica) PushHandler(w http.ResponseWriter, req *http.Request) {
+	if delta, ok := readState(w, req); ok {
+		r.Merge(delta)
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// * POST /crdt/exchange
+// Merges the whole state of another replica, and answers with the whole state of this one
+func (r *Replica) ExchangeHandler(w http.ResponseWriter, req *http.Request) {
+	state, ok := readState(w, req)
+	if !ok {
+		return
+	}
+	r.Merge(state)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	gob.NewEncoder(w).Encode(r.State())
+}
+
+// * GET /v2/crdt/counters/{key}
+func (r *Replica) GetCounterHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("key")
+	value, found := r.Counter(key)
+	if !found {
+		api.WriteError(w, http.StatusNotFound, "Key not found")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+}
+
+// * POST /v2/crdt/counters/{key}?by=5
+// Adds by (1 when missing, may be negative) to the counter
+func (r *Replica) IncrementHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("key")
+	delta := int64(1)
+	if by := req.URL.Query().Get("by"); by != "" {
+		n, err := strconv.ParseInt(by, 10, 64)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, "by must be an integer")
+			return
+		}
+		delta = n
+	}
+	value, err := r.Increment(key, delta)
+	if err != nil {
+		writeReplicaError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+}
+
+// * GET /v2/crdt/registers/{key}
+func (r *Replica) GetRegisterHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("key")
+	value, found := r.Get(key)
+	if !found {
+		api.WriteError(w, http.StatusNotFound, "Key not found")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+}
+
+// * PUT /v2/crdt/registers/{key}
+// With Content-Type: application/json the body is the JSON value, otherwise the body is stored as a string
+func (r *Replica) SetRegisterHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("key")
+	body, err := io.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Value too large")
+		return
+	}
+	var value interface{} = string(body)
+	if mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type")); mediaType == "application/json" {
+		if err := json.Unmarshal(body, &value); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+	}
+	if err := r.Set(key, value); err != nil {
+		writeReplicaError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value})
+}
+
+// * GET /v2/crdt/sets/{key}
+func (r *Replica) MembersHandler(w http.ResponseWriter, req *http.Request) {
+	key := req.PathValue("key")
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "members": nonNil(r.Members(key))})
+}
+
+// * PUT /v2/crdt/sets/{key}/{member}
+func (r *Replica) AddMemberHandler(w http.ResponseWriter, req *http.Request) {
+	r.changeMember(w, req, r.SAdd)
+}
+
+// * DELETE /v2/crdt/sets/{key}/{member}
+func (r *Replica) RemoveMemberHandler(w http.ResponseWriter, req *http.Request) {
+	r.changeMember(w, req, r.SRem)
+}
+
+func (r *Replica) changeMember(w http.ResponseWriter, req *http.Request, change func(key string, members ...string) (int, error)) {
+	key := req.PathValue("key")
+	if _, err := change(key, req.PathValue("member")); err != nil {
+		writeReplicaError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "members": nonNil(r.Members(key))})
+}
+
+// So an empty set is [] in JSON rather than null
+func nonNil(members []string) []string {
+	if members == nil {
+		return []string{}
+	}
+	return members
+}
+
+func writeReplicaError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrWrongType) {
+		api.WriteError(w, http.StatusConflict, "Key holds another type")
+		return
+	}
+	api.WriteError(w, http.StatusInternalServerError, err.Error())
+}
@@ -0,0 +1,83 @@
+package crdt
+
+import (
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Will test the client routes on two replicas syncing over the HTTPTransport
+func TestHTTP(t *testing.T) {
+	var urls []string
+	var muxes []*http.ServeMux
+	for i := 0; i < 2; i++ {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		urls, muxes = append(urls, srv.URL), append(muxes, mux)
+	}
+	var replicas []*Replica
+	for i, url := range urls {
+		c := cache.NewCache()
+		t.Cleanup(c.Stop)
+		r, err := NewReplicaWithOptions(c, url, urls, NewHTTPTransport(nil), testOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(r.Close)
+		r.RegisterRoutes(muxes[i])
+		replicas = append(replicas, r)
+	}
+
+	do := func(method, url, body string, header ...string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, url, strings.NewReader(body))
+		if len(header) == 2 {
+			req.Header.Set(header[0], header[1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp.StatusCode, decoded
+	}
+
+	// Writes on both replicas at once
+	do("POST", urls[0]+"/v2/crdt/counters/hits?by=5", "")
+	do("POST", urls[1]+"/v2/crdt/counters/hits?by=-2", "")
+	do("PUT", urls[0]+"/v2/crdt/sets/tags/red", "")
+	do("PUT", urls[1]+"/v2/crdt/sets/tags/blue", "")
+	do("PUT", urls[1]+"/v2/crdt/registers/config", `{"mode": "fast"}`, "Content-Type", "application/json")
+	waitConverged(t, replicas)
+
+	for _, url := range urls {
+		if status, body := do("GET", url+"/v2/crdt/counters/hits", ""); status != http.StatusOK || body["value"] != float64(3) {
+			t.Errorf("Unexpected counter on %s: %d %v", url, status, body)
+		}
+		if _, body := do("GET", url+"/v2/crdt/sets/tags", ""); len(body["members"].([]interface{})) != 2 {
+			t.Errorf("Unexpected set on %s: %v", url, body)
+		}
+		if _, body := do("GET", url+"/v2/crdt/registers/config", ""); body["value"].(map[string]interface{})["mode"] != "fast" {
+			t.Errorf("Unexpected register on %s: %v", url, body)
+		}
+	}
+
+	if status, body := do("DELETE", urls[0]+"/v2/crdt/sets/tags/red", ""); status != http.StatusOK || len(body["members"].([]interface{})) != 1 {
+		t.Errorf("Unexpected response to a removal: %d %v", status, body)
+	}
+	if status, _ := do("PUT", urls[0]+"/v2/crdt/registers/hits", "x"); status != http.StatusConflict {
+		t.Errorf("Expected 409 when a counter is set as a register, got %d", status)
+	}
+	if status, _ := do("GET", urls[0]+"/v2/crdt/counters/missing", ""); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for a missing counter, got %d", status)
+	}
+	if stats := replicas[0].GetStats(); stats["crdt_full_syncs"] == 0 || stats["crdt_keys"] != 3 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
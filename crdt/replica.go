@@ -0,0 +1,364 @@
+package crdt
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"golang-memory-cache/cache"
+	mrand "math/rand"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Multi-primary replication with CRDTs (conflict-free replicated data types). Every replica takes writes on its
+// own, without a leader, and replicas converge once they have exchanged their updates:
+//
+//   - Counters (Increment) are PN-Counters, so concurrent increments all count.
+//   - Plain values (Set) are last-writer-wins registers.
+//   - Sets (SAdd/SRem) are observed-remove sets, where an add concurrent with a remove wins.
+//
+// Anti-entropy runs two ways. Every update is kept as a delta for each peer, and the deltas are pushed every
+// SyncInterval (deltas that could not be delivered are kept, merged with the next ones). Every FullSyncInterval
+// a replica also exchanges its whole state with a random peer, which brings back replicas that restarted
+// or missed deltas for any other reason.
+//
+// The converged value of every key is written to a cache.Cache, so the usual read paths see it. The keys must
+// only be written through the Replica, a write to the cache is overwritten by the next update of the key.
+
+// Settings used when creating a Replica
+type Options struct {
+	SyncInterval     time.Duration // How often deltas are pushed to the peers
+	FullSyncInterval time.Duration // How often the whole state is exchanged with a random peer
+	Timeout          time.Duration // How long a push or exchange may take
+}
+
+// Options used by NewReplica
+var DefaultOptions = Options{
+	SyncInterval:     100 * time.Millisecond,
+	FullSyncInterval: 10 * time.Second,
+	Timeout:          5 * time.Second,
+}
+
+var ErrWrongType = errors.New("crdt: key holds another type")
+
+type replicaStats struct {
+	DeltasSent   uint64
+	SendFailures uint64
+	FullSyncs    uint64
+	Merges       uint64
+}
+
+type Replica struct {
+	cache     *cache.Cache
+	id        string
+	actor     string // id plus a random part, unique to this run
+	peers     []string
+	transport Transport
+	options   Options
+
+	mu      sync.Mutex
+	state   State
+	pending map[string]State // Deltas not delivered yet, per peer
+	seq     uint64           // Numbers the tags of this actor's set adds
+	clock   int64            // Latest register timestamp seen, so new writes always win over them
+
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	stats     replicaStats
+}
+
+// Creates a Replica writing to c with DefaultOptions, and starts syncing with peers. id is how the other
+// replicas reach this one through transport (id may be listed in peers too).
+func NewReplica(c *cache.Cache, id string, peers []string, transport Transport) (*Replica, error) {
+	return NewReplicaWithOptions(c, id, peers, transport, DefaultOptions)
+}
+
+// Creates a Replica with custom options. Zero values fall back to DefaultOptions.
+func NewReplicaWithOptions(c *cache.Cache, id string, peers []string, transport Transport, opts Options) (*Replica, error) {
+	if id == "" {
+		return nil, errors.New("crdt: missing replica id")
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultOptions.SyncInterval
+	}
+	if opts.FullSyncInterval <= 0 {
+		opts.FullSyncInterval = DefaultOptions.FullSyncInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultOptions.Timeout
+	}
+
+	b := make([]byte, 4)
+	rand.Read(b)
+	r := &Replica{
+		cache:     c,
+		id:        id,
+		actor:     id + "/" + hex.EncodeToString(b),
+		transport: transport,
+		options:   opts,
+		state:     make(State),
+		pending:   make(map[string]State),
+		done:      make(chan struct{}),
+	}
+	for _, peer := range peers {
+		if peer != id && r.pending[peer] == nil {
+			r.peers = append(r.peers, peer)
+			r.pending[peer] = make(State)
+		}
+	}
+
+	r.wg.Add(1)
+	go r.syncLoop()
+	return r, nil
+}
+
+// Will stop syncing. Deltas that were not delivered yet are dropped, peers get them with the next full sync.
+func (r *Replica) Close() {
+	r.closeOnce.Do(func() {
+		close(r.done)
+		r.wg.Wait()
+	})
+}
+
+func (r *Replica) ID() string { return r.id }
+
+// Will add delta to the counter at key and return its new value
+func (r *Replica) Increment(key string, delta int64) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.entry(key)
+	if entry.Register != nil || entry.Set != nil {
+		return 0, ErrWrongType
+	}
+	if entry.Counter == nil {
+		entry.Counter = NewPNCounter()
+	}
+	r.update(key, &Entry{Counter: entry.Counter.Increment(r.actor, delta)})
+	return entry.Counter.Value(), nil
+}
+
+// Will set the value at key, as a last-writer-wins register
+func (r *Replica) Set(key string, value interface{}) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.entry(key)
+	if entry.Counter != nil || entry.Set != nil {
+		return ErrWrongType
+	}
+	now := time.Now().UnixNano()
+	if now <= r.clock {
+		now = r.clock + 1
+	}
+	r.clock = now
+	register := &LWWRegister{Value: value, Timestamp: now, Actor: r.actor}
+	entry.Register = register
+	r.update(key, &Entry{Register: register.clone()})
+	return nil
+}
+
+// Will add members to the set at key, and return how many were not in it yet
+func (r *Replica) SAdd(key string, members ...string) (int, error) {
+	return r.changeSet(key, members, func(set *ORSet, member string) (*ORSet, bool) {
+		added := !set.Contains(member)
+		r.seq++
+		return set.Add(member, r.actor+"/"+strconv.FormatUint(r.seq, 10)), added
+	})
+}
+
+// Will remove members from the set at key, and return how many were in it
+func (r *Replica) SRem(key string, members ...string) (int, error) {
+	return r.changeSet(key, members, func(set *ORSet, member string) (*ORSet, bool) {
+		if !set.Contains(member) {
+			return nil, false
+		}
+		return set.Remove(member), true
+	})
+}
+
+func (r *Replica) changeSet(key string, members []string, change func(set *ORSet, member string) (*ORSet, bool)) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry := r.entry(key)
+	if entry.Counter != nil || entry.Register != nil {
+		return 0, ErrWrongType
+	}
+	if entry.Set == nil {
+		entry.Set = NewORSet()
+	}
+	delta := NewORSet()
+	changed := 0
+	for _, member := range members {
+		d, ok := change(entry.Set, member)
+		if d != nil {
+			delta.Merge(d)
+		}
+		if ok {
+			changed++
+		}
+	}
+	r.update(key, &Entry{Set: delta})
+	return changed, nil
+}
+
+// Will return the value of the counter at key
+func (r *Replica) Counter(key string) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.state[key]; ok && entry.Counter != nil {
+		return entry.Counter.Value(), true
+	}
+	return 0, false
+}
+
+// Will return the value of the register at key
+func (r *Replica) Get(key string) (interface{}, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.state[key]; ok && entry.Register != nil {
+		return entry.Register.Value, true
+	}
+	return nil, false
+}
+
+// Will return the members of the set at key, sorted
+func (r *Replica) Members(key string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if entry, ok := r.state[key]; ok && entry.Set != nil {
+		return entry.Set.Members()
+	}
+	return nil
+}
+
+// Will return a copy of the whole state
+func (r *Replica) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state.Clone()
+}
+
+// Will merge a state or delta from another replica. Called by the Transport.
+func (r *Replica) Merge(other State) {
+	atomic.AddUint64(&r.stats.Merges, 1)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.state.Merge(other) {
+		entry := r.state[key]
+		if entry.Register != nil && entry.Register.Timestamp > r.clock {
+			r.clock = entry.Register.Timestamp
+		}
+		r.cache.Set(key, entry.value(), cache.NoExpiration)
+	}
+}
+
+// Will return the entry for key, creating it. Must be called while holding r.mu.
+func (r *Replica) entry(key string) *Entry {
+	entry, ok := r.state[key]
+	if !ok {
+		entry = &Entry{}
+		r.state[key] = entry
+	}
+	return entry
+}
+
+// Will queue the delta of a local update for every peer, and show the new value in the cache.
+// Must be called while holding r.mu.
+func (r *Replica) update(key string, delta *Entry) {
+	for _, pending := range r.pending {
+		pending.Merge(State{key: delta})
+	}
+	r.cache.Set(key, r.state[key].value(), cache.NoExpiration)
+}
+
+func (r *Replica) syncLoop() {
+	defer r.wg.Done()
+	// Catch up with every peer right away, i.e. after a restart
+	for _, peer := range r.peers {
+		r.exchange(peer)
+	}
+
+	ticker := time.NewTicker(r.options.SyncInterval)
+	defer ticker.Stop()
+	fullSync := time.NewTicker(r.options.FullSyncInterval)
+	defer fullSync.Stop()
+	for {
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+			r.pushDeltas()
+		case <-fullSync.C:
+			if len(r.peers) > 0 {
+				r.exchange(r.peers[mrand.Intn(len(r.peers))])
+			}
+		}
+	}
+}
+
+// Will send every peer the deltas queued for it. Deltas that could not be delivered are queued again.
+func (r *Replica) pushDeltas() {
+	var wg sync.WaitGroup
+	for _, peer := range r.peers {
+		r.mu.Lock()
+		delta := r.pending[peer]
+		if len(delta) > 0 {
+			r.pending[peer] = make(State)
+		}
+		r.mu.Unlock()
+		if len(delta) == 0 {
+			continue
+		}
+
+		wg.Add(1)
+		go func(peer string, delta State) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(context.Background(), r.options.Timeout)
+			defer cancel()
+			if err := r.transport.Push(ctx, peer, delta); err != nil {
+				atomic.AddUint64(&r.stats.SendFailures, 1)
+				r.mu.Lock()
+				r.pending[peer].Merge(delta)
+				r.mu.Unlock()
+				return
+			}
+			atomic.AddUint64(&r.stats.DeltasSent, 1)
+		}(peer, delta)
+	}
+	wg.Wait()
+}
+
+// Will send the whole state to peer and merge the whole state it answers with
+func (r *Replica) exchange(peer string) {
+	ctx, cancel := context.WithTimeout(context.Background(), r.options.Timeout)
+	defer cancel()
+	theirs, err := r.transport.Exchange(ctx, peer, r.State())
+	if err != nil {
+		atomic.AddUint64(&r.stats.SendFailures, 1)
+		return
+	}
+	atomic.AddUint64(&r.stats.FullSyncs, 1)
+	r.Merge(theirs)
+}
+
+// Will return the replica's counters, prefixed with "crdt_"
+func (r *Replica) GetStats() map[string]uint64 {
+	r.mu.Lock()
+	keys := uint64(len(r.state))
+	var pending uint64
+	for _, delta := range r.pending {
+		pending += uint64(len(delta))
+	}
+	r.mu.Unlock()
+	return map[string]uint64{
+		"crdt_keys":          keys,
+		"crdt_pending":       pending,
+		"crdt_deltas_sent":   atomic.LoadUint64(&r.stats.DeltasSent),
+		"crdt_send_failures": atomic.LoadUint64(&r.stats.SendFailures),
+		"crdt_full_syncs":    atomic.LoadUint64(&r.stats.FullSyncs),
+		"crdt_merges":        atomic.LoadUint64(&r.stats.Merges),
+	}
+}
@@ -0,0 +1,137 @@
+package crdt
+
+import (
+	"fmt"
+	"golang-memory-cache/cache"
+	"testing"
+	"time"
+)
+
+var testOptions = Options{
+	SyncInterval:     10 * time.Millisecond,
+	FullSyncInterval: 100 * time.Millisecond,
+}
+
+// Will start n replicas connected by a MemNetwork, each writing to a cache of its own
+func newTestReplicas(t *testing.T, network *MemNetwork, n int) []*Replica {
+	t.Helper()
+	var ids []string
+	for i := 0; i < n; i++ {
+		ids = append(ids, fmt.Sprintf("zone-%d", i))
+	}
+	replicas := make([]*Replica, n)
+	for i, id := range ids {
+		replicas[i] = newTestReplica(t, network, id, ids)
+	}
+	return replicas
+}
+
+func newTestReplica(t *testing.T, network *MemNetwork, id string, ids []string) *Replica {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	r, err := NewReplicaWithOptions(c, id, ids, network.Transport(id), testOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(r.Close)
+	network.Add(r)
+	return r
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func waitConverged(t *testing.T, replicas []*Replica) {
+	t.Helper()
+	waitFor(t, "the replicas to converge", func() bool {
+		first := replicas[0].State()
+		for _, r := range replicas[1:] {
+			if !equalStates(first, r.State()) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Will test that replicas taking writes on both sides of a partition converge once it heals
+func TestConvergeAfterPartition(t *testing.T) {
+	network := NewMemNetwork()
+	replicas := newTestReplicas(t, network, 3)
+	network.Partition([]string{"zone-0"}, []string{"zone-1", "zone-2"})
+
+	for i, r := range replicas {
+		for j := 0; j < 10; j++ {
+			if _, err := r.Increment("visits", 1); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.SAdd("online", fmt.Sprintf("user-%d", i))
+		r.Set("motd", fmt.Sprintf("hello from %d", i))
+	}
+	replicas[1].SRem("online", "user-1")
+	replicas[2].SAdd("online", "user-1") // Concurrent with the removal, may or may not have reached zone-1 first
+
+	time.Sleep(50 * time.Millisecond)
+	if n, _ := replicas[0].Counter("visits"); n != 10 {
+		t.Errorf("Expected zone-0 to only see its own increments while cut off, got %d", n)
+	}
+
+	network.Heal()
+	waitConverged(t, replicas)
+	for _, r := range replicas {
+		if n, _ := r.Counter("visits"); n != 30 {
+			t.Errorf("Expected every increment to count, got %d on %s", n, r.ID())
+		}
+		if members := r.Members("online"); len(members) < 2 {
+			t.Errorf("Unexpected members on %s: %v", r.ID(), members)
+		}
+		if v, _ := r.cache.Get("visits"); v != int64(30) {
+			t.Errorf("Expected the cache of %s to show the converged counter, got %v", r.ID(), v)
+		}
+	}
+	motd, _ := replicas[0].Get("motd")
+	for _, r := range replicas[1:] {
+		if v, _ := r.Get("motd"); v != motd {
+			t.Errorf("Expected every replica to keep the same write, got %v and %v", v, motd)
+		}
+	}
+}
+
+// Will test that a replica that restarts empty gets the state back from the full sync, and that its new
+// increments add to the old ones instead of replacing them
+func TestRestartedReplica(t *testing.T) {
+	network := NewMemNetwork()
+	replicas := newTestReplicas(t, network, 2)
+	replicas[1].Increment("n", 5)
+	waitConverged(t, replicas)
+
+	replicas[1].Close()
+	restarted := newTestReplica(t, network, "zone-1", []string{"zone-0", "zone-1"})
+	restarted.Increment("n", 1) // Possibly before it synced
+	replicas[0].Increment("n", 2)
+	waitConverged(t, []*Replica{replicas[0], restarted})
+	if n, _ := restarted.Counter("n"); n != 8 {
+		t.Errorf("Expected 8, got %d", n)
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	r := newTestReplicas(t, NewMemNetwork(), 1)[0]
+	r.Increment("k", 1)
+	if err := r.Set("k", "v"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := r.SAdd("k", "m"); err != ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
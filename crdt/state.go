@@ -0,0 +1,143 @@
+package crdt
+
+// What a replica knows about one key. Normally only one of the fields is set. When replicas used a key with
+// different types, each type is merged on its own, and the cache shows the counter, else the set, else the register.
+type Entry struct {
+	Counter  *PNCounter
+	Set      *ORSet
+	Register *LWWRegister
+}
+
+// The replicated state of every key, or a delta of it. Deltas and states are merged the same way,
+// and are encoded with encoding/gob between replicas.
+type State map[string]*Entry
+
+// Will merge other into e, copying what it takes so other can still be changed afterwards
+func (e *Entry) Merge(other *Entry) {
+	if other.Counter != nil {
+		if e.Counter == nil {
+			e.Counter = NewPNCounter()
+		}
+		e.Counter.Merge(other.Counter)
+	}
+	if other.Set != nil {
+		if e.Set == nil {
+			e.Set = NewORSet()
+		}
+		e.Set.Merge(other.Set)
+	}
+	if other.Register != nil {
+		if e.Register == nil {
+			e.Register = other.Register.clone()
+		} else {
+			e.Register.Merge(other.Register)
+		}
+	}
+}
+
+// Will return the value the cache shows for the entry
+func (e *Entry) value() interface{} {
+	switch {
+	case e.Counter != nil:
+		return e.Counter.Value()
+	case e.Set != nil:
+		members := e.Set.Members()
+		values := make([]interface{}, len(members)) // A type the cache snapshots know
+		for i, member := range members {
+			values[i] = member
+		}
+		return values
+	case e.Register != nil:
+		return e.Register.Value
+	}
+	return nil
+}
+
+// Will merge other into s, and return the keys that changed
+func (s State) Merge(other State) []string {
+	var changed []string
+	for key, entry := range other {
+		current, ok := s[key]
+		if !ok {
+			current = &Entry{}
+			s[key] = current
+		}
+		before := current.clone()
+		current.Merge(entry)
+		if !ok || !before.equal(current) {
+			changed = append(changed, key)
+		}
+	}
+	return changed
+}
+
+// Will return a deep copy of s
+func (s State) Clone() State {
+	c := make(State, len(s))
+	for key, entry := range s {
+		c[key] = entry.clone()
+	}
+	return c
+}
+
+func (e *Entry) clone() *Entry {
+	c := &Entry{}
+	c.Merge(e)
+	return c
+}
+
+// Will report whether two entries hold the same state
+func (e *Entry) equal(other *Entry) bool {
+	return equalCounters(e.Counter, other.Counter) && equalSets(e.Set, other.Set) && equalRegisters(e.Register, other.Register)
+}
+
+func equalCounters(a, b *PNCounter) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return equalGCounters(a.P, b.P) && equalGCounters(a.N, b.N)
+}
+
+func equalGCounters(a, b GCounter) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for actor, n := range a {
+		if b[actor] != n {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSets(a, b *ORSet) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if len(a.Adds) != len(b.Adds) || len(a.Removed) != len(b.Removed) {
+		return false
+	}
+	for member, tags := range a.Adds {
+		if len(b.Adds[member]) != len(tags) {
+			return false
+		}
+		for tag := range tags {
+			if !b.Adds[member][tag] {
+				return false
+			}
+		}
+	}
+	for tag := range a.Removed {
+		if !b.Removed[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+func equalRegisters(a, b *LWWRegister) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Timestamp == b.Timestamp && a.Actor == b.Actor
+}
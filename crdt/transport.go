@@ -0,0 +1,94 @@
+package crdt
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Carries states between replicas, identified by their ID. The receiving side hands them to Replica.Merge,
+// and answers an Exchange with Replica.State.
+type Transport interface {
+	Push(ctx context.Context, peer string, delta State) error
+	Exchange(ctx context.Context, peer string, state State) (State, error)
+}
+
+// Returned by a MemNetwork transport when the peer is cut off or unknown
+var ErrUnreachable = errors.New("crdt: replica unreachable")
+
+// Connects replicas of the same process, for tests. Partition cuts the network into groups that can't reach
+// each other. States are copied on the way, like they would be on a real network.
+type MemNetwork struct {
+	mu       sync.RWMutex
+	replicas map[string]*Replica
+	group    map[string]int // Partition each replica is in, nil when the network is whole
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{replicas: make(map[string]*Replica)}
+}
+
+// Will return the transport for the replica with the given ID
+func (m *MemNetwork) Transport(id string) Transport {
+	return &memTransport{network: m, from: id}
+}
+
+// Will make a replica reachable, replacing any replica with the same ID (i.e. to restart it)
+func (m *MemNetwork) Add(r *Replica) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replicas[r.ID()] = r
+}
+
+// Will split the network into groups of replica IDs. Replicas only reach replicas of their own group,
+// and replicas that are not in any group reach nobody.
+func (m *MemNetwork) Partition(groups ...[]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group = make(map[string]int)
+	for i, ids := range groups {
+		for _, id := range ids {
+			m.group[id] = i + 1
+		}
+	}
+}
+
+// Will reconnect every replica
+func (m *MemNetwork) Heal() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group = nil
+}
+
+func (m *MemNetwork) route(from, to string) (*Replica, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	r, ok := m.replicas[to]
+	if !ok || (m.group != nil && (m.group[from] == 0 || m.group[from] != m.group[to])) {
+		return nil, ErrUnreachable
+	}
+	return r, nil
+}
+
+type memTransport struct {
+	network *MemNetwork
+	from    string
+}
+
+func (t *memTransport) Push(ctx context.Context, peer string, delta State) error {
+	r, err := t.network.route(t.from, peer)
+	if err != nil {
+		return err
+	}
+	r.Merge(delta.Clone())
+	return nil
+}
+
+func (t *memTransport) Exchange(ctx context.Context, peer string, state State) (State, error) {
+	r, err := t.network.route(t.from, peer)
+	if err != nil {
+		return nil, err
+	}
+	r.Merge(state.Clone())
+	return r.State(), nil
+}
@@ -0,0 +1,170 @@
+package crdt
+
+import "sort"
+
+// The value types. Each one has a Merge that is commutative, associative and idempotent, so replicas that have
+// seen the same updates end up equal, whatever order and however many times the updates arrived.
+// Updates are identified by actor: a replica's ID plus a random part picked when it starts, so a restarted
+// replica never reuses the slots or tags of its previous run.
+
+// Counter that only grows: one count per actor, the value is their sum
+type GCounter map[string]uint64
+
+func (g GCounter) Value() uint64 {
+	var sum uint64
+	for _, n := range g {
+		sum += n
+	}
+	return sum
+}
+
+// Will keep the highest count of every actor
+func (g GCounter) Merge(other GCounter) {
+	for actor, n := range other {
+		if n > g[actor] {
+			g[actor] = n
+		}
+	}
+}
+
+func (g GCounter) clone() GCounter {
+	c := make(GCounter, len(g))
+	for actor, n := range g {
+		c[actor] = n
+	}
+	return c
+}
+
+// Counter that can go up and down: increments and decrements are counted separately
+type PNCounter struct {
+	P GCounter
+	N GCounter
+}
+
+func NewPNCounter() *PNCounter {
+	return &PNCounter{P: GCounter{}, N: GCounter{}}
+}
+
+func (c *PNCounter) Value() int64 {
+	return int64(c.P.Value() - c.N.Value())
+}
+
+// Will add delta for actor, and return the delta to send to other replicas
+func (c *PNCounter) Increment(actor string, delta int64) *PNCounter {
+	d := NewPNCounter()
+	if delta >= 0 {
+		c.P[actor] += uint64(delta)
+		d.P[actor] = c.P[actor]
+	} else {
+		c.N[actor] += uint64(-delta)
+		d.N[actor] = c.N[actor]
+	}
+	return d
+}
+
+func (c *PNCounter) Merge(other *PNCounter) {
+	c.P.Merge(other.P)
+	c.N.Merge(other.N)
+}
+
+func (c *PNCounter) clone() *PNCounter {
+	return &PNCounter{P: c.P.clone(), N: c.N.clone()}
+}
+
+// Last-writer-wins register. The write with the highest timestamp wins, ties go to the highest actor.
+type LWWRegister struct {
+	Value     interface{}
+	Timestamp int64 // Unix nanoseconds, kept above every timestamp the replica has seen for the key
+	Actor     string
+}
+
+func (r *LWWRegister) newer(other *LWWRegister) bool {
+	if r.Timestamp != other.Timestamp {
+		return r.Timestamp > other.Timestamp
+	}
+	return r.Actor > other.Actor
+}
+
+func (r *LWWRegister) Merge(other *LWWRegister) {
+	if other.newer(r) {
+		*r = *other
+	}
+}
+
+func (r *LWWRegister) clone() *LWWRegister {
+	c := *r
+	return &c
+}
+
+// Observed-remove set of strings. Every add gets a unique tag, and a remove only removes the tags it has seen,
+// so an add concurrent with a remove wins. Removed tags are kept as tombstones.
+type ORSet struct {
+	Adds    map[string]map[string]bool // Member -> tags of the adds that were not removed
+	Removed map[string]bool            // Tags of removed adds
+}
+
+func NewORSet() *ORSet {
+	return &ORSet{Adds: make(map[string]map[string]bool), Removed: make(map[string]bool)}
+}
+
+// Will add member with a new tag, and return the delta to send to other replicas
+func (s *ORSet) Add(member, tag string) *ORSet {
+	d := NewORSet()
+	d.Adds[member] = map[string]bool{tag: true}
+	s.Merge(d)
+	return d
+}
+
+// Will remove member as far as this replica has seen it, and return the delta to send to other replicas
+func (s *ORSet) Remove(member string) *ORSet {
+	d := NewORSet()
+	for tag := range s.Adds[member] {
+		d.Removed[tag] = true
+	}
+	s.Merge(d)
+	return d
+}
+
+func (s *ORSet) Contains(member string) bool {
+	return len(s.Adds[member]) > 0
+}
+
+// Will return the members, sorted
+func (s *ORSet) Members() []string {
+	members := make([]string, 0, len(s.Adds))
+	for member := range s.Adds {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+func (s *ORSet) Merge(other *ORSet) {
+	for tag := range other.Removed {
+		s.Removed[tag] = true
+	}
+	for member, tags := range other.Adds {
+		for tag := range tags {
+			if s.Adds[member] == nil {
+				s.Adds[member] = make(map[string]bool)
+			}
+			s.Adds[member][tag] = true
+		}
+	}
+	for member, tags := range s.Adds {
+		for tag := range tags {
+			if s.Removed[tag] {
+				delete(tags, tag)
+			}
+		}
+		if len(tags) == 0 {
+			delete(s.Adds, member)
+		}
+	}
+}
+
+func (s *ORSet) clone() *ORSet {
+	c := NewORSet()
+	c.Merge(s)
+	return c
+}
@@ -0,0 +1,150 @@
+package crdt
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func equalStates(a, b State) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for key, entry := range a {
+		other, ok := b[key]
+		if !ok || !entry.equal(other) {
+			return false
+		}
+	}
+	return true
+}
+
+// Will apply random updates to n independent states, the same ones for a given seed
+func randomStates(seed int64, n int) []State {
+	rnd := rand.New(rand.NewSource(seed))
+	keys := []string{"counter", "set", "register"}
+	states := make([]State, n)
+	for i := range states {
+		states[i] = make(State)
+		actor := fmt.Sprintf("actor-%d", i)
+		for op := 0; op < 50; op++ {
+			key := keys[rnd.Intn(len(keys))]
+			entry, ok := states[i][key]
+			if !ok {
+				entry = &Entry{}
+				states[i][key] = entry
+			}
+			switch key {
+			case "counter":
+				if entry.Counter == nil {
+					entry.Counter = NewPNCounter()
+				}
+				entry.Counter.Increment(actor, rnd.Int63n(21)-10)
+			case "set":
+				if entry.Set == nil {
+					entry.Set = NewORSet()
+				}
+				member := fmt.Sprint(rnd.Intn(5))
+				if rnd.Intn(3) == 0 {
+					entry.Set.Remove(member)
+				} else {
+					entry.Set.Add(member, fmt.Sprintf("%s/%d", actor, op))
+				}
+			case "register":
+				// Few distinct timestamps, so ties are common
+				entry.Register = &LWWRegister{Value: rnd.Intn(100), Timestamp: rnd.Int63n(5), Actor: actor}
+			}
+		}
+	}
+	return states
+}
+
+func merged(states ...State) State {
+	result := make(State)
+	for _, s := range states {
+		result.Merge(s.Clone())
+	}
+	return result
+}
+
+// Will test that merging is commutative, associative and idempotent, for many random histories
+func TestMergeProperties(t *testing.T) {
+	for seed := int64(1); seed <= 200; seed++ {
+		s := randomStates(seed, 3)
+		a, b, c := s[0], s[1], s[2]
+
+		if !equalStates(merged(a, b), merged(b, a)) {
+			t.Fatalf("seed %d: merge is not commutative", seed)
+		}
+		if !equalStates(merged(merged(a, b), c), merged(a, merged(b, c))) {
+			t.Fatalf("seed %d: merge is not associative", seed)
+		}
+		if !equalStates(merged(a, a), merged(a)) || !equalStates(merged(a, b, a, b), merged(a, b)) {
+			t.Fatalf("seed %d: merge is not idempotent", seed)
+		}
+		// Replicas that saw the same updates in any order show the same values
+		x, y := merged(c, a, b), merged(b, c, a)
+		for key, entry := range x {
+			if fmt.Sprint(entry.value()) != fmt.Sprint(y[key].value()) {
+				t.Fatalf("seed %d: %s is %v on one replica and %v on the other", seed, key, entry.value(), y[key].value())
+			}
+		}
+	}
+}
+
+func TestPNCounter(t *testing.T) {
+	a, b := NewPNCounter(), NewPNCounter()
+	a.Increment("a", 5)
+	a.Increment("a", -2)
+	b.Increment("b", 10)
+	delta := b.Increment("b", -1)
+
+	a.Merge(b)
+	a.Merge(delta) // Delivered twice
+	if a.Value() != 12 {
+		t.Errorf("Expected every concurrent increment to count, got %d", a.Value())
+	}
+	c := NewPNCounter()
+	c.Increment("c", -7)
+	if c.Value() != -7 {
+		t.Errorf("Expected negative values, got %d", c.Value())
+	}
+}
+
+func TestLWWRegister(t *testing.T) {
+	r := &LWWRegister{Value: "old", Timestamp: 10, Actor: "b"}
+	r.Merge(&LWWRegister{Value: "older", Timestamp: 5, Actor: "z"})
+	if r.Value != "old" {
+		t.Errorf("Expected an older write to lose, got %v", r.Value)
+	}
+	r.Merge(&LWWRegister{Value: "tie", Timestamp: 10, Actor: "c"})
+	if r.Value != "tie" {
+		t.Errorf("Expected ties to go to the highest actor, got %v", r.Value)
+	}
+	r.Merge(&LWWRegister{Value: "lower actor", Timestamp: 10, Actor: "a"})
+	if r.Value != "tie" {
+		t.Errorf("Expected ties to go to the highest actor, got %v", r.Value)
+	}
+}
+
+// Will test that an add concurrent with a remove wins, and that a remove only removes the adds it has seen
+func TestORSetAddWins(t *testing.T) {
+	a, b := NewORSet(), NewORSet()
+	a.Add("x", "a/1")
+	b.Merge(a)
+
+	removal := b.Remove("x")   // b removes the add it saw
+	readd := a.Add("x", "a/2") // while a adds x again
+
+	a.Merge(removal)
+	b.Merge(readd)
+	if !a.Contains("x") || !b.Contains("x") {
+		t.Errorf("Expected the concurrent add to win, got %v and %v", a.Members(), b.Members())
+	}
+
+	b.Remove("x")
+	a.Merge(b)
+	if a.Contains("x") || len(a.Members()) != 0 {
+		t.Errorf("Expected x to be removed once every add was seen, got %v", a.Members())
+	}
+}
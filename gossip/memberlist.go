@@ -0,0 +1,433 @@
+package gossip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SWIM-style membership over UDP. Every member probes one other member per ProbeInterval. When the direct ping
+// is not acknowledged within ProbeTimeout, IndirectChecks other members are asked to ping it too, so a single lost
+// packet or a bad link between two nodes doesn't get a healthy member removed. A member that still doesn't answer
+// becomes suspect, and is declared dead if it does not refute the suspicion within SuspicionTimeout.
+//
+// Membership updates are not sent on their own but piggybacked on the probe traffic, and every member passes on
+// what it hears a few times (about RetransmitMult * log2(members)), so updates reach everyone quickly without
+// any node sending to all the others.
+//
+// Every member has an incarnation number that only it increases. A member that hears it is suspected or dead
+// refutes it by announcing itself alive with a higher incarnation, which wins over the older rumour everywhere.
+
+// Where a member stands, as far as this node knows
+type State uint8
+
+const (
+	StateAlive State = iota
+	StateSuspect
+	StateDead
+	StateLeft // Left on purpose with Leave
+)
+
+var stateNames = [...]string{"alive", "suspect", "dead", "left"}
+
+func (s State) String() string {
+	if int(s) < len(stateNames) {
+		return stateNames[s]
+	}
+	return fmt.Sprintf("State(%d)", s)
+}
+
+// Alive and suspect members are still members, dead and left ones are not
+func (s State) live() bool { return s == StateAlive || s == StateSuspect }
+
+// With the same incarnation a later state wins: alive < suspect < dead = left
+func (s State) rank() int {
+	if s == StateLeft {
+		return int(StateDead)
+	}
+	return int(s)
+}
+
+// A node of the cluster
+type Member struct {
+	Name        string // Unique name of the member
+	Addr        string // UDP address it gossips on
+	Meta        string // Set by the member itself, i.e. the URL of its HTTP API
+	Incarnation uint64
+	State       State
+}
+
+// Settings used when creating a Memberlist
+type Options struct {
+	Meta          string         // Passed on to the other members with this member
+	AdvertiseAddr string         // Address the other members send to, the bound address when empty (set it when binding 0.0.0.0)
+	Conn          net.PacketConn // Used instead of listening on the bind address, i.e. to simulate packet loss
+
+	ProbeInterval    time.Duration // How often a member is probed
+	ProbeTimeout     time.Duration // How long to wait for a direct ack before asking other members, below ProbeInterval
+	IndirectChecks   int           // How many members are asked to ping a member that did not answer
+	SuspicionTimeout time.Duration // How long a suspect member has to refute before it is declared dead
+	RetransmitMult   int           // Every update is passed on RetransmitMult * log2(members + 1) times
+	DeadRetention    time.Duration // How long dead and left members are remembered, so old rumours can't bring them back
+
+	// Called with the live members (see Members) whenever a member joins, dies, leaves or changes its Meta.
+	// Calls are serialized, and changes that happen during a call are coalesced into the next one.
+	OnChange func(members []Member)
+}
+
+// Options used by NewMemberlist
+var DefaultOptions = Options{
+	ProbeInterval:    time.Second,
+	ProbeTimeout:     300 * time.Millisecond,
+	IndirectChecks:   3,
+	SuspicionTimeout: 5 * time.Second,
+	RetransmitMult:   4,
+	DeadRetention:    time.Minute,
+}
+
+type Memberlist struct {
+	name    string
+	addr    string
+	options Options
+	conn    net.PacketConn
+
+	mu         sync.Mutex
+	members    map[string]*memberState // Including this member
+	probeOrder []string
+	broadcasts []*broadcast
+	acks       map[uint64]func() // Called when the ack with this sequence number arrives
+	seq        uint64
+	leaving    bool
+
+	stats   Stats
+	changed chan struct{}
+	done    chan struct{}
+	closed  sync.Once
+	wg      sync.WaitGroup
+}
+
+type memberState struct {
+	Member
+	since time.Time // When State last changed
+}
+
+// Counters of a Memberlist, read with GetStats
+type Stats struct {
+	Probes          uint64 // Direct pings sent
+	IndirectProbes  uint64 // Probes that needed the help of other members
+	ProbeFailures   uint64 // Probes that got no ack at all
+	Suspicions      uint64 // Members that became suspect
+	Refutations     uint64 // Times this member refuted a rumour about itself
+	PacketsSent     uint64
+	PacketsReceived uint64
+}
+
+// Creates a Memberlist with DefaultOptions. See NewMemberlistWithOptions.
+func NewMemberlist(name, bindAddr string) (*Memberlist, error) {
+	return NewMemberlistWithOptions(name, bindAddr, DefaultOptions)
+}
+
+// Creates a member called name, listening for gossip on bindAddr (i.e. ":7946"), and starts probing.
+// It is alone until Join is called. Zero options fall back to DefaultOptions. Call Leave and Close when done.
+func NewMemberlistWithOptions(name, bindAddr string, opts Options) (*Memberlist, error) {
+	if name == "" {
+		return nil, errors.New("gossip: a member needs a name")
+	}
+	if opts.ProbeInterval <= 0 {
+		opts.ProbeInterval = DefaultOptions.ProbeInterval
+	}
+	if opts.ProbeTimeout <= 0 || opts.ProbeTimeout >= opts.ProbeInterval {
+		opts.ProbeTimeout = opts.ProbeInterval * 3 / 10
+	}
+	if opts.IndirectChecks <= 0 {
+		opts.IndirectChecks = DefaultOptions.IndirectChecks
+	}
+	if opts.SuspicionTimeout <= 0 {
+		opts.SuspicionTimeout = DefaultOptions.SuspicionTimeout
+	}
+	if opts.RetransmitMult <= 0 {
+		opts.RetransmitMult = DefaultOptions.RetransmitMult
+	}
+	if opts.DeadRetention <= 0 {
+		opts.DeadRetention = DefaultOptions.DeadRetention
+	}
+
+	conn := opts.Conn
+	if conn == nil {
+		var err error
+		if conn, err = net.ListenPacket("udp", bindAddr); err != nil {
+			return nil, err
+		}
+	}
+	addr := opts.AdvertiseAddr
+	if addr == "" {
+		addr = conn.LocalAddr().String()
+	}
+
+	m := &Memberlist{
+		name:    name,
+		addr:    addr,
+		options: opts,
+		conn:    conn,
+		members: make(map[string]*memberState),
+		acks:    make(map[uint64]func()),
+		changed: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	m.members[name] = &memberState{
+		Member: Member{Name: name, Addr: addr, Meta: opts.Meta, State: StateAlive},
+		since:  time.Now(),
+	}
+
+	m.wg.Add(3)
+	go m.readLoop()
+	go m.probeLoop()
+	go m.notifyLoop()
+	return m, nil
+}
+
+// Will return this member
+func (m *Memberlist) Local() Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.members[m.name].Member
+}
+
+// Will return the live (alive and suspect) members, including this one, sorted by name
+func (m *Memberlist) Members() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.liveMembers()
+}
+
+// Must be called while holding m.mu
+func (m *Memberlist) liveMembers() []Member {
+	members := make([]Member, 0, len(m.members))
+	for _, ms := range m.members {
+		if ms.State.live() {
+			members = append(members, ms.Member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Will return the state of every member this node knows about, including dead and left ones
+func (m *Memberlist) AllMembers() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]Member, 0, len(m.members))
+	for _, ms := range m.members {
+		members = append(members, ms.Member)
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].Name < members[j].Name })
+	return members
+}
+
+// Will contact the members at addrs (gossip addresses, i.e. "10.0.0.2:7946") and fetch the membership from them.
+// One member that answers is enough. Returns how many answered.
+func (m *Memberlist) Join(addrs ...string) (int, error) {
+	const attempts = 3
+	answers := make(chan string, attempts*len(addrs)) // Every request gets at most one answer
+	answered := make(map[string]bool)
+
+	// UDP may drop the request or the answer, so it is sent a few times
+	for attempt := 0; attempt < attempts && len(answered) < len(addrs); attempt++ {
+		for _, addr := range addrs {
+			if answered[addr] {
+				continue
+			}
+			addr := addr
+			seq := m.expectAck(m.options.ProbeInterval, func() { answers <- addr })
+			m.send(addr, message{Type: msgJoin, Seq: seq, Updates: []Member{m.Local()}})
+		}
+
+		timeout := time.After(m.options.ProbeInterval)
+	wait:
+		for len(answered) < len(addrs) {
+			select {
+			case addr := <-answers:
+				answered[addr] = true
+			case <-timeout:
+				break wait
+			case <-m.done:
+				return 0, errors.New("gossip: closed")
+			}
+		}
+	}
+
+	if len(answered) == 0 {
+		return 0, fmt.Errorf("gossip: no member answered at %v", addrs)
+	}
+	return len(answered), nil
+}
+
+// Will tell the other members that this one is leaving, so they drop it right away instead of waiting for it
+// to be declared dead. Close should be called afterwards.
+func (m *Memberlist) Leave() {
+	m.mu.Lock()
+	m.leaving = true
+	self := m.members[m.name]
+	self.Incarnation++
+	self.State = StateLeft
+	self.since = time.Now()
+	left := self.Member
+	var targets []string
+	for _, ms := range m.members {
+		if ms.Name != m.name && ms.State.live() {
+			targets = append(targets, ms.Addr)
+		}
+	}
+	m.mu.Unlock()
+
+	// Sent to every member directly, instead of waiting for the rumour to spread
+	for _, addr := range targets {
+		m.send(addr, message{Type: msgPing, Updates: []Member{left}})
+	}
+}
+
+// Will stop gossiping and close the connection
+func (m *Memberlist) Close() error {
+	var err error
+	m.closed.Do(func() {
+		close(m.done)
+		err = m.conn.Close()
+		m.wg.Wait()
+	})
+	return err
+}
+
+// Will apply an update heard from another member. Must be called while holding m.mu.
+// The update is passed on when it changed anything.
+func (m *Memberlist) merge(u Member) {
+	if u.Name == m.name {
+		m.refute(u)
+		return
+	}
+
+	cur, known := m.members[u.Name]
+	if !known {
+		if !u.State.live() {
+			return // Never heard of it, no need to learn it died
+		}
+		m.members[u.Name] = &memberState{Member: u, since: time.Now()}
+		m.queue(u)
+		m.notifyChange()
+		return
+	}
+
+	if u.Incarnation < cur.Incarnation || (u.Incarnation == cur.Incarnation && u.State.rank() <= cur.State.rank()) {
+		return // Old news
+	}
+
+	wasLive, oldMeta := cur.State.live(), cur.Meta
+	if u.State != cur.State {
+		cur.since = time.Now()
+	}
+	cur.Member = u
+	m.queue(u)
+
+	if u.State == StateSuspect {
+		atomic.AddUint64(&m.stats.Suspicions, 1)
+		m.startSuspicion(u)
+	}
+	if wasLive != u.State.live() || oldMeta != u.Meta {
+		m.notifyChange()
+	}
+}
+
+// Will answer a rumour about this member. A member that was not asked to leave denies being suspect or dead
+// by announcing itself alive with a higher incarnation. Must be called while holding m.mu.
+func (m *Memberlist) refute(u Member) {
+	self := m.members[m.name]
+	if m.leaving || u.Incarnation < self.Incarnation {
+		return // Leaving anyway, or an old rumour already refuted by a newer incarnation
+	}
+	if u.State == StateAlive && u.Incarnation == self.Incarnation {
+		return // True
+	}
+	self.Incarnation = u.Incarnation + 1
+	atomic.AddUint64(&m.stats.Refutations, 1)
+	m.queue(self.Member)
+}
+
+// Will declare a suspect member dead, unless it refutes in time. Must be called while holding m.mu.
+func (m *Memberlist) startSuspicion(u Member) {
+	time.AfterFunc(m.options.SuspicionTimeout, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		cur, ok := m.members[u.Name]
+		if !ok || cur.State != StateSuspect || cur.Incarnation != u.Incarnation {
+			return // Refuted, or already dead
+		}
+		dead := cur.Member
+		dead.State = StateDead
+		m.merge(dead)
+	})
+}
+
+// Will forget members that have been dead or gone for longer than DeadRetention
+func (m *Memberlist) reap() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for name, ms := range m.members {
+		if name != m.name && !ms.State.live() && time.Since(ms.since) > m.options.DeadRetention {
+			delete(m.members, name)
+		}
+	}
+}
+
+// Will wake up notifyLoop. Must be called while holding m.mu.
+func (m *Memberlist) notifyChange() {
+	select {
+	case m.changed <- struct{}{}:
+	default: // A call is already pending, it will see this change too
+	}
+}
+
+func (m *Memberlist) notifyLoop() {
+	defer m.wg.Done()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.changed:
+			if m.options.OnChange != nil {
+				m.options.OnChange(m.Members())
+			}
+		}
+	}
+}
+
+// Will return the counters, plus the number of members per state
+func (m *Memberlist) GetStats() map[string]uint64 {
+	stats := map[string]uint64{
+		"probes":           atomic.LoadUint64(&m.stats.Probes),
+		"indirect_probes":  atomic.LoadUint64(&m.stats.IndirectProbes),
+		"probe_failures":   atomic.LoadUint64(&m.stats.ProbeFailures),
+		"suspicions":       atomic.LoadUint64(&m.stats.Suspicions),
+		"refutations":      atomic.LoadUint64(&m.stats.Refutations),
+		"packets_sent":     atomic.LoadUint64(&m.stats.PacketsSent),
+		"packets_received": atomic.LoadUint64(&m.stats.PacketsReceived),
+		"members_alive":    0,
+		"members_suspect":  0,
+		"members_dead":     0,
+	}
+	m.mu.Lock()
+	for _, ms := range m.members {
+		switch ms.State {
+		case StateAlive:
+			stats["members_alive"]++
+		case StateSuspect:
+			stats["members_suspect"]++
+		default:
+			stats["members_dead"]++
+		}
+	}
+	m.mu.Unlock()
+	return stats
+}
@@ -0,0 +1,250 @@
+package gossip
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Short timings, so failures are detected within a second
+var testOptions = Options{
+	ProbeInterval:    50 * time.Millisecond,
+	ProbeTimeout:     20 * time.Millisecond,
+	SuspicionTimeout: 500 * time.Millisecond,
+}
+
+// Drops a share of the packets it sends, to simulate a lossy network on localhost
+type lossyConn struct {
+	net.PacketConn
+	mu   sync.Mutex
+	loss float64
+	rnd  *rand.Rand
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	drop := c.rnd.Float64() < c.loss
+	c.mu.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// Will start a member on a random localhost port, losing the given share of its outgoing packets
+func newTestMember(t *testing.T, name string, loss float64, opts Options) *Memberlist {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Conn = &lossyConn{PacketConn: conn, loss: loss, rnd: rand.New(rand.NewSource(int64(len(name))))}
+	opts.Meta = "http://" + name
+	m, err := NewMemberlistWithOptions(name, "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m
+}
+
+// Will start n members that all join the first one
+func newTestCluster(t *testing.T, n int, loss float64) []*Memberlist {
+	t.Helper()
+	members := make([]*Memberlist, n)
+	for i := range members {
+		members[i] = newTestMember(t, fmt.Sprintf("node-%d", i), loss, testOptions)
+		if i > 0 {
+			if _, err := members[i].Join(members[0].Local().Addr); err != nil {
+				t.Fatalf("node-%d could not join: %v", i, err)
+			}
+		}
+	}
+	waitFor(t, "every member to know every other member", func() bool { return converged(members, n) })
+	return members
+}
+
+func converged(members []*Memberlist, live int) bool {
+	for _, m := range members {
+		if len(m.Members()) != live {
+			return false
+		}
+	}
+	return true
+}
+
+// Will poll cond until it is true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stateOf(m *Memberlist, name string) (State, bool) {
+	for _, member := range m.AllMembers() {
+		if member.Name == name {
+			return member.State, true
+		}
+	}
+	return 0, false
+}
+
+func TestJoin(t *testing.T) {
+	members := newTestCluster(t, 5, 0)
+	for _, member := range members[3].Members() {
+		if member.Meta != "http://"+member.Name || member.State != StateAlive {
+			t.Errorf("Unexpected member %+v", member)
+		}
+	}
+	if _, err := newTestMember(t, "lonely", 0, testOptions).Join("127.0.0.1:1"); err == nil {
+		t.Errorf("Expected an error when nobody answers")
+	}
+}
+
+// Will test that a member that stops answering is suspected, declared dead, and reported through OnChange
+func TestFailureDetection(t *testing.T) {
+	var mu sync.Mutex
+	var last []Member
+	opts := testOptions
+	opts.OnChange = func(members []Member) {
+		mu.Lock()
+		last = members
+		mu.Unlock()
+	}
+
+	observer := newTestMember(t, "observer", 0, opts)
+	members := []*Memberlist{observer}
+	for i := 0; i < 3; i++ {
+		m := newTestMember(t, fmt.Sprintf("node-%d", i), 0, testOptions)
+		if _, err := m.Join(observer.Local().Addr); err != nil {
+			t.Fatal(err)
+		}
+		members = append(members, m)
+	}
+	waitFor(t, "convergence", func() bool { return converged(members, 4) })
+
+	members[3].Close() // Crashes, without leaving
+	waitFor(t, "node-2 to be declared dead", func() bool {
+		state, _ := stateOf(observer, "node-2")
+		return state == StateDead
+	})
+	waitFor(t, "every member to drop node-2", func() bool { return converged(members[:3], 3) })
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(last) != 3 {
+		t.Errorf("Expected OnChange to report 3 members, got %v", last)
+	}
+	if stats := observer.GetStats(); stats["suspicions"] == 0 || stats["members_dead"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestLeave(t *testing.T) {
+	members := newTestCluster(t, 3, 0)
+	members[2].Leave()
+
+	// Much faster than the suspicion timeout
+	start := time.Now()
+	waitFor(t, "the others to see node-2 leave", func() bool {
+		a, _ := stateOf(members[0], "node-2")
+		b, _ := stateOf(members[1], "node-2")
+		return a == StateLeft && b == StateLeft
+	})
+	if elapsed := time.Since(start); elapsed > testOptions.SuspicionTimeout {
+		t.Errorf("Leaving took %s", elapsed)
+	}
+}
+
+// Will test that a member refutes a suspicion about itself with a higher incarnation
+func TestRefute(t *testing.T) {
+	members := newTestCluster(t, 3, 0)
+	target := members[1].Local()
+
+	suspect := target
+	suspect.State = StateSuspect
+	members[0].mu.Lock()
+	members[0].merge(suspect)
+	members[0].mu.Unlock()
+
+	waitFor(t, "the refutation", func() bool {
+		for _, member := range members[0].AllMembers() {
+			if member.Name == target.Name {
+				return member.State == StateAlive && member.Incarnation > target.Incarnation
+			}
+		}
+		return false
+	})
+	time.Sleep(testOptions.SuspicionTimeout + 100*time.Millisecond)
+	if state, _ := stateOf(members[0], target.Name); state != StateAlive {
+		t.Errorf("Expected the refuted member to stay alive, got %s", state)
+	}
+	if members[1].GetStats()["refutations"] == 0 {
+		t.Errorf("Expected the refutation to be counted")
+	}
+}
+
+// Will test that with packets being lost no healthy member is declared dead, thanks to indirect probes and
+// refutation, while a member that really crashed still is
+func TestPacketLoss(t *testing.T) {
+	members := newTestCluster(t, 5, 0.1)
+
+	deaths := 0
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, m := range members {
+			deaths += int(m.GetStats()["members_dead"])
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if deaths != 0 {
+		t.Errorf("Expected no healthy member to be declared dead")
+	}
+	var indirect uint64
+	for _, m := range members {
+		indirect += m.GetStats()["indirect_probes"]
+	}
+	if indirect == 0 {
+		t.Errorf("Expected lost packets to cause indirect probes")
+	}
+
+	members[4].Close()
+	waitFor(t, "the crashed member to be dropped", func() bool { return converged(members[:4], 4) })
+}
+
+func TestMergeRules(t *testing.T) {
+	m := newTestMember(t, "self", 0, testOptions)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := func() Member { return m.members["other"].Member }
+	m.merge(Member{Name: "other", Addr: "127.0.0.1:1", Incarnation: 1, State: StateAlive})
+	m.merge(Member{Name: "other", Incarnation: 0, State: StateDead})
+	if state().State != StateAlive {
+		t.Errorf("Expected an older incarnation to be ignored, got %+v", state())
+	}
+	m.merge(Member{Name: "other", Incarnation: 1, State: StateSuspect})
+	if state().State != StateSuspect {
+		t.Errorf("Expected suspect to win over alive with the same incarnation, got %+v", state())
+	}
+	m.merge(Member{Name: "other", Incarnation: 1, State: StateAlive})
+	if state().State != StateSuspect {
+		t.Errorf("Expected alive not to win over suspect with the same incarnation, got %+v", state())
+	}
+	m.merge(Member{Name: "other", Incarnation: 2, State: StateAlive})
+	if state().State != StateAlive {
+		t.Errorf("Expected a newer incarnation to win, got %+v", state())
+	}
+	m.merge(Member{Name: "unknown", Incarnation: 5, State: StateDead})
+	if _, ok := m.members["unknown"]; ok {
+		t.Errorf("Expected dead members that were never known to be ignored")
+	}
+}
@@ -0,0 +1,113 @@
+package gossip
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+func (m *Memberlist) probeLoop() {
+	defer m.wg.Done()
+	ticker := time.NewTicker(m.options.ProbeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		m.reap()
+		if target, ok := m.nextProbeTarget(); ok {
+			m.probe(target)
+		}
+	}
+}
+
+// Will pick the next member to probe. Members are probed in a random order that is shuffled again after every
+// round, so every member is probed once per round and a failed member is found within one round.
+func (m *Memberlist) nextProbeTarget() (Member, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for reshuffled := false; ; {
+		for len(m.probeOrder) > 0 {
+			name := m.probeOrder[0]
+			m.probeOrder = m.probeOrder[1:]
+			if ms, ok := m.members[name]; ok && ms.State.live() {
+				return ms.Member, true
+			}
+		}
+		if reshuffled {
+			return Member{}, false
+		}
+		for name, ms := range m.members {
+			if name != m.name && ms.State.live() {
+				m.probeOrder = append(m.probeOrder, name)
+			}
+		}
+		rand.Shuffle(len(m.probeOrder), func(i, j int) {
+			m.probeOrder[i], m.probeOrder[j] = m.probeOrder[j], m.probeOrder[i]
+		})
+		reshuffled = true
+	}
+}
+
+// Will ping target, ask other members to ping it when it does not answer in time, and suspect it when nobody
+// got an answer by the end of the probe interval
+func (m *Memberlist) probe(target Member) {
+	acked := make(chan struct{}, 1)
+	seq := m.expectAck(m.options.ProbeInterval, func() {
+		select {
+		case acked <- struct{}{}:
+		default:
+		}
+	})
+	atomic.AddUint64(&m.stats.Probes, 1)
+	m.send(target.Addr, message{Type: msgPing, Seq: seq, Target: target.Name})
+
+	select {
+	case <-acked:
+		return
+	case <-m.done:
+		return
+	case <-time.After(m.options.ProbeTimeout):
+	}
+
+	// Maybe only the link between us is bad, ask others. Their acks come back with the same seq.
+	atomic.AddUint64(&m.stats.IndirectProbes, 1)
+	for _, helper := range m.randomMembers(m.options.IndirectChecks, target.Name) {
+		m.send(helper.Addr, message{Type: msgIndirectPing, Seq: seq, Target: target.Name, TargetAddr: target.Addr})
+	}
+
+	select {
+	case <-acked:
+		return
+	case <-m.done:
+		return
+	case <-time.After(m.options.ProbeInterval - m.options.ProbeTimeout):
+	}
+
+	atomic.AddUint64(&m.stats.ProbeFailures, 1)
+	suspect := target
+	suspect.State = StateSuspect
+	m.mu.Lock()
+	m.merge(suspect) // Ignored if target refuted in the meantime
+	m.mu.Unlock()
+}
+
+// Will return up to n random live members, other than this one and except
+func (m *Memberlist) randomMembers(n int, except string) []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var candidates []Member
+	for name, ms := range m.members {
+		if name != m.name && name != except && ms.State.live() {
+			candidates = append(candidates, ms.Member)
+		}
+	}
+	rand.Shuffle(len(candidates), func(i, j int) { candidates[i], candidates[j] = candidates[j], candidates[i] })
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+	return candidates
+}
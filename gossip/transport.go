@@ -0,0 +1,184 @@
+package gossip
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type msgType uint8
+
+const (
+	msgPing         msgType = iota + 1 // Answered with an ack
+	msgIndirectPing                    // Asks the receiver to ping Target, and pass on its ack
+	msgAck
+	msgJoin  // Answered with a msgState listing every member
+	msgState // Answer to msgJoin
+)
+
+// One UDP packet, encoded as JSON
+type message struct {
+	Type       msgType  `json:"t"`
+	Seq        uint64   `json:"s,omitempty"`  // Matches an ack (or a msgState) to its request
+	Target     string   `json:"tn,omitempty"` // Name of the member a ping is meant for
+	TargetAddr string   `json:"ta,omitempty"` // Where to send an indirect ping
+	Updates    []Member `json:"u,omitempty"`  // Piggybacked membership updates
+}
+
+// Most updates piggybacked on a single packet
+const maxPiggyback = 8
+
+// Largest packet read
+const maxPacketSize = 64 << 10
+
+// An update waiting to be passed on
+type broadcast struct {
+	member    Member
+	transmits int
+}
+
+// Will queue an update to be passed on, replacing any older update about the same member.
+// Must be called while holding m.mu.
+func (m *Memberlist) queue(u Member) {
+	for _, b := range m.broadcasts {
+		if b.member.Name == u.Name {
+			b.member, b.transmits = u, 0
+			return
+		}
+	}
+	m.broadcasts = append(m.broadcasts, &broadcast{member: u})
+}
+
+// Will take the updates to piggyback on the next packet, the least sent ones first.
+// Updates are dropped once they were sent often enough to have reached everyone.
+func (m *Memberlist) takeBroadcasts() []Member {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.broadcasts) == 0 {
+		return nil
+	}
+
+	limit := m.options.RetransmitMult * int(math.Ceil(math.Log2(float64(len(m.members)+1))))
+	sort.SliceStable(m.broadcasts, func(i, j int) bool { return m.broadcasts[i].transmits < m.broadcasts[j].transmits })
+	var updates []Member
+	for _, b := range m.broadcasts {
+		if len(updates) == maxPiggyback {
+			break
+		}
+		updates = append(updates, b.member)
+		b.transmits++
+	}
+
+	kept := m.broadcasts[:0]
+	for _, b := range m.broadcasts {
+		if b.transmits < limit {
+			kept = append(kept, b)
+		}
+	}
+	m.broadcasts = kept
+	return updates
+}
+
+// Will send msg to addr, with pending updates piggybacked
+func (m *Memberlist) send(addr string, msg message) {
+	msg.Updates = append(msg.Updates, m.takeBroadcasts()...)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return
+	}
+	if _, err := m.conn.WriteTo(data, udpAddr); err == nil {
+		atomic.AddUint64(&m.stats.PacketsSent, 1)
+	}
+}
+
+// Will register fn to be called when the ack with the returned sequence number arrives within timeout
+func (m *Memberlist) expectAck(timeout time.Duration, fn func()) uint64 {
+	m.mu.Lock()
+	m.seq++
+	seq := m.seq
+	m.acks[seq] = fn
+	m.mu.Unlock()
+
+	time.AfterFunc(timeout, func() {
+		m.mu.Lock()
+		delete(m.acks, seq)
+		m.mu.Unlock()
+	})
+	return seq
+}
+
+func (m *Memberlist) ackReceived(seq uint64) {
+	m.mu.Lock()
+	fn := m.acks[seq]
+	delete(m.acks, seq)
+	m.mu.Unlock()
+	if fn != nil {
+		fn()
+	}
+}
+
+func (m *Memberlist) readLoop() {
+	defer m.wg.Done()
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := m.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			select {
+			case <-m.done:
+				return
+			default:
+				continue
+			}
+		}
+		atomic.AddUint64(&m.stats.PacketsReceived, 1)
+
+		var msg message
+		if err := json.Unmarshal(buf[:n], &msg); err != nil {
+			continue // Not ours
+		}
+		m.handle(msg, from.String())
+	}
+}
+
+// Will apply the updates of a packet, then answer it
+func (m *Memberlist) handle(msg message, from string) {
+	m.mu.Lock()
+	for _, u := range msg.Updates {
+		m.merge(u)
+	}
+	m.mu.Unlock()
+
+	switch msg.Type {
+	case msgPing:
+		// A ping for a member that used to have this address is not ours to answer
+		if msg.Seq != 0 && (msg.Target == "" || msg.Target == m.name) {
+			m.send(from, message{Type: msgAck, Seq: msg.Seq})
+		}
+	case msgIndirectPing:
+		seq := m.expectAck(m.options.ProbeInterval, func() {
+			m.send(from, message{Type: msgAck, Seq: msg.Seq})
+		})
+		m.send(msg.TargetAddr, message{Type: msgPing, Seq: seq, Target: msg.Target})
+	case msgAck, msgState:
+		m.ackReceived(msg.Seq)
+	case msgJoin:
+		m.mu.Lock()
+		members := make([]Member, 0, len(m.members))
+		for _, ms := range m.members {
+			members = append(members, ms.Member)
+		}
+		m.mu.Unlock()
+		m.send(from, message{Type: msgState, Seq: msg.Seq, Updates: members})
+	}
+}
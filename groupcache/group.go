@@ -0,0 +1,293 @@
+package groupcache
+
+import (
+	"bytes"
+	"context"
+	"golang-memory-cache/cache"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A Group caches immutable values that are expensive to produce, over several nodes, the way groupcache does.
+// Every key has an owner node. A miss asks the owner for the value, and only the owner runs the Getter,
+// once per key no matter how many callers ask at the same time. Values are never updated or deleted:
+// a key always has the same value, so the caches never need invalidating.
+//
+// Each group has two tiers, both a cache.Cache:
+//   - main holds the keys this node owns (or loaded itself because their owner was unreachable), for MainTTL
+//     and up to MaxMainKeys
+//   - hot holds copies of keys owned by other nodes that are requested so often that going to the owner
+//     every time would make it a bottleneck
+//
+// Requests for a key stay on one node as long as it is not hot, so the whole cluster holds one copy of most keys.
+
+// Loads the value of a key when no node has it cached
+type Getter interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+}
+
+// Lets an ordinary function be used as a Getter
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
+
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) { return f(ctx, key) }
+
+// Finds the node that owns a key. HTTPPool implements it.
+type PeerPicker interface {
+	// Will return the owner of key, or false when this node owns it
+	PickPeer(key string) (PeerGetter, bool)
+}
+
+// Fetches a value from another node
+type PeerGetter interface {
+	Get(ctx context.Context, group, key string) ([]byte, error)
+}
+
+// Settings used when creating a Group
+type Options struct {
+	Peers           PeerPicker    // Every key is owned by this node when nil
+	MainTTL         time.Duration // How long loaded values are kept in the main tier, cache.NoExpiration to keep them forever
+	MaxMainKeys     int           // Most values the main tier holds at once. Loads past it are not kept until values expire.
+	CleanupInterval time.Duration // How often expired values are removed from both tiers
+	LoadTimeout     time.Duration // Deadline of a load, which doesn't stop when the caller that started it gives up
+
+	// A key owned by another node is copied into the hot tier once it was fetched from its owner HotThreshold
+	// times within HotWindow. Hot copies are kept for HotTTL, and at most MaxHotKeys are held at once.
+	HotThreshold int
+	HotWindow    time.Duration
+	HotTTL       time.Duration
+	MaxHotKeys   int
+}
+
+// Options used by NewGroup
+var DefaultOptions = Options{
+	MainTTL:         10 * time.Minute,
+	MaxMainKeys:     100000,
+	CleanupInterval: time.Minute,
+	LoadTimeout:     30 * time.Second,
+	HotThreshold:    5,
+	HotWindow:       10 * time.Second,
+	HotTTL:          time.Minute,
+	MaxHotKeys:      1000,
+}
+
+type Group struct {
+	name    string
+	getter  Getter
+	options Options
+	main    *cache.Cache
+	hot     *cache.Cache
+	loads   flightGroup
+	stats   Stats
+
+	// Fetches from the owner per key in the current window, used to find hot keys
+	mu          sync.Mutex
+	peerFetches map[string]int
+	windowStart time.Time
+}
+
+// Counters of a Group, read with GetStats
+type Stats struct {
+	Gets            uint64 // Every call to Get
+	MainHits        uint64 // Served from the main tier
+	HotHits         uint64 // Served from the hot tier
+	Loads           uint64 // Misses that had to be loaded, from a peer or the Getter
+	LoadsDeduped    uint64 // Loads that waited for a load of the same key already in progress
+	PeerLoads       uint64 // Values fetched from the owner node
+	PeerErrors      uint64 // Failed fetches from the owner, the value is then loaded locally
+	LocalLoads      uint64 // Calls to the Getter
+	LocalLoadErrors uint64 // Calls to the Getter that failed
+	ServerRequests  uint64 // Requests from other nodes for keys this node owns
+}
+
+// Creates a Group with DefaultOptions, where every key is owned by this node
+func NewGroup(name string, getter Getter) *Group {
+	return NewGroupWithOptions(name, getter, DefaultOptions)
+}
+
+// Creates a Group with custom options. Zero values fall back to DefaultOptions. Call Close when done with it.
+func NewGroupWithOptions(name string, getter Getter, opts Options) *Group {
+	if opts.MainTTL == 0 {
+		opts.MainTTL = DefaultOptions.MainTTL
+	}
+	if opts.MaxMainKeys <= 0 {
+		opts.MaxMainKeys = DefaultOptions.MaxMainKeys
+	}
+	if opts.CleanupInterval <= 0 {
+		opts.CleanupInterval = DefaultOptions.CleanupInterval
+	}
+	if opts.LoadTimeout <= 0 {
+		opts.LoadTimeout = DefaultOptions.LoadTimeout
+	}
+	if opts.HotThreshold <= 0 {
+		opts.HotThreshold = DefaultOptions.HotThreshold
+	}
+	if opts.HotWindow <= 0 {
+		opts.HotWindow = DefaultOptions.HotWindow
+	}
+	if opts.HotTTL <= 0 {
+		opts.HotTTL = DefaultOptions.HotTTL
+	}
+	if opts.MaxHotKeys <= 0 {
+		opts.MaxHotKeys = DefaultOptions.MaxHotKeys
+	}
+
+	cacheOpts := cache.Options{CleanupInterval: opts.CleanupInterval}
+	return &Group{
+		name:        name,
+		getter:      getter,
+		options:     opts,
+		main:        cache.NewCacheWithOptions(cacheOpts),
+		hot:         cache.NewCacheWithOptions(cacheOpts),
+		peerFetches: make(map[string]int),
+		windowStart: time.Now(),
+	}
+}
+
+// Will return the name of the group
+func (g *Group) Name() string { return g.name }
+
+// Will return the value of key, from this node's tiers, its owner, or the Getter.
+// The returned slice is a copy, so callers may modify it.
+func (g *Group) Get(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.Gets, 1)
+	if value, ok := g.lookup(key); ok {
+		return bytes.Clone(value), nil
+	}
+
+	value, err := g.load(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(value), nil
+}
+
+// Will look in both tiers
+func (g *Group) lookup(key string) ([]byte, bool) {
+	if value, found := g.main.Get(key); found {
+		atomic.AddUint64(&g.stats.MainHits, 1)
+		return value.([]byte), true
+	}
+	if value, found := g.hot.Get(key); found {
+		atomic.AddUint64(&g.stats.HotHits, 1)
+		return value.([]byte), true
+	}
+	return nil, false
+}
+
+// Will load a missing key from its owner, or with the Getter when this node owns it or the owner can't be reached
+func (g *Group) load(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.Loads, 1)
+	value, err, shared := g.loads.do(ctx, key, func() ([]byte, error) {
+		// Another load may have finished between the lookup and now
+		if value, ok := g.lookup(key); ok {
+			return value, nil
+		}
+		ctx, cancel := g.loadContext(ctx)
+		defer cancel()
+
+		if g.options.Peers != nil {
+			if peer, ok := g.options.Peers.PickPeer(key); ok {
+				value, err := peer.Get(ctx, g.name, key)
+				if err == nil {
+					atomic.AddUint64(&g.stats.PeerLoads, 1)
+					g.recordPeerFetch(key, value)
+					return value, nil
+				}
+				atomic.AddUint64(&g.stats.PeerErrors, 1)
+			}
+		}
+		return g.loadLocally(ctx, key)
+	})
+	if shared {
+		atomic.AddUint64(&g.stats.LoadsDeduped, 1)
+	}
+	return value, err
+}
+
+// Will return the context of a load started by the caller with ctx. It keeps the caller's values but not its
+// cancellation, which would fail every other caller waiting for the load, and ends after LoadTimeout.
+func (g *Group) loadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), g.options.LoadTimeout)
+}
+
+// Will run the Getter and keep the value in the main tier, when it has room
+func (g *Group) loadLocally(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.LocalLoads, 1)
+	value, err := g.getter.Get(ctx, key)
+	if err != nil {
+		atomic.AddUint64(&g.stats.LocalLoadErrors, 1)
+		return nil, err
+	}
+	value = bytes.Clone(value) // The Getter may reuse its buffer
+	if g.main.Len() < g.options.MaxMainKeys {
+		g.main.Set(key, value, g.options.MainTTL)
+	}
+	return value, nil
+}
+
+// Will count a fetch from the owner of key, and copy the value into the hot tier once the key is hot
+func (g *Group) recordPeerFetch(key string, value []byte) {
+	g.mu.Lock()
+	if time.Since(g.windowStart) > g.options.HotWindow {
+		g.peerFetches = make(map[string]int)
+		g.windowStart = time.Now()
+	}
+	g.peerFetches[key]++
+	hot := g.peerFetches[key] >= g.options.HotThreshold
+	if hot {
+		delete(g.peerFetches, key)
+	}
+	g.mu.Unlock()
+
+	// When the hot tier is full the key waits for room, freed as hot copies expire
+	if hot && g.hot.Len() < g.options.MaxHotKeys {
+		g.hot.Set(key, value, g.options.HotTTL)
+	}
+}
+
+// Will serve a request from another node, which believes this node owns key. The value is never fetched from
+// a peer here, so nodes that disagree on ownership (i.e. during a membership change) can't bounce a request around.
+func (g *Group) serve(ctx context.Context, key string) ([]byte, error) {
+	atomic.AddUint64(&g.stats.ServerRequests, 1)
+	if value, ok := g.lookup(key); ok {
+		return value, nil
+	}
+	atomic.AddUint64(&g.stats.Loads, 1)
+	value, err, shared := g.loads.do(ctx, key, func() ([]byte, error) {
+		if value, ok := g.lookup(key); ok {
+			return value, nil
+		}
+		ctx, cancel := g.loadContext(ctx)
+		defer cancel()
+		return g.loadLocally(ctx, key)
+	})
+	if shared {
+		atomic.AddUint64(&g.stats.LoadsDeduped, 1)
+	}
+	return value, err
+}
+
+// Will return the counters, plus "main_keys" and "hot_keys" for how many values each tier holds right now
+func (g *Group) GetStats() map[string]uint64 {
+	return map[string]uint64{
+		"gets":              atomic.LoadUint64(&g.stats.Gets),
+		"main_hits":         atomic.LoadUint64(&g.stats.MainHits),
+		"hot_hits":          atomic.LoadUint64(&g.stats.HotHits),
+		"loads":             atomic.LoadUint64(&g.stats.Loads),
+		"loads_deduped":     atomic.LoadUint64(&g.stats.LoadsDeduped),
+		"peer_loads":        atomic.LoadUint64(&g.stats.PeerLoads),
+		"peer_errors":       atomic.LoadUint64(&g.stats.PeerErrors),
+		"local_loads":       atomic.LoadUint64(&g.stats.LocalLoads),
+		"local_load_errors": atomic.LoadUint64(&g.stats.LocalLoadErrors),
+		"server_requests":   atomic.LoadUint64(&g.stats.ServerRequests),
+		"main_keys":         uint64(g.main.Len()),
+		"hot_keys":          uint64(g.hot.Len()),
+	}
+}
+
+// Will stop the janitors of both tiers
+func (g *Group) Close() {
+	g.main.Stop()
+	g.hot.Stop()
+}
@@ -0,0 +1,160 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A Getter that counts its calls per key
+type countingGetter struct {
+	mu    sync.Mutex
+	calls map[string]int
+	delay time.Duration
+	err   error
+}
+
+func (g *countingGetter) Get(ctx context.Context, key string) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]int)
+	}
+	g.calls[key]++
+	g.mu.Unlock()
+	time.Sleep(g.delay)
+	if g.err != nil {
+		return nil, g.err
+	}
+	return []byte("value of " + key), nil
+}
+
+func (g *countingGetter) count(key string) int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.calls[key]
+}
+
+func TestGroupGet(t *testing.T) {
+	getter := &countingGetter{}
+	g := NewGroup("test", getter)
+	defer g.Close()
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		value, err := g.Get(ctx, "a")
+		if err != nil || string(value) != "value of a" {
+			t.Fatalf("Get returned %q, %v", value, err)
+		}
+		value[0] = 'X' // Callers get a copy
+	}
+	if getter.count("a") != 1 {
+		t.Errorf("Expected the getter to run once, got %d", getter.count("a"))
+	}
+
+	stats := g.GetStats()
+	if stats["gets"] != 3 || stats["main_hits"] != 2 || stats["local_loads"] != 1 || stats["main_keys"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestGroupDedup(t *testing.T) {
+	getter := &countingGetter{delay: 20 * time.Millisecond}
+	g := NewGroup("test", getter)
+	defer g.Close()
+
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := g.Get(context.Background(), "slow"); err != nil {
+				failed.Store(true)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if failed.Load() {
+		t.Errorf("Expected every Get to succeed")
+	}
+	if getter.count("slow") != 1 {
+		t.Errorf("Expected concurrent misses to share one load, got %d", getter.count("slow"))
+	}
+	if g.GetStats()["loads_deduped"] == 0 {
+		t.Errorf("Expected deduplicated loads to be counted, got %v", g.GetStats())
+	}
+}
+
+// Will test that a load goes on when the caller that started it gives up, and ignores its cancellation
+func TestGroupLoadOutlivesCaller(t *testing.T) {
+	var sawCancel atomic.Bool
+	release := make(chan struct{})
+	g := NewGroup("test", GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		<-release
+		if ctx.Err() != nil {
+			sawCancel.Store(true)
+		}
+		return []byte("value"), nil
+	}))
+	defer g.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := g.Get(ctx, "a")
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the caller to stop with its context, got %v", err)
+	}
+
+	close(release)
+	if value, err := g.Get(context.Background(), "a"); err != nil || string(value) != "value" {
+		t.Errorf("Expected the load to finish, got %q %v", value, err)
+	}
+	if sawCancel.Load() {
+		t.Error("Expected the getter not to see the caller's cancellation")
+	}
+}
+
+func TestGroupMaxMainKeys(t *testing.T) {
+	getter := &countingGetter{}
+	g := NewGroupWithOptions("test", getter, Options{MaxMainKeys: 2})
+	defer g.Close()
+
+	for _, key := range []string{"a", "b", "c", "c"} {
+		if value, err := g.Get(context.Background(), key); err != nil || string(value) != "value of "+key {
+			t.Fatalf("Get returned %q, %v", value, err)
+		}
+	}
+	if g.GetStats()["main_keys"] != 2 {
+		t.Errorf("Expected the main tier to stop at 2 keys, got %v", g.GetStats())
+	}
+	if getter.count("c") != 2 {
+		t.Errorf("Expected the key past the limit to be loaded every time, got %d", getter.count("c"))
+	}
+}
+
+func TestGroupGetterError(t *testing.T) {
+	getter := &countingGetter{err: errors.New("backend down")}
+	g := NewGroup("test", getter)
+	defer g.Close()
+
+	if _, err := g.Get(context.Background(), "a"); err == nil || err.Error() != "backend down" {
+		t.Errorf("Expected the getter's error, got %v", err)
+	}
+	// Errors are not cached
+	g.Get(context.Background(), "a")
+	if getter.count("a") != 2 {
+		t.Errorf("Expected the getter to run again after an error, got %d", getter.count("a"))
+	}
+	if stats := g.GetStats(); stats["local_load_errors"] != 2 || stats["main_keys"] != 0 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
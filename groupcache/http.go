@@ -0,0 +1,201 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"golang-memory-cache/cluster"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// Path the groups of a pool are served under, by default
+const DefaultBasePath = "/_groupcache/"
+
+// Settings used when creating an HTTPPool
+type PoolOptions struct {
+	BasePath   string       // Prefix of the peer endpoint, DefaultBasePath when empty
+	Replicas   int          // Virtual nodes per node on the hash ring, cluster.DefaultReplicas when 0
+	HTTPClient *http.Client // Used to fetch from peers, http.DefaultClient when nil
+}
+
+// Options used by NewHTTPPool
+var DefaultPoolOptions = PoolOptions{
+	BasePath: DefaultBasePath,
+	Replicas: cluster.DefaultReplicas,
+}
+
+// An HTTPPool is the set of nodes sharing groups. It picks the owner of a key on a consistent hash ring,
+// fetches values from the other nodes over HTTP, and serves this node's groups to them at
+// GET {BasePath}{group}/{key}. Mount it on the server every node runs, i.e. mux.Handle(DefaultBasePath, pool).
+type HTTPPool struct {
+	self    string
+	options PoolOptions
+	client  *http.Client
+	ring    *cluster.Ring
+
+	mu     sync.RWMutex
+	groups map[string]*Group
+}
+
+// Creates a pool with DefaultPoolOptions. self is the URL other nodes reach this node at (i.e. "http://10.0.0.1:8080").
+func NewHTTPPool(self string) (*HTTPPool, error) {
+	return NewHTTPPoolWithOptions(self, DefaultPoolOptions)
+}
+
+// Creates a pool with custom options. Call Set to add the other nodes.
+func NewHTTPPoolWithOptions(self string, opts PoolOptions) (*HTTPPool, error) {
+	self, err := normalizePeer(self)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BasePath == "" {
+		opts.BasePath = DefaultPoolOptions.BasePath
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	p := &HTTPPool{
+		self:    self,
+		options: opts,
+		client:  client,
+		ring:    cluster.NewRing(opts.Replicas),
+		groups:  make(map[string]*Group),
+	}
+	p.ring.Set([]string{self})
+	return p, nil
+}
+
+// Will check that a peer is an http(s) URL, and drop trailing slashes so the same node always has the same name
+func normalizePeer(peer string) (string, error) {
+	peer = strings.TrimRight(strings.TrimSpace(peer), "/")
+	u, err := url.Parse(peer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("groupcache: invalid peer URL %q", peer)
+	}
+	return peer, nil
+}
+
+// Will replace the nodes of the pool. This node is always one of them.
+func (p *HTTPPool) Set(peers ...string) error {
+	nodes := []string{p.self}
+	for _, peer := range peers {
+		peer, err := normalizePeer(peer)
+		if err != nil {
+			return err
+		}
+		nodes = append(nodes, peer)
+	}
+	p.ring.Set(nodes)
+	return nil
+}
+
+// Will return the owner of key, or false when it is this node
+func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
+	owner := p.ring.Get(key)
+	if owner == "" || owner == p.self {
+		return nil, false
+	}
+	return &httpGetter{client: p.client, baseURL: owner + p.options.BasePath}, true
+}
+
+// Same as NewGroup, but keys are spread over the nodes of the pool, and the group is served to them
+func (p *HTTPPool) NewGroup(name string, getter Getter) *Group {
+	return p.NewGroupWithOptions(name, getter, DefaultOptions)
+}
+
+// Same as NewGroupWithOptions, with the pool as opts.Peers. Every node must create its groups with the same names.
+func (p *HTTPPool) NewGroupWithOptions(name string, getter Getter, opts Options) *Group {
+	opts.Peers = p
+	g := NewGroupWithOptions(name, getter, opts)
+	p.mu.Lock()
+	p.groups[name] = g
+	p.mu.Unlock()
+	return g
+}
+
+// Will return a group created by the pool, or nil
+func (p *HTTPPool) Group(name string) *Group {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.groups[name]
+}
+
+// * GET /_groupcache/{group}/{key}
+// Will answer another node asking for a key this node owns, with the raw value
+func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// The escaped path is used, so keys can contain '/'
+	rest, ok := strings.CutPrefix(r.URL.EscapedPath(), p.options.BasePath)
+	groupName, escapedKey, found := strings.Cut(rest, "/")
+	if !ok || !found {
+		http.Error(w, "Expected "+p.options.BasePath+"{group}/{key}", http.StatusBadRequest)
+		return
+	}
+	groupName, err1 := url.PathUnescape(groupName)
+	key, err2 := url.PathUnescape(escapedKey)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "Invalid path", http.StatusBadRequest)
+		return
+	}
+
+	g := p.Group(groupName)
+	if g == nil {
+		http.Error(w, "No such group: "+groupName, http.StatusNotFound)
+		return
+	}
+	value, err := g.serve(r.Context(), key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(value)
+}
+
+// Will return the stats of every group of the pool, named "<group>_<counter>"
+func (p *HTTPPool) GetStats() map[string]uint64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	stats := make(map[string]uint64)
+	for name, g := range p.groups {
+		for counter, value := range g.GetStats() {
+			stats[name+"_"+counter] = value
+		}
+	}
+	return stats
+}
+
+// Fetches values from one peer
+type httpGetter struct {
+	client  *http.Client
+	baseURL string // i.e. "http://10.0.0.2:8080/_groupcache/"
+}
+
+func (h *httpGetter) Get(ctx context.Context, group, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", h.baseURL+url.PathEscape(group)+"/"+url.PathEscape(key), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("groupcache: peer returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return body, nil
+}
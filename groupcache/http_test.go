@@ -0,0 +1,162 @@
+package groupcache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// A node running in-process on a loopback port
+type testNode struct {
+	pool   *HTTPPool
+	group  *Group
+	getter *countingGetter
+	server *httptest.Server
+}
+
+// Will start n nodes that all know each other, each with the group "test"
+func newTestNodes(t *testing.T, n int, opts Options) []*testNode {
+	t.Helper()
+	nodes := make([]*testNode, n)
+	urls := make([]string, n)
+	for i := range nodes {
+		node := &testNode{getter: &countingGetter{}}
+		node.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			node.pool.ServeHTTP(w, r)
+		}))
+		urls[i] = node.server.URL
+		nodes[i] = node
+	}
+	for i, node := range nodes {
+		pool, err := NewHTTPPool(urls[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pool.Set(urls...); err != nil {
+			t.Fatal(err)
+		}
+		node.pool = pool
+		node.group = pool.NewGroupWithOptions("test", node.getter, opts)
+		t.Cleanup(func() {
+			node.server.Close()
+			node.group.Close()
+		})
+	}
+	return nodes
+}
+
+// Will return the node that owns key, and another one
+func ownerAndOther(nodes []*testNode, key string) (owner, other *testNode) {
+	for _, n := range nodes {
+		if _, remote := n.pool.PickPeer(key); !remote {
+			owner = n
+		} else if other == nil {
+			other = n
+		}
+	}
+	return owner, other
+}
+
+// Will test that a key requested on every node is only loaded once, by its owner
+func TestPeerLoad(t *testing.T) {
+	nodes := newTestNodes(t, 3, DefaultOptions)
+	ctx := context.Background()
+
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("user/%d", i) // '/' must survive the peer request
+		for _, n := range nodes {
+			value, err := n.group.Get(ctx, key)
+			if err != nil || string(value) != "value of "+key {
+				t.Fatalf("Get %s returned %q, %v", key, value, err)
+			}
+		}
+
+		owner, _ := ownerAndOther(nodes, key)
+		for _, n := range nodes {
+			want := 0
+			if n == owner {
+				want = 1
+			}
+			if got := n.getter.count(key); got != want {
+				t.Errorf("%s: getter ran %d times on %s, expected %d", key, got, n.server.URL, want)
+			}
+		}
+	}
+
+	var peerLoads, serverRequests uint64
+	for _, n := range nodes {
+		stats := n.group.GetStats()
+		peerLoads += stats["peer_loads"]
+		serverRequests += stats["server_requests"]
+	}
+	if peerLoads != 40 || serverRequests != 40 {
+		t.Errorf("Expected 40 peer loads and server requests, got %d and %d", peerLoads, serverRequests)
+	}
+}
+
+// Will test that a key fetched from its owner often enough is copied into the hot tier of the requester
+func TestHotKeys(t *testing.T) {
+	nodes := newTestNodes(t, 2, Options{HotThreshold: 3, HotWindow: time.Minute})
+	ctx := context.Background()
+	owner, other := ownerAndOther(nodes, "popular")
+
+	for i := 0; i < 3; i++ {
+		if _, err := other.group.Get(ctx, "popular"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := other.group.GetStats()
+	if stats["peer_loads"] != 3 || stats["hot_keys"] != 1 || stats["main_keys"] != 0 {
+		t.Fatalf("Expected the key to become hot after 3 fetches, got %v", stats)
+	}
+
+	other.group.Get(ctx, "popular")
+	if stats := other.group.GetStats(); stats["hot_hits"] != 1 || stats["peer_loads"] != 3 {
+		t.Errorf("Expected the next get to be served from the hot tier, got %v", stats)
+	}
+	if owner.group.GetStats()["server_requests"] != 3 {
+		t.Errorf("Expected the owner to stop getting requests, got %v", owner.group.GetStats())
+	}
+}
+
+// Will test that a key is loaded locally when its owner is down
+func TestPeerDown(t *testing.T) {
+	nodes := newTestNodes(t, 2, DefaultOptions)
+	owner, other := ownerAndOther(nodes, "k")
+	owner.server.Close()
+
+	value, err := other.group.Get(context.Background(), "k")
+	if err != nil || string(value) != "value of k" {
+		t.Fatalf("Expected a local load, got %q, %v", value, err)
+	}
+	if stats := other.group.GetStats(); stats["peer_errors"] != 1 || stats["local_loads"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestServeHTTPErrors(t *testing.T) {
+	pool, err := NewHTTPPool("http://a:8080")
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := pool.NewGroup("known", &countingGetter{})
+	defer g.Close()
+
+	for path, want := range map[string]int{
+		"/_groupcache/known/k":   http.StatusOK,
+		"/_groupcache/unknown/k": http.StatusNotFound,
+		"/_groupcache/known":     http.StatusBadRequest,
+	} {
+		rec := httptest.NewRecorder()
+		pool.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != want {
+			t.Errorf("%s: expected %d, got %d", path, want, rec.Code)
+		}
+	}
+	if stats := pool.GetStats(); stats["known_server_requests"] != 1 {
+		t.Errorf("Expected prefixed group stats, got %v", stats)
+	}
+}
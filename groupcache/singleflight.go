@@ -0,0 +1,50 @@
+package groupcache
+
+import (
+	"context"
+	"sync"
+)
+
+// A load in progress, shared by every caller asking for the same key
+type call struct {
+	done  chan struct{} // Closed once value and err are set
+	value []byte
+	err   error
+}
+
+// Runs a function once per key at a time. Callers that ask for a key while it is already being loaded
+// wait for that load and get its result, instead of loading it again.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// Will run fn for key, or join the run already in progress, and wait for its result until ctx is done.
+// fn runs on its own: a caller that gives up leaves it running for the others. shared is true when the result
+// came from another caller's run.
+func (f *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, error)) (value []byte, err error, shared bool) {
+	f.mu.Lock()
+	if f.calls == nil {
+		f.calls = make(map[string]*call)
+	}
+	c, shared := f.calls[key]
+	if !shared {
+		c = &call{done: make(chan struct{})}
+		f.calls[key] = c
+		go func() {
+			c.value, c.err = fn()
+			f.mu.Lock()
+			delete(f.calls, key)
+			f.mu.Unlock()
+			close(c.done)
+		}()
+	}
+	f.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return c.value, c.err, shared
+	case <-ctx.Done():
+		return nil, ctx.Err(), shared
+	}
+}
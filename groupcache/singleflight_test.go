@@ -0,0 +1,80 @@
+package groupcache
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFlightGroupDedup(t *testing.T) {
+	var f flightGroup
+	var runs, shared atomic.Int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			value, err, wasShared := f.do(context.Background(), "key", func() ([]byte, error) {
+				runs.Add(1)
+				<-release
+				return []byte("value"), nil
+			})
+			if err != nil || string(value) != "value" {
+				t.Errorf("Unexpected result %q, %v", value, err)
+			}
+			if wasShared {
+				shared.Add(1)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond) // Let every caller arrive while the first run is blocked
+	close(release)
+	wg.Wait()
+
+	if runs.Load() != 1 || shared.Load() != 9 {
+		t.Errorf("Expected 1 run shared by 9 callers, got %d runs and %d shared", runs.Load(), shared.Load())
+	}
+
+	// Once done, the next call runs again
+	f.do(context.Background(), "key", func() ([]byte, error) { runs.Add(1); return nil, nil })
+	if runs.Load() != 2 {
+		t.Errorf("Expected a new run after the first finished")
+	}
+}
+
+// Will test that the caller that started a run can give up without failing the callers that joined it
+func TestFlightGroupCallerGivesUp(t *testing.T) {
+	var f flightGroup
+	release := make(chan struct{})
+	fn := func() ([]byte, error) {
+		<-release
+		return []byte("value"), nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err, _ := f.do(ctx, "key", fn)
+		first <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	second := make(chan []byte)
+	go func() {
+		value, _, _ := f.do(context.Background(), "key", fn)
+		second <- value
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the first caller to stop with its context, got %v", err)
+	}
+	close(release)
+	if value := <-second; string(value) != "value" {
+		t.Errorf("Expected the second caller to get the value, got %q", value)
+	}
+}
@@ -0,0 +1,169 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"golang-memory-cache/api"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Longest a POST may block waiting for a lock, so a forgotten wait can't hold a connection forever
+const maxWait = 5 * time.Minute
+
+type lockResponse struct {
+	Name      string    `json:"name"`
+	Owner     string    `json:"owner"`
+	Token     uint64    `json:"token"`
+	TTL       float64   `json:"ttl"` // Seconds until the lock expires
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Will mount the lock routes on mux:
+// * GET /v2/locks/{name}
+// * POST /v2/locks/{name}?owner=&ttl=&wait=
+// * PUT /v2/locks/{name}?owner=&ttl=
+// * DELETE /v2/locks/{name}?owner=
+func (l *Locker) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v2/locks/{name}", l.GetHandler)
+	mux.HandleFunc("POST /v2/locks/{name}", l.AcquireHandler)
+	mux.HandleFunc("PUT /v2/locks/{name}", l.RenewHandler)
+	mux.HandleFunc("DELETE /v2/locks/{name}", l.ReleaseHandler)
+}
+
+// * GET /v2/locks/{name}
+// Answers with the current holder, or 404 when the lock is free
+func (l *Locker) GetHandler(w http.ResponseWriter, r *http.Request) {
+	lock, held := l.Get(r.PathValue("name"))
+	if !held {
+		api.WriteError(w, http.StatusNotFound, "Lock not held")
+		return
+	}
+	writeLock(w, lock)
+}
+
+// * POST /v2/locks/{name}?owner=worker-1&ttl=30s&wait=10s
+// Takes the lock, waiting up to wait (none by default) for the current holder to release it or let it expire.
+// Answers 409 with the holder when the lock is still taken.
+func (l *Locker) AcquireHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ttl, ok := lockParams(w, r)
+	if !ok {
+		return
+	}
+	wait, err := parseDuration(r.URL.Query().Get("wait"), 0)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "wait: "+err.Error())
+		return
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	name := r.PathValue("name")
+	var lock Lock
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		lock, err = l.AcquireWait(ctx, name, owner, ttl)
+		if errors.Is(err, context.DeadlineExceeded) {
+			lock, err = l.Acquire(name, owner, ttl) // One last try, and the holder for the response
+		}
+	} else {
+		lock, err = l.Acquire(name, owner, ttl)
+	}
+	switch {
+	case errors.Is(err, ErrLocked):
+		api.WriteJSON(w, http.StatusConflict, map[string]interface{}{
+			"error":  map[string]interface{}{"status": http.StatusConflict, "message": "Lock held by another owner"},
+			"holder": newLockResponse(lock),
+		})
+	case err != nil:
+		writeLockerError(w, err)
+	default:
+		writeLock(w, lock)
+	}
+}
+
+// * PUT /v2/locks/{name}?owner=worker-1&ttl=30s
+// Extends the lock held by owner, 409 when owner does not hold it anymore
+func (l *Locker) RenewHandler(w http.ResponseWriter, r *http.Request) {
+	owner, ttl, ok := lockParams(w, r)
+	if !ok {
+		return
+	}
+	lock, err := l.Renew(r.PathValue("name"), owner, ttl)
+	if err != nil {
+		writeLockerError(w, err)
+		return
+	}
+	writeLock(w, lock)
+}
+
+// * DELETE /v2/locks/{name}?owner=worker-1
+// Releases the lock held by owner, 409 when owner does not hold it anymore
+func (l *Locker) ReleaseHandler(w http.ResponseWriter, r *http.Request) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		api.WriteError(w, http.StatusBadRequest, "Missing owner")
+		return
+	}
+	if err := l.Release(r.PathValue("name"), owner); err != nil {
+		writeLockerError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Will read the owner and ttl query parameters, both required
+func lockParams(w http.ResponseWriter, r *http.Request) (string, time.Duration, bool) {
+	owner := r.URL.Query().Get("owner")
+	if owner == "" {
+		api.WriteError(w, http.StatusBadRequest, "Missing owner")
+		return "", 0, false
+	}
+	ttl, err := parseDuration(r.URL.Query().Get("ttl"), -1)
+	if err != nil || ttl <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "ttl must be a positive number of seconds or a duration")
+		return "", 0, false
+	}
+	return owner, ttl, true
+}
+
+// Will parse a number of seconds ("30", "0.5") or a Go duration ("30s"), returning missing when s is empty
+func parseDuration(s string, missing time.Duration) (time.Duration, error) {
+	if s == "" {
+		return missing, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+func newLockResponse(lock Lock) lockResponse {
+	ttl := time.Until(lock.Expires).Seconds()
+	if ttl < 0 {
+		ttl = 0
+	}
+	return lockResponse{Name: lock.Name, Owner: lock.Owner, Token: lock.Token, TTL: ttl, ExpiresAt: lock.Expires.UTC()}
+}
+
+func writeLock(w http.ResponseWriter, lock Lock) {
+	api.WriteJSON(w, http.StatusOK, newLockResponse(lock))
+}
+
+func writeLockerError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotOwner):
+		api.WriteError(w, http.StatusConflict, "Lock not held by this owner")
+	case errors.Is(err, ErrNoOwner), errors.Is(err, ErrInvalidTTL):
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
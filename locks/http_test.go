@@ -0,0 +1,72 @@
+package locks
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTP(t *testing.T) {
+	l, _ := newTestLocker(t)
+	mux := http.NewServeMux()
+	l.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	do := func(method, path string) (int, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, srv.URL+path, nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var body map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp.StatusCode, body
+	}
+
+	status, body := do("POST", "/v2/locks/job?owner=a&ttl=30s")
+	if status != http.StatusOK || body["owner"] != "a" || body["token"] != float64(1) {
+		t.Fatalf("Unexpected response to acquire: %d %v", status, body)
+	}
+	status, body = do("POST", "/v2/locks/job?owner=b&ttl=30")
+	if status != http.StatusConflict || body["holder"].(map[string]interface{})["owner"] != "a" {
+		t.Errorf("Expected 409 with the holder, got %d %v", status, body)
+	}
+	if status, _ := do("PUT", "/v2/locks/job?owner=b&ttl=30"); status != http.StatusConflict {
+		t.Errorf("Expected 409 when someone else renews, got %d", status)
+	}
+	if status, body := do("PUT", "/v2/locks/job?owner=a&ttl=60"); status != http.StatusOK || body["ttl"].(float64) < 59 {
+		t.Errorf("Unexpected response to renew: %d %v", status, body)
+	}
+	if status, _ := do("POST", "/v2/locks/job?owner=a"); status != http.StatusBadRequest {
+		t.Errorf("Expected 400 without a ttl, got %d", status)
+	}
+
+	// b waits while a releases
+	waited := make(chan map[string]interface{})
+	go func() {
+		_, body := do("POST", "/v2/locks/job?owner=b&ttl=30s&wait=5s")
+		waited <- body
+	}()
+	time.Sleep(20 * time.Millisecond)
+	if status, _ := do("DELETE", "/v2/locks/job?owner=a"); status != http.StatusNoContent {
+		t.Errorf("Expected 204 on release, got %d", status)
+	}
+	if body := <-waited; body["owner"] != "b" || body["token"] != float64(2) {
+		t.Errorf("Expected b to get the lock with the next token, got %v", body)
+	}
+
+	if status, body := do("GET", "/v2/locks/job"); status != http.StatusOK || body["owner"] != "b" {
+		t.Errorf("Unexpected holder: %d %v", status, body)
+	}
+	if status, _ := do("GET", "/v2/locks/free"); status != http.StatusNotFound {
+		t.Errorf("Expected 404 for a free lock, got %d", status)
+	}
+	if status, _ := do("POST", "/v2/locks/job?owner=c&ttl=30s&wait=20ms"); status != http.StatusConflict {
+		t.Errorf("Expected 409 once the wait ran out, got %d", status)
+	}
+}
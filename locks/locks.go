@@ -0,0 +1,237 @@
+package locks
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"golang-memory-cache/cache"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Locks and leases on top of a cache.Cache. A lock is a key holding its owner and a fencing token, and expires
+// with the key's TTL unless the owner renews it. Only the owner can renew or release it.
+//
+// Fencing tokens come from a counter kept in the cache, so they keep increasing across locks, releases and
+// restarts from a snapshot. A lock holder that paused for longer than its TTL may still believe it holds the
+// lock, so the resources it protects should reject writes carrying a lower token than one they have already seen.
+//
+// The lock keys (under Prefix) and the token counter must only be written through the Locker: anything else
+// writing to c, like a flush, could reset the counter and hand out tokens again. Give the Locker a cache of its
+// own when c is also reachable by clients, as the server does.
+
+// Settings used when creating a Locker
+type Options struct {
+	Prefix        string        // Prepended to a lock's name to get its cache key
+	TokenKey      string        // Cache key of the counter fencing tokens are taken from
+	RetryInterval time.Duration // Longest a blocked AcquireWait sleeps before trying again, releases wake it earlier
+}
+
+// Options used by NewLocker
+var DefaultOptions = Options{
+	Prefix:        "lock:",
+	TokenKey:      "lock-token",
+	RetryInterval: time.Second,
+}
+
+var (
+	ErrLocked     = errors.New("locks: held by another owner")
+	ErrNotOwner   = errors.New("locks: not held by this owner")
+	ErrInvalidTTL = errors.New("locks: ttl must be positive")
+	ErrNoOwner    = errors.New("locks: missing owner")
+)
+
+// A held lock. The cache stores it as the value of the lock's key, Expires is filled in from the key's expiration.
+type Lock struct {
+	Name    string
+	Owner   string
+	Token   uint64
+	Expires time.Time
+}
+
+// Lock values end up in snapshots and replication streams
+func init() {
+	gob.Register(Lock{})
+}
+
+type lockerStats struct {
+	Acquired  uint64
+	Contended uint64
+	Renewed   uint64
+	Released  uint64
+	Lost      uint64 // Renewals and releases refused because the lock was lost
+}
+
+type Locker struct {
+	cache   *cache.Cache
+	options Options
+
+	// Serializes every operation of the Locker, so a token is only taken once the lock is known to be free
+	// and tokens are handed out in the order locks are acquired
+	mu       sync.Mutex
+	released chan struct{} // Closed and replaced on every release, wakes up AcquireWait
+	stats    lockerStats
+}
+
+// Creates a Locker keeping its locks in c, with DefaultOptions
+func NewLocker(c *cache.Cache) *Locker {
+	return NewLockerWithOptions(c, DefaultOptions)
+}
+
+// Creates a Locker with custom options. Zero values fall back to DefaultOptions.
+func NewLockerWithOptions(c *cache.Cache, opts Options) *Locker {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultOptions.Prefix
+	}
+	if opts.TokenKey == "" {
+		opts.TokenKey = DefaultOptions.TokenKey
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultOptions.RetryInterval
+	}
+	return &Locker{cache: c, options: opts, released: make(chan struct{})}
+}
+
+// Will return the current holder of the lock. Must be called while holding l.mu.
+func (l *Locker) current(name string) (Lock, bool) {
+	item, found := l.cache.Peek(l.options.Prefix + name)
+	if !found || item.Expired(time.Now().UnixNano()) {
+		return Lock{}, false
+	}
+	lock, ok := item.Value.(Lock)
+	if !ok {
+		return Lock{}, false
+	}
+	lock.Expires = time.Unix(0, item.Expiration)
+	return lock, true
+}
+
+// Will return the current holder of the lock, and false when it is free
+func (l *Locker) Get(name string) (Lock, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.current(name)
+}
+
+// Will take the lock for owner for ttl, and return it with a new fencing token.
+// Acquiring a lock the owner already holds extends it and keeps its token, so retrying after a lost response is safe.
+// When someone else holds the lock, returns the holder and ErrLocked.
+func (l *Locker) Acquire(name, owner string, ttl time.Duration) (Lock, error) {
+	if owner == "" {
+		return Lock{}, ErrNoOwner
+	}
+	if ttl <= 0 {
+		return Lock{}, ErrInvalidTTL
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if holder, held := l.current(name); held {
+		if holder.Owner != owner {
+			atomic.AddUint64(&l.stats.Contended, 1)
+			return holder, ErrLocked
+		}
+		return l.extend(name, owner, ttl)
+	}
+
+	token, err := l.cache.Increment(l.options.TokenKey, 1)
+	if err != nil {
+		return Lock{}, err
+	}
+	lock := Lock{Name: name, Owner: owner, Token: uint64(token)}
+	item, _ := l.cache.SetIf(l.options.Prefix+name, lock, ttl, nil, nil)
+	lock.Expires = time.Unix(0, item.Expiration)
+	atomic.AddUint64(&l.stats.Acquired, 1)
+	return lock, nil
+}
+
+// Will try to take the lock until it succeeds or ctx is done. Returns ctx.Err() when giving up.
+func (l *Locker) AcquireWait(ctx context.Context, name, owner string, ttl time.Duration) (Lock, error) {
+	for {
+		// Taken before trying, so a release right after a failed attempt still wakes us up
+		l.mu.Lock()
+		released := l.released
+		l.mu.Unlock()
+
+		holder, err := l.Acquire(name, owner, ttl)
+		if !errors.Is(err, ErrLocked) {
+			return holder, err
+		}
+
+		wait := time.Until(holder.Expires)
+		if wait > l.options.RetryInterval {
+			wait = l.options.RetryInterval
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Lock{}, ctx.Err()
+		case <-released:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Will extend the lock to expire ttl from now. Fails with ErrNotOwner when owner does not hold it (anymore).
+func (l *Locker) Renew(name, owner string, ttl time.Duration) (Lock, error) {
+	if ttl <= 0 {
+		return Lock{}, ErrInvalidTTL
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.extend(name, owner, ttl)
+}
+
+// Must be called while holding l.mu
+func (l *Locker) extend(name, owner string, ttl time.Duration) (Lock, error) {
+	now := time.Now()
+	item, ok := l.cache.Update(l.options.Prefix+name, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+		lock, isLock := item.Value.(Lock)
+		if !found || item.Expired(now.UnixNano()) || !isLock || lock.Owner != owner {
+			return item, false
+		}
+		item.Expiration = now.Add(ttl).UnixNano()
+		return item, true
+	})
+	if !ok {
+		atomic.AddUint64(&l.stats.Lost, 1)
+		return Lock{}, ErrNotOwner
+	}
+	atomic.AddUint64(&l.stats.Renewed, 1)
+	lock := item.Value.(Lock)
+	lock.Expires = time.Unix(0, item.Expiration)
+	return lock, nil
+}
+
+// Will free the lock. Fails with ErrNotOwner when owner does not hold it (anymore).
+func (l *Locker) Release(name, owner string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now().UnixNano()
+	deleted := l.cache.DeleteIf(l.options.Prefix+name, func(item cache.CacheItem, found bool) bool {
+		lock, isLock := item.Value.(Lock)
+		return found && !item.Expired(now) && isLock && lock.Owner == owner
+	})
+	if !deleted {
+		atomic.AddUint64(&l.stats.Lost, 1)
+		return ErrNotOwner
+	}
+	atomic.AddUint64(&l.stats.Released, 1)
+	close(l.released)
+	l.released = make(chan struct{})
+	return nil
+}
+
+// Will return the locker's counters, prefixed with "locks_"
+func (l *Locker) GetStats() map[string]uint64 {
+	return map[string]uint64{
+		"locks_acquired":  atomic.LoadUint64(&l.stats.Acquired),
+		"locks_contended": atomic.LoadUint64(&l.stats.Contended),
+		"locks_renewed":   atomic.LoadUint64(&l.stats.Renewed),
+		"locks_released":  atomic.LoadUint64(&l.stats.Released),
+		"locks_lost":      atomic.LoadUint64(&l.stats.Lost),
+	}
+}
@@ -0,0 +1,169 @@
+package locks
+
+import (
+	"bytes"
+	"context"
+	"golang-memory-cache/cache"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestLocker(t *testing.T) (*Locker, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	return NewLockerWithOptions(c, Options{RetryInterval: 10 * time.Millisecond}), c
+}
+
+func TestAcquireRelease(t *testing.T) {
+	l, _ := newTestLocker(t)
+	a, err := l.Acquire("job", "a", time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if holder, err := l.Acquire("job", "b", time.Minute); err != ErrLocked || holder.Owner != "a" {
+		t.Errorf("Expected ErrLocked with a as the holder, got %v %+v", err, holder)
+	}
+	if again, err := l.Acquire("job", "a", time.Minute); err != nil || again.Token != a.Token {
+		t.Errorf("Expected the owner to get its lock back with the same token, got %v %+v", err, again)
+	}
+
+	if err := l.Release("job", "b"); err != ErrNotOwner {
+		t.Errorf("Expected ErrNotOwner when someone else releases, got %v", err)
+	}
+	if err := l.Release("job", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, held := l.Get("job"); held {
+		t.Error("Expected the lock to be free after its release")
+	}
+	b, err := l.Acquire("job", "b", time.Minute)
+	if err != nil || b.Token <= a.Token {
+		t.Errorf("Expected a higher token than %d, got %v %+v", a.Token, err, b)
+	}
+	if err := l.Release("job", "a"); err != ErrNotOwner {
+		t.Errorf("Expected a released owner to stay released, got %v", err)
+	}
+}
+
+// Will test that a lock that was not renewed expires, and that its late owner can't renew or release it
+func TestExpiration(t *testing.T) {
+	l, _ := newTestLocker(t)
+	a, _ := l.Acquire("job", "a", 30*time.Millisecond)
+	if _, err := l.Renew("job", "a", 30*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	b, err := l.Acquire("job", "b", time.Minute)
+	if err != nil || b.Token <= a.Token {
+		t.Fatalf("Expected b to take the expired lock with a higher token, got %v %+v", err, b)
+	}
+	if _, err := l.Renew("job", "a", time.Minute); err != ErrNotOwner {
+		t.Errorf("Expected ErrNotOwner when renewing a lost lock, got %v", err)
+	}
+	if err := l.Release("job", "a"); err != ErrNotOwner {
+		t.Errorf("Expected ErrNotOwner when releasing a lost lock, got %v", err)
+	}
+	if stats := l.GetStats(); stats["locks_acquired"] != 2 || stats["locks_lost"] != 2 || stats["locks_renewed"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestAcquireWait(t *testing.T) {
+	l, _ := newTestLocker(t)
+	l.Acquire("job", "a", time.Minute)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.AcquireWait(ctx, "job", "b", time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Expected to give up with the context, got %v", err)
+	}
+
+	got := make(chan Lock)
+	go func() {
+		lock, err := l.AcquireWait(context.Background(), "job", "b", time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- lock
+	}()
+	time.Sleep(20 * time.Millisecond)
+	l.Release("job", "a")
+	select {
+	case lock := <-got:
+		if lock.Owner != "b" {
+			t.Errorf("Expected b to hold the lock, got %+v", lock)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the release to wake up the waiter")
+	}
+
+	// Woken up by the expiration as well
+	l.Acquire("short", "a", 30*time.Millisecond)
+	start := time.Now()
+	if _, err := l.AcquireWait(context.Background(), "short", "b", time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > time.Second {
+		t.Errorf("Expected the waiter to get the lock once it expired, took %v", time.Since(start))
+	}
+}
+
+// Will test that each of many concurrent workers holds the lock alone, with tokens increasing in the order
+// the lock was held
+func TestMutualExclusion(t *testing.T) {
+	l, _ := newTestLocker(t)
+	var (
+		mu        sync.Mutex
+		holders   int
+		lastToken uint64
+		wg        sync.WaitGroup
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				lock, err := l.AcquireWait(context.Background(), "counter", owner, time.Minute)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				mu.Lock()
+				holders++
+				if holders != 1 || lock.Token <= lastToken {
+					t.Errorf("Unexpected state: %d holders, token %d after %d", holders, lock.Token, lastToken)
+				}
+				lastToken = lock.Token
+				holders--
+				mu.Unlock()
+				l.Release("counter", owner)
+			}
+		}(string(rune('a' + i)))
+	}
+	wg.Wait()
+}
+
+// Will test that tokens keep increasing after a restart from a snapshot
+func TestTokensSurviveSnapshot(t *testing.T) {
+	l, c := newTestLocker(t)
+	a, _ := l.Acquire("job", "a", time.Minute)
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, c2 := newTestLocker(t)
+	if _, err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if holder, held := restored.Get("job"); !held || holder.Owner != "a" || holder.Token != a.Token {
+		t.Errorf("Expected the lock to be restored, got %+v", holder)
+	}
+	b, _ := restored.Acquire("other", "b", time.Minute)
+	if b.Token <= a.Token {
+		t.Errorf("Expected tokens to keep increasing, got %d after %d", b.Token, a.Token)
+	}
+}
@@ -1,7 +1,367 @@
 package main
 
-import "fmt"
+import (
+	"context"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"golang-memory-cache/cluster"
+	"golang-memory-cache/crdt"
+	"golang-memory-cache/gossip"
+	"golang-memory-cache/locks"
+	"golang-memory-cache/memcache"
+	"golang-memory-cache/pubsub"
+	"golang-memory-cache/queue"
+	"golang-memory-cache/raft"
+	"golang-memory-cache/ratelimit"
+	"golang-memory-cache/replication"
+	"golang-memory-cache/resp"
+	"golang-memory-cache/sketch"
+	"golang-memory-cache/stream"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
 
 func main() {
-	fmt.Printf("Hello World")
+	cfg, err := loadConfig(os.Args[1:], os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The context is cancelled on SIGINT (ctrl+c) or SIGTERM (i.e. docker stop), which starts the graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	listener, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := run(ctx, cfg, listener); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// How often the peers file is checked for changes in cluster mode
+const peersReloadInterval = 5 * time.Second
+
+// How long handing keys to their new owners may take after a membership change
+const rebalanceTimeout = 30 * time.Second
+
+// Will start gossiping on cfg.GossipAddr and join cfg.Join. From then on the cluster's nodes follow the live
+// gossip members, and keys this node no longer owns after a change are handed to their new owner.
+func startGossip(cfg Config, node *cluster.Cluster, c *cache.Cache) (*gossip.Memberlist, error) {
+	opts := gossip.DefaultOptions
+	opts.Meta = node.Self()
+	opts.AdvertiseAddr = advertiseAddr(cfg.GossipAddr, node.Self())
+	opts.OnChange = func(members []gossip.Member) {
+		peers := make([]string, 0, len(members))
+		for _, m := range members {
+			if m.Meta != "" {
+				peers = append(peers, m.Meta)
+			}
+		}
+		if err := node.SetPeers(peers); err != nil {
+			log.Printf("gossip: %v", err)
+			return
+		}
+		log.Printf("gossip: nodes: %s", strings.Join(node.Peers(), ", "))
+
+		ctx, cancel := context.WithTimeout(context.Background(), rebalanceTimeout)
+		defer cancel()
+		moved, err := node.Rebalance(ctx, c)
+		if err != nil {
+			log.Printf("gossip: rebalance: %v", err)
+		}
+		if moved > 0 {
+			log.Printf("gossip: handed %d keys to their new owners", moved)
+		}
+	}
+
+	members, err := gossip.NewMemberlistWithOptions(node.Self(), cfg.GossipAddr, opts)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("gossip on %s", opts.AdvertiseAddr)
+	if len(cfg.Join) > 0 {
+		// Not fatal, the first nodes of a cluster may start before the ones they join. They are found once those join them.
+		if _, err := members.Join(cfg.Join...); err != nil {
+			log.Printf("gossip: %v", err)
+		}
+	}
+	return members, nil
+}
+
+// Will return the gossip address other nodes can reach. When gossipAddr has no host (i.e. ":7946"),
+// the host of this node's URL is used with its port.
+func advertiseAddr(gossipAddr, self string) string {
+	host, port, err := net.SplitHostPort(gossipAddr)
+	if err != nil || (host != "" && host != "0.0.0.0" && host != "::") {
+		return gossipAddr
+	}
+	u, err := url.Parse(self)
+	if err != nil || u.Hostname() == "" {
+		return gossipAddr
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// Will drop the trailing slash of a node URL, nodes must name each other exactly the same way
+func trimURL(u string) string {
+	return strings.TrimSuffix(u, "/")
+}
+
+func trimURLs(urls []string) []string {
+	trimmed := make([]string, len(urls))
+	for i, u := range urls {
+		trimmed[i] = trimURL(u)
+	}
+	return trimmed
+}
+
+// A TCP server for another wire protocol, like resp.Server or memcache.Server
+type protocolServer interface {
+	Serve(l net.Listener) error
+	Close() error
+}
+
+// Will serve the cache on listener until ctx is cancelled, then shut down gracefully:
+// stop accepting connections, let in-flight requests finish, stop the janitor and save a snapshot if configured.
+func run(ctx context.Context, cfg Config, listener net.Listener) error {
+	c := cache.NewCacheWithOptions(cache.Options{CleanupInterval: cfg.CleanupInterval})
+	defer c.Stop()
+
+	// Locks and queues keep their state in a cache of their own, out of reach of the key API, flushes and restores,
+	// which could otherwise reset the fencing token and message ID counters. It is saved next to the snapshot.
+	internal := cache.NewCacheWithOptions(cache.Options{CleanupInterval: cfg.CleanupInterval})
+	defer internal.Stop()
+
+	if cfg.SnapshotPath != "" {
+		if err := loadSnapshot(c, cfg.SnapshotPath); err != nil {
+			return err
+		}
+		if err := loadSnapshot(internal, internalSnapshotPath(cfg.SnapshotPath)); err != nil {
+			return err
+		}
+	}
+
+	h := &api.Handler{Cache: c, MaxRestoreSize: cfg.MaxRestoreSize}
+	var primary *replication.Primary
+	if cfg.Replicate {
+		primary = replication.NewPrimary(c)
+		defer primary.Close()
+		h.StatsSources = append(h.StatsSources, primary)
+		log.Printf("replication: serving changes as primary %s", primary.ID())
+	}
+	if cfg.ReplicaOf != "" {
+		follower, err := replication.NewFollower(c, cfg.ReplicaOf)
+		if err != nil {
+			return err
+		}
+		defer follower.Close()
+		h.StatsSources = append(h.StatsSources, follower)
+		log.Printf("replication: following %s", cfg.ReplicaOf)
+	}
+
+	var store *raft.Store
+	if len(cfg.RaftPeers) > 0 {
+		// Consistent keys live in a cache of their own, only ever written through the log
+		storage, err := raft.NewFileStorage(cfg.RaftDir)
+		if err != nil {
+			return err
+		}
+		defer storage.Close()
+		opts := raft.DefaultStoreOptions
+		opts.Node.Storage = storage
+		store, err = raft.NewStoreWithOptions(cache.NewCache(), trimURL(cfg.Self), trimURLs(cfg.RaftPeers), raft.NewHTTPTransport(nil), opts)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		h.StatsSources = append(h.StatsSources, store)
+		log.Printf("raft: consistent keys replicated by %s", strings.Join(cfg.RaftPeers, ", "))
+	}
+
+	var replica *crdt.Replica
+	if len(cfg.CRDTPeers) > 0 {
+		var err error
+		replica, err = crdt.NewReplica(c, trimURL(cfg.Self), trimURLs(cfg.CRDTPeers), crdt.NewHTTPTransport(nil))
+		if err != nil {
+			return err
+		}
+		defer replica.Close()
+		h.StatsSources = append(h.StatsSources, replica)
+		log.Printf("crdt: replicating with %s", strings.Join(cfg.CRDTPeers, ", "))
+	}
+
+	locker := locks.NewLocker(internal)
+	limiter := ratelimit.NewLimiter(c)
+	sketches := sketch.NewSketches(c)
+	queues := queue.NewQueues(internal)
+	streams := stream.NewStreams(c)
+	broker := pubsub.NewBroker()
+	h.StatsSources = append(h.StatsSources, locker, limiter, queues, broker)
+
+	mux := h.Routes()
+	locker.RegisterRoutes(mux)
+	limiter.RegisterRoutes(mux)
+	sketches.RegisterRoutes(mux)
+	queues.RegisterRoutes(mux)
+	streams.RegisterRoutes(mux)
+	broker.RegisterRoutes(mux)
+	if primary != nil {
+		primary.RegisterRoutes(mux)
+	}
+	var handler http.Handler = mux
+	if cfg.ReplicaOf != "" {
+		// Writes on a follower would be overwritten by the primary
+		handler = replication.ReadOnly(handler)
+	}
+
+	if cfg.clusterMode() {
+		node, err := cluster.NewCluster(cfg.Self, cfg.Peers)
+		if err != nil {
+			return err
+		}
+		if cfg.PeersFile != "" {
+			if err := node.WatchPeersFile(ctx, cfg.PeersFile, peersReloadInterval); err != nil {
+				return err
+			}
+		}
+		if cfg.GossipAddr != "" {
+			members, err := startGossip(cfg, node, c)
+			if err != nil {
+				return err
+			}
+			defer members.Close()
+			defer members.Leave() // Runs first, so the other nodes drop this one right away
+		}
+		log.Printf("cluster mode as %s, nodes: %s", node.Self(), strings.Join(node.Peers(), ", "))
+		handler = node.Handler(handler)
+	}
+
+	if store != nil || replica != nil {
+		// Outside of ReadOnly and the cluster, the Raft group and the CRDT replicas replicate these requests themselves
+		outer := http.NewServeMux()
+		if store != nil {
+			store.RegisterRoutes(outer)
+		}
+		if replica != nil {
+			replica.RegisterRoutes(outer)
+		}
+		outer.Handle("/", handler)
+		handler = outer
+	}
+
+	// Every request context derives from baseCtx. Shutdown does not cancel request contexts by itself,
+	// so we cancel baseCtx when it starts, which ends long lived /watch streams instead of waiting for the timeout.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+
+	srv := &http.Server{
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancelBase)
+
+	serveErr := make(chan error, 3)
+	go func() {
+		log.Printf("listening on %s", listener.Addr())
+		serveErr <- srv.Serve(listener)
+	}()
+
+	// Optional protocol listeners, sharing the same cache. Like the HTTP API, they can't write on a follower.
+	respServer := resp.NewServer(c)
+	respServer.ReadOnly = cfg.ReplicaOf != ""
+	memcacheServer := memcache.NewServer(c)
+	memcacheServer.ReadOnly = cfg.ReplicaOf != ""
+	var protocolServers []protocolServer
+	closeProtocolServers := func() {
+		for _, ps := range protocolServers {
+			ps.Close()
+		}
+	}
+	optional := []struct {
+		name   string
+		addr   string
+		server protocolServer
+		closed error
+	}{
+		{"RESP", cfg.RESPAddr, respServer, resp.ErrServerClosed},
+		{"memcache", cfg.MemcacheAddr, memcacheServer, memcache.ErrServerClosed},
+	}
+	for _, o := range optional {
+		if o.addr == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", o.addr)
+		if err != nil {
+			srv.Close()
+			closeProtocolServers()
+			return err
+		}
+		protocolServers = append(protocolServers, o.server)
+		go func(name string, server protocolServer, closed error) {
+			log.Printf("serving %s on %s", name, l.Addr())
+			if err := server.Serve(l); !errors.Is(err, closed) {
+				serveErr <- err
+			}
+		}(o.name, o.server, o.closed)
+	}
+
+	select {
+	case err := <-serveErr:
+		srv.Close()
+		closeProtocolServers()
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Print("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+	}
+	closeProtocolServers()
+
+	c.Stop()
+	internal.Stop()
+
+	if cfg.SnapshotPath != "" {
+		if err := c.SaveSnapshotFile(cfg.SnapshotPath); err != nil {
+			return err
+		}
+		if err := internal.SaveSnapshotFile(internalSnapshotPath(cfg.SnapshotPath)); err != nil {
+			return err
+		}
+		log.Printf("saved snapshot to %s", cfg.SnapshotPath)
+	}
+	return nil
+}
+
+// Will load the snapshot at path into c, starting empty when there is none
+func loadSnapshot(c *cache.Cache, path string) error {
+	loaded, err := c.LoadSnapshotFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		log.Printf("no snapshot at %s, starting empty", path)
+	case err != nil:
+		return err
+	default:
+		log.Printf("loaded %d items from %s", loaded, path)
+	}
+	return nil
+}
+
+// Will return where the cache of locks and queues is saved, next to the snapshot of the main cache
+func internalSnapshotPath(path string) string {
+	return path + ".internal"
 }
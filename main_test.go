@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"golang-memory-cache/cluster"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Will test that env variables replace defaults and flags replace env variables
+func TestLoadConfig(t *testing.T) {
+	env := map[string]string{
+		"CACHE_ADDR":             ":9000",
+		"CACHE_CLEANUP_INTERVAL": "5s",
+		"CACHE_RESP_ADDR":        ":6379",
+	}
+	getenv := func(name string) string { return env[name] }
+
+	cfg, err := loadConfig([]string{"-addr", ":9001", "-snapshot", "/tmp/cache.snap", "-memcache-addr", ":11211"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Addr != ":9001" {
+		t.Errorf("Expected flag to win over env, got %v", cfg.Addr)
+	}
+	if cfg.CleanupInterval != 5*time.Second {
+		t.Errorf("Expected cleanup interval from env, got %v", cfg.CleanupInterval)
+	}
+	if cfg.SnapshotPath != "/tmp/cache.snap" {
+		t.Errorf("Expected snapshot path from flag, got %v", cfg.SnapshotPath)
+	}
+	if cfg.RESPAddr != ":6379" {
+		t.Errorf("Expected RESP address from env, got %v", cfg.RESPAddr)
+	}
+	if cfg.MemcacheAddr != ":11211" {
+		t.Errorf("Expected memcache address from flag, got %v", cfg.MemcacheAddr)
+	}
+	if cfg.ShutdownTimeout != 10*time.Second {
+		t.Errorf("Expected default shutdown timeout, got %v", cfg.ShutdownTimeout)
+	}
+	if cfg.MaxRestoreSize != api.DefaultMaxRestoreSize {
+		t.Errorf("Expected default max restore size, got %v", cfg.MaxRestoreSize)
+	}
+
+	env["CACHE_MAX_RESTORE_SIZE"] = "1024"
+	if cfg, _ := loadConfig(nil, getenv); cfg.MaxRestoreSize != 1024 {
+		t.Errorf("Expected max restore size from env, got %v", cfg.MaxRestoreSize)
+	}
+	if _, err := loadConfig([]string{"-max-restore-size", "0"}, getenv); err == nil {
+		t.Error("Expected error for a max restore size of 0")
+	}
+
+	env["CACHE_CLEANUP_INTERVAL"] = "soon"
+	if _, err := loadConfig(nil, getenv); err == nil {
+		t.Error("Expected error for invalid duration in env")
+	}
+}
+
+func TestLoadConfigCluster(t *testing.T) {
+	getenv := func(name string) string {
+		if name == "CACHE_PEERS" {
+			return "http://b:8080, http://c:8080,"
+		}
+		return ""
+	}
+
+	if _, err := loadConfig(nil, getenv); err == nil {
+		t.Error("Expected error for peers without -self")
+	}
+
+	cfg, err := loadConfig([]string{"-self", "http://a:8080"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Peers) != 2 || cfg.Peers[0] != "http://b:8080" || cfg.Peers[1] != "http://c:8080" {
+		t.Errorf("Expected 2 peers from env, got %q", cfg.Peers)
+	}
+}
+
+// Will start the server, set a key over HTTP, shut down and check the key made it into the snapshot
+func TestRunGracefulShutdown(t *testing.T) {
+	snapshot := filepath.Join(t.TempDir(), "cache.snap")
+	cfg := Config{
+		CleanupInterval: time.Second,
+		SnapshotPath:    snapshot,
+		ShutdownTimeout: time.Second,
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, cfg, listener) }()
+
+	base := "http://" + listener.Addr().String()
+	resp, err := http.Post(base+"/set?key=k&value=v&duration=60", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		t.Fatalf("wrong status code: got %v, expected %v", resp.StatusCode, http.StatusCreated)
+	}
+	resp, err = http.Post(base+"/v2/locks/job?owner=a&ttl=60", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the lock to be acquired, got %v", resp.StatusCode)
+	}
+
+	// An open watch stream must not hold up the shutdown
+	stream, err := http.Get(base + "/watch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.Body.Close()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("Expected run to return after cancel")
+	}
+
+	restored := cache.NewCache()
+	defer restored.Stop()
+	if _, err := restored.LoadSnapshotFile(snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if v, found := restored.Get("k"); !found || v != "v" {
+		t.Errorf("Expected key to be saved in snapshot, got %v", v)
+	}
+	if _, found := restored.Get("lock-token"); found {
+		t.Error("Expected the fencing token counter to stay out of the main keyspace")
+	}
+	internal := cache.NewCache()
+	defer internal.Stop()
+	if _, err := internal.LoadSnapshotFile(internalSnapshotPath(snapshot)); err != nil {
+		t.Fatal(err)
+	}
+	if token, _ := internal.Get("lock-token"); token != int64(1) {
+		t.Errorf("Expected the fencing token counter to be saved next to the snapshot, got %v", token)
+	}
+}
+
+func TestLoadConfigReplication(t *testing.T) {
+	getenv := func(name string) string {
+		if name == "CACHE_REPLICATE" {
+			return "true"
+		}
+		return ""
+	}
+	cfg, err := loadConfig([]string{"-replica-of", "http://primary:8080"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.Replicate || cfg.ReplicaOf != "http://primary:8080" {
+		t.Errorf("Unexpected replication config: %+v", cfg)
+	}
+
+	bad := func(name string) string {
+		if name == "CACHE_REPLICATE" {
+			return "maybe"
+		}
+		return ""
+	}
+	if _, err := loadConfig(nil, bad); err == nil {
+		t.Error("Expected error for an invalid CACHE_REPLICATE")
+	}
+}
+
+func TestLoadConfigGossip(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	cfg, err := loadConfig([]string{"-self", "http://a:8080", "-gossip-addr", ":7946", "-join", "b:7946, c:7946"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.clusterMode() || len(cfg.Join) != 2 || cfg.Join[1] != "c:7946" {
+		t.Errorf("Unexpected gossip config: %+v", cfg)
+	}
+
+	for _, args := range [][]string{
+		{"-gossip-addr", ":7946"},                                               // No -self
+		{"-self", "http://a:8080", "-join", "b:7946"},                           // -join without gossip
+		{"-self", "http://a:8080", "-gossip-addr", ":7946", "-peers-file", "p"}, // Both membership sources
+	} {
+		if _, err := loadConfig(args, noEnv); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestAdvertiseAddr(t *testing.T) {
+	for _, tt := range []struct{ gossip, self, want string }{
+		{":7946", "http://10.0.0.1:8080", "10.0.0.1:7946"},
+		{"0.0.0.0:7946", "http://cache-1:8080", "cache-1:7946"},
+		{"10.0.0.2:7946", "http://10.0.0.1:8080", "10.0.0.2:7946"},
+	} {
+		if got := advertiseAddr(tt.gossip, tt.self); got != tt.want {
+			t.Errorf("advertiseAddr(%q, %q) = %q, expected %q", tt.gossip, tt.self, got, tt.want)
+		}
+	}
+}
+
+// Will test that a node joining through gossip becomes part of the routing, and gets the keys it now owns
+func TestRunGossip(t *testing.T) {
+	start := func(cfg Config, listener net.Listener) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() { done <- run(ctx, cfg, listener) }()
+		t.Cleanup(func() {
+			cancel()
+			<-done
+		})
+	}
+	newNode := func() (net.Listener, string, string) {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		udp, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		udp.Close() // Only used to find a free port
+		return listener, "http://" + listener.Addr().String(), udp.LocalAddr().String()
+	}
+	config := func(self, gossipAddr string, join ...string) Config {
+		return Config{CleanupInterval: time.Second, ShutdownTimeout: time.Second, Self: self, GossipAddr: gossipAddr, Join: join}
+	}
+
+	listenerA, urlA, gossipA := newNode()
+	listenerB, urlB, gossipB := newNode()
+	start(config(urlA, gossipA), listenerA)
+
+	// Written while A is alone, so A holds every key
+	ring, _ := cluster.NewCluster(urlA, []string{urlB})
+	var keyOfB string
+	for i := 0; keyOfB == ""; i++ {
+		if key := fmt.Sprintf("key-%d", i); ring.Owner(key) == urlB {
+			keyOfB = key
+		}
+	}
+	req, _ := http.NewRequest("PUT", urlA+"/v2/keys/"+keyOfB, strings.NewReader("value"))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	start(config(urlB, gossipB, gossipA), listenerB)
+
+	// A hands the key to B once it hears about B, and forwards requests for it from then on
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		req, _ := http.NewRequest("GET", urlB+"/v2/keys/"+keyOfB, nil)
+		req.Header.Set(cluster.ForwardedHeader, "test") // Served by B itself
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the key to be handed to B")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	resp, err = http.Get(urlA + "/v2/keys/" + keyOfB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "value" {
+		t.Errorf("Expected A to forward the request to B, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestLoadConfigRaft(t *testing.T) {
+	getenv := func(name string) string {
+		if name == "CACHE_RAFT_PEERS" {
+			return "http://a:8080,http://b:8080, http://c:8080"
+		}
+		return ""
+	}
+	if _, err := loadConfig(nil, getenv); err == nil {
+		t.Error("Expected error for raft peers without -self")
+	}
+	if _, err := loadConfig([]string{"-self", "http://a:8080"}, getenv); err == nil {
+		t.Error("Expected error for raft peers without -raft-dir")
+	}
+	cfg, err := loadConfig([]string{"-self", "http://a:8080", "-raft-dir", "/var/lib/cache/raft"}, getenv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.RaftPeers) != 3 || cfg.RaftPeers[2] != "http://c:8080" || cfg.RaftDir != "/var/lib/cache/raft" || cfg.clusterMode() {
+		t.Errorf("Unexpected raft config: %+v", cfg)
+	}
+}
+
+// Will test that consistent keys are served next to the normal API, by a group of one
+func TestRunRaft(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "http://" + listener.Addr().String()
+	cfg := Config{CleanupInterval: time.Second, ShutdownTimeout: time.Second, Self: base, RaftPeers: []string{base}, RaftDir: t.TempDir()}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- run(ctx, cfg, listener) }()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Writes fail with 503 until the node elected itself
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		req, _ := http.NewRequest("PUT", base+"/v2/consistent/keys/flag", strings.NewReader("on"))
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the write to succeed once the node is leader")
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	resp, err := http.Get(base + "/v2/consistent/keys/flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), `"value":"on"`) {
+		t.Errorf("Unexpected consistent read: %d %s", resp.StatusCode, body)
+	}
+
+	// The normal keyspace is separate
+	resp, err = http.Get(base + "/v2/keys/flag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("Expected consistent keys to stay out of the normal keyspace, got %d", resp.StatusCode)
+	}
+}
+
+func TestLoadConfigCRDT(t *testing.T) {
+	noEnv := func(string) string { return "" }
+	if _, err := loadConfig([]string{"-crdt-peers", "http://b:8080"}, noEnv); err == nil {
+		t.Error("Expected error for CRDT peers without -self")
+	}
+	cfg, err := loadConfig([]string{"-self", "http://a:8080/", "-crdt-peers", "http://b:8080/, http://c:8080"}, noEnv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peers := trimURLs(cfg.CRDTPeers); len(peers) != 2 || peers[0] != "http://b:8080" || trimURL(cfg.Self) != "http://a:8080" {
+		t.Errorf("Unexpected CRDT config: %+v", cfg)
+	}
+}
@@ -0,0 +1,252 @@
+package memcache
+
+import (
+	"fmt"
+	"golang-memory-cache/cache"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The meta commands (mg, ms, md) take single letter flags after the key, some with a token right after the letter
+// (i.e. "T30" or "Oabc"). Replies use two letter codes and echo back the requested return flags.
+
+// Will split meta flags into their letter and token
+type metaFlag struct {
+	letter byte
+	token  string
+}
+
+func parseMetaFlags(fields []string) []metaFlag {
+	flags := make([]metaFlag, 0, len(fields))
+	for _, f := range fields {
+		if f == "" {
+			continue
+		}
+		flags = append(flags, metaFlag{letter: f[0], token: f[1:]})
+	}
+	return flags
+}
+
+func hasFlag(flags []metaFlag, letter byte) bool {
+	_, ok := flagToken(flags, letter)
+	return ok
+}
+
+func flagToken(flags []metaFlag, letter byte) (string, bool) {
+	for _, f := range flags {
+		if f.letter == letter {
+			return f.token, true
+		}
+	}
+	return "", false
+}
+
+// Will write a reply code followed by the return flags, i.e. "HD c12 kfoo"
+func (sess *session) metaReply(code string, ret []string) {
+	if len(ret) == 0 {
+		sess.reply(code)
+		return
+	}
+	sess.reply(code + " " + strings.Join(ret, " "))
+}
+
+// The O (opaque) and k (key) flags are echoed back by every meta command
+func echoFlags(flags []metaFlag, key string) []string {
+	var ret []string
+	for _, f := range flags {
+		switch f.letter {
+		case 'O':
+			ret = append(ret, "O"+f.token)
+		case 'k':
+			ret = append(ret, "k"+key)
+		}
+	}
+	return ret
+}
+
+// mg <key> <flags>*
+// v: return the value, f: client flags, t: remaining TTL (-1 for none), c: CAS unique, s: size,
+// T<ttl>: update the TTL, q: no reply on a miss, O<opaque> and k: echoed back
+func (sess *session) cmdMetaGet(fields []string) {
+	if len(fields) < 2 || !validKey(fields[1]) {
+		sess.clientError("bad command line format")
+		return
+	}
+	key := fields[1]
+	flags := parseMetaFlags(fields[2:])
+
+	if token, ok := flagToken(flags, 'T'); ok {
+		exptime, err := strconv.ParseInt(token, 10, 64)
+		if err != nil {
+			sess.clientError("bad token in command line format")
+			return
+		}
+		if sess.rejectWrite() {
+			return
+		}
+		sess.server.Cache.Expire(key, exptimeDuration(exptime))
+	}
+
+	item, found := sess.server.Cache.GetItem(key)
+	if !found {
+		if !hasFlag(flags, 'q') {
+			sess.reply("EN")
+		}
+		return
+	}
+
+	data := valueBytes(item.Value)
+	var ret []string
+	for _, f := range flags {
+		switch f.letter {
+		case 'f':
+			ret = append(ret, fmt.Sprintf("f%d", itemFlags(item)))
+		case 't':
+			if ttl, expires := item.TTL(); expires {
+				// Round up, so a fresh item with T100 reports t100 and not t99
+				ret = append(ret, fmt.Sprintf("t%d", int64((ttl+time.Second-1)/time.Second)))
+			} else {
+				ret = append(ret, "t-1")
+			}
+		case 'c':
+			ret = append(ret, fmt.Sprintf("c%d", item.Version))
+		case 's':
+			ret = append(ret, fmt.Sprintf("s%d", len(data)))
+		}
+	}
+	ret = append(ret, echoFlags(flags, key)...)
+
+	if !hasFlag(flags, 'v') {
+		sess.metaReply("HD", ret)
+		return
+	}
+	sess.metaReply(fmt.Sprintf("VA %d", len(data)), ret)
+	sess.w.Write(data)
+	sess.w.WriteString("\r\n")
+}
+
+// Mode tokens of the ms M flag
+var metaModes = map[string]storeMode{
+	"S": modeSet, "s": modeSet,
+	"E": modeAdd, "e": modeAdd,
+	"A": modeAppend, "a": modeAppend,
+	"P": modePrepend, "p": modePrepend,
+	"R": modeReplace, "r": modeReplace,
+}
+
+// ms <key> <datalen> <flags>*
+// T<ttl>: exptime, F<flags>: client flags, C<cas>: compare CAS unique on top of the mode,
+// M<mode>: S set, E add, A append, P prepend, R replace,
+// c: return the new CAS unique, q: no reply on success, O<opaque> and k: echoed back
+func (sess *session) cmdMetaSet(fields []string) error {
+	if len(fields) < 3 {
+		sess.clientError("bad command line format")
+		return nil
+	}
+	key := fields[1]
+	size, err := strconv.Atoi(fields[2])
+	if err != nil || size < 0 {
+		sess.clientError("bad data chunk")
+		return errBadDataChunk
+	}
+	data, err := sess.readData(size)
+	if err != nil {
+		sess.dataError(err)
+		return err
+	}
+	if !validKey(key) {
+		sess.clientError("bad command line format")
+		return nil
+	}
+	if sess.rejectWrite() {
+		return nil
+	}
+
+	flags := parseMetaFlags(fields[3:])
+	var (
+		exptime     int64
+		clientFlags uint64
+		casUnique   *uint64
+		mode        = modeSet
+	)
+	for _, f := range flags {
+		var err error
+		switch f.letter {
+		case 'T':
+			exptime, err = strconv.ParseInt(f.token, 10, 64)
+		case 'F':
+			clientFlags, err = strconv.ParseUint(f.token, 10, 32)
+		case 'C':
+			var n uint64
+			n, err = strconv.ParseUint(f.token, 10, 64)
+			casUnique = &n
+		case 'M':
+			m, ok := metaModes[f.token]
+			if !ok {
+				sess.clientError("invalid mode for ms")
+				return nil
+			}
+			mode = m
+		}
+		if err != nil {
+			sess.clientError("bad token in command line format")
+			return nil
+		}
+	}
+
+	result, version := sess.server.store(mode, key, data, uint32(clientFlags), exptimeDuration(exptime), casUnique)
+
+	ret := echoFlags(flags, key)
+	switch result {
+	case resultStored:
+		if hasFlag(flags, 'q') {
+			return nil
+		}
+		if hasFlag(flags, 'c') {
+			ret = append([]string{fmt.Sprintf("c%d", version)}, ret...)
+		}
+		sess.metaReply("HD", ret)
+	case resultNotStored:
+		sess.metaReply("NS", ret)
+	case resultExists:
+		sess.metaReply("EX", ret)
+	case resultNotFound:
+		sess.metaReply("NF", ret)
+	}
+	return nil
+}
+
+// md <key> <flags>*
+// C<cas>: only delete if the CAS unique matches, q: no reply on success, O<opaque> and k: echoed back
+func (sess *session) cmdMetaDelete(fields []string) {
+	if len(fields) < 2 || !validKey(fields[1]) {
+		sess.clientError("bad command line format")
+		return
+	}
+	key := fields[1]
+	flags := parseMetaFlags(fields[2:])
+
+	var cond cache.Condition
+	if token, ok := flagToken(flags, 'C'); ok {
+		casUnique, err := strconv.ParseUint(token, 10, 64)
+		if err != nil {
+			sess.clientError("bad token in command line format")
+			return
+		}
+		cond = cache.IfVersion(casUnique)
+	}
+
+	ret := echoFlags(flags, key)
+	if sess.server.Cache.DeleteIf(key, cond) {
+		if !hasFlag(flags, 'q') {
+			sess.metaReply("HD", ret)
+		}
+		return
+	}
+	if _, found := sess.server.Cache.Peek(key); found {
+		sess.metaReply("EX", ret)
+		return
+	}
+	sess.metaReply("NF", ret)
+}
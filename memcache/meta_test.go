@@ -0,0 +1,72 @@
+package memcache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMetaGet(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set key 7 100 5\r\nhello\r\n", "STORED")
+	item, _ := c.Peek("key")
+
+	client.expect("mg key v f c s Oabc k\r\n", fmt.Sprintf("VA 5 f7 c%d s5 Oabc kkey", item.Version), "hello")
+	client.expect("mg key t\r\n", "HD t100")
+	client.expect("mg missing v\r\n", "EN")
+
+	// q suppresses the miss, mn marks the end of the batch
+	client.expect("mg missing v q\r\nmn\r\n", "MN")
+
+	// T updates the TTL
+	client.expect("mg key T0 t\r\n", "HD t-1")
+}
+
+func TestMetaSet(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("ms key 5 T100 F3\r\nhello\r\n", "HD")
+	client.expect("get key\r\n", "VALUE key 3 5", "hello", "END")
+
+	// Modes: E add, R replace, A append, P prepend
+	client.expect("ms key 1 ME\r\nx\r\n", "NS")
+	client.expect("ms missing 1 MR\r\nx\r\n", "NS")
+	client.expect("ms key 1 MA\r\n!\r\n", "HD")
+	client.expect("ms key 1 MP\r\n>\r\n", "HD")
+	client.expect("mg key v\r\n", "VA 7", ">hello!")
+	client.expect("ms key 1 MX\r\nx\r\n", "CLIENT_ERROR invalid mode for ms")
+
+	// Compare and swap with C, c returns the new CAS unique
+	item, _ := c.Peek("key")
+	client.send(fmt.Sprintf("ms key 2 C%d c\r\nv2\r\n", item.Version))
+	got := client.line()
+	updated, _ := c.Peek("key")
+	if want := fmt.Sprintf("HD c%d", updated.Version); got != want {
+		t.Errorf("got %q, expected %q", got, want)
+	}
+	client.expect(fmt.Sprintf("ms key 2 C%d\r\nv3\r\n", item.Version), "EX")
+	client.expect("ms missing 2 C1\r\nv3\r\n", "NF")
+
+	// C is checked on top of the mode
+	item, _ = c.Peek("key")
+	client.expect(fmt.Sprintf("ms key 1 C%d MA\r\n!\r\n", item.Version+1), "EX")
+	client.expect(fmt.Sprintf("ms key 1 C%d MA\r\n!\r\n", item.Version), "HD")
+	client.expect("mg key v\r\n", "VA 3", "v2!")
+	item, _ = c.Peek("key")
+	client.expect(fmt.Sprintf("ms key 1 C%d ME\r\nx\r\n", item.Version), "NS")
+	client.expect("ms missing 1 C1 MA\r\nx\r\n", "NF")
+
+	// q suppresses success
+	client.expect("ms quiet 1 q\r\nx\r\nmn\r\n", "MN")
+}
+
+func TestMetaDelete(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set key 0 0 1\r\nx\r\n", "STORED")
+	item, _ := c.Peek("key")
+
+	client.expect(fmt.Sprintf("md key C%d Oxyz\r\n", item.Version+1), "EX Oxyz")
+	client.expect(fmt.Sprintf("md key C%d\r\n", item.Version), "HD")
+	client.expect("md key\r\n", "NF")
+}
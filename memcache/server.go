@@ -0,0 +1,331 @@
+package memcache
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang-memory-cache/cache"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Serves a cache.Cache over the memcached text protocol, including the meta commands (mg, ms, md, mn),
+// so services that only have a memcached client can use the cache.
+//
+// Mapping onto the cache:
+//   - the 32 bit client flags are stored in the item's metadata under FlagsMetadataKey
+//   - exptime becomes the item's expiration
+//   - the CAS unique is the item's version
+
+// Returned by Serve after Close was called
+var ErrServerClosed = errors.New("memcache: server closed")
+
+// Metadata key that holds the client flags of an item, as a decimal string. Missing means 0.
+const FlagsMetadataKey = "memcache-flags"
+
+// Same limits as memcached
+const (
+	maxKeyLength  = 250
+	maxValueSize  = 1 << 20
+	maxLineLength = 2048
+)
+
+// Version reported by the "version" command and in stats
+const serverVersion = "1.6.0-golang-memory-cache"
+
+type Server struct {
+	Cache *cache.Cache
+	// When set, commands that write answer SERVER_ERROR instead, i.e. on a replication follower
+	ReadOnly bool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup
+
+	started          time.Time
+	totalConnections uint64
+}
+
+func NewServer(c *cache.Cache) *Server {
+	return &Server{
+		Cache:     c,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+		started:   time.Now(),
+	}
+}
+
+// Will listen on addr and serve connections until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Will accept connections on l and handle each one in its own goroutine.
+// Always returns a non-nil error, ErrServerClosed after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+		atomic.AddUint64(&s.totalConnections, 1)
+
+		go s.serveConn(conn)
+	}
+}
+
+// Will stop every listener, close every open connection and wait for their goroutines to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// State kept for a single client connection
+type session struct {
+	server *Server
+	r      *bufio.Reader
+	w      *bufio.Writer
+	quit   bool
+}
+
+// Will read commands and write replies until the client disconnects.
+// Like the RESP server, replies are only flushed once no more pipelined commands are buffered.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	sess := &session{
+		server: s,
+		r:      bufio.NewReaderSize(conn, 64<<10),
+		w:      bufio.NewWriter(conn),
+	}
+
+	for !sess.quit {
+		line, err := sess.readLine()
+		if err != nil {
+			if errors.Is(err, errLineTooLong) {
+				sess.clientError("line too long")
+				sess.w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("memcache: %v", err)
+			}
+			return
+		}
+
+		if err := sess.execute(line); err != nil {
+			// Only fatal errors get here (the connection can't be trusted anymore, i.e. a broken data block)
+			sess.w.Flush()
+			return
+		}
+
+		if sess.r.Buffered() == 0 || sess.quit {
+			if err := sess.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+var errLineTooLong = errors.New("memcache: line too long")
+
+// Will read one command line without the trailing \r\n (a bare \n is accepted too, like memcached)
+func (sess *session) readLine() (string, error) {
+	line, err := sess.r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull || len(line) > maxLineLength {
+		return "", errLineTooLong
+	}
+	if err != nil {
+		return "", err
+	}
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
+
+var (
+	errBadDataChunk  = errors.New("memcache: bad data chunk")
+	errValueTooLarge = errors.New("memcache: object too large for cache")
+)
+
+// Will read a data block of n bytes followed by \r\n. Blocks larger than maxValueSize are refused before
+// anything is allocated, leaving the block unread, so the connection can't continue after that.
+func (sess *session) readData(n int) ([]byte, error) {
+	if n > maxValueSize {
+		return nil, errValueTooLarge
+	}
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(sess.r, buf); err != nil {
+		return nil, err
+	}
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, errBadDataChunk
+	}
+	return buf[:n], nil
+}
+
+func (sess *session) reply(s string) {
+	sess.w.WriteString(s)
+	sess.w.WriteString("\r\n")
+}
+
+func (sess *session) clientError(msg string) {
+	sess.reply("CLIENT_ERROR " + msg)
+}
+
+// Will answer SERVER_ERROR to a command that writes when the server is read-only, and report whether it did
+func (sess *session) rejectWrite() bool {
+	if !sess.server.ReadOnly {
+		return false
+	}
+	sess.reply("SERVER_ERROR read only replica")
+	return true
+}
+
+// Will answer a storage command whose data block could not be read
+func (sess *session) dataError(err error) {
+	if errors.Is(err, errValueTooLarge) {
+		sess.reply("SERVER_ERROR object too large for cache")
+		return
+	}
+	sess.clientError("bad data chunk")
+}
+
+// Will report whether a key is valid: 1 to 250 bytes, no spaces or control characters
+func validKey(key string) bool {
+	if len(key) == 0 || len(key) > maxKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] <= ' ' || key[i] == 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+// Any exptime above 30 days is a unix timestamp instead of a number of seconds, like memcached
+const relativeExptimeLimit = 60 * 60 * 24 * 30
+
+// Will turn a memcached exptime into a duration for the cache
+func exptimeDuration(exptime int64) time.Duration {
+	switch {
+	case exptime == 0:
+		return cache.NoExpiration
+	case exptime < 0:
+		return -time.Second // Negative means the item is expired immediately
+	case exptime > relativeExptimeLimit:
+		d := time.Until(time.Unix(exptime, 0))
+		if d <= 0 {
+			return -time.Second
+		}
+		return d
+	default:
+		return time.Duration(exptime) * time.Second
+	}
+}
+
+// Will read the client flags stored with an item
+func itemFlags(item cache.CacheItem) uint32 {
+	n, _ := strconv.ParseUint(item.Metadata[FlagsMetadataKey], 10, 32)
+	return uint32(n)
+}
+
+// Will build the metadata holding the client flags, nil when they are 0 to keep items small
+func flagsMetadata(flags uint32) map[string]string {
+	if flags == 0 {
+		return nil
+	}
+	return map[string]string{FlagsMetadataKey: strconv.FormatUint(uint64(flags), 10)}
+}
+
+// Will turn a cached value into the bytes sent to the client.
+// Values set through other APIs are converted: strings as-is, numbers as decimals.
+func valueBytes(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	case int:
+		return []byte(strconv.Itoa(v))
+	case int64:
+		return []byte(strconv.FormatInt(v, 10))
+	case uint64:
+		return []byte(strconv.FormatUint(v, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(v, 'f', -1, 64))
+	case bool:
+		return []byte(strconv.FormatBool(v))
+	default:
+		// Anything else (i.e. values set through the JSON API) is sent as JSON
+		data, err := json.Marshal(v)
+		if err != nil {
+			return []byte(fmt.Sprint(v))
+		}
+		return data
+	}
+}
+
+// Process id reported in stats
+var pid = os.Getpid()
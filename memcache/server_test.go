@@ -0,0 +1,190 @@
+package memcache
+
+import (
+	"bufio"
+	"golang-memory-cache/cache"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A raw text client for the tests
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Will start a server on a loopback port and connect a client to it
+func newTestServer(t *testing.T) (*cache.Cache, *testClient) {
+	t.Helper()
+	c := cache.NewCache()
+	return c, serveTest(t, NewServer(c))
+}
+
+// Will serve srv on a loopback port and connect a client to it. The server and its cache are stopped with the test.
+func serveTest(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Close()
+		srv.Cache.Stop()
+	})
+	return &testClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Will send raw protocol text
+func (tc *testClient) send(s string) {
+	tc.t.Helper()
+	if _, err := tc.conn.Write([]byte(s)); err != nil {
+		tc.t.Fatal(err)
+	}
+}
+
+// Will read one reply line without \r\n
+func (tc *testClient) line() string {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	line, err := tc.r.ReadString('\n')
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return strings.TrimSuffix(line, "\r\n")
+}
+
+// Will send a command and check the reply lines
+func (tc *testClient) expect(command string, lines ...string) {
+	tc.t.Helper()
+	tc.send(command)
+	for _, want := range lines {
+		if got := tc.line(); got != want {
+			tc.t.Errorf("%q: got %q, expected %q", strings.TrimSpace(command), got, want)
+		}
+	}
+}
+
+// Will test that a read-only server, like on a replication follower, answers SERVER_ERROR to writes but still serves reads
+func TestReadOnly(t *testing.T) {
+	c := cache.NewCache()
+	c.Set("key", "hello", cache.NoExpiration)
+	srv := NewServer(c)
+	srv.ReadOnly = true
+	client := serveTest(t, srv)
+
+	client.expect("get key\r\n", "VALUE key 0 5", "hello", "END")
+	for _, command := range []string{
+		"set key 0 0 5\r\nother\r\n",
+		"append key 0 0 1\r\n!\r\n",
+		"delete key\r\n",
+		"incr key 1\r\n",
+		"touch key 10\r\n",
+		"flush_all\r\n",
+		"ms key 5\r\nother\r\n",
+		"md key\r\n",
+		"mg key v T10\r\n",
+	} {
+		client.expect(command, "SERVER_ERROR read only replica")
+	}
+	client.expect("mg key v\r\n", "VA 5", "hello")
+	if item, _ := c.GetItem("key"); item.Value != "hello" || item.Expiration != 0 {
+		t.Errorf("expected the cache to be unchanged, got %+v", item)
+	}
+}
+
+func TestStats(t *testing.T) {
+	_, client := newTestServer(t)
+
+	client.expect("set key 0 0 5\r\nhello\r\n", "STORED")
+	client.expect("get key missing\r\n", "VALUE key 0 5", "hello", "END")
+
+	client.send("stats\r\n")
+	stats := map[string]string{}
+	for {
+		line := client.line()
+		if line == "END" {
+			break
+		}
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 || parts[0] != "STAT" {
+			t.Fatalf("unexpected stats line %q", line)
+		}
+		stats[parts[1]] = parts[2]
+	}
+
+	expected := map[string]string{
+		"get_hits":         "1",
+		"get_misses":       "1",
+		"cmd_get":          "2",
+		"cmd_set":          "1",
+		"curr_items":       "1",
+		"curr_connections": "1",
+		"version":          serverVersion,
+	}
+	for name, want := range expected {
+		if stats[name] != want {
+			t.Errorf("STAT %s: got %q, expected %q", name, stats[name], want)
+		}
+	}
+}
+
+func TestVersionAndErrors(t *testing.T) {
+	_, client := newTestServer(t)
+
+	client.expect("version\r\n", "VERSION "+serverVersion)
+	client.expect("nosuchcommand\r\n", "ERROR")
+	client.expect("get\r\n", "ERROR")
+	client.expect("set key 0 0\r\n", "ERROR")
+	client.expect("set bad\x01key 0 0 1\r\nx\r\n", "CLIENT_ERROR bad command line format")
+
+	// A data block that does not end where announced closes the connection
+	client.expect("set key 0 0 1\r\ntoolong\r\n", "CLIENT_ERROR bad data chunk")
+	client.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.r.ReadString('\n'); err == nil {
+		t.Error("expected connection to be closed after a bad data chunk")
+	}
+}
+
+// Will test that a huge announced size is refused before anything is allocated, and closes the connection
+func TestValueTooLarge(t *testing.T) {
+	for _, command := range []string{
+		"set key 0 0 9223372036854775807\r\n",
+		"set key 0 0 1048577\r\n",
+		"ms key 9223372036854775807\r\n",
+	} {
+		_, client := newTestServer(t)
+		client.expect(command, "SERVER_ERROR object too large for cache")
+		client.conn.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := client.r.ReadString('\n'); err == nil {
+			t.Errorf("%q: expected the connection to be closed", command)
+		}
+	}
+}
+
+func TestServerClose(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	srv := NewServer(c)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	srv.Close()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+}
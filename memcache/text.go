@@ -0,0 +1,403 @@
+package memcache
+
+import (
+	"fmt"
+	"golang-memory-cache/cache"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The classic memcached text protocol commands
+
+// Outcome of a storage command, shared by the text commands and ms
+type storeResult int
+
+const (
+	resultStored    storeResult = iota
+	resultNotStored             // add/replace/append/prepend condition failed
+	resultExists                // cas unique did not match
+	resultNotFound              // cas on a missing key
+)
+
+// The different storage commands. The meta command ms uses the same modes through its M flag.
+type storeMode int
+
+const (
+	modeSet storeMode = iota
+	modeAdd
+	modeReplace
+	modeAppend
+	modePrepend
+)
+
+var storageModes = map[string]storeMode{
+	"set":     modeSet,
+	"add":     modeAdd,
+	"replace": modeReplace,
+	"append":  modeAppend,
+	"prepend": modePrepend,
+	"cas":     modeSet, // Set with a version check
+}
+
+// Will dispatch one command line. Returns an error only when the connection must be closed.
+func (sess *session) execute(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		sess.reply("ERROR")
+		return nil
+	}
+
+	// Storage commands are rejected once their data block was read, and mg only when it changes the TTL
+	switch fields[0] {
+	case "delete", "incr", "decr", "touch", "flush_all", "md":
+		if sess.rejectWrite() {
+			return nil
+		}
+	}
+
+	switch name := fields[0]; name {
+	case "get", "gets":
+		sess.cmdGet(fields, name == "gets")
+	case "set", "add", "replace", "append", "prepend", "cas":
+		return sess.cmdStore(fields)
+	case "delete":
+		sess.cmdDelete(fields)
+	case "incr", "decr":
+		sess.cmdIncr(fields)
+	case "touch":
+		sess.cmdTouch(fields)
+	case "flush_all":
+		sess.cmdFlushAll(fields)
+	case "stats":
+		sess.cmdStats()
+	case "version":
+		sess.reply("VERSION " + serverVersion)
+	case "verbosity":
+		sess.noreplyOr(fields, "OK")
+	case "quit":
+		sess.quit = true
+	case "mg":
+		sess.cmdMetaGet(fields)
+	case "ms":
+		return sess.cmdMetaSet(fields)
+	case "md":
+		sess.cmdMetaDelete(fields)
+	case "mn":
+		sess.reply("MN")
+	default:
+		sess.reply("ERROR")
+	}
+	return nil
+}
+
+// Will write msg unless the last field is "noreply"
+func (sess *session) noreplyOr(fields []string, msg string) {
+	if fields[len(fields)-1] == "noreply" {
+		return
+	}
+	sess.reply(msg)
+}
+
+// get <key>* / gets <key>*
+func (sess *session) cmdGet(fields []string, withCAS bool) {
+	if len(fields) < 2 {
+		sess.reply("ERROR")
+		return
+	}
+	for _, key := range fields[1:] {
+		if !validKey(key) {
+			sess.clientError("bad command line format")
+			return
+		}
+	}
+
+	for _, key := range fields[1:] {
+		item, found := sess.server.Cache.GetItem(key)
+		if !found {
+			continue
+		}
+		data := valueBytes(item.Value)
+		if withCAS {
+			fmt.Fprintf(sess.w, "VALUE %s %d %d %d\r\n", key, itemFlags(item), len(data), item.Version)
+		} else {
+			fmt.Fprintf(sess.w, "VALUE %s %d %d\r\n", key, itemFlags(item), len(data))
+		}
+		sess.w.Write(data)
+		sess.w.WriteString("\r\n")
+	}
+	sess.reply("END")
+}
+
+// <command> <key> <flags> <exptime> <bytes> [noreply]
+// cas <key> <flags> <exptime> <bytes> <cas unique> [noreply]
+func (sess *session) cmdStore(fields []string) error {
+	name := fields[0]
+	isCAS := name == "cas"
+
+	want := 5
+	if isCAS {
+		want = 6
+	}
+	if len(fields) != want && !(len(fields) == want+1 && fields[want] == "noreply") {
+		sess.reply("ERROR")
+		return nil
+	}
+	noreply := len(fields) == want+1
+
+	key := fields[1]
+	flags, errFlags := strconv.ParseUint(fields[2], 10, 32)
+	exptime, errExp := strconv.ParseInt(fields[3], 10, 64)
+	size, errSize := strconv.Atoi(fields[4])
+	var casUnique uint64
+	var errCAS error
+	if isCAS {
+		casUnique, errCAS = strconv.ParseUint(fields[5], 10, 64)
+	}
+	if errSize != nil || size < 0 {
+		// Without a valid size we can't know where the data block ends, so the connection can't continue
+		sess.clientError("bad data chunk")
+		return errBadDataChunk
+	}
+
+	data, err := sess.readData(size)
+	if err != nil {
+		sess.dataError(err)
+		return err
+	}
+
+	if !validKey(key) || errFlags != nil || errExp != nil || errCAS != nil {
+		sess.clientError("bad command line format")
+		return nil
+	}
+	if sess.rejectWrite() {
+		return nil
+	}
+
+	var result storeResult
+	if isCAS {
+		result, _ = sess.server.store(modeSet, key, data, uint32(flags), exptimeDuration(exptime), &casUnique)
+	} else {
+		result, _ = sess.server.store(storageModes[name], key, data, uint32(flags), exptimeDuration(exptime), nil)
+	}
+
+	if !noreply {
+		sess.reply(map[storeResult]string{
+			resultStored:    "STORED",
+			resultNotStored: "NOT_STORED",
+			resultExists:    "EXISTS",
+			resultNotFound:  "NOT_FOUND",
+		}[result])
+	}
+	return nil
+}
+
+// Will apply a storage command to the cache. casUnique, when not nil, must match the item's version on top of the
+// condition of the mode. Also returns the new CAS unique when the item was stored.
+func (s *Server) store(mode storeMode, key string, data []byte, flags uint32, ttl time.Duration, casUnique *uint64) (storeResult, uint64) {
+	c := s.Cache
+	metadata := flagsMetadata(flags)
+
+	var casCond cache.Condition
+	if casUnique != nil {
+		casCond = cache.IfVersion(*casUnique)
+	}
+	// Will add the CAS check to the condition of the mode
+	withCAS := func(cond cache.Condition) cache.Condition {
+		switch {
+		case casCond == nil:
+			return cond
+		case cond == nil:
+			return casCond
+		}
+		return func(item cache.CacheItem, found bool) bool {
+			return casCond(item, found) && cond(item, found)
+		}
+	}
+
+	var (
+		item cache.CacheItem
+		ok   bool
+	)
+	switch mode {
+	case modeAdd:
+		item, ok = c.SetIf(key, data, ttl, metadata, withCAS(cache.IfMissing))
+	case modeReplace:
+		item, ok = c.SetIf(key, data, ttl, metadata, withCAS(cache.IfExists))
+	case modeAppend, modePrepend:
+		// Appending keeps the flags and expiration of the existing item, like memcached
+		item, ok = c.Update(key, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+			if !withCAS(cache.IfExists)(item, found) {
+				return item, false
+			}
+			current := valueBytes(item.Value)
+			joined := make([]byte, 0, len(current)+len(data))
+			if mode == modeAppend {
+				joined = append(append(joined, current...), data...)
+			} else {
+				joined = append(append(joined, data...), current...)
+			}
+			item.Value = joined
+			return item, true
+		})
+	default:
+		item, ok = c.SetIf(key, data, ttl, metadata, withCAS(nil))
+	}
+
+	switch {
+	case ok:
+		return resultStored, item.Version
+	case casUnique != nil && item.Version == 0: // Zero item, the key does not exist
+		return resultNotFound, 0
+	case casUnique != nil && item.Version != *casUnique:
+		return resultExists, 0
+	default:
+		return resultNotStored, 0
+	}
+}
+
+// delete <key> [noreply]
+func (sess *session) cmdDelete(fields []string) {
+	// Old clients send "delete <key> 0", which is still accepted
+	if len(fields) < 2 || len(fields) > 4 {
+		sess.reply("ERROR")
+		return
+	}
+	if sess.server.Cache.DeleteIf(fields[1], nil) {
+		sess.noreplyOr(fields, "DELETED")
+	} else {
+		sess.noreplyOr(fields, "NOT_FOUND")
+	}
+}
+
+var errNonNumeric = fmt.Errorf("cannot increment or decrement non-numeric value")
+
+// incr|decr <key> <value> [noreply]
+// The value is an unsigned 64 bit integer. incr wraps around at 2^64 and decr stops at 0, like memcached.
+func (sess *session) cmdIncr(fields []string) {
+	if len(fields) != 3 && !(len(fields) == 4 && fields[3] == "noreply") {
+		sess.reply("ERROR")
+		return
+	}
+	delta, err := strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		sess.clientError("invalid numeric delta argument")
+		return
+	}
+
+	result, found, err := sess.server.incr(fields[1], delta, fields[0] == "incr")
+	switch {
+	case err != nil:
+		sess.clientError(err.Error())
+	case !found:
+		sess.noreplyOr(fields, "NOT_FOUND")
+	default:
+		sess.noreplyOr(fields, strconv.FormatUint(result, 10))
+	}
+}
+
+// Will increment or decrement the number stored at key. Returns found=false for a missing key.
+func (s *Server) incr(key string, delta uint64, increment bool) (uint64, bool, error) {
+	var (
+		result uint64
+		found  bool
+		err    error
+	)
+	s.Cache.Update(key, func(item cache.CacheItem, exists bool) (cache.CacheItem, bool) {
+		found = exists
+		if !exists {
+			return item, false
+		}
+		current, parseErr := strconv.ParseUint(strings.TrimSpace(string(valueBytes(item.Value))), 10, 64)
+		if parseErr != nil {
+			err = errNonNumeric
+			return item, false
+		}
+		switch {
+		case increment:
+			result = current + delta
+		case delta > current:
+			result = 0
+		default:
+			result = current - delta
+		}
+		item.Value = []byte(strconv.FormatUint(result, 10))
+		return item, true
+	})
+	return result, found, err
+}
+
+// touch <key> <exptime> [noreply]
+func (sess *session) cmdTouch(fields []string) {
+	if len(fields) != 3 && !(len(fields) == 4 && fields[3] == "noreply") {
+		sess.reply("ERROR")
+		return
+	}
+	exptime, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		sess.clientError("invalid exptime argument")
+		return
+	}
+	if sess.server.Cache.Expire(fields[1], exptimeDuration(exptime)) {
+		sess.noreplyOr(fields, "TOUCHED")
+	} else {
+		sess.noreplyOr(fields, "NOT_FOUND")
+	}
+}
+
+// flush_all [delay] [noreply]
+func (sess *session) cmdFlushAll(fields []string) {
+	delay := int64(0)
+	if len(fields) > 1 && fields[1] != "noreply" {
+		n, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil || n < 0 {
+			sess.clientError("invalid exptime argument")
+			return
+		}
+		delay = n
+	}
+
+	if delay > 0 {
+		time.AfterFunc(time.Duration(delay)*time.Second, func() { sess.server.Cache.Flush() })
+	} else {
+		sess.server.Cache.Flush()
+	}
+	sess.noreplyOr(fields, "OK")
+}
+
+// stats, built from the cache's GetStats with memcached's names
+func (sess *session) cmdStats() {
+	s := sess.server
+	stats := s.Cache.GetStats()
+
+	s.mu.Lock()
+	currConnections := len(s.conns)
+	s.mu.Unlock()
+
+	now := time.Now()
+	lines := []struct {
+		name  string
+		value interface{}
+	}{
+		{"pid", pid},
+		{"uptime", int64(now.Sub(s.started).Seconds())},
+		{"time", now.Unix()},
+		{"version", serverVersion},
+		{"curr_connections", currConnections},
+		{"total_connections", atomic.LoadUint64(&s.totalConnections)},
+		{"cmd_get", stats["hits"] + stats["misses"]},
+		{"cmd_set", stats["sets"]},
+		{"get_hits", stats["hits"]},
+		{"get_misses", stats["misses"]},
+		{"delete_hits", stats["deletes"]},
+		{"curr_items", s.Cache.Len()},
+		{"total_items", stats["sets"]},
+		{"expired_unfetched", stats["expirations"]},
+		{"evictions", 0},
+	}
+	for _, line := range lines {
+		fmt.Fprintf(sess.w, "STAT %s %v\r\n", line.name, line.value)
+	}
+	sess.reply("END")
+}
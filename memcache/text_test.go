@@ -0,0 +1,130 @@
+package memcache
+
+import (
+	"fmt"
+	"golang-memory-cache/cache"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStorageCommands(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set key 42 0 5\r\nhello\r\n", "STORED")
+	client.expect("get key\r\n", "VALUE key 42 5", "hello", "END")
+
+	client.expect("add key 0 0 1\r\nx\r\n", "NOT_STORED")
+	client.expect("add other 0 0 1\r\nx\r\n", "STORED")
+	client.expect("replace missing 0 0 1\r\nx\r\n", "NOT_STORED")
+	client.expect("replace other 0 0 1\r\ny\r\n", "STORED")
+
+	// append and prepend keep the flags of the existing item
+	client.expect("append key 0 0 6\r\n world\r\n", "STORED")
+	client.expect("prepend key 0 0 1\r\n>\r\n", "STORED")
+	client.expect("get key\r\n", "VALUE key 42 12", ">hello world", "END")
+	client.expect("append missing 0 0 1\r\nx\r\n", "NOT_STORED")
+
+	// Values and flags are stored in the cache item
+	item, _ := c.Peek("key")
+	if string(item.Value.([]byte)) != ">hello world" || item.Metadata[FlagsMetadataKey] != "42" {
+		t.Errorf("unexpected cache item: %+v", item)
+	}
+
+	// noreply suppresses the reply, so the next reply belongs to the get
+	client.expect("set quiet 0 0 1 noreply\r\nq\r\nget quiet\r\n", "VALUE quiet 0 1", "q", "END")
+
+	// Data blocks are binary safe
+	client.expect("set binary 0 0 4\r\na\r\nb\r\n", "STORED")
+	client.expect("get binary\r\n", "VALUE binary 0 4", "a", "b", "END")
+}
+
+func TestCAS(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set key 0 0 2\r\nv1\r\n", "STORED")
+	item, _ := c.Peek("key")
+	client.expect("gets key\r\n", fmt.Sprintf("VALUE key 0 2 %d", item.Version), "v1", "END")
+
+	client.expect(fmt.Sprintf("cas key 0 0 2 %d\r\nv2\r\n", item.Version), "STORED")
+	// The version changed, so the same CAS unique fails now
+	client.expect(fmt.Sprintf("cas key 0 0 2 %d\r\nv3\r\n", item.Version), "EXISTS")
+	client.expect("cas missing 0 0 2 1\r\nv3\r\n", "NOT_FOUND")
+	client.expect("get key\r\n", "VALUE key 0 2", "v2", "END")
+}
+
+func TestDeleteIncrTouch(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set counter 0 0 2\r\n10\r\n", "STORED")
+	client.expect("incr counter 5\r\n", "15")
+	client.expect("decr counter 100\r\n", "0") // decr stops at 0
+	client.expect("incr missing 1\r\n", "NOT_FOUND")
+	client.expect("set text 0 0 2\r\nhi\r\n", "STORED")
+	client.expect("incr text 1\r\n", "CLIENT_ERROR cannot increment or decrement non-numeric value")
+	client.expect("set max 0 0 20\r\n18446744073709551615\r\n", "STORED")
+	client.expect("incr max 2\r\n", "1") // incr wraps around at 2^64
+
+	client.expect("touch counter 100\r\n", "TOUCHED")
+	item, _ := c.Peek("counter")
+	if ttl, expires := item.TTL(); !expires || ttl > 100*time.Second || ttl < 99*time.Second {
+		t.Errorf("expected TTL of 100s after touch, got %v", ttl)
+	}
+	client.expect("touch missing 100\r\n", "NOT_FOUND")
+
+	client.expect("delete counter\r\n", "DELETED")
+	client.expect("delete counter\r\n", "NOT_FOUND")
+}
+
+func TestExptime(t *testing.T) {
+	c, client := newTestServer(t)
+
+	client.expect("set relative 0 100 1\r\nx\r\n", "STORED")
+	absolute := time.Now().Add(time.Hour).Unix()
+	client.expect(fmt.Sprintf("set absolute 0 %d 1\r\nx\r\n", absolute), "STORED")
+	client.expect("set forever 0 0 1\r\nx\r\n", "STORED")
+	client.expect("set expired 0 -1 1\r\nx\r\n", "STORED")
+
+	if item, _ := c.Peek("relative"); item.Expiration == 0 {
+		t.Error("expected relative exptime to set an expiration")
+	}
+	if item, _ := c.Peek("absolute"); item.Expiration/int64(time.Second) > absolute || item.Expiration/int64(time.Second) < absolute-1 {
+		t.Errorf("expected absolute exptime %d, got %d", absolute, item.Expiration/int64(time.Second))
+	}
+	if item, _ := c.Peek("forever"); item.Expiration != 0 {
+		t.Error("expected exptime 0 to never expire")
+	}
+	client.expect("get expired\r\n", "END")
+}
+
+func TestFlushAll(t *testing.T) {
+	c, client := newTestServer(t)
+	c.Set("a", "1", cache.NoExpiration)
+
+	client.expect("flush_all\r\n", "OK")
+	if c.Len() != 0 {
+		t.Errorf("expected empty cache, got %d keys", c.Len())
+	}
+
+	c.Set("b", "2", cache.NoExpiration)
+	client.expect("flush_all 1 noreply\r\nversion\r\n", "VERSION "+serverVersion)
+	if c.Len() != 1 {
+		t.Error("expected delayed flush not to happen right away")
+	}
+}
+
+// Will send several commands in one write and check every reply comes back in order
+func TestPipelining(t *testing.T) {
+	_, client := newTestServer(t)
+
+	var b strings.Builder
+	for i := 0; i < 50; i++ {
+		fmt.Fprintf(&b, "set key%d 0 0 1\r\nx\r\n", i)
+	}
+	client.send(b.String())
+	for i := 0; i < 50; i++ {
+		if got := client.line(); got != "STORED" {
+			t.Fatalf("reply %d: got %q", i, got)
+		}
+	}
+}
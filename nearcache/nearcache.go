@@ -0,0 +1,255 @@
+package nearcache
+
+import (
+	"context"
+	"golang-memory-cache/cache"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A NearCache keeps a small local cache.Cache in front of a remote cache server, so hot keys are read
+// from process memory instead of over the network.
+//
+// Local copies are kept for a short LocalTTL and dropped as soon as the server reports a change to the key
+// through its change stream. While the change stream is down nothing is cached locally, so stale values
+// can only be served for the short time between a remote change and its event arriving. A server drops
+// the change stream of a client that falls behind instead of skipping events, and every local copy is
+// dropped when the stream ends, so a missed event can't leave a stale copy behind either.
+
+// The remote side of a NearCache. *client.Client implements it.
+type Backend interface {
+	Get(ctx context.Context, key string) (interface{}, bool, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+	Delete(ctx context.Context, key string) error
+	// Will stream changes to keys matching pattern, the channel is closed when the stream ends
+	Watch(ctx context.Context, pattern string) (<-chan cache.Event, error)
+}
+
+// Settings used when creating a NearCache
+type Options struct {
+	LocalTTL        time.Duration // How long a value is served locally at most, even without a change event
+	CleanupInterval time.Duration // How often expired local copies are removed
+	Pattern         string        // Only keys matching this glob are cached locally (and watched), every key when empty
+
+	// Wait before reconnecting to the change stream, doubled after every failed attempt up to MaxReconnectBackoff
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+}
+
+// Options used by NewNearCache
+var DefaultOptions = Options{
+	LocalTTL:            5 * time.Second,
+	CleanupInterval:     time.Minute,
+	ReconnectBackoff:    100 * time.Millisecond,
+	MaxReconnectBackoff: 10 * time.Second,
+}
+
+type NearCache struct {
+	backend Backend
+	local   *cache.Cache
+	options Options
+	stats   Stats
+
+	// Local copies are only used while the change stream is connected, otherwise remote changes would go unnoticed
+	connected atomic.Bool
+	// Bumped by every invalidation. A value fetched from the backend is only stored locally if no invalidation
+	// happened while it was being fetched, so a change that raced with the fetch can't leave a stale copy behind.
+	// mu is held while checking it and storing the value, and while invalidating, so the two can't interleave.
+	mu         sync.Mutex
+	generation uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan struct{} // Closed once the change stream connected for the first time
+	once   sync.Once
+}
+
+// Counters of a NearCache, read with GetStats
+type Stats struct {
+	LocalHits     uint64 // Served from the local cache
+	RemoteHits    uint64 // Not found locally, but found on the server
+	Misses        uint64 // Found nowhere
+	Invalidations uint64 // Local copies dropped because of a change event
+	Reconnects    uint64 // Times the change stream was lost and connected again
+}
+
+// Creates a NearCache with DefaultOptions, and starts following the backend's change stream
+func NewNearCache(backend Backend) *NearCache {
+	return NewNearCacheWithOptions(backend, DefaultOptions)
+}
+
+// Creates a NearCache with custom options. Call Close to stop following the change stream.
+func NewNearCacheWithOptions(backend Backend, opts Options) *NearCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &NearCache{
+		backend: backend,
+		local:   cache.NewCacheWithOptions(cache.Options{CleanupInterval: opts.CleanupInterval}),
+		options: opts,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+	go n.follow(ctx)
+	return n
+}
+
+// Will block until the change stream is connected, so the next reads can be served locally, or until ctx is done
+func (n *NearCache) WaitReady(ctx context.Context) error {
+	select {
+	case <-n.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Will return the value of key, from the local cache when possible
+func (n *NearCache) Get(ctx context.Context, key string) (interface{}, bool, error) {
+	cacheable := n.cacheable(key)
+	if cacheable {
+		if value, found := n.local.Get(key); found {
+			atomic.AddUint64(&n.stats.LocalHits, 1)
+			return value, true, nil
+		}
+	}
+
+	n.mu.Lock()
+	generation := n.generation
+	n.mu.Unlock()
+	value, found, err := n.backend.Get(ctx, key)
+	if err != nil {
+		return nil, false, err
+	}
+	if !found {
+		atomic.AddUint64(&n.stats.Misses, 1)
+		return nil, false, nil
+	}
+	atomic.AddUint64(&n.stats.RemoteHits, 1)
+
+	if cacheable {
+		n.mu.Lock()
+		if n.generation == generation {
+			n.local.Set(key, value, n.options.LocalTTL)
+		}
+		n.mu.Unlock()
+	}
+	return value, true, nil
+}
+
+// Will write value to the server. The local copy is dropped, the next Get reads the new value back.
+func (n *NearCache) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	n.invalidate(key)
+	err := n.backend.Set(ctx, key, value, ttl)
+	// Dropped again after the write, in case a concurrent Get stored the old value in between
+	n.invalidate(key)
+	return err
+}
+
+// Will delete key on the server and locally
+func (n *NearCache) Delete(ctx context.Context, key string) error {
+	n.invalidate(key)
+	err := n.backend.Delete(ctx, key)
+	n.invalidate(key)
+	return err
+}
+
+// Will report whether a key may be kept locally right now
+func (n *NearCache) cacheable(key string) bool {
+	return n.connected.Load() && cache.MatchPattern(n.options.Pattern, key)
+}
+
+func (n *NearCache) invalidate(key string) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.generation++
+	n.local.Delete(key)
+}
+
+func (n *NearCache) invalidateAll() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.generation++
+	n.local.Flush()
+}
+
+// Will follow the change stream until Close, reconnecting with backoff whenever it ends
+func (n *NearCache) follow(ctx context.Context) {
+	defer close(n.done)
+
+	backoff := n.options.ReconnectBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+		}
+
+		events, err := n.backend.Watch(ctx, n.options.Pattern)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			backoff = n.nextBackoff(backoff)
+			continue
+		}
+		if attempt > 0 {
+			atomic.AddUint64(&n.stats.Reconnects, 1)
+		}
+		backoff = n.options.ReconnectBackoff
+
+		// Anything cached before (i.e. during a previous connection) may have changed while disconnected
+		n.invalidateAll()
+		n.connected.Store(true)
+		n.once.Do(func() { close(n.ready) })
+
+		for ev := range events {
+			n.apply(ev)
+		}
+
+		n.connected.Store(false)
+		n.invalidateAll()
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (n *NearCache) nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if n.options.MaxReconnectBackoff > 0 && d > n.options.MaxReconnectBackoff {
+		d = n.options.MaxReconnectBackoff
+	}
+	return d
+}
+
+// Will drop the local copies touched by a change event
+func (n *NearCache) apply(ev cache.Event) {
+	atomic.AddUint64(&n.stats.Invalidations, 1)
+	if ev.Type == cache.EventFlush {
+		n.invalidateAll()
+		return
+	}
+	n.invalidate(ev.Key)
+}
+
+// Will return the counters, plus "local_keys" for how many copies are held right now
+func (n *NearCache) GetStats() map[string]uint64 {
+	return map[string]uint64{
+		"local_hits":    atomic.LoadUint64(&n.stats.LocalHits),
+		"remote_hits":   atomic.LoadUint64(&n.stats.RemoteHits),
+		"misses":        atomic.LoadUint64(&n.stats.Misses),
+		"invalidations": atomic.LoadUint64(&n.stats.Invalidations),
+		"reconnects":    atomic.LoadUint64(&n.stats.Reconnects),
+		"local_keys":    uint64(n.local.Len()),
+	}
+}
+
+// Will stop following the change stream and release the local cache. The backend is not closed.
+func (n *NearCache) Close() {
+	n.cancel()
+	<-n.done
+	n.local.Stop()
+}
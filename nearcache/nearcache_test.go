@@ -0,0 +1,279 @@
+package nearcache
+
+import (
+	"context"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"golang-memory-cache/client"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A Backend on top of a local cache.Cache, standing in for a remote server.
+// Watch streams can be cut with disconnect, and failWatch makes new ones fail.
+type fakeBackend struct {
+	c         *cache.Cache
+	gets      int32
+	failWatch atomic.Bool
+
+	mu      sync.Mutex
+	cancels []func()
+}
+
+func newFakeBackend() *fakeBackend {
+	return &fakeBackend{c: cache.NewCache()}
+}
+
+func (b *fakeBackend) Get(ctx context.Context, key string) (interface{}, bool, error) {
+	atomic.AddInt32(&b.gets, 1)
+	value, found := b.c.Get(key)
+	return value, found, nil
+}
+
+func (b *fakeBackend) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	b.c.Set(key, value, ttl)
+	return nil
+}
+
+func (b *fakeBackend) Delete(ctx context.Context, key string) error {
+	b.c.Delete(key)
+	return nil
+}
+
+func (b *fakeBackend) Watch(ctx context.Context, pattern string) (<-chan cache.Event, error) {
+	if b.failWatch.Load() {
+		return nil, errors.New("watch unavailable")
+	}
+	events, cancel := b.c.Watch(pattern)
+	b.mu.Lock()
+	b.cancels = append(b.cancels, cancel)
+	b.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		cancel()
+	}()
+	return events, nil
+}
+
+// Will close every open change stream, like a dropped connection
+func (b *fakeBackend) disconnect() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, cancel := range b.cancels {
+		cancel()
+	}
+	b.cancels = nil
+}
+
+func (b *fakeBackend) remoteGets() int32 { return atomic.LoadInt32(&b.gets) }
+
+func newTestNearCache(t *testing.T, b Backend, opts Options) *NearCache {
+	t.Helper()
+	n := NewNearCacheWithOptions(b, opts)
+	t.Cleanup(n.Close)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := n.WaitReady(ctx); err != nil {
+		t.Fatalf("Change stream never connected: %v", err)
+	}
+	return n
+}
+
+// Will wait until cond is true, the change stream delivers events asynchronously
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestGetServesLocally(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	// Seeded before connecting, otherwise the change event of this write could drop the first local copy
+	b.c.Set("a", "remote", cache.NoExpiration)
+	n := newTestNearCache(t, b, DefaultOptions)
+	ctx := context.Background()
+
+	for i := 0; i < 3; i++ {
+		value, found, err := n.Get(ctx, "a")
+		if err != nil || !found || value != "remote" {
+			t.Fatalf("Get returned %v, %v, %v", value, found, err)
+		}
+	}
+	if gets := b.remoteGets(); gets != 1 {
+		t.Errorf("Expected a single remote read, got %d", gets)
+	}
+
+	if _, found, _ := n.Get(ctx, "missing"); found {
+		t.Errorf("Expected missing key not to be found")
+	}
+
+	stats := n.GetStats()
+	if stats["local_hits"] != 2 || stats["remote_hits"] != 1 || stats["misses"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
+
+func TestRemoteChangeInvalidates(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	b.c.Set("a", "v1", cache.NoExpiration)
+	b.c.Set("b", "v1", cache.NoExpiration)
+	n := newTestNearCache(t, b, DefaultOptions)
+	ctx := context.Background()
+
+	n.Get(ctx, "a")
+	n.Get(ctx, "b")
+
+	// Another client changes a on the server
+	b.c.Set("a", "v2", cache.NoExpiration)
+	eventually(t, func() bool {
+		value, _, _ := n.Get(ctx, "a")
+		return value == "v2"
+	})
+	if stats := n.GetStats(); stats["invalidations"] == 0 {
+		t.Errorf("Expected an invalidation to be counted, got %v", stats)
+	}
+
+	// A flush on the server drops every local copy
+	b.c.Flush()
+	eventually(t, func() bool {
+		_, found, _ := n.Get(ctx, "b")
+		return !found
+	})
+}
+
+func TestSetAndDelete(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	n := newTestNearCache(t, b, DefaultOptions)
+	ctx := context.Background()
+
+	n.Set(ctx, "a", "v1", cache.NoExpiration)
+	n.Get(ctx, "a")
+	n.Set(ctx, "a", "v2", cache.NoExpiration)
+
+	// The writer reads its own write, without waiting for the change event
+	if value, _, _ := n.Get(ctx, "a"); value != "v2" {
+		t.Errorf("Expected v2 after Set, got %v", value)
+	}
+
+	n.Delete(ctx, "a")
+	if _, found, _ := n.Get(ctx, "a"); found {
+		t.Errorf("Expected a to be gone after Delete")
+	}
+	if _, found := b.c.Get("a"); found {
+		t.Errorf("Expected a to be deleted on the backend")
+	}
+}
+
+func TestLocalTTL(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	opts := DefaultOptions
+	opts.LocalTTL = 20 * time.Millisecond
+	b.c.Set("a", "v1", cache.NoExpiration)
+	n := newTestNearCache(t, b, opts)
+	ctx := context.Background()
+
+	n.Get(ctx, "a")
+	time.Sleep(40 * time.Millisecond)
+	n.Get(ctx, "a")
+	if gets := b.remoteGets(); gets != 2 {
+		t.Errorf("Expected the local copy to expire and be read again, got %d remote reads", gets)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	opts := DefaultOptions
+	opts.Pattern = "hot:*"
+	b.c.Set("hot:1", 1, cache.NoExpiration)
+	b.c.Set("cold:1", 1, cache.NoExpiration)
+	n := newTestNearCache(t, b, opts)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		n.Get(ctx, "hot:1")
+		n.Get(ctx, "cold:1")
+	}
+	if gets := b.remoteGets(); gets != 3 {
+		t.Errorf("Expected only hot keys to be cached locally, got %d remote reads", gets)
+	}
+}
+
+// Will test that nothing is served locally while the change stream is down, and that it reconnects
+func TestReconnect(t *testing.T) {
+	b := newFakeBackend()
+	defer b.c.Stop()
+	opts := DefaultOptions
+	opts.ReconnectBackoff = 10 * time.Millisecond
+	n := newTestNearCache(t, b, opts)
+	ctx := context.Background()
+
+	b.c.Set("a", "v1", cache.NoExpiration)
+	n.Get(ctx, "a")
+
+	b.failWatch.Store(true)
+	b.disconnect()
+	eventually(t, func() bool { return !n.connected.Load() })
+
+	// Changes made while disconnected can't be missed, every read goes to the backend
+	b.c.Set("a", "v2", cache.NoExpiration)
+	before := b.remoteGets()
+	if value, _, _ := n.Get(ctx, "a"); value != "v2" {
+		t.Errorf("Expected v2 while disconnected, got %v", value)
+	}
+	n.Get(ctx, "a")
+	if gets := b.remoteGets() - before; gets != 2 {
+		t.Errorf("Expected every read to go to the backend while disconnected, got %d", gets)
+	}
+
+	b.failWatch.Store(false)
+	eventually(t, func() bool { return n.connected.Load() })
+	if stats := n.GetStats(); stats["reconnects"] != 1 {
+		t.Errorf("Expected 1 reconnect, got %v", stats)
+	}
+}
+
+// Will test the NearCache against a real server through the HTTP client
+func TestWithHTTPClient(t *testing.T) {
+	c := cache.NewCache()
+	srv := httptest.NewServer((&api.Handler{Cache: c}).Routes())
+	// Cleanups run last in first out, so the NearCache closes its change stream before the server shuts down
+	t.Cleanup(func() {
+		srv.Close()
+		c.Stop()
+	})
+	c.Set("a", "v1", cache.NoExpiration)
+
+	cl, err := client.NewClient(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := newTestNearCache(t, cl, DefaultOptions)
+	ctx := context.Background()
+
+	n.Get(ctx, "a")
+	n.Get(ctx, "a")
+	if stats := n.GetStats(); stats["local_hits"] != 1 || stats["remote_hits"] != 1 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+
+	c.Set("a", "v2", cache.NoExpiration)
+	eventually(t, func() bool {
+		value, _, _ := n.Get(ctx, "a")
+		return value == "v2"
+	})
+}
@@ -0,0 +1,126 @@
+package pubsub
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/api"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// How often an idle subscription stream sends a comment line, so proxies don't close the connection
+var sseKeepAlive = 15 * time.Second
+
+// Largest message accepted by the publish route
+const maxBodySize = 1 << 20
+
+// Largest buffer a subscriber may ask for
+const maxBufferSize = 10000
+
+type messageEvent struct {
+	Channel string `json:"channel"`
+	Pattern string `json:"pattern"`
+	Payload string `json:"payload"`
+}
+
+type publishResponse struct {
+	Channel   string `json:"channel"`
+	Receivers int    `json:"receivers"`
+}
+
+// Will mount the pub/sub routes on mux:
+// * GET /v2/pubsub?pattern=&buffer=&policy=
+// * POST /v2/pubsub/{channel}
+func (b *Broker) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v2/pubsub", b.SubscribeHandler)
+	mux.HandleFunc("POST /v2/pubsub/{channel}", b.PublishHandler)
+}
+
+// * POST /v2/pubsub/{channel}
+// Publishes the request body on channel, and answers with the number of subscribers that received it
+func (b *Broker) PublishHandler(w http.ResponseWriter, r *http.Request) {
+	channel := r.PathValue("channel")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Message too large")
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, publishResponse{Channel: channel, Receivers: b.Publish(channel, string(body))})
+}
+
+// * GET /v2/pubsub?pattern=news.*&pattern=alerts&buffer=64&policy=drop
+// Streams the messages published on channels matching any pattern (repeat it to subscribe to several, every
+// channel when left out) as Server-Sent Events. buffer and policy ("drop" or "disconnect") control what
+// happens when the client reads slower than messages are published.
+func (b *Broker) SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	// Flushing is needed to push every message to the client right away instead of buffering the response
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := DefaultOptions
+	if s := query.Get("buffer"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > maxBufferSize {
+			api.WriteError(w, http.StatusBadRequest, fmt.Sprintf("buffer must be between 0 and %d", maxBufferSize))
+			return
+		}
+		opts.BufferSize = n
+	}
+	switch query.Get("policy") {
+	case "":
+	case "drop":
+		opts.Policy = PolicyDrop
+	case "disconnect":
+		opts.Policy = PolicyDisconnect
+	default:
+		api.WriteError(w, http.StatusBadRequest, "policy must be drop or disconnect")
+		return
+	}
+	patterns := query["pattern"]
+	if len(patterns) == 0 {
+		patterns = []string{""}
+	}
+
+	sub := b.SubscribeWithOptions(opts, patterns...)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		// The request context is cancelled when the client disconnects or the server shuts down
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case msg, ok := <-sub.C:
+			if !ok {
+				return // Disconnected for being too slow
+			}
+			data, err := json.Marshal(messageEvent{Channel: msg.Channel, Pattern: msg.Pattern, Payload: msg.Payload})
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: message\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package pubsub
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPPublishSubscribe(t *testing.T) {
+	b := NewBroker()
+	mux := http.NewServeMux()
+	b.RegisterRoutes(mux)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL+"/v2/pubsub?pattern=news.*&pattern=alerts", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("wrong content type: got %v, expected text/event-stream", ct)
+	}
+
+	// The subscription exists once headers were sent, so these messages will be streamed
+	publish := func(channel, body string) publishResponse {
+		t.Helper()
+		resp, err := http.Post(srv.URL+"/v2/pubsub/"+channel, "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var got publishResponse
+		json.NewDecoder(resp.Body).Decode(&got)
+		return got
+	}
+	if got := publish("weather", "rain"); got.Receivers != 0 {
+		t.Errorf("Expected no receivers, got %+v", got)
+	}
+	if got := publish("news.sport", "goal"); got.Channel != "news.sport" || got.Receivers != 1 {
+		t.Errorf("Unexpected publish response: %+v", got)
+	}
+
+	reader := bufio.NewReader(resp.Body)
+	eventLine, _ := reader.ReadString('\n')
+	dataLine, _ := reader.ReadString('\n')
+	if eventLine != "event: message\n" {
+		t.Errorf("unexpected event line: %q", eventLine)
+	}
+	var got messageEvent
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(dataLine, "data: ")), &got); err != nil {
+		t.Fatalf("Failed to parse event data %q: %v", dataLine, err)
+	}
+	if got.Channel != "news.sport" || got.Pattern != "news.*" || got.Payload != "goal" {
+		t.Errorf("unexpected message: %+v", got)
+	}
+
+	// Cancelling the request unsubscribes
+	cancel()
+	deadline := time.Now().Add(time.Second)
+	for b.GetStats()["pubsub_subscribers"] != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the subscription to be closed after the client disconnected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestHTTPSubscribeInvalidOptions(t *testing.T) {
+	b := NewBroker()
+	mux := http.NewServeMux()
+	b.RegisterRoutes(mux)
+	for _, target := range []string{"/v2/pubsub?buffer=-1", "/v2/pubsub?buffer=x", "/v2/pubsub?policy=block"} {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", target, w.Code)
+		}
+	}
+}
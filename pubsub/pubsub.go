@@ -0,0 +1,195 @@
+package pubsub
+
+import (
+	"golang-memory-cache/cache"
+	"sync"
+	"sync/atomic"
+)
+
+// Publish/subscribe messaging on channels, independent of the keyspace: messages are delivered to the current
+// subscribers and never stored. A subscriber that is not connected when a message is published misses it.
+//
+// Subscriptions take glob patterns (see cache.MatchPattern). A channel name without '*', '?' or '[' only matches
+// itself, and an empty pattern matches every channel. Every subscription has its own buffer, so a slow
+// subscriber never holds up a publisher or the other subscribers: when its buffer is full, new messages are
+// dropped or it is disconnected, depending on its Policy.
+
+// What happens when a subscriber's buffer is full and a new message arrives
+type Policy int
+
+const (
+	PolicyDrop       Policy = iota // Drop the new message and count it in stats
+	PolicyDisconnect               // Close the subscription's channel, the subscriber has to subscribe again
+)
+
+// Settings of a subscription
+type Options struct {
+	BufferSize int // How many messages can be queued before the policy kicks in
+	Policy     Policy
+}
+
+// Options used by Subscribe
+var DefaultOptions = Options{
+	BufferSize: 64,
+	Policy:     PolicyDrop,
+}
+
+// A published message, as received by a subscriber
+type Message struct {
+	Channel string
+	Pattern string // The pattern of the subscription that matched Channel
+	Payload string
+}
+
+type brokerStats struct {
+	Published    uint64
+	Delivered    uint64
+	Dropped      uint64
+	Disconnected uint64
+}
+
+// Delivers published messages to the subscriptions whose patterns match their channel
+type Broker struct {
+	mu     sync.RWMutex
+	subs   map[uint64]*Subscription
+	nextID uint64
+	stats  brokerStats
+}
+
+func NewBroker() *Broker {
+	return &Broker{subs: make(map[uint64]*Subscription)}
+}
+
+// A subscription to every channel matching one of its patterns. Messages arrive on C, which is closed once
+// the subscription is closed or disconnected.
+type Subscription struct {
+	C <-chan Message
+
+	broker    *Broker
+	id        uint64
+	patterns  []string
+	opts      Options
+	ch        chan Message
+	done      chan struct{}
+	closeOnce sync.Once
+	dropped   uint64
+}
+
+// Will subscribe to the channels matching patterns, with DefaultOptions
+func (b *Broker) Subscribe(patterns ...string) *Subscription {
+	return b.SubscribeWithOptions(DefaultOptions, patterns...)
+}
+
+// Same as Subscribe, but with a custom buffer and slow subscriber policy
+func (b *Broker) SubscribeWithOptions(opts Options, patterns ...string) *Subscription {
+	if opts.BufferSize < 0 {
+		opts.BufferSize = 0
+	}
+	s := &Subscription{
+		broker:   b,
+		patterns: append([]string(nil), patterns...),
+		opts:     opts,
+		ch:       make(chan Message, opts.BufferSize),
+		done:     make(chan struct{}),
+	}
+	s.C = s.ch
+
+	b.mu.Lock()
+	s.id = b.nextID
+	b.nextID++
+	b.subs[s.id] = s
+	b.mu.Unlock()
+	return s
+}
+
+// Will stop the subscription and close C. Safe to call more than once.
+func (s *Subscription) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.broker.mu.Lock()
+		delete(s.broker.subs, s.id)
+		s.broker.mu.Unlock()
+		// Every send happens while holding the read lock, so once we got the write lock nobody can be sending anymore
+		close(s.ch)
+	})
+}
+
+// Will return how many messages were dropped because the buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Will return the first pattern matching channel, and false when none does
+func (s *Subscription) match(channel string) (string, bool) {
+	for _, pattern := range s.patterns {
+		if cache.MatchPattern(pattern, channel) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// Will send payload to every subscription matching channel, once per subscription, and return how many
+// subscriptions got it (dropped messages are not counted)
+func (b *Broker) Publish(channel, payload string) int {
+	atomic.AddUint64(&b.stats.Published, 1)
+	received := 0
+	var disconnect []*Subscription
+
+	b.mu.RLock()
+	for _, s := range b.subs {
+		pattern, ok := s.match(channel)
+		if !ok {
+			continue
+		}
+		select {
+		case <-s.done:
+			continue // Closing, nothing to do
+		case s.ch <- Message{Channel: channel, Pattern: pattern, Payload: payload}:
+			received++
+			continue
+		default:
+		}
+		atomic.AddUint64(&b.stats.Dropped, 1)
+		atomic.AddUint64(&s.dropped, 1)
+		if s.opts.Policy == PolicyDisconnect {
+			disconnect = append(disconnect, s)
+		}
+	}
+	b.mu.RUnlock()
+
+	// Closing takes the write lock, so it has to happen after we let go of the read lock
+	for _, s := range disconnect {
+		s.Close()
+		atomic.AddUint64(&b.stats.Disconnected, 1)
+	}
+	atomic.AddUint64(&b.stats.Delivered, uint64(received))
+	return received
+}
+
+// Will return how many subscriptions match channel
+func (b *Broker) Subscribers(channel string) int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	n := 0
+	for _, s := range b.subs {
+		if _, ok := s.match(channel); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// Will return the broker's counters, prefixed with "pubsub_"
+func (b *Broker) GetStats() map[string]uint64 {
+	b.mu.RLock()
+	subscribers := len(b.subs)
+	b.mu.RUnlock()
+	return map[string]uint64{
+		"pubsub_published":    atomic.LoadUint64(&b.stats.Published),
+		"pubsub_delivered":    atomic.LoadUint64(&b.stats.Delivered),
+		"pubsub_dropped":      atomic.LoadUint64(&b.stats.Dropped),
+		"pubsub_disconnected": atomic.LoadUint64(&b.stats.Disconnected),
+		"pubsub_subscribers":  uint64(subscribers),
+	}
+}
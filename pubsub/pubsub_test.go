@@ -0,0 +1,147 @@
+package pubsub
+
+import (
+	"testing"
+	"time"
+)
+
+func receive(t *testing.T, sub *Subscription) Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.C:
+		if !ok {
+			t.Fatal("Subscription closed")
+		}
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a message")
+	}
+	return Message{}
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	b := NewBroker()
+	exact := b.Subscribe("news")
+	defer exact.Close()
+	glob := b.Subscribe("alerts", "news.*")
+	defer glob.Close()
+
+	if n := b.Publish("news", "hello"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+	if msg := receive(t, exact); msg.Channel != "news" || msg.Pattern != "news" || msg.Payload != "hello" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	if n := b.Publish("news.sport", "goal"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+	if msg := receive(t, glob); msg.Channel != "news.sport" || msg.Pattern != "news.*" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+
+	if n := b.Publish("weather", "rain"); n != 0 {
+		t.Errorf("Expected no receivers, got %d", n)
+	}
+	if n := b.Subscribers("alerts"); n != 1 {
+		t.Errorf("Expected 1 subscriber to alerts, got %d", n)
+	}
+}
+
+func TestSubscribeAllChannels(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe("")
+	defer sub.Close()
+	b.Publish("anything", "x")
+	if msg := receive(t, sub); msg.Channel != "anything" {
+		t.Errorf("Unexpected message: %+v", msg)
+	}
+}
+
+func TestDeliveredOncePerSubscription(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe("news.*", "*")
+	defer sub.Close()
+	if n := b.Publish("news.sport", "goal"); n != 1 {
+		t.Errorf("Expected 1 receiver, got %d", n)
+	}
+	if msg := receive(t, sub); msg.Pattern != "news.*" {
+		t.Errorf("Expected the first matching pattern, got %q", msg.Pattern)
+	}
+	select {
+	case msg := <-sub.C:
+		t.Errorf("Unexpected second message: %+v", msg)
+	default:
+	}
+}
+
+func TestSlowSubscriberDrop(t *testing.T) {
+	b := NewBroker()
+	sub := b.SubscribeWithOptions(Options{BufferSize: 1, Policy: PolicyDrop}, "ch")
+	defer sub.Close()
+	b.Publish("ch", "1")
+	if n := b.Publish("ch", "2"); n != 0 {
+		t.Errorf("Expected the message to be dropped, got %d receivers", n)
+	}
+	if sub.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", sub.Dropped())
+	}
+	if msg := receive(t, sub); msg.Payload != "1" {
+		t.Errorf("Expected the first message, got %+v", msg)
+	}
+	b.Publish("ch", "3")
+	if msg := receive(t, sub); msg.Payload != "3" {
+		t.Errorf("Expected the subscription to keep working, got %+v", msg)
+	}
+}
+
+func TestSlowSubscriberDisconnect(t *testing.T) {
+	b := NewBroker()
+	sub := b.SubscribeWithOptions(Options{BufferSize: 1, Policy: PolicyDisconnect}, "ch")
+	b.Publish("ch", "1")
+	b.Publish("ch", "2")
+	<-sub.C
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected the subscription to be closed")
+	}
+	if n := b.Subscribers("ch"); n != 0 {
+		t.Errorf("Expected no subscribers left, got %d", n)
+	}
+	sub.Close() // Closing again is fine
+}
+
+func TestClose(t *testing.T) {
+	b := NewBroker()
+	sub := b.Subscribe("ch")
+	sub.Close()
+	if _, ok := <-sub.C; ok {
+		t.Error("Expected C to be closed")
+	}
+	if n := b.Publish("ch", "x"); n != 0 {
+		t.Errorf("Expected no receivers after close, got %d", n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	b := NewBroker()
+	fast := b.Subscribe("*")
+	defer fast.Close()
+	slow := b.SubscribeWithOptions(Options{BufferSize: 0}, "*")
+	defer slow.Close()
+	b.Publish("a", "1")
+	b.Publish("b", "2")
+
+	stats := b.GetStats()
+	want := map[string]uint64{
+		"pubsub_published":    2,
+		"pubsub_delivered":    2,
+		"pubsub_dropped":      2,
+		"pubsub_disconnected": 0,
+		"pubsub_subscribers":  2,
+	}
+	for k, v := range want {
+		if stats[k] != v {
+			t.Errorf("Expected %s to be %d, got %d", k, v, stats[k])
+		}
+	}
+}
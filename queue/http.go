@@ -0,0 +1,209 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"golang-memory-cache/api"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Longest a dequeue may block waiting for a message, so a forgotten wait can't hold a connection forever
+const maxWait = 5 * time.Minute
+
+// Largest message body accepted by the enqueue route
+const maxBodySize = 1 << 20
+
+type messageResponse struct {
+	ID         uint64    `json:"id"`
+	Queue      string    `json:"queue"`
+	Body       string    `json:"body"`
+	Deliveries int       `json:"deliveries"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	Receipt    string    `json:"receipt,omitempty"`
+}
+
+type infoResponse struct {
+	Name     string `json:"name"`
+	Messages int    `json:"messages"`
+	Visible  int    `json:"visible"`
+	InFlight int    `json:"in_flight"`
+	Delayed  int    `json:"delayed"`
+}
+
+// Will mount the queue routes on mux:
+// * GET /v2/queues/{name}
+// * POST /v2/queues/{name}?delay=
+// * POST /v2/queues/{name}/dequeue?visibility=&wait=
+// * DELETE /v2/queues/{name}/messages/{id}?receipt=
+// * POST /v2/queues/{name}/messages/{id}/nack?receipt=&delay=
+func (q *Queues) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /v2/queues/{name}", q.InfoHandler)
+	mux.HandleFunc("POST /v2/queues/{name}", q.EnqueueHandler)
+	mux.HandleFunc("POST /v2/queues/{name}/dequeue", q.DequeueHandler)
+	mux.HandleFunc("DELETE /v2/queues/{name}/messages/{id}", q.AckHandler)
+	mux.HandleFunc("POST /v2/queues/{name}/messages/{id}/nack", q.NackHandler)
+}
+
+// * GET /v2/queues/{name}
+// Answers with the number of messages of the queue, by state
+func (q *Queues) InfoHandler(w http.ResponseWriter, r *http.Request) {
+	info := q.Info(r.PathValue("name"))
+	api.WriteJSON(w, http.StatusOK, infoResponse{
+		Name:     info.Name,
+		Messages: info.Messages,
+		Visible:  info.Visible,
+		InFlight: info.InFlight,
+		Delayed:  info.Delayed,
+	})
+}
+
+// * POST /v2/queues/{name}?delay=10s
+// Enqueues the request body as a message, hidden for delay (none by default). Answers 201 with the message.
+func (q *Queues) EnqueueHandler(w http.ResponseWriter, r *http.Request) {
+	delay, err := parseDuration(r.URL.Query().Get("delay"), 0)
+	if err != nil || delay < 0 {
+		api.WriteError(w, http.StatusBadRequest, "delay must be a number of seconds or a duration")
+		return
+	}
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Message too large")
+		return
+	}
+	msg, err := q.Enqueue(r.PathValue("name"), string(body), delay)
+	if err != nil {
+		writeQueueError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, newMessageResponse(msg))
+}
+
+// * POST /v2/queues/{name}/dequeue?visibility=30s&wait=20s
+// Hands out the oldest visible message with its receipt, hidden for visibility (the default timeout when left
+// out). Waits up to wait (none by default) for a message, then answers 204 when there is still none.
+func (q *Queues) DequeueHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	visibility, err := parseDuration(query.Get("visibility"), 0)
+	if err != nil || visibility < 0 {
+		api.WriteError(w, http.StatusBadRequest, "visibility must be a number of seconds or a duration")
+		return
+	}
+	wait, err := parseDuration(query.Get("wait"), 0)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "wait: "+err.Error())
+		return
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	name := r.PathValue("name")
+	var msg Message
+	if wait > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		msg, err = q.DequeueWait(ctx, name, visibility)
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrEmpty
+		}
+	} else {
+		msg, err = q.Dequeue(name, visibility)
+	}
+	switch {
+	case errors.Is(err, ErrEmpty), errors.Is(err, context.Canceled):
+		w.WriteHeader(http.StatusNoContent)
+	case err != nil:
+		writeQueueError(w, err)
+	default:
+		api.WriteJSON(w, http.StatusOK, newMessageResponse(msg))
+	}
+}
+
+// * DELETE /v2/queues/{name}/messages/{id}?receipt=
+// Acks a delivered message, 409 when the receipt is not valid anymore
+func (q *Queues) AckHandler(w http.ResponseWriter, r *http.Request) {
+	id, receipt, ok := deliveryParams(w, r)
+	if !ok {
+		return
+	}
+	if err := q.Ack(r.PathValue("name"), id, receipt); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// * POST /v2/queues/{name}/messages/{id}/nack?receipt=&delay=10s
+// Gives a delivered message back, visible again after delay (right away by default). 409 when the receipt is
+// not valid anymore.
+func (q *Queues) NackHandler(w http.ResponseWriter, r *http.Request) {
+	id, receipt, ok := deliveryParams(w, r)
+	if !ok {
+		return
+	}
+	delay, err := parseDuration(r.URL.Query().Get("delay"), 0)
+	if err != nil || delay < 0 {
+		api.WriteError(w, http.StatusBadRequest, "delay must be a number of seconds or a duration")
+		return
+	}
+	if err := q.Nack(r.PathValue("name"), id, receipt, delay); err != nil {
+		writeQueueError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Will read the message id from the path and the receipt query parameter, both required
+func deliveryParams(w http.ResponseWriter, r *http.Request) (uint64, string, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid message id")
+		return 0, "", false
+	}
+	receipt := r.URL.Query().Get("receipt")
+	if receipt == "" {
+		api.WriteError(w, http.StatusBadRequest, "Missing receipt")
+		return 0, "", false
+	}
+	return id, receipt, true
+}
+
+// Will parse a number of seconds ("30", "0.5") or a Go duration ("30s"), returning missing when s is empty
+func parseDuration(s string, missing time.Duration) (time.Duration, error) {
+	if s == "" {
+		return missing, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+func newMessageResponse(msg Message) messageResponse {
+	return messageResponse{
+		ID:         msg.ID,
+		Queue:      msg.Queue,
+		Body:       msg.Body,
+		Deliveries: msg.Deliveries,
+		EnqueuedAt: msg.Enqueued.UTC(),
+		Receipt:    msg.Receipt,
+	}
+}
+
+func writeQueueError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidReceipt):
+		api.WriteError(w, http.StatusConflict, "Receipt is not valid anymore")
+	case errors.Is(err, ErrNoName):
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
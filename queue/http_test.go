@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	q, _ := newTestQueues(t)
+	mux := http.NewServeMux()
+	q.RegisterRoutes(mux)
+
+	do := func(method, target, body string) (int, map[string]interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var got map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got
+	}
+
+	if code, got := do("POST", "/v2/queues/jobs", `{"task": "resize"}`); code != http.StatusCreated || got["body"] != `{"task": "resize"}` {
+		t.Fatalf("Unexpected response to an enqueue: %d %v", code, got)
+	}
+	do("POST", "/v2/queues/jobs?delay=1m", "later")
+
+	code, msg := do("POST", "/v2/queues/jobs/dequeue?visibility=60", "")
+	if code != http.StatusOK || msg["receipt"] == "" || msg["deliveries"] != float64(1) {
+		t.Fatalf("Unexpected response to a dequeue: %d %v", code, msg)
+	}
+	if code, _ := do("POST", "/v2/queues/jobs/dequeue?wait=0.02", ""); code != http.StatusNoContent {
+		t.Errorf("Expected 204 once the wait is over, got %d", code)
+	}
+	if _, got := do("GET", "/v2/queues/jobs", ""); got["in_flight"] != float64(1) || got["delayed"] != float64(1) {
+		t.Errorf("Unexpected info: %v", got)
+	}
+
+	path := fmt.Sprintf("/v2/queues/jobs/messages/%v", msg["id"])
+	if code, _ := do("POST", path+"/nack?receipt=wrong", ""); code != http.StatusConflict {
+		t.Errorf("Expected 409 for a wrong receipt, got %d", code)
+	}
+	if code, _ := do("DELETE", path+"?receipt="+msg["receipt"].(string), ""); code != http.StatusNoContent {
+		t.Errorf("Expected 204 for an ack, got %d", code)
+	}
+
+	for _, req := range []struct{ method, target string }{
+		{"DELETE", "/v2/queues/jobs/messages/abc?receipt=r"},
+		{"DELETE", "/v2/queues/jobs/messages/1"},
+		{"POST", "/v2/queues/jobs?delay=soon"},
+		{"POST", "/v2/queues/jobs/dequeue?wait=-"},
+	} {
+		if code, _ := do(req.method, req.target, ""); code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, got %d", req.method, req.target, code)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package queue
+
+import (
+	"container/heap"
+	"sync"
+)
+
+// What Queues keep in memory about a queue, so an operation never has to look at every message.
+// The cache stays the source of truth: entries of the heaps are only hints, checked against the message and
+// its hidden marker when they come out, and dropped when they are out of date (i.e. the message was acked).
+type queueState struct {
+	mu      sync.Mutex
+	ids     map[uint64]struct{} // Every message of the queue
+	visible idHeap              // Messages that became visible, oldest first
+	hidden  hiddenHeap          // Messages hidden until their marker expires, soonest first
+	dropped bool                // Removed from Queues once its last message was, must be looked up again
+}
+
+func newQueueState() *queueState {
+	return &queueState{ids: make(map[uint64]struct{})}
+}
+
+// Will move the messages whose hidden marker expired before now (in Unix nanoseconds) to the visible heap
+func (s *queueState) reveal(now int64) {
+	for len(s.hidden) > 0 && now > s.hidden[0].at {
+		heap.Push(&s.visible, heap.Pop(&s.hidden).(hiddenEntry).id)
+	}
+}
+
+// Will return the state of the queue, locked. When the queue has no messages, returns nil unless create is set.
+func (q *Queues) lock(name string, create bool) *queueState {
+	for {
+		q.mu.Lock()
+		s := q.queues[name]
+		if s == nil && create {
+			s = newQueueState()
+			q.queues[name] = s
+		}
+		q.mu.Unlock()
+		if s == nil {
+			return nil
+		}
+		s.mu.Lock()
+		if !s.dropped {
+			return s
+		}
+		s.mu.Unlock() // Emptied while we waited for it
+	}
+}
+
+// Will unlock the state of the queue, dropping it once the queue has no messages left
+func (q *Queues) unlock(name string, s *queueState) {
+	if len(s.ids) == 0 {
+		q.mu.Lock()
+		delete(q.queues, name)
+		q.mu.Unlock()
+		s.dropped = true
+	}
+	s.mu.Unlock()
+}
+
+// Message IDs, smallest first. IDs grow with every enqueue, so the smallest is the oldest message.
+type idHeap []uint64
+
+func (h idHeap) Len() int           { return len(h) }
+func (h idHeap) Less(i, j int) bool { return h[i] < h[j] }
+func (h idHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *idHeap) Push(x interface{}) { *h = append(*h, x.(uint64)) }
+
+func (h *idHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// A message hidden until at, the expiration of its hidden marker in Unix nanoseconds
+type hiddenEntry struct {
+	at int64
+	id uint64
+}
+
+// Hidden messages, the one that becomes visible first on top
+type hiddenHeap []hiddenEntry
+
+func (h hiddenHeap) Len() int           { return len(h) }
+func (h hiddenHeap) Less(i, j int) bool { return h[i].at < h[j].at }
+func (h hiddenHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *hiddenHeap) Push(x interface{}) { *h = append(*h, x.(hiddenEntry)) }
+
+func (h *hiddenHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
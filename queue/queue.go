@@ -0,0 +1,419 @@
+package queue
+
+import (
+	"container/heap"
+	"context"
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"golang-memory-cache/cache"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Work queues on top of a cache.Cache. Every message is a key holding the name of its queue. Queues index
+// their messages in memory, by queue, so enqueues, dequeues and acks take a time logarithmic in the number of
+// messages. The index is built again from the cache when Queues are created on a cache restored from a snapshot.
+//
+// A message is hidden while a "hidden" key with a TTL exists for it, so the cache's expiration is what brings
+// it back: Dequeue hides a message for the visibility timeout, and a delayed message is hidden until its delay
+// is over. The hidden key of a delivered message holds a receipt that changes on every delivery. Only the
+// consumer holding the current receipt can Ack or Nack the message, so a consumer that was too slow can't
+// remove a message that was already handed to another one.
+//
+// A message delivered MaxDeliveries times without being acked is moved to the dead-letter queue (the queue's
+// name followed by DeadLetterSuffix) the next time it would be delivered. It keeps its delivery count, so the
+// consumers of the dead-letter queue see how often it failed. Dead-letter queues never move their messages further.
+//
+// The keys under Prefix and the ID counter must only be written through Queues: anything else writing to c,
+// like a flush, could reset the counter, so the receipts and markers of old messages would match new ones.
+// Give the Queues a cache of their own when c is also reachable by clients, as the server does.
+
+// Settings used when creating Queues
+type Options struct {
+	Prefix            string        // Prepended to the keys of queues, messages and hidden markers
+	IDKey             string        // Cache key of the counter message IDs are taken from
+	VisibilityTimeout time.Duration // How long Dequeue hides a message when no timeout is given
+	MaxDeliveries     int           // Deliveries after which a message is dead-lettered
+	DeadLetterSuffix  string        // Appended to a queue's name to get its dead-letter queue
+	RetryInterval     time.Duration // Longest a blocked DequeueWait sleeps before looking again, enqueues wake it earlier
+}
+
+// Options used by NewQueues
+var DefaultOptions = Options{
+	Prefix:            "queue:",
+	IDKey:             "queue-id",
+	VisibilityTimeout: 30 * time.Second,
+	MaxDeliveries:     5,
+	DeadLetterSuffix:  ":dead",
+	RetryInterval:     time.Second,
+}
+
+var (
+	ErrEmpty          = errors.New("queue: no message ready")
+	ErrInvalidReceipt = errors.New("queue: receipt is not valid, the message was acked or delivered again")
+	ErrNoName         = errors.New("queue: missing queue name")
+)
+
+// A message. The cache stores it without Receipt, which only the copy returned by Dequeue has.
+type Message struct {
+	ID         uint64
+	Queue      string
+	Body       string
+	Deliveries int // Times Dequeue handed it out
+	Enqueued   time.Time
+	Receipt    string // Needed to Ack or Nack this delivery
+}
+
+// Messages end up in snapshots
+func init() {
+	gob.Register(Message{})
+}
+
+// Counts of the messages of a queue
+type Info struct {
+	Name     string
+	Messages int // Every message, hidden or not
+	Visible  int // Ready to be delivered
+	InFlight int // Delivered, not acked yet and within their visibility timeout
+	Delayed  int // Enqueued or nacked with a delay that is not over yet
+}
+
+type queuesStats struct {
+	Enqueued     uint64
+	Delivered    uint64
+	Acked        uint64
+	Nacked       uint64
+	DeadLettered uint64
+}
+
+type Queues struct {
+	cache   *cache.Cache
+	options Options
+
+	// Guards the map of queues. Every queue has a lock of its own, held during every operation on the queue,
+	// so a message is only handed to one consumer.
+	mu     sync.Mutex
+	queues map[string]*queueState // Only the queues that have messages
+	ready  chan struct{}          // Closed and replaced whenever a message becomes visible, wakes up DequeueWait
+	stats  queuesStats
+}
+
+// Creates Queues keeping their messages in c, with DefaultOptions
+func NewQueues(c *cache.Cache) *Queues {
+	return NewQueuesWithOptions(c, DefaultOptions)
+}
+
+// Creates Queues with custom options. Zero values fall back to DefaultOptions.
+func NewQueuesWithOptions(c *cache.Cache, opts Options) *Queues {
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultOptions.Prefix
+	}
+	if opts.IDKey == "" {
+		opts.IDKey = DefaultOptions.IDKey
+	}
+	if opts.VisibilityTimeout <= 0 {
+		opts.VisibilityTimeout = DefaultOptions.VisibilityTimeout
+	}
+	if opts.MaxDeliveries <= 0 {
+		opts.MaxDeliveries = DefaultOptions.MaxDeliveries
+	}
+	if opts.DeadLetterSuffix == "" {
+		opts.DeadLetterSuffix = DefaultOptions.DeadLetterSuffix
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultOptions.RetryInterval
+	}
+	q := &Queues{cache: c, options: opts, queues: make(map[string]*queueState), ready: make(chan struct{})}
+	q.index()
+	return q
+}
+
+// Will index the messages already in the cache, i.e. restored from a snapshot
+func (q *Queues) index() {
+	prefix := q.options.Prefix + "m:"
+	for _, key := range q.cache.Keys("*") {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		msg, ok := q.peek(key).(Message)
+		if !ok {
+			continue
+		}
+		s := q.queues[msg.Queue]
+		if s == nil {
+			s = newQueueState()
+			q.queues[msg.Queue] = s
+		}
+		s.ids[msg.ID] = struct{}{}
+		if hidden, found := q.cache.Peek(q.hiddenKey(msg.ID)); found {
+			heap.Push(&s.hidden, hiddenEntry{at: hidden.Expiration, id: msg.ID})
+		} else {
+			heap.Push(&s.visible, msg.ID)
+		}
+	}
+}
+
+// Keys of a message and its hidden marker. Their first segments differ, so they never collide.
+func (q *Queues) messageKey(id uint64) string {
+	return q.options.Prefix + "m:" + strconv.FormatUint(id, 10)
+}
+
+func (q *Queues) hiddenKey(id uint64) string {
+	return q.options.Prefix + "h:" + strconv.FormatUint(id, 10)
+}
+
+// Will wake up every DequeueWait
+func (q *Queues) signal() {
+	q.mu.Lock()
+	close(q.ready)
+	q.ready = make(chan struct{})
+	q.mu.Unlock()
+}
+
+// Will add a message to the queue, hidden for delay (none when 0)
+func (q *Queues) Enqueue(name, body string, delay time.Duration) (Message, error) {
+	if name == "" {
+		return Message{}, ErrNoName
+	}
+	s := q.lock(name, true)
+	defer q.unlock(name, s)
+
+	id, err := q.cache.Increment(q.options.IDKey, 1)
+	if err != nil {
+		return Message{}, err
+	}
+	msg := Message{ID: uint64(id), Queue: name, Body: body, Enqueued: time.Now()}
+	q.add(s, msg, delay)
+	atomic.AddUint64(&q.stats.Enqueued, 1)
+	return msg, nil
+}
+
+// Will store msg and add it to its queue s. Must be called while holding s.mu.
+func (q *Queues) add(s *queueState, msg Message, delay time.Duration) {
+	s.ids[msg.ID] = struct{}{}
+	q.cache.Set(q.messageKey(msg.ID), msg, cache.NoExpiration)
+	if delay > 0 {
+		q.hide(s, msg.ID, "", delay)
+		return
+	}
+	heap.Push(&s.visible, msg.ID)
+	q.signal()
+}
+
+// Will hide a message of s for d, with receipt as its hidden marker. Must be called while holding s.mu.
+func (q *Queues) hide(s *queueState, id uint64, receipt string, d time.Duration) {
+	marker, _ := q.cache.SetIf(q.hiddenKey(id), receipt, d, nil, nil)
+	// The marker's own expiration, so the message is only revealed once the marker is gone
+	heap.Push(&s.hidden, hiddenEntry{at: marker.Expiration, id: id})
+}
+
+// Will remove a message of s, with its hidden marker. Must be called while holding s.mu.
+func (q *Queues) remove(s *queueState, id uint64) {
+	delete(s.ids, id)
+	q.cache.Delete(q.messageKey(id))
+	q.cache.Delete(q.hiddenKey(id))
+}
+
+// Will hand out the oldest visible message of the queue and hide it for visibility (the default timeout when 0).
+// The message comes with a receipt to Ack or Nack it. Fails with ErrEmpty when no message is visible.
+func (q *Queues) Dequeue(name string, visibility time.Duration) (Message, error) {
+	msg, _, err := q.dequeue(name, visibility)
+	return msg, err
+}
+
+// Same as Dequeue, also returning when the next hidden message becomes visible when none is (zero when unknown)
+func (q *Queues) dequeue(name string, visibility time.Duration) (Message, time.Time, error) {
+	if name == "" {
+		return Message{}, time.Time{}, ErrNoName
+	}
+	if visibility <= 0 {
+		visibility = q.options.VisibilityTimeout
+	}
+	s := q.lock(name, false)
+	if s == nil {
+		return Message{}, time.Time{}, ErrEmpty
+	}
+	defer q.unlock(name, s)
+
+	s.reveal(time.Now().UnixNano())
+	for s.visible.Len() > 0 {
+		id := heap.Pop(&s.visible).(uint64)
+		if _, ok := s.ids[id]; !ok {
+			continue // Acked or dead-lettered since
+		}
+		msg, ok := q.peek(q.messageKey(id)).(Message)
+		if !ok {
+			delete(s.ids, id) // Deleted behind our back, i.e. by a flush
+			continue
+		}
+		if _, hidden := q.cache.Peek(q.hiddenKey(id)); hidden {
+			continue // Hidden again since, its entry in s.hidden brings it back
+		}
+		if msg.Deliveries >= q.options.MaxDeliveries && !q.isDeadLetter(name) {
+			q.deadLetter(s, msg)
+			continue
+		}
+
+		msg.Deliveries++
+		q.cache.Set(q.messageKey(id), msg, cache.NoExpiration)
+		msg.Receipt = newReceipt()
+		q.hide(s, id, msg.Receipt, visibility)
+		atomic.AddUint64(&q.stats.Delivered, 1)
+		return msg, time.Time{}, nil
+	}
+
+	var next time.Time
+	if len(s.hidden) > 0 {
+		next = time.Unix(0, s.hidden[0].at)
+	}
+	return Message{}, next, ErrEmpty
+}
+
+// Will move msg from its queue s to the dead-letter queue. Must be called while holding s.mu.
+func (q *Queues) deadLetter(s *queueState, msg Message) {
+	q.remove(s, msg.ID)
+	msg.Queue += q.options.DeadLetterSuffix
+	// Dead-letter queues never move their messages, so no other goroutine locks them in the opposite order
+	dead := q.lock(msg.Queue, true)
+	q.add(dead, msg, 0)
+	q.unlock(msg.Queue, dead)
+	atomic.AddUint64(&q.stats.DeadLettered, 1)
+}
+
+// Will report whether name is a dead-letter queue
+func (q *Queues) isDeadLetter(name string) bool {
+	return strings.HasSuffix(name, q.options.DeadLetterSuffix)
+}
+
+// Will dequeue a message, waiting until one becomes visible or ctx is done. Returns ctx.Err() when giving up.
+func (q *Queues) DequeueWait(ctx context.Context, name string, visibility time.Duration) (Message, error) {
+	for {
+		// Taken before looking, so an enqueue right after an empty look still wakes us up
+		q.mu.Lock()
+		ready := q.ready
+		q.mu.Unlock()
+
+		msg, next, err := q.dequeue(name, visibility)
+		if !errors.Is(err, ErrEmpty) {
+			return msg, err
+		}
+
+		wait := q.options.RetryInterval
+		if !next.IsZero() && time.Until(next) < wait {
+			wait = time.Until(next)
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return Message{}, ctx.Err()
+		case <-ready:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Will check that receipt is the current receipt of a message of s. Must be called while holding s.mu.
+func (q *Queues) checkReceipt(s *queueState, id uint64, receipt string) error {
+	if _, ok := s.ids[id]; !ok || receipt == "" {
+		return ErrInvalidReceipt
+	}
+	if hidden, _ := q.peek(q.hiddenKey(id)).(string); hidden != receipt {
+		return ErrInvalidReceipt
+	}
+	return nil
+}
+
+// Will remove a delivered message for good. Fails with ErrInvalidReceipt when receipt is not the one of its
+// latest delivery, or the visibility timeout of that delivery is over.
+func (q *Queues) Ack(name string, id uint64, receipt string) error {
+	s := q.lock(name, false)
+	if s == nil {
+		return ErrInvalidReceipt
+	}
+	defer q.unlock(name, s)
+	if err := q.checkReceipt(s, id, receipt); err != nil {
+		return err
+	}
+	q.remove(s, id)
+	atomic.AddUint64(&q.stats.Acked, 1)
+	return nil
+}
+
+// Will give a delivered message back to the queue, visible again after delay (right away when 0).
+// Fails with ErrInvalidReceipt like Ack.
+func (q *Queues) Nack(name string, id uint64, receipt string, delay time.Duration) error {
+	s := q.lock(name, false)
+	if s == nil {
+		return ErrInvalidReceipt
+	}
+	defer q.unlock(name, s)
+	if err := q.checkReceipt(s, id, receipt); err != nil {
+		return err
+	}
+	if delay > 0 {
+		q.hide(s, id, "", delay)
+	} else {
+		q.cache.Delete(q.hiddenKey(id))
+		heap.Push(&s.visible, id)
+		q.signal()
+	}
+	atomic.AddUint64(&q.stats.Nacked, 1)
+	return nil
+}
+
+// Will count the messages of the queue
+func (q *Queues) Info(name string) Info {
+	info := Info{Name: name}
+	s := q.lock(name, false)
+	if s == nil {
+		return info
+	}
+	defer q.unlock(name, s)
+
+	for id := range s.ids {
+		if _, ok := q.peek(q.messageKey(id)).(Message); !ok {
+			delete(s.ids, id)
+			continue
+		}
+		info.Messages++
+		switch receipt, hidden := q.peek(q.hiddenKey(id)).(string); {
+		case !hidden:
+			info.Visible++
+		case receipt == "":
+			info.Delayed++
+		default:
+			info.InFlight++
+		}
+	}
+	return info
+}
+
+// Will return the value at key, nil when missing, without counting a hit or miss
+func (q *Queues) peek(key string) interface{} {
+	item, _ := q.cache.Peek(key)
+	return item.Value
+}
+
+func newReceipt() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Will return the queue counters, prefixed with "queue_"
+func (q *Queues) GetStats() map[string]uint64 {
+	return map[string]uint64{
+		"queue_enqueued":      atomic.LoadUint64(&q.stats.Enqueued),
+		"queue_delivered":     atomic.LoadUint64(&q.stats.Delivered),
+		"queue_acked":         atomic.LoadUint64(&q.stats.Acked),
+		"queue_nacked":        atomic.LoadUint64(&q.stats.Nacked),
+		"queue_dead_lettered": atomic.LoadUint64(&q.stats.DeadLettered),
+	}
+}
@@ -0,0 +1,285 @@
+package queue
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"golang-memory-cache/cache"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newTestQueues(t *testing.T) (*Queues, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	return NewQueuesWithOptions(c, Options{MaxDeliveries: 2, RetryInterval: 10 * time.Millisecond}), c
+}
+
+func TestEnqueueDequeueAck(t *testing.T) {
+	q, c := newTestQueues(t)
+	first, _ := q.Enqueue("jobs", "one", 0)
+	q.Enqueue("jobs", "two", 0)
+
+	msg, err := q.Dequeue("jobs", time.Minute)
+	if err != nil || msg.ID != first.ID || msg.Body != "one" || msg.Deliveries != 1 || msg.Receipt == "" {
+		t.Fatalf("Expected the first message, got %+v %v", msg, err)
+	}
+	if next, _ := q.Dequeue("jobs", time.Minute); next.Body != "two" {
+		t.Errorf("Expected the hidden first message to be skipped, got %+v", next)
+	}
+	if _, err := q.Dequeue("jobs", time.Minute); err != ErrEmpty {
+		t.Errorf("Expected ErrEmpty with every message in flight, got %v", err)
+	}
+	if info := q.Info("jobs"); info.Messages != 2 || info.InFlight != 2 {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+
+	if err := q.Ack("jobs", msg.ID, "wrong"); err != ErrInvalidReceipt {
+		t.Errorf("Expected ErrInvalidReceipt, got %v", err)
+	}
+	if err := q.Ack("other", msg.ID, msg.Receipt); err != ErrInvalidReceipt {
+		t.Errorf("Expected ErrInvalidReceipt for another queue, got %v", err)
+	}
+	if err := q.Ack("jobs", msg.ID, msg.Receipt); err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Ack("jobs", msg.ID, msg.Receipt); err != ErrInvalidReceipt {
+		t.Errorf("Expected a second ack to fail, got %v", err)
+	}
+	if q.Info("jobs").Messages != 1 {
+		t.Error("Expected the acked message to be gone")
+	}
+	if _, found := c.Peek(q.messageKey(msg.ID)); found {
+		t.Error("Expected the acked message's key to be deleted")
+	}
+}
+
+// Will test that a message that was not acked within its visibility timeout is delivered again, and that the
+// late consumer can't ack it anymore
+func TestVisibilityTimeout(t *testing.T) {
+	q, _ := newTestQueues(t)
+	q.Enqueue("jobs", "one", 0)
+
+	slow, _ := q.Dequeue("jobs", 30*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+
+	again, err := q.Dequeue("jobs", time.Minute)
+	if err != nil || again.ID != slow.ID || again.Deliveries != 2 || again.Receipt == slow.Receipt {
+		t.Fatalf("Expected a second delivery with a new receipt, got %+v %v", again, err)
+	}
+	if err := q.Ack("jobs", slow.ID, slow.Receipt); err != ErrInvalidReceipt {
+		t.Errorf("Expected the late consumer's ack to fail, got %v", err)
+	}
+	if err := q.Ack("jobs", again.ID, again.Receipt); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNack(t *testing.T) {
+	q, _ := newTestQueues(t)
+	q.Enqueue("jobs", "one", 0)
+
+	msg, _ := q.Dequeue("jobs", time.Minute)
+	if err := q.Nack("jobs", msg.ID, msg.Receipt, 0); err != nil {
+		t.Fatal(err)
+	}
+	msg, err := q.Dequeue("jobs", time.Minute)
+	if err != nil || msg.Deliveries != 2 {
+		t.Fatalf("Expected the nacked message right away, got %+v %v", msg, err)
+	}
+
+	q.Enqueue("retries", "two", 0)
+	retry, _ := q.Dequeue("retries", time.Minute)
+	q.Nack("retries", retry.ID, retry.Receipt, 30*time.Millisecond)
+	if info := q.Info("retries"); info.Delayed != 1 {
+		t.Errorf("Expected a delayed message, got %+v", info)
+	}
+	if _, err := q.Dequeue("retries", time.Minute); err != ErrEmpty {
+		t.Errorf("Expected the message to be hidden during its delay, got %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if _, err := q.Dequeue("retries", time.Minute); err != nil {
+		t.Errorf("Expected the message after its delay, got %v", err)
+	}
+}
+
+func TestDelayedMessage(t *testing.T) {
+	q, _ := newTestQueues(t)
+	q.Enqueue("jobs", "later", 30*time.Millisecond)
+	q.Enqueue("jobs", "now", 0)
+
+	if msg, _ := q.Dequeue("jobs", time.Minute); msg.Body != "now" {
+		t.Errorf("Expected the delayed message to be skipped, got %+v", msg)
+	}
+	if info := q.Info("jobs"); info.Delayed != 1 || info.Visible != 0 {
+		t.Errorf("Unexpected info: %+v", info)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if msg, _ := q.Dequeue("jobs", time.Minute); msg.Body != "later" {
+		t.Errorf("Expected the delayed message once its delay is over, got %+v", msg)
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	q, _ := newTestQueues(t)
+	q.Enqueue("jobs", "poison", 0)
+
+	for i := 0; i < 2; i++ {
+		msg, err := q.Dequeue("jobs", time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q.Nack("jobs", msg.ID, msg.Receipt, 0)
+	}
+	if _, err := q.Dequeue("jobs", time.Minute); err != ErrEmpty {
+		t.Errorf("Expected the message to be dead-lettered after 2 deliveries, got %v", err)
+	}
+	if info := q.Info("jobs"); info.Messages != 0 {
+		t.Errorf("Expected the queue to be empty, got %+v", info)
+	}
+	dead, err := q.Dequeue("jobs:dead", time.Minute)
+	if err != nil || dead.Body != "poison" || dead.Queue != "jobs:dead" || dead.Deliveries != 3 {
+		t.Errorf("Expected the message in the dead-letter queue with its deliveries, got %+v %v", dead, err)
+	}
+
+	// The dead-letter queue keeps redelivering it
+	q.Nack("jobs:dead", dead.ID, dead.Receipt, 0)
+	if again, err := q.Dequeue("jobs:dead", time.Minute); err != nil || again.Deliveries != 4 {
+		t.Errorf("Expected the dead-letter queue to keep the message, got %+v %v", again, err)
+	}
+	if q.GetStats()["queue_dead_lettered"] != 1 {
+		t.Errorf("Unexpected stats: %v", q.GetStats())
+	}
+}
+
+func TestDequeueWait(t *testing.T) {
+	q, _ := newTestQueues(t)
+	q.options.RetryInterval = time.Minute // Only an enqueue can wake it up in time
+
+	done := make(chan Message)
+	go func() {
+		msg, err := q.DequeueWait(context.Background(), "jobs", time.Minute)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- msg
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.Enqueue("jobs", "one", 0)
+	select {
+	case msg := <-done:
+		if msg.Body != "one" {
+			t.Errorf("Unexpected message: %+v", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the enqueue to wake up the waiting dequeue")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.DequeueWait(ctx, "jobs", time.Minute); err != context.DeadlineExceeded {
+		t.Errorf("Expected DeadlineExceeded on an empty queue, got %v", err)
+	}
+}
+
+// Will test that concurrent consumers never get the same message
+func TestConcurrentConsumers(t *testing.T) {
+	q, _ := newTestQueues(t)
+	for i := 0; i < 100; i++ {
+		q.Enqueue("jobs", fmt.Sprint(i), 0)
+	}
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				msg, err := q.Dequeue("jobs", time.Minute)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				if seen[msg.ID] {
+					t.Errorf("Message %d delivered twice", msg.ID)
+				}
+				seen[msg.ID] = true
+				mu.Unlock()
+				q.Ack("jobs", msg.ID, msg.Receipt)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(seen) != 100 {
+		t.Errorf("Expected 100 messages, got %d", len(seen))
+	}
+}
+
+func TestMessagesSurviveSnapshot(t *testing.T) {
+	q, c := newTestQueues(t)
+	q.Enqueue("jobs", "one", 0)
+	q.Enqueue("jobs", "later", time.Minute)
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := cache.NewCache()
+	defer c2.Stop()
+	if _, err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q2 := NewQueues(c2)
+	if msg, err := q2.Dequeue("jobs", time.Minute); err != nil || msg.Body != "one" {
+		t.Errorf("Expected the message after the restore, got %+v %v", msg, err)
+	}
+	if _, err := q2.Dequeue("jobs", time.Minute); err != ErrEmpty {
+		t.Errorf("Expected the delayed message to stay hidden after the restore, got %v", err)
+	}
+	if info := q2.Info("jobs"); info.Messages != 2 || info.Delayed != 1 {
+		t.Errorf("Unexpected info after the restore: %+v", info)
+	}
+	if next, _ := q2.Enqueue("jobs", "two", 0); next.ID != 3 {
+		t.Errorf("Expected IDs to continue after the restore, got %d", next.ID)
+	}
+}
+
+// Will test that a message visible again goes before the messages enqueued after it
+func TestOldestFirst(t *testing.T) {
+	q, _ := newTestQueues(t)
+	for i := 0; i < 3; i++ {
+		q.Enqueue("jobs", fmt.Sprint(i), 0)
+	}
+	first, _ := q.Dequeue("jobs", time.Minute)
+	second, _ := q.Dequeue("jobs", 20*time.Millisecond)
+	q.Nack("jobs", first.ID, first.Receipt, 0)
+	time.Sleep(40 * time.Millisecond)
+
+	for _, want := range []string{"0", "1", "2"} {
+		if msg, err := q.Dequeue("jobs", time.Minute); err != nil || msg.Body != want {
+			t.Errorf("Expected message %s, got %+v %v", want, msg, err)
+		}
+	}
+	if _, err := q.Dequeue("jobs", time.Minute); err != ErrEmpty {
+		t.Errorf("Expected every message in flight, got %v", err)
+	}
+	if second.Body != "1" {
+		t.Errorf("Unexpected second message: %+v", second)
+	}
+}
+
+// Will test that queues with no messages left are forgotten
+func TestEmptyQueuesDropped(t *testing.T) {
+	q, _ := newTestQueues(t)
+	msg, _ := q.Enqueue("jobs", "one", 0)
+	msg, _ = q.Dequeue("jobs", time.Minute)
+	q.Info("missing")
+	q.Ack("jobs", msg.ID, msg.Receipt)
+	if len(q.queues) != 0 {
+		t.Errorf("Expected no queue state left, got %d", len(q.queues))
+	}
+}
@@ -0,0 +1,91 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Paths of the RPCs, under the URL of the target node
+const (
+	votePath     = "/raft/vote"
+	appendPath   = "/raft/append"
+	snapshotPath = "/raft/snapshot"
+)
+
+// Sends RPCs as gob encoded POST requests. Node IDs are the base URLs the nodes serve their routes on
+// (see Node.RegisterRoutes), i.e. "http://10.0.0.1:8080".
+type HTTPTransport struct {
+	client *http.Client
+}
+
+// Creates an HTTPTransport, http.DefaultClient is used when client is nil
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPTransport{client: client}
+}
+
+func (t *HTTPTransport) call(ctx context.Context, target, path string, req, resp interface{}) error {
+	var body bytes.Buffer
+	if err := gob.NewEncoder(&body).Encode(req); err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(target, "/")+path, &body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	httpResp, err := t.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return fmt.Errorf("raft: %s%s: %s", target, path, httpResp.Status)
+	}
+	return gob.NewDecoder(httpResp.Body).Decode(resp)
+}
+
+func (t *HTTPTransport) RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error) {
+	var resp VoteResponse
+	return &resp, t.call(ctx, target, votePath, req, &resp)
+}
+
+func (t *HTTPTransport) AppendEntries(ctx context.Context, target string, req *AppendRequest) (*AppendResponse, error) {
+	var resp AppendResponse
+	return &resp, t.call(ctx, target, appendPath, req, &resp)
+}
+
+func (t *HTTPTransport) InstallSnapshot(ctx context.Context, target string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	var resp SnapshotResponse
+	return &resp, t.call(ctx, target, snapshotPath, req, &resp)
+}
+
+// Will mount the RPC routes the HTTPTransport of the other nodes calls:
+// * POST /raft/vote
+// * POST /raft/append
+// * POST /raft/snapshot
+func (n *Node) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST "+votePath, rpcHandler(n.HandleRequestVote))
+	mux.HandleFunc("POST "+appendPath, rpcHandler(n.HandleAppendEntries))
+	mux.HandleFunc("POST "+snapshotPath, rpcHandler(n.HandleInstallSnapshot))
+}
+
+// Will decode a gob request, handle it and encode the gob response
+func rpcHandler[Req, Resp any](handle func(*Req) *Resp) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+		if err := gob.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		resp := handle(&req)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		gob.NewEncoder(w).Encode(resp)
+	}
+}
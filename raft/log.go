@@ -0,0 +1,67 @@
+package raft
+
+// Kind of log entry
+type EntryType uint8
+
+const (
+	EntryCommand EntryType = iota + 1 // Passed to the state machine
+	EntryNoop                         // Appended by every new leader, so it can commit entries of earlier terms
+)
+
+// One entry of the replicated log
+type Entry struct {
+	Index   uint64
+	Term    uint64 // Term of the leader that appended it
+	Type    EntryType
+	Command []byte
+}
+
+// The helpers below must be called while holding n.mu. The log always starts with the entry the snapshot ends
+// with (index 0 before the first snapshot), so entries are found by their offset from it.
+
+func (n *Node) snapshotIndex() uint64 { return n.log[0].Index }
+func (n *Node) lastIndex() uint64     { return n.log[len(n.log)-1].Index }
+func (n *Node) lastTerm() uint64      { return n.log[len(n.log)-1].Term }
+
+// Will return the term of the entry at index, and false when the log does not have it (anymore)
+func (n *Node) termAt(index uint64) (uint64, bool) {
+	if index < n.snapshotIndex() || index > n.lastIndex() {
+		return 0, false
+	}
+	return n.log[index-n.snapshotIndex()].Term, true
+}
+
+// Will return a copy of up to max entries starting at index
+func (n *Node) entriesFrom(index uint64, max int) []Entry {
+	if index > n.lastIndex() {
+		return nil
+	}
+	last := n.lastIndex()
+	if index+uint64(max)-1 < last {
+		last = index + uint64(max) - 1
+	}
+	return n.entriesBetween(index, last)
+}
+
+// Will return a copy of the entries from first to last, both included
+func (n *Node) entriesBetween(first, last uint64) []Entry {
+	offset := n.snapshotIndex()
+	return append([]Entry(nil), n.log[first-offset:last-offset+1]...)
+}
+
+// Will drop the entries up to index, which the snapshot now covers
+func (n *Node) compact(index, term uint64) {
+	var rest []Entry
+	if index < n.lastIndex() {
+		rest = n.log[index-n.snapshotIndex()+1:]
+	}
+	n.log = append([]Entry{{Index: index, Term: term}}, rest...)
+}
+
+// Will report whether a candidate's log is at least as up to date as this node's, the condition for a vote
+func (n *Node) upToDate(lastIndex, lastTerm uint64) bool {
+	if lastTerm != n.lastTerm() {
+		return lastTerm > n.lastTerm()
+	}
+	return lastIndex >= n.lastIndex()
+}
@@ -0,0 +1,846 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Raft consensus (https://raft.github.io/raft.pdf) for a small, fixed group of nodes. Commands are appended to a
+// replicated log by the leader and applied to a StateMachine on every node once a majority has stored them, so
+// every node applies the same commands in the same order.
+//
+// On top of the paper:
+//   - Pre-vote: a node only starts a real election (and bumps its term) once a majority would vote for it, so a
+//     node coming back from a partition does not depose a healthy leader.
+//   - Check quorum: a leader that has not heard from a majority for an election timeout steps down, so clients
+//     of a leader cut off from the others get an error instead of waiting.
+//   - Read index: linearizable reads without writing to the log, see ReadIndex.
+//
+// The log is compacted into a snapshot of the state machine every SnapshotThreshold entries. Followers that
+// fall behind the snapshot get it whole. The term, the vote, the log and the snapshot are saved to a Storage
+// before the node acts on them, so a restarted node picks up where it left off.
+
+// Role of a node
+type State uint8
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	switch s {
+	case Follower:
+		return "follower"
+	case Candidate:
+		return "candidate"
+	case Leader:
+		return "leader"
+	}
+	return fmt.Sprintf("State(%d)", s)
+}
+
+var (
+	ErrNotLeader      = errors.New("raft: not the leader")
+	ErrLeadershipLost = errors.New("raft: leadership lost, the command may or may not have been applied")
+	ErrClosed         = errors.New("raft: node closed")
+)
+
+// What the log replicates. Apply is called with the committed commands in log order, on every node. It must be
+// deterministic: the same commands must give the same state everywhere. Snapshot and Restore save and load the
+// whole state, Apply is never called while they run.
+type StateMachine interface {
+	Apply(index uint64, command []byte) interface{}
+	Snapshot(w io.Writer) error
+	Restore(r io.Reader) error
+}
+
+// Settings used when creating a Node
+type Options struct {
+	ElectionTimeout   time.Duration // Followers start an election after hearing nothing from a leader for 1 to 2 times this long
+	HeartbeatInterval time.Duration // How often the leader contacts idle followers, well below ElectionTimeout
+	SnapshotThreshold uint64        // Applied entries kept in the log before it is compacted into a snapshot
+	MaxEntries        int           // Most entries sent in a single AppendEntries
+	Storage           Storage       // Where the node saves its state, a new MemoryStorage when nil
+}
+
+// Options used by NewNode
+var DefaultOptions = Options{
+	ElectionTimeout:   time.Second,
+	HeartbeatInterval: 100 * time.Millisecond,
+	SnapshotThreshold: 8192,
+	MaxEntries:        256,
+}
+
+// A command waiting to be applied, on the leader that appended it
+type proposal struct {
+	term uint64
+	done chan proposalResult
+}
+
+type proposalResult struct {
+	value interface{}
+	err   error
+}
+
+type nodeStats struct {
+	Elections          uint64
+	Proposals          uint64
+	ReadIndexes        uint64
+	SnapshotsTaken     uint64
+	SnapshotsInstalled uint64
+	SnapshotsSent      uint64
+}
+
+type Node struct {
+	id        string
+	peers     []string // Every other node of the group
+	sm        StateMachine
+	transport Transport
+	storage   Storage
+	options   Options
+
+	mu            sync.Mutex
+	state         State
+	term          uint64
+	votedFor      string
+	leader        string
+	log           []Entry // log[0] stands for the last entry in the snapshot, only its Index and Term are set
+	snapshot      []byte  // State machine at log[0].Index
+	commitIndex   uint64
+	lastApplied   uint64
+	deadline      time.Time // When a follower or candidate starts the next election
+	leaderContact time.Time // When a leader was last heard from, pre-votes are refused before an election timeout
+
+	// Leader only
+	nextIndex   map[string]uint64    // Next entry to send to each peer
+	matchIndex  map[string]uint64    // Last entry known to be stored by each peer
+	lastContact map[string]time.Time // Last answer of each peer, for check quorum
+	round       uint64               // Bumped by ReadIndex, peers answering a later AppendEntries confirm the leadership
+	acked       map[string]uint64    // Latest round each peer answered
+	proposals   map[uint64]*proposal // By log index
+
+	changed chan struct{} // Closed and replaced when commitIndex, lastApplied, acked or the state change
+
+	applyMu   sync.Mutex    // Held while the state machine changes
+	applyCh   chan struct{} // Wakes up the applier
+	replicate map[string]chan struct{}
+	done      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+	stats     nodeStats
+}
+
+// Creates a node with DefaultOptions and starts it. id is how the other nodes reach this one through transport,
+// peers are the other nodes of the group (id may be listed too). Every node must be given the same group.
+func NewNode(id string, peers []string, sm StateMachine, transport Transport) (*Node, error) {
+	return NewNodeWithOptions(id, peers, sm, transport, DefaultOptions)
+}
+
+// Creates a node with custom options and starts it. Zero values fall back to DefaultOptions.
+func NewNodeWithOptions(id string, peers []string, sm StateMachine, transport Transport, opts Options) (*Node, error) {
+	if id == "" {
+		return nil, errors.New("raft: missing node id")
+	}
+	if opts.ElectionTimeout <= 0 {
+		opts.ElectionTimeout = DefaultOptions.ElectionTimeout
+	}
+	if opts.HeartbeatInterval <= 0 {
+		opts.HeartbeatInterval = DefaultOptions.HeartbeatInterval
+	}
+	if opts.SnapshotThreshold == 0 {
+		opts.SnapshotThreshold = DefaultOptions.SnapshotThreshold
+	}
+	if opts.MaxEntries <= 0 {
+		opts.MaxEntries = DefaultOptions.MaxEntries
+	}
+	storage := opts.Storage
+	if storage == nil {
+		storage = NewMemoryStorage()
+	}
+
+	n := &Node{
+		id:        id,
+		sm:        sm,
+		transport: transport,
+		storage:   storage,
+		options:   opts,
+		log:       []Entry{{}},
+		changed:   make(chan struct{}),
+		applyCh:   make(chan struct{}, 1),
+		replicate: make(map[string]chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := n.load(); err != nil {
+		return nil, err
+	}
+	seen := map[string]bool{id: true}
+	for _, peer := range peers {
+		if !seen[peer] {
+			seen[peer] = true
+			n.peers = append(n.peers, peer)
+			n.replicate[peer] = make(chan struct{}, 1)
+		}
+	}
+	n.resetElectionTimer()
+
+	n.wg.Add(2 + len(n.peers))
+	go n.run()
+	go n.applyLoop()
+	for _, peer := range n.peers {
+		go n.replicateLoop(peer)
+	}
+	return n, nil
+}
+
+// Will pick up the state saved in the node's storage, restoring the state machine from the snapshot
+func (n *Node) load() error {
+	saved, err := n.storage.Load()
+	if err != nil {
+		return fmt.Errorf("raft: loading saved state: %w", err)
+	}
+	for i, entry := range saved.Entries {
+		if entry.Index != saved.SnapshotIndex+uint64(i)+1 {
+			return errors.New("raft: loading saved state: the log has a gap")
+		}
+	}
+	if saved.Snapshot != nil {
+		if err := n.sm.Restore(bytes.NewReader(saved.Snapshot)); err != nil {
+			return fmt.Errorf("raft: restoring saved snapshot: %w", err)
+		}
+	}
+	n.term, n.votedFor = saved.Term, saved.VotedFor
+	n.log = append([]Entry{{Index: saved.SnapshotIndex, Term: saved.SnapshotTerm}}, saved.Entries...)
+	n.snapshot = saved.Snapshot
+	// Entries after the snapshot may have been committed, the leader tells once it is heard from
+	n.commitIndex, n.lastApplied = saved.SnapshotIndex, saved.SnapshotIndex
+	return nil
+}
+
+// Will save the term and the vote, and report whether it worked. Must be called while holding n.mu.
+func (n *Node) saveState() bool {
+	if err := n.storage.SaveState(n.term, n.votedFor); err != nil {
+		log.Printf("raft: saving term and vote: %v", err)
+		return false
+	}
+	return true
+}
+
+// Will stop the node. Pending proposals and reads fail with ErrClosed.
+func (n *Node) Close() {
+	n.closeOnce.Do(func() {
+		close(n.done)
+		n.wg.Wait()
+	})
+}
+
+func (n *Node) ID() string { return n.id }
+
+// Will return the other nodes of the group
+func (n *Node) Peers() []string { return append([]string(nil), n.peers...) }
+
+// Will return the node's role and current term
+func (n *Node) State() (State, uint64) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state, n.term
+}
+
+// Will return the ID of the current leader as far as this node knows, empty when there is none
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// Will append command to the log and wait until it was applied, returning what the state machine's Apply
+// returned. Only the leader takes proposals, other nodes return ErrNotLeader.
+func (n *Node) Propose(ctx context.Context, command []byte) (interface{}, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	index, err := n.appendEntry(EntryCommand, command)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	p := &proposal{term: n.term, done: make(chan proposalResult, 1)}
+	n.proposals[index] = p
+	n.mu.Unlock()
+	atomic.AddUint64(&n.stats.Proposals, 1)
+
+	select {
+	case result := <-p.done:
+		return result.value, result.err
+	case <-ctx.Done():
+		n.mu.Lock()
+		delete(n.proposals, index)
+		n.mu.Unlock()
+		return nil, ctx.Err()
+	case <-n.done:
+		return nil, ErrClosed
+	}
+}
+
+// Will return a commit index that covers every command applied anywhere before the call, after confirming with
+// a majority that this node is still the leader. Once the state machine has applied that index (see
+// WaitApplied), reading it is linearizable. Only the leader serves read indexes, other nodes return ErrNotLeader.
+func (n *Node) ReadIndex(ctx context.Context) (uint64, error) {
+	atomic.AddUint64(&n.stats.ReadIndexes, 1)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.state != Leader {
+		return 0, ErrNotLeader
+	}
+	term := n.term
+
+	// A new leader only knows the latest commit index once an entry of its own term is committed
+	if err := n.waitLocked(ctx, func() bool {
+		t, _ := n.termAt(n.commitIndex)
+		return n.state != Leader || n.term != term || t == term
+	}); err != nil {
+		return 0, err
+	}
+	if n.state != Leader || n.term != term {
+		return 0, ErrLeadershipLost
+	}
+	index := n.commitIndex
+
+	n.round++
+	round := n.round
+	n.triggerReplication()
+	if err := n.waitLocked(ctx, func() bool {
+		return n.state != Leader || n.term != term || n.countAcked(round) >= n.quorum()
+	}); err != nil {
+		return 0, err
+	}
+	if n.state != Leader || n.term != term {
+		return 0, ErrLeadershipLost
+	}
+	return index, nil
+}
+
+// Will wait until the state machine has applied the entry at index
+func (n *Node) WaitApplied(ctx context.Context, index uint64) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.waitLocked(ctx, func() bool { return n.lastApplied >= index })
+}
+
+// Will wait until cond is true. Must be called while holding n.mu, which is released while waiting.
+func (n *Node) waitLocked(ctx context.Context, cond func() bool) error {
+	for !cond() {
+		changed := n.changed
+		n.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			n.mu.Lock()
+			return ctx.Err()
+		case <-n.done:
+			n.mu.Lock()
+			return ErrClosed
+		}
+		n.mu.Lock()
+	}
+	return nil
+}
+
+// Will wake up everybody waiting in waitLocked. Must be called while holding n.mu.
+func (n *Node) notifyChanged() {
+	close(n.changed)
+	n.changed = make(chan struct{})
+}
+
+// Votes needed to win an election, or acks needed to commit
+func (n *Node) quorum() int {
+	return (len(n.peers)+1)/2 + 1
+}
+
+// Will count this node and the peers that answered round. Must be called while holding n.mu.
+func (n *Node) countAcked(round uint64) int {
+	count := 1
+	for _, peer := range n.peers {
+		if n.acked[peer] >= round {
+			count++
+		}
+	}
+	return count
+}
+
+// Must be called while holding n.mu
+func (n *Node) resetElectionTimer() {
+	timeout := n.options.ElectionTimeout + time.Duration(rand.Int63n(int64(n.options.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// Will step down to follower of leader (empty when unknown), moving to term when it is newer.
+// Returns false when the newer term could not be saved: the node still steps down, but stays in its term, so it
+// does not vote or acknowledge entries in a term it would forget on a restart. Must be called while holding n.mu.
+func (n *Node) becomeFollower(term uint64, leader string) bool {
+	saved := true
+	if term > n.term {
+		previousTerm, previousVote := n.term, n.votedFor
+		n.term = term
+		n.votedFor = ""
+		if !n.saveState() {
+			n.term, n.votedFor = previousTerm, previousVote
+			leader = ""
+			saved = false
+		}
+	}
+	if n.state == Leader {
+		for index, p := range n.proposals {
+			p.done <- proposalResult{err: ErrLeadershipLost}
+			delete(n.proposals, index)
+		}
+	}
+	if n.state != Follower || n.leader != leader {
+		n.state = Follower
+		n.leader = leader
+		n.notifyChanged()
+	}
+	return saved
+}
+
+// Must be called while holding n.mu
+func (n *Node) becomeLeader() {
+	n.state = Leader
+	n.leader = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.lastContact = make(map[string]time.Time)
+	n.acked = make(map[string]uint64)
+	n.proposals = make(map[uint64]*proposal)
+	now := time.Now()
+	for _, peer := range n.peers {
+		n.nextIndex[peer] = n.lastIndex() + 1
+		n.lastContact[peer] = now
+	}
+	// Committing an entry of the new term also commits every entry left over from earlier terms
+	if _, err := n.appendEntry(EntryNoop, nil); err != nil {
+		n.becomeFollower(n.term, "")
+		return
+	}
+	n.notifyChanged()
+}
+
+// Will save an entry to the leader's log and start replicating it. Must be called while holding n.mu.
+func (n *Node) appendEntry(t EntryType, command []byte) (uint64, error) {
+	entry := Entry{Index: n.lastIndex() + 1, Term: n.term, Type: t, Command: command}
+	// Saved first, the leader counts itself towards the majority that commits the entry
+	if err := n.storage.Append([]Entry{entry}); err != nil {
+		log.Printf("raft: saving entry %d: %v", entry.Index, err)
+		return 0, fmt.Errorf("raft: saving entry: %w", err)
+	}
+	n.log = append(n.log, entry)
+	n.triggerReplication()
+	n.maybeCommit() // A group of one commits right away
+	return entry.Index, nil
+}
+
+func (n *Node) triggerReplication() {
+	for _, ch := range n.replicate {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Will move the commit index to the latest entry of the current term stored by a majority.
+// Must be called while holding n.mu.
+func (n *Node) maybeCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if term, _ := n.termAt(index); term != n.term {
+			return // Entries of earlier terms are only committed along with one of the current term
+		}
+		count := 1
+		for _, peer := range n.peers {
+			if n.matchIndex[peer] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.setCommitIndex(index)
+			return
+		}
+	}
+}
+
+// Must be called while holding n.mu
+func (n *Node) setCommitIndex(index uint64) {
+	if index <= n.commitIndex {
+		return
+	}
+	n.commitIndex = index
+	n.notifyChanged()
+	select {
+	case n.applyCh <- struct{}{}:
+	default:
+	}
+}
+
+// Will start elections when the leader goes quiet, and make a leader step down when it loses the majority
+func (n *Node) run() {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.options.HeartbeatInterval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		now := time.Now()
+		switch {
+		case n.state == Leader:
+			contacted := 1
+			for _, peer := range n.peers {
+				if now.Sub(n.lastContact[peer]) < n.options.ElectionTimeout {
+					contacted++
+				}
+			}
+			if contacted < n.quorum() {
+				n.becomeFollower(n.term, "")
+				n.resetElectionTimer()
+			}
+		case now.After(n.deadline):
+			n.resetElectionTimer()
+			go n.campaign()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// Will hold a pre-vote, and an election when the pre-vote is won
+func (n *Node) campaign() {
+	n.mu.Lock()
+	if n.state == Leader {
+		n.mu.Unlock()
+		return
+	}
+	term := n.term
+	req := VoteRequest{Term: term + 1, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm(), PreVote: true}
+	n.mu.Unlock()
+	if !n.poll(req) {
+		return
+	}
+
+	n.mu.Lock()
+	if n.state == Leader || n.term != term {
+		n.mu.Unlock()
+		return // Heard from a leader or another candidate in the meantime
+	}
+	n.term++
+	n.votedFor = n.id
+	if !n.saveState() {
+		n.mu.Unlock()
+		return
+	}
+	n.state = Candidate
+	n.leader = ""
+	n.resetElectionTimer()
+	n.notifyChanged()
+	req = VoteRequest{Term: n.term, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	n.mu.Unlock()
+	atomic.AddUint64(&n.stats.Elections, 1)
+
+	won := n.poll(req)
+	n.mu.Lock()
+	if won && n.state == Candidate && n.term == req.Term {
+		n.becomeLeader()
+	}
+	n.mu.Unlock()
+}
+
+// Will ask every peer for its vote and report whether a majority granted it
+func (n *Node) poll(req VoteRequest) bool {
+	votes := 1
+	if votes >= n.quorum() {
+		return true
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), n.options.ElectionTimeout)
+	defer cancel()
+
+	granted := make(chan bool, len(n.peers))
+	for _, peer := range n.peers {
+		go func(peer string) {
+			resp, err := n.transport.RequestVote(ctx, peer, &req)
+			if err != nil {
+				granted <- false
+				return
+			}
+			if !resp.Granted {
+				n.mu.Lock()
+				if resp.Term > n.term {
+					n.becomeFollower(resp.Term, "")
+				}
+				n.mu.Unlock()
+			}
+			granted <- resp.Granted
+		}(peer)
+	}
+	for range n.peers {
+		select {
+		case ok := <-granted:
+			if ok {
+				votes++
+			}
+		case <-n.done:
+			return false
+		}
+		if votes >= n.quorum() {
+			return true
+		}
+	}
+	return false
+}
+
+// Will keep one peer up to date while this node is the leader, with heartbeats when there is nothing to send
+func (n *Node) replicateLoop(peer string) {
+	defer n.wg.Done()
+	ticker := time.NewTicker(n.options.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-ticker.C:
+		case <-n.replicate[peer]:
+		}
+		for n.replicateTo(peer) {
+			select {
+			case <-n.done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+// Will send the next entries (or the snapshot) to peer, and report whether there is more to send right away
+func (n *Node) replicateTo(peer string) bool {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.mu.Unlock()
+		return false
+	}
+	term, round := n.term, n.round
+	next := n.nextIndex[peer]
+	if next <= n.snapshotIndex() {
+		n.mu.Unlock()
+		return n.sendSnapshot(peer, term, round)
+	}
+	prev := next - 1
+	prevTerm, _ := n.termAt(prev)
+	req := &AppendRequest{
+		Term:         term,
+		Leader:       n.id,
+		PrevLogIndex: prev,
+		PrevLogTerm:  prevTerm,
+		Entries:      n.entriesFrom(next, n.options.MaxEntries),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.options.ElectionTimeout)
+	resp, err := n.transport.AppendEntries(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.acknowledged(peer, resp.Term, term, round) {
+		return false
+	}
+	if resp.Success {
+		if match := prev + uint64(len(req.Entries)); match > n.matchIndex[peer] {
+			n.matchIndex[peer] = match
+		}
+		n.nextIndex[peer] = n.matchIndex[peer] + 1
+		n.maybeCommit()
+		return n.nextIndex[peer] <= n.lastIndex()
+	}
+	// The logs differ before next, continue from where the follower says they might match
+	next = resp.ConflictIndex
+	if next <= n.matchIndex[peer] {
+		next = n.matchIndex[peer] + 1
+	}
+	if next < 1 {
+		next = 1
+	}
+	// A follower that could not save the entries answers with the same index, retried on the next heartbeat
+	moved := next != n.nextIndex[peer]
+	n.nextIndex[peer] = next
+	return moved
+}
+
+// Will send the snapshot to a peer that needs entries the log no longer has
+func (n *Node) sendSnapshot(peer string, term, round uint64) bool {
+	n.mu.Lock()
+	req := &SnapshotRequest{Term: term, Leader: n.id, LastIndex: n.log[0].Index, LastTerm: n.log[0].Term, Data: n.snapshot}
+	n.mu.Unlock()
+
+	// Snapshots can be large, they get longer than other messages
+	ctx, cancel := context.WithTimeout(context.Background(), 10*n.options.ElectionTimeout)
+	resp, err := n.transport.InstallSnapshot(ctx, peer, req)
+	cancel()
+	if err != nil {
+		return false
+	}
+	atomic.AddUint64(&n.stats.SnapshotsSent, 1)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if !n.acknowledged(peer, resp.Term, term, round) || !resp.Success {
+		return false
+	}
+	if req.LastIndex > n.matchIndex[peer] {
+		n.matchIndex[peer] = req.LastIndex
+	}
+	n.nextIndex[peer] = n.matchIndex[peer] + 1
+	n.maybeCommit()
+	return n.nextIndex[peer] <= n.lastIndex()
+}
+
+// Will handle the term of a peer's answer to a request sent in term and round, and report whether this node
+// is still the leader of that term. Must be called while holding n.mu.
+func (n *Node) acknowledged(peer string, respTerm, term, round uint64) bool {
+	if respTerm > n.term {
+		n.becomeFollower(respTerm, "")
+		n.resetElectionTimer()
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	n.lastContact[peer] = time.Now()
+	if round > n.acked[peer] {
+		n.acked[peer] = round
+		n.notifyChanged()
+	}
+	return true
+}
+
+// Will apply committed entries to the state machine, and compact the log when it grew long enough
+func (n *Node) applyLoop() {
+	defer n.wg.Done()
+	for {
+		select {
+		case <-n.done:
+			return
+		case <-n.applyCh:
+		}
+		n.applyCommitted()
+	}
+}
+
+func (n *Node) applyCommitted() {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= n.commitIndex {
+			n.mu.Unlock()
+			break
+		}
+		entries := n.entriesBetween(n.lastApplied+1, n.commitIndex)
+		n.mu.Unlock()
+
+		for _, entry := range entries {
+			var value interface{}
+			if entry.Type == EntryCommand {
+				value = n.sm.Apply(entry.Index, entry.Command)
+			}
+			n.mu.Lock()
+			n.lastApplied = entry.Index
+			p := n.proposals[entry.Index]
+			delete(n.proposals, entry.Index)
+			n.notifyChanged()
+			n.mu.Unlock()
+
+			if p != nil {
+				if p.term == entry.Term {
+					p.done <- proposalResult{value: value}
+				} else {
+					p.done <- proposalResult{err: ErrLeadershipLost} // Replaced by another leader's entry
+				}
+			}
+		}
+	}
+	n.maybeSnapshot()
+}
+
+// Will compact the log into a snapshot once SnapshotThreshold entries were applied since the last one.
+// Must be called while holding n.applyMu, so the state machine matches lastApplied.
+func (n *Node) maybeSnapshot() {
+	n.mu.Lock()
+	applied := n.lastApplied
+	due := applied-n.snapshotIndex() >= n.options.SnapshotThreshold
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := n.sm.Snapshot(&buf); err != nil {
+		return // Tried again after the next entries
+	}
+	n.mu.Lock()
+	term, _ := n.termAt(applied)
+	var rest []Entry
+	if applied < n.lastIndex() {
+		rest = n.entriesBetween(applied+1, n.lastIndex())
+	}
+	if err := n.storage.SaveSnapshot(applied, term, buf.Bytes(), rest); err != nil {
+		n.mu.Unlock()
+		log.Printf("raft: saving snapshot: %v", err)
+		return // Tried again after the next entries
+	}
+	n.compact(applied, term)
+	n.snapshot = buf.Bytes()
+	n.mu.Unlock()
+	atomic.AddUint64(&n.stats.SnapshotsTaken, 1)
+}
+
+// Will return the node's counters, and its view of the log
+func (n *Node) GetStats() map[string]uint64 {
+	n.mu.Lock()
+	leader := uint64(0)
+	if n.state == Leader {
+		leader = 1
+	}
+	stats := map[string]uint64{
+		"term":           n.term,
+		"leader":         leader,
+		"last_index":     n.lastIndex(),
+		"commit_index":   n.commitIndex,
+		"applied_index":  n.lastApplied,
+		"snapshot_index": n.snapshotIndex(),
+	}
+	n.mu.Unlock()
+
+	stats["elections"] = atomic.LoadUint64(&n.stats.Elections)
+	stats["proposals"] = atomic.LoadUint64(&n.stats.Proposals)
+	stats["read_indexes"] = atomic.LoadUint64(&n.stats.ReadIndexes)
+	stats["snapshots_taken"] = atomic.LoadUint64(&n.stats.SnapshotsTaken)
+	stats["snapshots_sent"] = atomic.LoadUint64(&n.stats.SnapshotsSent)
+	stats["snapshots_installed"] = atomic.LoadUint64(&n.stats.SnapshotsInstalled)
+	return stats
+}
@@ -0,0 +1,467 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Short timings, so elections take a few hundred milliseconds
+var testOptions = Options{
+	ElectionTimeout:   150 * time.Millisecond,
+	HeartbeatInterval: 20 * time.Millisecond,
+	SnapshotThreshold: 1000,
+}
+
+// Keeps every applied command, in order
+type logMachine struct {
+	mu      sync.Mutex
+	applied []string
+}
+
+func (m *logMachine) Apply(index uint64, command []byte) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.applied = append(m.applied, string(command))
+	return len(m.applied)
+}
+
+func (m *logMachine) Snapshot(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return json.NewEncoder(w).Encode(m.applied)
+}
+
+func (m *logMachine) Restore(r io.Reader) error {
+	var applied []string
+	if err := json.NewDecoder(r).Decode(&applied); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.applied = applied
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *logMachine) commands() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.applied...)
+}
+
+type testCluster struct {
+	t        *testing.T
+	network  *MemNetwork
+	ids      []string
+	nodes    map[string]*Node
+	machines map[string]*logMachine
+	storages map[string]*MemoryStorage
+}
+
+// Will start n nodes connected by a MemNetwork
+func newTestCluster(t *testing.T, n int, opts Options) *testCluster {
+	t.Helper()
+	tc := &testCluster{
+		t:        t,
+		network:  NewMemNetwork(),
+		nodes:    make(map[string]*Node),
+		machines: make(map[string]*logMachine),
+		storages: make(map[string]*MemoryStorage),
+	}
+	for i := 0; i < n; i++ {
+		tc.ids = append(tc.ids, fmt.Sprintf("node-%d", i))
+	}
+	for _, id := range tc.ids {
+		tc.start(id, opts)
+	}
+	t.Cleanup(func() {
+		for _, node := range tc.nodes {
+			node.Close()
+		}
+	})
+	return tc
+}
+
+// Will start (or restart, with what it saved) the node with the given ID
+func (tc *testCluster) start(id string, opts Options) {
+	tc.t.Helper()
+	if old, ok := tc.nodes[id]; ok {
+		old.Close()
+	}
+	if tc.storages[id] == nil {
+		tc.storages[id] = NewMemoryStorage()
+	}
+	opts.Storage = tc.storages[id]
+	machine := &logMachine{}
+	node, err := NewNodeWithOptions(id, tc.ids, machine, tc.network.Transport(id), opts)
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	tc.nodes[id] = node
+	tc.machines[id] = machine
+	tc.network.Add(node)
+}
+
+// Will wait until exactly one of ids is leader, and every one of them agrees on it
+func (tc *testCluster) waitLeader(ids ...string) *Node {
+	tc.t.Helper()
+	if len(ids) == 0 {
+		ids = tc.ids
+	}
+	var leader *Node
+	waitFor(tc.t, "a leader", func() bool {
+		leader = nil
+		for _, id := range ids {
+			if state, _ := tc.nodes[id].State(); state == Leader {
+				if leader != nil {
+					return false
+				}
+				leader = tc.nodes[id]
+			}
+		}
+		if leader == nil {
+			return false
+		}
+		for _, id := range ids {
+			if tc.nodes[id].Leader() != leader.ID() {
+				return false
+			}
+		}
+		return true
+	})
+	return leader
+}
+
+// Will wait until each of ids applied exactly want
+func (tc *testCluster) waitApplied(want []string, ids ...string) {
+	tc.t.Helper()
+	for _, id := range ids {
+		waitFor(tc.t, id+" to apply every command", func() bool {
+			return fmt.Sprint(tc.machines[id].commands()) == fmt.Sprint(want)
+		})
+	}
+}
+
+func without(ids []string, id string) []string {
+	var rest []string
+	for _, other := range ids {
+		if other != id {
+			rest = append(rest, other)
+		}
+	}
+	return rest
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func propose(t *testing.T, node *Node, command string) interface{} {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	result, err := node.Propose(ctx, []byte(command))
+	if err != nil {
+		t.Fatalf("Proposing %q: %v", command, err)
+	}
+	return result
+}
+
+func TestElectionAndReplication(t *testing.T) {
+	tc := newTestCluster(t, 3, testOptions)
+	leader := tc.waitLeader()
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		command := fmt.Sprintf("cmd-%d", i)
+		want = append(want, command)
+		if result := propose(t, leader, command); result != i+1 {
+			t.Errorf("Expected Apply's result %d, got %v", i+1, result)
+		}
+	}
+	tc.waitApplied(want, tc.ids...)
+
+	for _, id := range without(tc.ids, leader.ID()) {
+		if _, err := tc.nodes[id].Propose(context.Background(), []byte("x")); !errors.Is(err, ErrNotLeader) {
+			t.Errorf("Expected followers to refuse proposals, got %v", err)
+		}
+	}
+}
+
+func TestSingleNode(t *testing.T) {
+	tc := newTestCluster(t, 1, testOptions)
+	leader := tc.waitLeader()
+	propose(t, leader, "a")
+	if _, err := leader.ReadIndex(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tc.waitApplied([]string{"a"}, leader.ID())
+}
+
+// Will test that the others elect a new leader when the leader is cut off, and that the old leader neither
+// commits nor serves reads while it is alone
+func TestLeaderPartition(t *testing.T) {
+	tc := newTestCluster(t, 5, testOptions)
+	old := tc.waitLeader()
+	propose(t, old, "before")
+
+	rest := without(tc.ids, old.ID())
+	tc.network.Partition([]string{old.ID()}, rest)
+	leader := tc.waitLeader(rest...)
+	propose(t, leader, "after")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	if _, err := old.Propose(ctx, []byte("lost")); err == nil {
+		t.Error("Expected the cut off leader not to commit")
+	}
+	if _, err := old.ReadIndex(ctx); err == nil {
+		t.Error("Expected the cut off leader not to serve reads")
+	}
+	waitFor(t, "the old leader to step down", func() bool {
+		state, _ := old.State()
+		return state != Leader
+	})
+
+	tc.network.Heal()
+	propose(t, tc.waitLeader(), "healed")
+	tc.waitApplied([]string{"before", "after", "healed"}, tc.ids...)
+}
+
+// Will test that a node coming back from a partition does not depose the leader, thanks to pre-vote
+func TestPreVote(t *testing.T) {
+	tc := newTestCluster(t, 3, testOptions)
+	leader := tc.waitLeader()
+	_, term := leader.State()
+
+	follower := without(tc.ids, leader.ID())[0]
+	tc.network.Partition([]string{follower}, without(tc.ids, follower))
+	time.Sleep(5 * testOptions.ElectionTimeout) // The follower keeps trying to get elected
+	tc.network.Heal()
+	time.Sleep(5 * testOptions.ElectionTimeout)
+
+	if state, newTerm := leader.State(); state != Leader || newTerm != term {
+		t.Errorf("Expected the leader to stay in term %d, got %s in term %d", term, state, newTerm)
+	}
+	if _, followerTerm := tc.nodes[follower].State(); followerTerm != term {
+		t.Errorf("Expected the returning node to stay in term %d, got %d", term, followerTerm)
+	}
+}
+
+// Will test that a minority partition can't elect a leader, so there is never more than one leader able to commit
+func TestMinorityPartition(t *testing.T) {
+	tc := newTestCluster(t, 5, testOptions)
+	tc.waitLeader()
+	tc.network.Partition(tc.ids[:2], tc.ids[2:])
+	leader := tc.waitLeader(tc.ids[2:]...)
+	propose(t, leader, "majority")
+
+	time.Sleep(5 * testOptions.ElectionTimeout)
+	for _, id := range tc.ids[:2] {
+		if state, _ := tc.nodes[id].State(); state == Leader {
+			t.Errorf("Expected %s in the minority not to be leader", id)
+		}
+		if len(tc.machines[id].commands()) != 0 {
+			t.Errorf("Expected %s in the minority not to apply anything", id)
+		}
+	}
+
+	tc.network.Heal()
+	tc.waitApplied([]string{"majority"}, tc.ids...)
+}
+
+// Will test that a node that lost its state catches up through a snapshot once the log was compacted
+func TestSnapshotCatchUp(t *testing.T) {
+	opts := testOptions
+	opts.SnapshotThreshold = 10
+	opts.MaxEntries = 4
+	tc := newTestCluster(t, 3, opts)
+	leader := tc.waitLeader()
+
+	lagging := without(tc.ids, leader.ID())[0]
+	tc.network.Partition(without(tc.ids, lagging))
+	var want []string
+	for i := 0; i < 35; i++ {
+		command := fmt.Sprintf("cmd-%d", i)
+		want = append(want, command)
+		propose(t, leader, command)
+	}
+	if leader.GetStats()["snapshots_taken"] == 0 {
+		t.Fatal("Expected the leader to compact its log")
+	}
+
+	tc.start(lagging, opts) // Still behind the leader's snapshot after the restart
+	tc.network.Heal()
+	propose(t, leader, "last")
+	want = append(want, "last")
+	tc.waitApplied(want, tc.ids...)
+	if tc.nodes[lagging].GetStats()["snapshots_installed"] == 0 {
+		t.Error("Expected the lagging node to install a snapshot")
+	}
+}
+
+// Will test that the log survives leaders crashing one after the other, as long as a majority is up
+func TestLeaderCrashes(t *testing.T) {
+	tc := newTestCluster(t, 3, testOptions)
+	var want []string
+	for round := 0; round < 3; round++ {
+		leader := tc.waitLeader()
+		for i := 0; i < 5; i++ {
+			command := fmt.Sprintf("round-%d-%d", round, i)
+			want = append(want, command)
+			propose(t, leader, command)
+		}
+		tc.waitApplied(want, tc.ids...)
+		tc.start(leader.ID(), testOptions) // Restarts from its saved log, and catches up from the new leader
+	}
+	tc.waitLeader()
+	tc.waitApplied(want, tc.ids...)
+}
+
+// Will test that ReadIndex waits for every acknowledged write
+func TestReadIndex(t *testing.T) {
+	tc := newTestCluster(t, 3, testOptions)
+	leader := tc.waitLeader()
+	for i := 0; i < 5; i++ {
+		propose(t, leader, fmt.Sprint(i))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	index, err := leader.ReadIndex(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index < 6 { // 5 commands and the leader's no-op
+		t.Errorf("Expected the read index to include every write, got %d", index)
+	}
+	if err := leader.WaitApplied(ctx, index); err != nil {
+		t.Fatal(err)
+	}
+	if len(tc.machines[leader.ID()].commands()) != 5 {
+		t.Errorf("Expected every write to be applied at the read index")
+	}
+	follower := tc.nodes[without(tc.ids, leader.ID())[0]]
+	if _, err := follower.ReadIndex(ctx); !errors.Is(err, ErrNotLeader) {
+		t.Errorf("Expected followers to refuse read indexes, got %v", err)
+	}
+}
+
+// Will test that a restarted node keeps its term, its vote and its log
+func TestRestart(t *testing.T) {
+	storage := NewMemoryStorage()
+	opts := testOptions
+	opts.Storage = storage
+	machine := &logMachine{}
+	node, err := NewNodeWithOptions("node-0", []string{"node-0"}, machine, NewMemNetwork().Transport("node-0"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "a leader", func() bool { state, _ := node.State(); return state == Leader })
+	propose(t, node, "a")
+	propose(t, node, "b")
+	_, term := node.State()
+	node.Close()
+
+	machine = &logMachine{}
+	node, err = NewNodeWithOptions("node-0", []string{"node-0"}, machine, NewMemNetwork().Transport("node-0"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+	waitFor(t, "a leader", func() bool { state, _ := node.State(); return state == Leader })
+	if _, restarted := node.State(); restarted <= term {
+		t.Errorf("Expected a term after %d, got %d", term, restarted)
+	}
+	propose(t, node, "c")
+	if got := fmt.Sprint(machine.commands()); got != "[a b c]" {
+		t.Errorf("Expected the saved log to be applied again, got %s", got)
+	}
+}
+
+// Will test that a node does not vote twice in a term across a restart
+func TestRestartKeepsVote(t *testing.T) {
+	storage := NewMemoryStorage()
+	opts := testOptions
+	opts.ElectionTimeout = time.Hour // Never campaigns itself
+	opts.Storage = storage
+	ids := []string{"node-0", "node-1", "node-2"}
+	start := func() *Node {
+		node, err := NewNodeWithOptions("node-0", ids, &logMachine{}, NewMemNetwork().Transport("node-0"), opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return node
+	}
+
+	node := start()
+	if resp := node.HandleRequestVote(&VoteRequest{Term: 5, Candidate: "node-1"}); !resp.Granted {
+		t.Fatal("Expected the first vote of the term to be granted")
+	}
+	node.Close()
+
+	node = start()
+	defer node.Close()
+	if resp := node.HandleRequestVote(&VoteRequest{Term: 5, Candidate: "node-2"}); resp.Granted || resp.Term != 5 {
+		t.Errorf("Expected the second vote of term 5 to be refused, got %+v", resp)
+	}
+	if resp := node.HandleRequestVote(&VoteRequest{Term: 5, Candidate: "node-1"}); !resp.Granted {
+		t.Error("Expected the vote to be granted again to the same candidate")
+	}
+}
+
+// A MemoryStorage that fails to save the term and vote while fail is set
+type failingStorage struct {
+	*MemoryStorage
+	fail bool
+}
+
+func (s *failingStorage) SaveState(term uint64, votedFor string) error {
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return s.MemoryStorage.SaveState(term, votedFor)
+}
+
+// Will test that a node that can't save a newer term neither votes nor acknowledges entries in it
+func TestUnsavedTerm(t *testing.T) {
+	storage := &failingStorage{MemoryStorage: NewMemoryStorage(), fail: true}
+	opts := testOptions
+	opts.ElectionTimeout = time.Hour // Never campaigns itself
+	opts.Storage = storage
+	ids := []string{"node-0", "node-1", "node-2"}
+	node, err := NewNodeWithOptions("node-0", ids, &logMachine{}, NewMemNetwork().Transport("node-0"), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.Close()
+
+	if resp := node.HandleRequestVote(&VoteRequest{Term: 5, Candidate: "node-1"}); resp.Granted || resp.Term != 0 {
+		t.Errorf("Expected the vote to be refused in the saved term, got %+v", resp)
+	}
+	if resp := node.HandleAppendEntries(&AppendRequest{Term: 5, Leader: "node-1"}); resp.Success || resp.Term != 0 {
+		t.Errorf("Expected the entries to be refused in the saved term, got %+v", resp)
+	}
+	if leader := node.Leader(); leader != "" {
+		t.Errorf("Expected no leader to be followed, got %q", leader)
+	}
+
+	storage.fail = false
+	if resp := node.HandleAppendEntries(&AppendRequest{Term: 5, Leader: "node-1"}); !resp.Success || resp.Term != 5 {
+		t.Errorf("Expected the entries to be acknowledged once the term is saved, got %+v", resp)
+	}
+	if saved, _ := storage.Load(); saved.Term != 5 {
+		t.Errorf("Expected term 5 to be saved, got %d", saved.Term)
+	}
+}
@@ -0,0 +1,201 @@
+package raft
+
+import (
+	"bytes"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// Messages exchanged between nodes, named after the RPCs of the paper
+
+type VoteRequest struct {
+	Term         uint64
+	Candidate    string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+	PreVote      bool // Only asks whether the vote would be granted, nothing changes on the voter
+}
+
+type VoteResponse struct {
+	Term    uint64
+	Granted bool
+}
+
+type AppendRequest struct {
+	Term         uint64
+	Leader       string
+	PrevLogIndex uint64 // Entry just before Entries, which the follower must have for them to be appended
+	PrevLogTerm  uint64
+	Entries      []Entry // Empty for heartbeats
+	LeaderCommit uint64
+}
+
+type AppendResponse struct {
+	Term          uint64
+	Success       bool
+	ConflictIndex uint64 // When Success is false, where the leader should continue from
+}
+
+type SnapshotRequest struct {
+	Term      uint64
+	Leader    string
+	LastIndex uint64 // Last entry the snapshot covers
+	LastTerm  uint64
+	Data      []byte
+}
+
+type SnapshotResponse struct {
+	Term    uint64
+	Success bool
+}
+
+// Will answer a vote request from a candidate. Called by the Transport.
+func (n *Node) HandleRequestVote(req *VoteRequest) *VoteResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.PreVote {
+		// Refused while a leader is around, so a node returning from a partition can't depose it
+		heardFromLeader := n.state == Leader || time.Since(n.leaderContact) < n.options.ElectionTimeout
+		granted := req.Term > n.term && !heardFromLeader && n.upToDate(req.LastLogIndex, req.LastLogTerm)
+		return &VoteResponse{Term: n.term, Granted: granted}
+	}
+
+	if req.Term < n.term {
+		return &VoteResponse{Term: n.term}
+	}
+	if req.Term > n.term && !n.becomeFollower(req.Term, "") {
+		return &VoteResponse{Term: n.term}
+	}
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && n.upToDate(req.LastLogIndex, req.LastLogTerm) {
+		previous := n.votedFor
+		n.votedFor = req.Candidate
+		if !n.saveState() {
+			n.votedFor = previous
+			return &VoteResponse{Term: n.term}
+		}
+		n.resetElectionTimer()
+		return &VoteResponse{Term: n.term, Granted: true}
+	}
+	return &VoteResponse{Term: n.term}
+}
+
+// Will append the leader's entries to the log, after checking the log matches up to them. Called by the Transport.
+func (n *Node) HandleAppendEntries(req *AppendRequest) *AppendResponse {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &AppendResponse{Term: n.term}
+	}
+	if !n.heardFrom(req.Term, req.Leader) {
+		return &AppendResponse{Term: n.term, ConflictIndex: req.PrevLogIndex + 1}
+	}
+
+	prev, entries := req.PrevLogIndex, req.Entries
+	if prev < n.snapshotIndex() {
+		// Already covered by the snapshot, and therefore committed
+		skip := n.snapshotIndex() - prev
+		if skip >= uint64(len(entries)) {
+			return &AppendResponse{Term: n.term, Success: true}
+		}
+		prev, entries = n.snapshotIndex(), entries[skip:]
+	} else if prev > n.lastIndex() {
+		return &AppendResponse{Term: n.term, ConflictIndex: n.lastIndex() + 1}
+	} else if term, _ := n.termAt(prev); term != req.PrevLogTerm {
+		// Skip the whole conflicting term at once instead of one entry per round trip
+		conflict := prev
+		for conflict > n.snapshotIndex()+1 {
+			if t, _ := n.termAt(conflict - 1); t != term {
+				break
+			}
+			conflict--
+		}
+		return &AppendResponse{Term: n.term, ConflictIndex: conflict}
+	}
+
+	for i, entry := range entries {
+		term, ok := n.termAt(entry.Index)
+		if ok && term == entry.Term {
+			continue
+		}
+		// Saved before the log changes, entries that were acknowledged must survive a restart
+		if err := n.storage.Append(entries[i:]); err != nil {
+			log.Printf("raft: saving entries from %d: %v", entry.Index, err)
+			return &AppendResponse{Term: n.term, ConflictIndex: req.PrevLogIndex + 1}
+		}
+		if ok {
+			// Never committed, the leader's entries replace it and everything after it
+			n.log = n.log[:entry.Index-n.snapshotIndex()]
+		}
+		n.log = append(n.log, entries[i:]...)
+		break
+	}
+
+	if last := prev + uint64(len(entries)); req.LeaderCommit > n.commitIndex {
+		commit := req.LeaderCommit
+		if commit > last {
+			commit = last
+		}
+		n.setCommitIndex(commit)
+	}
+	return &AppendResponse{Term: n.term, Success: true}
+}
+
+// Will replace the state machine and the log with the leader's snapshot. Called by the Transport.
+func (n *Node) HandleInstallSnapshot(req *SnapshotRequest) *SnapshotResponse {
+	n.applyMu.Lock()
+	defer n.applyMu.Unlock()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if req.Term < n.term {
+		return &SnapshotResponse{Term: n.term}
+	}
+	if !n.heardFrom(req.Term, req.Leader) {
+		return &SnapshotResponse{Term: n.term}
+	}
+	if req.LastIndex <= n.lastApplied {
+		return &SnapshotResponse{Term: n.term, Success: true} // Already there
+	}
+
+	// Entries after the snapshot are still good when the log has the entry it ends with
+	term, keep := n.termAt(req.LastIndex)
+	keep = keep && term == req.LastTerm
+	var rest []Entry
+	if keep && req.LastIndex < n.lastIndex() {
+		rest = n.entriesBetween(req.LastIndex+1, n.lastIndex())
+	}
+	if err := n.storage.SaveSnapshot(req.LastIndex, req.LastTerm, req.Data, rest); err != nil {
+		log.Printf("raft: saving snapshot: %v", err)
+		return &SnapshotResponse{Term: n.term}
+	}
+	if err := n.sm.Restore(bytes.NewReader(req.Data)); err != nil {
+		return &SnapshotResponse{Term: n.term}
+	}
+	if keep {
+		n.compact(req.LastIndex, req.LastTerm)
+	} else {
+		n.log = []Entry{{Index: req.LastIndex, Term: req.LastTerm}}
+	}
+	n.snapshot = req.Data
+	n.lastApplied = req.LastIndex
+	if req.LastIndex > n.commitIndex {
+		n.commitIndex = req.LastIndex
+	}
+	n.notifyChanged()
+	atomic.AddUint64(&n.stats.SnapshotsInstalled, 1)
+	return &SnapshotResponse{Term: n.term, Success: true}
+}
+
+// Will follow the leader of term, which just contacted this node. Returns false when its term could not be saved,
+// and the leader must not be answered. Must be called while holding n.mu.
+func (n *Node) heardFrom(term uint64, leader string) bool {
+	if !n.becomeFollower(term, leader) {
+		return false
+	}
+	n.leaderContact = time.Now()
+	n.resetElectionTimer()
+	return true
+}
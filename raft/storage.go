@@ -0,0 +1,334 @@
+package raft
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Where a node keeps what it must not forget when it restarts: its term, its vote, its log and its snapshot.
+// A node saves every change before acting on it (granting a vote, acknowledging entries, counting its own
+// entries towards a commit), so a restarted node never votes twice in a term or loses an entry it acknowledged.
+//
+// The methods are only called while the node holds its lock, one at a time.
+type Storage interface {
+	// Will return everything saved so far, the zero value for a new node
+	Load() (SavedState, error)
+	// Will save the current term and the vote given in it (empty when none)
+	SaveState(term uint64, votedFor string) error
+	// Will save entries after the saved log. Saved entries from the index of the first one on are replaced,
+	// since a follower's entries that were never committed can be overwritten by the leader.
+	Append(entries []Entry) error
+	// Will replace the saved snapshot and log with a snapshot of the state machine at index and the entries after it
+	SaveSnapshot(index, term uint64, data []byte, entries []Entry) error
+}
+
+// What a Storage returns when a node starts
+type SavedState struct {
+	Term          uint64
+	VotedFor      string
+	SnapshotIndex uint64 // Last entry the snapshot covers, 0 when there is none
+	SnapshotTerm  uint64
+	Snapshot      []byte
+	Entries       []Entry // Following the snapshot
+}
+
+// Keeps the state in memory. A node restarted with the same MemoryStorage picks up where it left, which is
+// what tests use to simulate a restart. A node given a new one starts out empty, like a new member of the group.
+type MemoryStorage struct {
+	mu    sync.Mutex
+	state SavedState
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (s *MemoryStorage) Load() (SavedState, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	state := s.state
+	state.Entries = append([]Entry(nil), s.state.Entries...)
+	return state, nil
+}
+
+func (s *MemoryStorage) SaveState(term uint64, votedFor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Term, s.state.VotedFor = term, votedFor
+	return nil
+}
+
+func (s *MemoryStorage) Append(entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.Entries = appendEntries(s.state.Entries, entries)
+	return nil
+}
+
+func (s *MemoryStorage) SaveSnapshot(index, term uint64, data []byte, entries []Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state.SnapshotIndex, s.state.SnapshotTerm, s.state.Snapshot = index, term, data
+	s.state.Entries = append([]Entry(nil), entries...)
+	return nil
+}
+
+// Will append entries to log, dropping the entries of log from the index of the first one on
+func appendEntries(log, entries []Entry) []Entry {
+	if len(entries) == 0 {
+		return log
+	}
+	first := entries[0].Index
+	for len(log) > 0 && log[len(log)-1].Index >= first {
+		log = log[:len(log)-1]
+	}
+	return append(log, entries...)
+}
+
+// Keeps the state in files in a directory, synced to disk before every method returns:
+//   - "state" holds the term and the vote, rewritten on every change
+//   - "snapshot" holds the latest snapshot
+//   - "log" holds the entries after the snapshot, appended to as they come. An entry replacing earlier ones
+//     is appended too, and replaces them when the log is read back. The file is rewritten on every snapshot.
+//
+// Files are replaced by writing a temporary file and renaming it, and every record has a checksum, so a crash
+// in the middle of a write leaves the previous state (a torn entry at the end of the log is dropped).
+type FileStorage struct {
+	dir string
+	log *os.File
+}
+
+var errCorruptStorage = errors.New("raft: corrupt storage")
+
+// Opens the storage in dir, creating dir when it does not exist
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+// Will close the log file
+func (s *FileStorage) Close() error {
+	if s.log == nil {
+		return nil
+	}
+	err := s.log.Close()
+	s.log = nil
+	return err
+}
+
+func (s *FileStorage) path(name string) string {
+	return filepath.Join(s.dir, name)
+}
+
+func (s *FileStorage) Load() (SavedState, error) {
+	var state SavedState
+	if b, err := readRecordFile(s.path("state")); err != nil {
+		return state, err
+	} else if b != nil {
+		r := bytes.NewReader(b)
+		var err error
+		if state.Term, err = binary.ReadUvarint(r); err != nil {
+			return state, errCorruptStorage
+		}
+		state.VotedFor = string(b[len(b)-r.Len():])
+	}
+
+	if b, err := readRecordFile(s.path("snapshot")); err != nil {
+		return state, err
+	} else if b != nil {
+		r := bytes.NewReader(b)
+		var err1, err2 error
+		state.SnapshotIndex, err1 = binary.ReadUvarint(r)
+		state.SnapshotTerm, err2 = binary.ReadUvarint(r)
+		if err1 != nil || err2 != nil {
+			return state, errCorruptStorage
+		}
+		state.Snapshot = b[len(b)-r.Len():]
+	}
+
+	b, err := os.ReadFile(s.path("log"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return state, err
+	}
+	var valid int // Length of the records that are whole, a crash may have left a torn one after them
+	for rest := b; ; {
+		record, n := decodeRecord(rest)
+		if n == 0 {
+			break
+		}
+		entry, err := decodeEntry(record)
+		if err != nil {
+			break
+		}
+		valid += n
+		rest = rest[n:]
+		if entry.Index > state.SnapshotIndex {
+			state.Entries = appendEntries(state.Entries, []Entry{entry})
+		}
+	}
+	if err := s.openLog(int64(valid)); err != nil {
+		return state, err
+	}
+	return state, nil
+}
+
+// Will open the log for appending, cut at size to drop a torn record
+func (s *FileStorage) openLog(size int64) error {
+	s.Close()
+	f, err := os.OpenFile(s.path("log"), os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := f.Truncate(size); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Seek(size, io.SeekStart); err != nil {
+		f.Close()
+		return err
+	}
+	s.log = f
+	return nil
+}
+
+func (s *FileStorage) SaveState(term uint64, votedFor string) error {
+	b := binary.AppendUvarint(nil, term)
+	b = append(b, votedFor...)
+	return s.writeFile("state", encodeRecord(nil, b))
+}
+
+func (s *FileStorage) Append(entries []Entry) error {
+	if s.log == nil {
+		if err := s.openLog(0); err != nil {
+			return err
+		}
+	}
+	var buf []byte
+	for _, entry := range entries {
+		buf = encodeRecord(buf, encodeEntry(entry))
+	}
+	if _, err := s.log.Write(buf); err != nil {
+		return err
+	}
+	return s.log.Sync()
+}
+
+func (s *FileStorage) SaveSnapshot(index, term uint64, data []byte, entries []Entry) error {
+	b := binary.AppendUvarint(nil, index)
+	b = binary.AppendUvarint(b, term)
+	b = append(b, data...)
+	if err := s.writeFile("snapshot", encodeRecord(nil, b)); err != nil {
+		return err
+	}
+
+	// The log still has the entries the snapshot covers until it is rewritten, they are skipped when read back
+	var buf []byte
+	for _, entry := range entries {
+		buf = encodeRecord(buf, encodeEntry(entry))
+	}
+	if err := s.writeFile("log", buf); err != nil {
+		return err
+	}
+	return s.openLog(int64(len(buf)))
+}
+
+// Will replace the file name with data, synced to disk
+func (s *FileStorage) writeFile(name string, data []byte) error {
+	tmp, err := os.CreateTemp(s.dir, name+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // Fails once renamed
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path(name)); err != nil {
+		return err
+	}
+	// The rename itself is only durable once the directory is synced
+	dir, err := os.Open(s.dir)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+// Will read a file holding a single record, nil when the file does not exist
+func readRecordFile(path string) ([]byte, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record, n := decodeRecord(b)
+	if n == 0 || n != len(b) {
+		return nil, fmt.Errorf("%w: %s", errCorruptStorage, path)
+	}
+	return record, nil
+}
+
+// Records are the length of the payload and its CRC-32, 4 bytes each, followed by the payload
+func encodeRecord(buf, payload []byte) []byte {
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(payload)))
+	buf = binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(payload))
+	return append(buf, payload...)
+}
+
+// Will return the payload of the record at the start of b and the length of the record, 0 when b does not
+// start with a whole, valid record
+func decodeRecord(b []byte) ([]byte, int) {
+	if len(b) < 8 {
+		return nil, 0
+	}
+	size := uint64(binary.LittleEndian.Uint32(b))
+	if uint64(len(b)-8) < size {
+		return nil, 0
+	}
+	payload := b[8 : 8+size]
+	if crc32.ChecksumIEEE(payload) != binary.LittleEndian.Uint32(b[4:]) {
+		return nil, 0
+	}
+	return payload, 8 + int(size)
+}
+
+func encodeEntry(entry Entry) []byte {
+	b := binary.AppendUvarint(nil, entry.Index)
+	b = binary.AppendUvarint(b, entry.Term)
+	b = append(b, byte(entry.Type))
+	return append(b, entry.Command...)
+}
+
+func decodeEntry(b []byte) (Entry, error) {
+	r := bytes.NewReader(b)
+	index, err1 := binary.ReadUvarint(r)
+	term, err2 := binary.ReadUvarint(r)
+	t, err3 := r.ReadByte()
+	if err1 != nil || err2 != nil || err3 != nil {
+		return Entry{}, errCorruptStorage
+	}
+	entry := Entry{Index: index, Term: term, Type: EntryType(t)}
+	if r.Len() > 0 {
+		entry.Command = append([]byte(nil), b[len(b)-r.Len():]...)
+	}
+	return entry, nil
+}
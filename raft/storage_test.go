@@ -0,0 +1,103 @@
+package raft
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Will test that everything saved to a FileStorage is loaded back by a new one on the same directory
+func TestFileStorage(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewFileStorage(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state, err := s.Load(); err != nil || state.Term != 0 || len(state.Entries) != 0 {
+		t.Fatalf("Expected an empty state, got %+v, %v", state, err)
+	}
+	s.SaveState(3, "node-1")
+	s.Append([]Entry{{Index: 1, Term: 1, Type: EntryNoop}, {Index: 2, Term: 1, Command: []byte("a")}, {Index: 3, Term: 1, Command: []byte("b")}})
+	// Replaces the entries from 3 on, like a follower overwritten by a new leader
+	s.Append([]Entry{{Index: 3, Term: 2, Command: []byte("c")}, {Index: 4, Term: 2, Command: []byte("d")}})
+	s.Close()
+
+	s, _ = NewFileStorage(dir)
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Term != 3 || state.VotedFor != "node-1" {
+		t.Errorf("Unexpected term and vote: %d %q", state.Term, state.VotedFor)
+	}
+	if got := fmt.Sprint(state.Entries); got != fmt.Sprint([]Entry{
+		{Index: 1, Term: 1, Type: EntryNoop}, {Index: 2, Term: 1, Command: []byte("a")},
+		{Index: 3, Term: 2, Command: []byte("c")}, {Index: 4, Term: 2, Command: []byte("d")},
+	}) {
+		t.Errorf("Unexpected entries: %s", got)
+	}
+
+	if err := s.SaveSnapshot(3, 2, []byte("snapshot"), []Entry{{Index: 4, Term: 2, Command: []byte("d")}}); err != nil {
+		t.Fatal(err)
+	}
+	s.Append([]Entry{{Index: 5, Term: 3, Command: []byte("e")}})
+	s.Close()
+
+	s, _ = NewFileStorage(dir)
+	defer s.Close()
+	state, err = s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.SnapshotIndex != 3 || state.SnapshotTerm != 2 || string(state.Snapshot) != "snapshot" {
+		t.Errorf("Unexpected snapshot: %d %d %q", state.SnapshotIndex, state.SnapshotTerm, state.Snapshot)
+	}
+	if len(state.Entries) != 2 || state.Entries[0].Index != 4 || state.Entries[1].Index != 5 {
+		t.Errorf("Expected the entries after the snapshot, got %v", state.Entries)
+	}
+}
+
+// Will test that a record torn by a crash at the end of the log is dropped, and the log appended to after it
+func TestFileStorageTornLog(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStorage(dir)
+	s.Load()
+	s.Append([]Entry{{Index: 1, Term: 1, Command: []byte("a")}, {Index: 2, Term: 1, Command: []byte("b")}})
+	s.Close()
+
+	path := filepath.Join(dir, "log")
+	info, _ := os.Stat(path)
+	if err := os.Truncate(path, info.Size()-1); err != nil {
+		t.Fatal(err)
+	}
+
+	s, _ = NewFileStorage(dir)
+	state, err := s.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Entries) != 1 || state.Entries[0].Index != 1 {
+		t.Fatalf("Expected only the whole entry, got %v", state.Entries)
+	}
+	s.Append([]Entry{{Index: 2, Term: 1, Command: []byte("c")}})
+	s.Close()
+
+	s, _ = NewFileStorage(dir)
+	defer s.Close()
+	state, _ = s.Load()
+	if len(state.Entries) != 2 || string(state.Entries[1].Command) != "c" {
+		t.Errorf("Expected the entry appended after the torn one, got %v", state.Entries)
+	}
+}
+
+// Will test that a corrupt state file is reported instead of starting with a forgotten vote
+func TestFileStorageCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	s, _ := NewFileStorage(dir)
+	s.SaveState(7, "node-2")
+	os.WriteFile(filepath.Join(dir, "state"), []byte("garbage!!"), 0o644)
+	if _, err := s.Load(); err == nil {
+		t.Error("Expected an error for a corrupt state file")
+	}
+}
@@ -0,0 +1,284 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"golang-memory-cache/cache"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"sync"
+	"time"
+)
+
+// A Store keeps a cache.Cache identical on every node of a Raft group, for keys that need strong consistency
+// (feature flags, locks...). Every write goes through the log, and reads confirm the leadership first, so a read
+// always sees every write acknowledged before it. Versions are the log index of the write, the same on every node.
+//
+// Expiration is part of the log too: conditions are checked against the clock of the leader that took the
+// write, and the leader removes expired keys with a log entry, so every node removes them at the same point.
+
+// Settings used when creating a Store
+type StoreOptions struct {
+	Node           Options
+	ExpireInterval time.Duration // How often the leader removes expired keys
+	RequestTimeout time.Duration // How long an HTTP request may wait for the log
+	HTTPClient     *http.Client  // Used to forward HTTP requests to the leader, http.DefaultClient when nil
+}
+
+// Options used by NewStore
+var DefaultStoreOptions = StoreOptions{
+	Node:           DefaultOptions,
+	ExpireInterval: time.Second,
+	RequestTimeout: 5 * time.Second,
+}
+
+// Condition a write is checked against when it is applied. The zero value always holds.
+type Condition struct {
+	IfVersion uint64 // Only when the key exists with this version
+	IfAbsent  bool   // Only when the key does not exist
+}
+
+func (cond Condition) holds(item cache.CacheItem, found bool) bool {
+	if cond.IfAbsent && found {
+		return false
+	}
+	return cond.IfVersion == 0 || (found && item.Version == cond.IfVersion)
+}
+
+type opType uint8
+
+const (
+	opSet opType = iota + 1
+	opDelete
+	opExpire // Removes every key expired at Now
+)
+
+// What a Store writes to the log, encoded with encoding/gob
+type command struct {
+	Op         opType
+	Key        string
+	Value      interface{}
+	Expiration int64 // Unix nanoseconds, 0 when the key never expires
+	Metadata   map[string]string
+	Cond       Condition
+	Now        int64 // Clock of the leader that took the write, so every node sees the same keys as expired
+}
+
+// What Apply returns for a command
+type applyResult struct {
+	Item cache.CacheItem // The written item, or the current one when the condition failed
+	OK   bool
+}
+
+type Store struct {
+	cache   *cache.Cache
+	node    *Node
+	options StoreOptions
+	client  *http.Client
+
+	mu      sync.Mutex
+	proxies map[string]*httputil.ReverseProxy // One per leader, reused so connections are pooled
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Creates a Store on c with DefaultStoreOptions, and starts its Raft node (see NewNode for id and peers).
+// The store owns c from now on: its janitor is stopped, as keys expire through the log instead.
+func NewStore(c *cache.Cache, id string, peers []string, transport Transport) (*Store, error) {
+	return NewStoreWithOptions(c, id, peers, transport, DefaultStoreOptions)
+}
+
+// Creates a Store with custom options. Zero values fall back to DefaultStoreOptions.
+func NewStoreWithOptions(c *cache.Cache, id string, peers []string, transport Transport, opts StoreOptions) (*Store, error) {
+	if opts.ExpireInterval <= 0 {
+		opts.ExpireInterval = DefaultStoreOptions.ExpireInterval
+	}
+	if opts.RequestTimeout <= 0 {
+		opts.RequestTimeout = DefaultStoreOptions.RequestTimeout
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	c.Stop()
+	s := &Store{
+		cache:   c,
+		options: opts,
+		client:  client,
+		proxies: make(map[string]*httputil.ReverseProxy),
+		done:    make(chan struct{}),
+	}
+	node, err := NewNodeWithOptions(id, peers, s, transport, opts.Node)
+	if err != nil {
+		return nil, err
+	}
+	s.node = node
+
+	s.wg.Add(1)
+	go s.expireLoop()
+	return s, nil
+}
+
+// Will stop the store and its node
+func (s *Store) Close() {
+	close(s.done)
+	s.wg.Wait()
+	s.node.Close()
+}
+
+func (s *Store) Node() *Node { return s.node }
+
+// Will return the cache the store applies the log to. Reading it directly is fast but may be stale,
+// it must never be written to.
+func (s *Store) Cache() *cache.Cache { return s.cache }
+
+// Will return the value of key with a linearizable read. Only works on the leader, other nodes return ErrNotLeader.
+func (s *Store) Get(ctx context.Context, key string) (cache.CacheItem, bool, error) {
+	index, err := s.node.ReadIndex(ctx)
+	if err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	if err := s.node.WaitApplied(ctx, index); err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	item, found := s.lookup(key, time.Now().UnixNano())
+	return item, found, nil
+}
+
+// Will set key through the log. The key never expires when ttl is cache.NoExpiration.
+func (s *Store) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) (cache.CacheItem, error) {
+	item, _, err := s.SetIf(ctx, key, value, ttl, nil, Condition{})
+	return item, err
+}
+
+// Will set key through the log when cond holds. Returns the new item and true, or the current item and false
+// when cond did not hold.
+func (s *Store) SetIf(ctx context.Context, key string, value interface{}, ttl time.Duration, metadata map[string]string, cond Condition) (cache.CacheItem, bool, error) {
+	now := time.Now()
+	var expiration int64
+	if ttl != cache.NoExpiration {
+		expiration = now.Add(ttl).UnixNano()
+	}
+	return s.propose(ctx, command{Op: opSet, Key: key, Value: value, Expiration: expiration, Metadata: metadata, Cond: cond, Now: now.UnixNano()})
+}
+
+// Will delete key through the log, and report whether it existed
+func (s *Store) Delete(ctx context.Context, key string) (bool, error) {
+	return s.DeleteIf(ctx, key, Condition{})
+}
+
+// Will delete key through the log when it exists and cond holds, and report whether it was deleted
+func (s *Store) DeleteIf(ctx context.Context, key string, cond Condition) (bool, error) {
+	_, ok, err := s.propose(ctx, command{Op: opDelete, Key: key, Cond: cond, Now: time.Now().UnixNano()})
+	return ok, err
+}
+
+func (s *Store) propose(ctx context.Context, cmd command) (cache.CacheItem, bool, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(&cmd); err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	value, err := s.node.Propose(ctx, buf.Bytes())
+	if err != nil {
+		return cache.CacheItem{}, false, err
+	}
+	result := value.(applyResult)
+	return result.Item, result.OK, nil
+}
+
+// Will return the item for key unless it expired at now. The janitor is stopped, so the cache has expired keys too.
+func (s *Store) lookup(key string, now int64) (cache.CacheItem, bool) {
+	item, found := s.cache.Peek(key)
+	if !found || item.Expired(now) {
+		return cache.CacheItem{}, false
+	}
+	return item, true
+}
+
+// Will apply a command from the log to the cache. Part of the StateMachine interface.
+func (s *Store) Apply(index uint64, data []byte) interface{} {
+	var cmd command
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&cmd); err != nil {
+		return applyResult{} // Same on every node
+	}
+
+	switch cmd.Op {
+	case opSet:
+		current, found := s.lookup(cmd.Key, cmd.Now)
+		if !cmd.Cond.holds(current, found) {
+			return applyResult{Item: current}
+		}
+		item := cache.CacheItem{Value: cmd.Value, Expiration: cmd.Expiration, Metadata: cmd.Metadata, Version: index}
+		s.cache.Apply(cache.Change{Type: cache.ChangeSet, Key: cmd.Key, Item: item})
+		return applyResult{Item: item, OK: true}
+	case opDelete:
+		current, found := s.lookup(cmd.Key, cmd.Now)
+		if !found || !cmd.Cond.holds(current, found) {
+			return applyResult{Item: current}
+		}
+		s.cache.Apply(cache.Change{Type: cache.ChangeDelete, Key: cmd.Key})
+		return applyResult{OK: true}
+	case opExpire:
+		for _, key := range s.cache.Keys("") {
+			if item, found := s.cache.Peek(key); found && item.Expired(cmd.Now) {
+				s.cache.Apply(cache.Change{Type: cache.ChangeExpire, Key: key})
+			}
+		}
+		return applyResult{OK: true}
+	}
+	return applyResult{}
+}
+
+// Will write the cache in the snapshot format of the cache package. Part of the StateMachine interface.
+func (s *Store) Snapshot(w io.Writer) error {
+	return s.cache.SaveSnapshot(w)
+}
+
+// Will replace the cache with a snapshot. Part of the StateMachine interface.
+func (s *Store) Restore(r io.Reader) error {
+	_, _, err := s.cache.ReplaceWithSnapshot(r)
+	return err
+}
+
+// Will have the leader remove expired keys through the log, only when there are some
+func (s *Store) expireLoop() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(s.options.ExpireInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		if state, _ := s.node.State(); state != Leader || !s.hasExpired(time.Now().UnixNano()) {
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), s.options.RequestTimeout)
+		s.propose(ctx, command{Op: opExpire, Now: time.Now().UnixNano()})
+		cancel()
+	}
+}
+
+func (s *Store) hasExpired(now int64) bool {
+	for _, key := range s.cache.Keys("") {
+		if item, found := s.cache.Peek(key); found && item.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Will return the node's counters prefixed with "raft_", and the number of keys
+func (s *Store) GetStats() map[string]uint64 {
+	stats := make(map[string]uint64)
+	for name, value := range s.node.GetStats() {
+		stats["raft_"+name] = value
+	}
+	stats["raft_keys"] = uint64(s.cache.Len())
+	return stats
+}
@@ -0,0 +1,215 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Set on requests forwarded to the leader. A node never forwards a request that carries it, so a request does
+// not bounce between nodes that disagree on the leader during an election.
+const ForwardedHeader = "X-Raft-Forwarded-By"
+
+// Largest value accepted by PUT, same as the limit of the v2 API
+const maxValueSize = 10 << 20
+
+type keyResponse struct {
+	Key      string            `json:"key"`
+	Value    interface{}       `json:"value"`
+	TTL      *float64          `json:"ttl"` // Seconds until the key expires, null when it never expires
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Version  uint64            `json:"version"`
+}
+
+type putRequest struct {
+	Value interface{}     `json:"value"`
+	TTL   json.RawMessage `json:"ttl"` // Seconds, or a Go duration like "1m30s"
+}
+
+// Will mount the node's RPC routes, and the key routes. Requests for keys are served by the leader,
+// other nodes forward them to it.
+// * GET /v2/consistent/keys/{key}
+// * PUT /v2/consistent/keys/{key}
+// * DELETE /v2/consistent/keys/{key}
+func (s *Store) RegisterRoutes(mux *http.ServeMux) {
+	s.node.RegisterRoutes(mux)
+	mux.HandleFunc("GET /v2/consistent/keys/{key}", s.onLeader(s.GetKeyHandler))
+	mux.HandleFunc("PUT /v2/consistent/keys/{key}", s.onLeader(s.PutKeyHandler))
+	mux.HandleFunc("DELETE /v2/consistent/keys/{key}", s.onLeader(s.DeleteKeyHandler))
+}
+
+// Will serve the request when this node is the leader, and forward it to the leader otherwise
+func (s *Store) onLeader(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		leader := s.node.Leader()
+		if leader == s.node.ID() {
+			next(w, r)
+			return
+		}
+		if leader == "" || r.Header.Get(ForwardedHeader) != "" {
+			api.WriteError(w, http.StatusServiceUnavailable, "No leader")
+			return
+		}
+		r.Header.Set(ForwardedHeader, s.node.ID())
+		s.proxy(leader).ServeHTTP(w, r)
+	}
+}
+
+// Will return the reverse proxy for a leader, creating it on first use
+func (s *Store) proxy(leader string) *httputil.ReverseProxy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if p, ok := s.proxies[leader]; ok {
+		return p
+	}
+	target, err := url.Parse(leader)
+	if err != nil {
+		target = &url.URL{}
+	}
+	p := httputil.NewSingleHostReverseProxy(target)
+	p.Transport = s.client.Transport
+	p.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+		api.WriteError(w, http.StatusBadGateway, "Leader unreachable")
+	}
+	s.proxies[leader] = p
+	return p
+}
+
+// * GET /v2/consistent/keys/{key}
+func (s *Store) GetKeyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), s.options.RequestTimeout)
+	defer cancel()
+	item, found, err := s.Get(ctx, r.PathValue("key"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !found {
+		api.WriteError(w, http.StatusNotFound, "Key not found")
+		return
+	}
+	writeItem(w, http.StatusOK, r.PathValue("key"), item)
+}
+
+// * PUT /v2/consistent/keys/{key}
+// With Content-Type: application/json the body is {"value": ..., "ttl": 60}, otherwise the body is stored as a string.
+// The Cache-TTL header wins over the ttl field. If-Match: "<version>" only writes over that version,
+// If-None-Match: * only writes when the key does not exist, and 412 is returned otherwise.
+func (s *Store) PutKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValueSize))
+	if err != nil {
+		api.WriteError(w, http.StatusRequestEntityTooLarge, "Value too large")
+		return
+	}
+
+	var value interface{} = string(body)
+	ttl := r.Header.Get("Cache-TTL")
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType == "application/json" {
+		var req putRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "Invalid JSON body")
+			return
+		}
+		if req.Value == nil {
+			api.WriteError(w, http.StatusBadRequest, "Missing value")
+			return
+		}
+		value = req.Value
+		if ttl == "" && len(req.TTL) > 0 && string(req.TTL) != "null" {
+			ttl = strings.Trim(string(req.TTL), `"`)
+		}
+	}
+	duration, err := api.ParseTTL(ttl)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	cond, err := condition(r)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.options.RequestTimeout)
+	defer cancel()
+	item, ok, err := s.SetIf(ctx, key, value, duration, nil, cond)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !ok {
+		api.WriteError(w, http.StatusPreconditionFailed, "Precondition failed")
+		return
+	}
+	writeItem(w, http.StatusOK, key, item)
+}
+
+// * DELETE /v2/consistent/keys/{key}
+// Accepts If-Match like PUT
+func (s *Store) DeleteKeyHandler(w http.ResponseWriter, r *http.Request) {
+	cond, err := condition(r)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), s.options.RequestTimeout)
+	defer cancel()
+	deleted, err := s.DeleteIf(ctx, r.PathValue("key"), cond)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !deleted && cond.IfVersion != 0 {
+		api.WriteError(w, http.StatusPreconditionFailed, "Precondition failed")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Will turn If-Match and If-None-Match headers into a Condition
+func condition(r *http.Request) (Condition, error) {
+	var cond Condition
+	if header := r.Header.Get("If-Match"); header != "" {
+		version, err := strconv.ParseUint(strings.Trim(strings.TrimPrefix(strings.TrimSpace(header), "W/"), `"`), 10, 64)
+		if err != nil || version == 0 {
+			return cond, errors.New("If-Match must be a single version")
+		}
+		cond.IfVersion = version
+	}
+	if strings.TrimSpace(r.Header.Get("If-None-Match")) == "*" {
+		cond.IfAbsent = true
+	}
+	return cond, nil
+}
+
+func writeItem(w http.ResponseWriter, status int, key string, item cache.CacheItem) {
+	resp := keyResponse{Key: key, Value: item.Value, Metadata: item.Metadata, Version: item.Version}
+	if ttl, expires := item.TTL(); expires {
+		seconds := ttl.Seconds()
+		resp.TTL = &seconds
+	}
+	w.Header().Set("ETag", `"`+strconv.FormatUint(item.Version, 10)+`"`)
+	api.WriteJSON(w, status, resp)
+}
+
+// Will map the errors of the log to a status: 503 while there is no leader to take the request, 504 on timeouts
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrNotLeader), errors.Is(err, ErrLeadershipLost), errors.Is(err, ErrClosed):
+		api.WriteError(w, http.StatusServiceUnavailable, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		api.WriteError(w, http.StatusGatewayTimeout, "Timed out waiting for the log")
+	default:
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
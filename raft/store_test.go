@@ -0,0 +1,216 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testStoreOptions = StoreOptions{
+	Node:           testOptions,
+	ExpireInterval: 20 * time.Millisecond,
+}
+
+// Will start n stores connected by a MemNetwork, and return them with the leader first
+func newTestStores(t *testing.T, n int) []*Store {
+	t.Helper()
+	network := NewMemNetwork()
+	var ids []string
+	for i := 0; i < n; i++ {
+		ids = append(ids, "store-"+string(rune('a'+i)))
+	}
+	var stores []*Store
+	for _, id := range ids {
+		s, err := NewStoreWithOptions(cache.NewCache(), id, ids, network.Transport(id), testStoreOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.Add(s.Node())
+		t.Cleanup(s.Close)
+		stores = append(stores, s)
+	}
+	return leaderFirst(t, stores)
+}
+
+func leaderFirst(t *testing.T, stores []*Store) []*Store {
+	t.Helper()
+	waitFor(t, "a leader", func() bool {
+		for i, s := range stores {
+			if state, _ := s.Node().State(); state == Leader {
+				stores[0], stores[i] = stores[i], stores[0]
+				return true
+			}
+		}
+		return false
+	})
+	return stores
+}
+
+func TestStoreConditionalWrites(t *testing.T) {
+	stores := newTestStores(t, 3)
+	leader := stores[0]
+	ctx := context.Background()
+
+	item, ok, err := leader.SetIf(ctx, "lock", "owner-1", cache.NoExpiration, nil, Condition{IfAbsent: true})
+	if err != nil || !ok {
+		t.Fatalf("Expected the first write to succeed, got %v %v", ok, err)
+	}
+	if _, ok, _ := leader.SetIf(ctx, "lock", "owner-2", cache.NoExpiration, nil, Condition{IfAbsent: true}); ok {
+		t.Error("Expected IfAbsent to fail on an existing key")
+	}
+	if _, ok, _ := leader.SetIf(ctx, "lock", "owner-2", cache.NoExpiration, nil, Condition{IfVersion: item.Version + 100}); ok {
+		t.Error("Expected IfVersion to fail on another version")
+	}
+	updated, ok, err := leader.SetIf(ctx, "lock", "owner-2", cache.NoExpiration, nil, Condition{IfVersion: item.Version})
+	if err != nil || !ok || updated.Version <= item.Version {
+		t.Fatalf("Expected IfVersion to succeed on the current version, got %+v %v %v", updated, ok, err)
+	}
+
+	got, found, err := leader.Get(ctx, "lock")
+	if err != nil || !found || got.Value != "owner-2" {
+		t.Fatalf("Unexpected read: %+v %v %v", got, found, err)
+	}
+
+	// Every node applies the same log, so items are identical down to the version
+	for _, s := range stores {
+		waitFor(t, "the write to be applied everywhere", func() bool {
+			item, found := s.Cache().Peek("lock")
+			return found && item.Value == "owner-2" && item.Version == updated.Version
+		})
+	}
+	if _, _, err := stores[1].Get(ctx, "lock"); err != ErrNotLeader {
+		t.Errorf("Expected followers to refuse reads, got %v", err)
+	}
+
+	if deleted, _ := leader.DeleteIf(ctx, "lock", Condition{IfVersion: item.Version}); deleted {
+		t.Error("Expected DeleteIf to fail on an old version")
+	}
+	if deleted, _ := leader.Delete(ctx, "lock"); !deleted {
+		t.Error("Expected Delete to delete the key")
+	}
+	if _, found, _ := leader.Get(ctx, "lock"); found {
+		t.Error("Expected the key to be gone")
+	}
+}
+
+// Will test that expired keys are invisible right away, and removed from every node through the log
+func TestStoreExpiration(t *testing.T) {
+	stores := newTestStores(t, 3)
+	ctx := context.Background()
+	if _, err := stores[0].Set(ctx, "flag", "on", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, found, _ := stores[0].Get(ctx, "flag"); found {
+		t.Error("Expected the expired key to be invisible")
+	}
+	if _, ok, _ := stores[0].SetIf(ctx, "flag", "off", cache.NoExpiration, nil, Condition{IfAbsent: true}); !ok {
+		t.Error("Expected IfAbsent to succeed on an expired key")
+	}
+	if _, err := stores[0].Set(ctx, "short", 1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range stores {
+		waitFor(t, "the expired key to be removed", func() bool {
+			_, found := s.Cache().Peek("short")
+			return !found && s.Cache().Len() == 1
+		})
+	}
+}
+
+// Will test the HTTP routes on a group using the HTTPTransport, with requests sent to a follower
+func TestStoreHTTP(t *testing.T) {
+	var servers []*httptest.Server
+	var muxes []*http.ServeMux
+	var ids []string
+	for i := 0; i < 3; i++ {
+		mux := http.NewServeMux()
+		srv := httptest.NewServer(mux)
+		t.Cleanup(srv.Close)
+		servers, muxes, ids = append(servers, srv), append(muxes, mux), append(ids, srv.URL)
+	}
+	var stores []*Store
+	for i, id := range ids {
+		s, err := NewStoreWithOptions(cache.NewCache(), id, ids, NewHTTPTransport(nil), testStoreOptions)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Close)
+		s.RegisterRoutes(muxes[i])
+		stores = append(stores, s)
+	}
+	stores = leaderFirst(t, stores)
+	waitFor(t, "every node to know the leader", func() bool {
+		for _, s := range stores {
+			if s.Node().Leader() != stores[0].Node().ID() {
+				return false
+			}
+		}
+		return true
+	})
+	follower := stores[1].Node().ID()
+
+	do := func(method, path, body string, header map[string]string) (*http.Response, map[string]interface{}) {
+		t.Helper()
+		req, _ := http.NewRequest(method, follower+path, strings.NewReader(body))
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var decoded map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&decoded)
+		return resp, decoded
+	}
+
+	resp, body := do("PUT", "/v2/consistent/keys/flag", `{"value": {"enabled": true}, "ttl": 60}`, map[string]string{"Content-Type": "application/json", "If-None-Match": "*"})
+	if resp.StatusCode != http.StatusOK || body["ttl"] == nil {
+		t.Fatalf("Unexpected PUT response: %d %v", resp.StatusCode, body)
+	}
+	etag := resp.Header.Get("ETag")
+
+	for _, bad := range []string{`{"ttl": 60}`, `{"value": 1, "ttl": "NaN"}`, `{"value": 1, "ttl": 1e300}`} {
+		if resp, _ := do("PUT", "/v2/consistent/keys/bad", bad, map[string]string{"Content-Type": "application/json"}); resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", bad, resp.StatusCode)
+		}
+	}
+
+	resp, _ = do("PUT", "/v2/consistent/keys/flag", "again", map[string]string{"If-None-Match": "*"})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected 412 on an existing key, got %d", resp.StatusCode)
+	}
+
+	resp, body = do("GET", "/v2/consistent/keys/flag", "", nil)
+	if value, _ := body["value"].(map[string]interface{}); resp.StatusCode != http.StatusOK || value["enabled"] != true || resp.Header.Get("ETag") != etag {
+		t.Errorf("Unexpected GET response: %d %v", resp.StatusCode, body)
+	}
+
+	resp, _ = do("PUT", "/v2/consistent/keys/flag", "off", map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected If-Match on the current version to succeed, got %d", resp.StatusCode)
+	}
+	resp, _ = do("DELETE", "/v2/consistent/keys/flag", "", map[string]string{"If-Match": etag})
+	if resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("Expected If-Match on an old version to fail, got %d", resp.StatusCode)
+	}
+	resp, _ = do("DELETE", "/v2/consistent/keys/flag", "", nil)
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("Expected 204, got %d", resp.StatusCode)
+	}
+	resp, body = do("GET", "/v2/consistent/keys/flag", "", nil)
+	if errBody, _ := body["error"].(map[string]interface{}); resp.StatusCode != http.StatusNotFound || errBody["message"] != "Key not found" {
+		t.Errorf("Unexpected response for a deleted key: %d %v", resp.StatusCode, body)
+	}
+
+	if stats := stores[0].GetStats(); stats["raft_leader"] != 1 || stats["raft_proposals"] < 3 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
@@ -0,0 +1,114 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Carries the RPCs of a node to the other nodes, identified by their ID. The receiving side hands them to
+// Node.HandleRequestVote, HandleAppendEntries and HandleInstallSnapshot.
+type Transport interface {
+	RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error)
+	AppendEntries(ctx context.Context, target string, req *AppendRequest) (*AppendResponse, error)
+	InstallSnapshot(ctx context.Context, target string, req *SnapshotRequest) (*SnapshotResponse, error)
+}
+
+// Returned by a MemNetwork transport when the target is cut off or unknown
+var ErrUnreachable = errors.New("raft: node unreachable")
+
+// Connects nodes of the same process, for tests. Partition cuts the network into groups that can't reach each other.
+type MemNetwork struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+	group map[string]int // Partition each node is in, nil when the network is whole
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{nodes: make(map[string]*Node)}
+}
+
+// Will return the transport for the node with the given ID
+func (m *MemNetwork) Transport(id string) Transport {
+	return &memTransport{network: m, from: id}
+}
+
+// Will make a node reachable, replacing any node with the same ID (i.e. to restart it)
+func (m *MemNetwork) Add(n *Node) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nodes[n.ID()] = n
+}
+
+// Will split the network into groups of node IDs. Nodes only reach nodes of their own group,
+// and nodes that are not in any group reach nobody.
+func (m *MemNetwork) Partition(groups ...[]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group = make(map[string]int)
+	for i, ids := range groups {
+		for _, id := range ids {
+			m.group[id] = i + 1
+		}
+	}
+}
+
+// Will reconnect every node
+func (m *MemNetwork) Heal() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.group = nil
+}
+
+// Will return the target node when from can reach it
+func (m *MemNetwork) route(from, to string) (*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	n, ok := m.nodes[to]
+	if !ok {
+		return nil, ErrUnreachable
+	}
+	if m.group != nil && (m.group[from] == 0 || m.group[from] != m.group[to]) {
+		return nil, ErrUnreachable
+	}
+	select {
+	case <-n.done:
+		return nil, ErrUnreachable
+	default:
+	}
+	return n, nil
+}
+
+type memTransport struct {
+	network *MemNetwork
+	from    string
+}
+
+// Will deliver a request and its answer, failing when the nodes got cut off from each other in between
+func memCall[Req, Resp any](t *memTransport, ctx context.Context, target string, req Req, handle func(*Node, Req) Resp) (Resp, error) {
+	var zero Resp
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	n, err := t.network.route(t.from, target)
+	if err != nil {
+		return zero, err
+	}
+	resp := handle(n, req)
+	if _, err := t.network.route(t.from, target); err != nil {
+		return zero, err
+	}
+	return resp, nil
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, target string, req *VoteRequest) (*VoteResponse, error) {
+	return memCall(t, ctx, target, req, (*Node).HandleRequestVote)
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, target string, req *AppendRequest) (*AppendResponse, error) {
+	return memCall(t, ctx, target, req, (*Node).HandleAppendEntries)
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, target string, req *SnapshotRequest) (*SnapshotResponse, error) {
+	return memCall(t, ctx, target, req, (*Node).HandleInstallSnapshot)
+}
@@ -0,0 +1,138 @@
+package ratelimit
+
+import (
+	"encoding/gob"
+	"time"
+)
+
+// One step of an algorithm: given the current state of a key (nil when there is none, or the state of another
+// algorithm), the time and the limit (all in nanoseconds), returns the state to store, when it expires and the result.
+// The state is only stored when the request is allowed. Stale state that the cache did not expire yet must be
+// handled like missing state.
+type stepFunc func(state interface{}, now, limit, window int64) (next interface{}, expires int64, r Result)
+
+// The states end up in snapshots and replication streams
+func init() {
+	gob.Register(gcraState{})
+	gob.Register(tokenBucketState{})
+	gob.Register(fixedWindowState{})
+	gob.Register(slidingLogState{})
+}
+
+// The theoretical arrival time: when the next request would be due if requests were evenly spaced.
+// A request is allowed as long as it does not push TAT more than a window ahead of now.
+type gcraState struct {
+	TAT int64
+}
+
+func gcraStep(state interface{}, now, limit, window int64) (interface{}, int64, Result) {
+	interval := window / limit
+	if interval == 0 {
+		interval = 1
+	}
+	tat := now
+	if s, ok := state.(gcraState); ok && s.TAT > now {
+		tat = s.TAT
+	}
+
+	next := tat + interval
+	if next-now > window {
+		return nil, 0, Result{
+			RetryAfter: time.Duration(next - window - now),
+			ResetAfter: time.Duration(tat - now),
+		}
+	}
+	return gcraState{TAT: next}, next, Result{
+		Allowed:    true,
+		Remaining:  int((window - (next - now)) / interval),
+		ResetAfter: time.Duration(next - now),
+	}
+}
+
+type tokenBucketState struct {
+	Tokens float64
+	Last   int64 // When Tokens was computed
+}
+
+func tokenBucketStep(state interface{}, now, limit, window int64) (interface{}, int64, Result) {
+	rate := float64(limit) / float64(window) // Tokens per nanosecond
+	tokens := float64(limit)
+	if s, ok := state.(tokenBucketState); ok && s.Last <= now {
+		tokens = s.Tokens + float64(now-s.Last)*rate
+		if tokens > float64(limit) {
+			tokens = float64(limit)
+		}
+	}
+
+	if tokens < 1 {
+		return nil, 0, Result{
+			RetryAfter: time.Duration((1 - tokens) / rate),
+			ResetAfter: time.Duration((float64(limit) - tokens) / rate),
+		}
+	}
+	tokens--
+	resetAfter := int64((float64(limit) - tokens) / rate)
+	return tokenBucketState{Tokens: tokens, Last: now}, now + resetAfter, Result{
+		Allowed:    true,
+		Remaining:  int(tokens),
+		ResetAfter: time.Duration(resetAfter),
+	}
+}
+
+type fixedWindowState struct {
+	Start int64 // Start of the window Count belongs to, a multiple of the window
+	Count int64
+}
+
+func fixedWindowStep(state interface{}, now, limit, window int64) (interface{}, int64, Result) {
+	start := now - now%window
+	end := start + window
+	var count int64
+	if s, ok := state.(fixedWindowState); ok && s.Start == start {
+		count = s.Count
+	}
+
+	if count >= limit {
+		return nil, 0, Result{RetryAfter: time.Duration(end - now), ResetAfter: time.Duration(end - now)}
+	}
+	count++
+	return fixedWindowState{Start: start, Count: count}, end, Result{
+		Allowed:    true,
+		Remaining:  int(limit - count),
+		ResetAfter: time.Duration(end - now),
+	}
+}
+
+type slidingLogState struct {
+	Times []int64 // Of the requests allowed during the last window, oldest first
+}
+
+func slidingLogStep(state interface{}, now, limit, window int64) (interface{}, int64, Result) {
+	var times []int64
+	if s, ok := state.(slidingLogState); ok {
+		for i, t := range s.Times {
+			if t > now-window {
+				times = s.Times[i:]
+				break
+			}
+		}
+	}
+
+	if int64(len(times)) >= limit {
+		// The oldest requests have to leave the window first
+		oldest := times[int64(len(times))-limit]
+		return nil, 0, Result{
+			RetryAfter: time.Duration(oldest + window - now),
+			ResetAfter: time.Duration(times[len(times)-1] + window - now),
+		}
+	}
+	// Copied, the old slice is still the value stored in the cache until Update replaces it
+	next := make([]int64, len(times), len(times)+1)
+	copy(next, times)
+	next = append(next, now)
+	return slidingLogState{Times: next}, now + window, Result{
+		Allowed:    true,
+		Remaining:  int(limit - int64(len(next))),
+		ResetAfter: time.Duration(window),
+	}
+}
@@ -0,0 +1,133 @@
+package ratelimit
+
+import (
+	"errors"
+	"golang-memory-cache/api"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type resultResponse struct {
+	Key        string  `json:"key"`
+	Algorithm  string  `json:"algorithm"`
+	Allowed    bool    `json:"allowed"`
+	Limit      int     `json:"limit"`
+	Remaining  int     `json:"remaining"`
+	RetryAfter float64 `json:"retry_after"` // Seconds
+	ResetAfter float64 `json:"reset_after"` // Seconds
+}
+
+// Will mount the rate limit route on mux:
+// * POST /v2/ratelimit/{key}?limit=&window=&algorithm=
+func (l *Limiter) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v2/ratelimit/{key}", l.AllowHandler)
+}
+
+// * POST /v2/ratelimit/{key}?limit=100&window=1m&algorithm=token-bucket
+// Counts a request for key. Answers 200 when it is allowed and 429 when it is not, with the result in the body
+// and the rate limit headers. algorithm defaults to the Limiter's.
+func (l *Limiter) AllowHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil || limit <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "limit must be a positive integer")
+		return
+	}
+	window, err := parseWindow(query.Get("window"))
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	limiter := l
+	if name := query.Get("algorithm"); name != "" {
+		algorithm, ok := ParseAlgorithm(name)
+		if !ok {
+			api.WriteError(w, http.StatusBadRequest, "Unknown algorithm, use gcra, token-bucket, fixed-window or sliding-log")
+			return
+		}
+		limiter = l.withAlgorithm(algorithm)
+	}
+
+	key := r.PathValue("key")
+	result, err := limiter.Allow(key, limit, window)
+	if err != nil {
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setHeaders(w, result)
+	status := http.StatusOK
+	if !result.Allowed {
+		status = http.StatusTooManyRequests
+	}
+	api.WriteJSON(w, status, resultResponse{
+		Key:        key,
+		Algorithm:  limiter.options.Algorithm.String(),
+		Allowed:    result.Allowed,
+		Limit:      result.Limit,
+		Remaining:  result.Remaining,
+		RetryAfter: result.RetryAfter.Seconds(),
+		ResetAfter: result.ResetAfter.Seconds(),
+	})
+}
+
+// Will parse a window given as a number of seconds ("60", "0.5") or as a Go duration ("1m")
+func parseWindow(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if seconds, numErr := strconv.ParseFloat(s, 64); numErr == nil {
+		d, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil || d <= 0 {
+		return 0, errors.New("window must be a positive number of seconds or a duration")
+	}
+	return d, nil
+}
+
+// Will return the IP address of the client, the default key of Middleware. Headers like X-Forwarded-For are
+// ignored, since any client can send them; use a key function that reads them when behind a trusted proxy.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Will only let limit requests per window through to next for each key, the client IP when key is nil.
+// Requests over the limit get 429 with a Retry-After header. Every response gets the RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers.
+func (l *Limiter) Middleware(next http.Handler, limit int, window time.Duration, key func(r *http.Request) string) http.Handler {
+	if key == nil {
+		key = ClientIP
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		result, err := l.Allow(key(r), limit, window)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		setHeaders(w, result)
+		if !result.Allowed {
+			api.WriteError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Will set the rate limit headers, durations rounded up to whole seconds
+func setHeaders(w http.ResponseWriter, result Result) {
+	h := w.Header()
+	h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.ResetAfter)))
+	if !result.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,72 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAllowHandler(t *testing.T) {
+	l, _ := newTestLimiter(t, GCRA)
+	mux := http.NewServeMux()
+	l.RegisterRoutes(mux)
+
+	do := func(path string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+		var body map[string]interface{}
+		json.NewDecoder(w.Body).Decode(&body)
+		return w, body
+	}
+
+	for i := 0; i < 2; i++ {
+		if w, body := do("/v2/ratelimit/user-1?limit=2&window=1m&algorithm=sliding-log"); w.Code != http.StatusOK || body["algorithm"] != "sliding-log" {
+			t.Fatalf("Unexpected response: %d %v", w.Code, body)
+		}
+	}
+	w, body := do("/v2/ratelimit/user-1?limit=2&window=60&algorithm=sliding-log")
+	if w.Code != http.StatusTooManyRequests || body["allowed"] != false || w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected 429 with Retry-After, got %d %v %v", w.Code, w.Header(), body)
+	}
+	if w, body := do("/v2/ratelimit/user-1?limit=2&window=1m"); w.Code != http.StatusOK || body["algorithm"] != "gcra" {
+		t.Errorf("Expected the default algorithm with a state of its own, got %d %v", w.Code, body)
+	}
+
+	for _, path := range []string{
+		"/v2/ratelimit/k?window=1m",
+		"/v2/ratelimit/k?limit=2",
+		"/v2/ratelimit/k?limit=2&window=1m&algorithm=leaky",
+	} {
+		if w, _ := do(path); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	l, _ := newTestLimiter(t, TokenBucket)
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNoContent) })
+	handler := l.Middleware(next, 3, time.Minute, nil)
+
+	request := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+	for i := 0; i < 3; i++ {
+		if w := request("10.0.0.1:1234"); w.Code != http.StatusNoContent || w.Header().Get("RateLimit-Limit") != "3" {
+			t.Fatalf("Unexpected response: %d %v", w.Code, w.Header())
+		}
+	}
+	if w := request("10.0.0.1:5678"); w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "20" || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("Expected the client to be limited on every port, got %d %v", w.Code, w.Header())
+	}
+	if w := request("10.0.0.2:1234"); w.Code != http.StatusNoContent {
+		t.Errorf("Expected another client to have a limit of its own, got %d", w.Code)
+	}
+}
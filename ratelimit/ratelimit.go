@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"errors"
+	"golang-memory-cache/cache"
+	"sync/atomic"
+	"time"
+)
+
+// Rate limiters keyed in a cache.Cache. Every key gets its own limit, and the state of a key is updated
+// atomically with Cache.Update, so concurrent requests for the same key can't both take the last slot.
+// The state expires with the key once the limit is fully available again, so idle keys take no memory.
+//
+// Every algorithm allows limit requests per window, they differ in how bursts are handled:
+//
+//   - GCRA spaces requests evenly (one every window/limit) but allows a burst of limit requests after a quiet period.
+//     Its state is a single timestamp.
+//   - TokenBucket refills limit tokens per window, continuously, and holds at most limit tokens.
+//   - FixedWindow counts requests in windows aligned to the clock. Cheap, but allows up to twice the limit
+//     around the boundary between two windows.
+//   - SlidingLog keeps the time of every allowed request of the last window. Exact, but the state grows with the limit.
+
+// Which algorithm a Limiter uses
+type Algorithm int
+
+const (
+	GCRA Algorithm = iota + 1
+	TokenBucket
+	FixedWindow
+	SlidingLog
+)
+
+// Will return the name of the algorithm, as accepted by ParseAlgorithm (i.e. "token-bucket")
+func (a Algorithm) String() string {
+	switch a {
+	case GCRA:
+		return "gcra"
+	case TokenBucket:
+		return "token-bucket"
+	case FixedWindow:
+		return "fixed-window"
+	case SlidingLog:
+		return "sliding-log"
+	default:
+		return "unknown"
+	}
+}
+
+// Will turn a name returned by String back into an Algorithm. Returns false for unknown names.
+func ParseAlgorithm(name string) (Algorithm, bool) {
+	for a := GCRA; a <= SlidingLog; a++ {
+		if a.String() == name {
+			return a, true
+		}
+	}
+	return 0, false
+}
+
+// Settings used when creating a Limiter
+type Options struct {
+	Algorithm Algorithm
+	Prefix    string // Prepended to a key to get the cache key holding its state
+}
+
+// Options used by NewLimiter
+var DefaultOptions = Options{
+	Algorithm: GCRA,
+	Prefix:    "ratelimit:",
+}
+
+var (
+	ErrInvalidLimit     = errors.New("ratelimit: limit and window must be positive")
+	ErrUnknownAlgorithm = errors.New("ratelimit: unknown algorithm")
+)
+
+// The outcome of Allow
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int           // Requests still allowed right now
+	RetryAfter time.Duration // How long until the next request is allowed, 0 when this one was
+	ResetAfter time.Duration // How long until the whole limit is available again
+}
+
+type limiterStats struct {
+	Allowed uint64
+	Denied  uint64
+}
+
+type Limiter struct {
+	cache   *cache.Cache
+	options Options
+	stats   *limiterStats // Shared with the copies made by withAlgorithm
+	now     func() int64  // Unix nanoseconds, replaced by tests
+}
+
+// Creates a Limiter keeping its state in c, with DefaultOptions
+func NewLimiter(c *cache.Cache) *Limiter {
+	return NewLimiterWithOptions(c, DefaultOptions)
+}
+
+// Creates a Limiter with custom options. Zero values fall back to DefaultOptions.
+func NewLimiterWithOptions(c *cache.Cache, opts Options) *Limiter {
+	if opts.Algorithm == 0 {
+		opts.Algorithm = DefaultOptions.Algorithm
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = DefaultOptions.Prefix
+	}
+	return &Limiter{
+		cache:   c,
+		options: opts,
+		stats:   &limiterStats{},
+		now:     func() int64 { return time.Now().UnixNano() },
+	}
+}
+
+// Will return a Limiter using another algorithm on the same cache and stats
+func (l *Limiter) withAlgorithm(a Algorithm) *Limiter {
+	copied := *l
+	copied.options.Algorithm = a
+	return &copied
+}
+
+// Will count one request for key, and report whether it stays within limit requests per window.
+// Denied requests are not counted. Keys using a different algorithm than before start over.
+func (l *Limiter) Allow(key string, limit int, window time.Duration) (Result, error) {
+	if limit <= 0 || window <= 0 {
+		return Result{}, ErrInvalidLimit
+	}
+	var step stepFunc
+	switch l.options.Algorithm {
+	case GCRA:
+		step = gcraStep
+	case TokenBucket:
+		step = tokenBucketStep
+	case FixedWindow:
+		step = fixedWindowStep
+	case SlidingLog:
+		step = slidingLogStep
+	default:
+		return Result{}, ErrUnknownAlgorithm
+	}
+
+	now := l.now()
+	var result Result
+	l.cache.Update(l.options.Prefix+key, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+		var state interface{}
+		if found {
+			state = item.Value
+		}
+		next, expires, r := step(state, now, int64(limit), int64(window))
+		result = r
+		if !r.Allowed {
+			return item, false
+		}
+		return cache.CacheItem{Value: next, Expiration: expires}, true
+	})
+	result.Limit = limit
+
+	if result.Allowed {
+		atomic.AddUint64(&l.stats.Allowed, 1)
+	} else {
+		atomic.AddUint64(&l.stats.Denied, 1)
+	}
+	return result, nil
+}
+
+// Will return the limiter's counters, prefixed with "ratelimit_"
+func (l *Limiter) GetStats() map[string]uint64 {
+	return map[string]uint64{
+		"ratelimit_allowed": atomic.LoadUint64(&l.stats.Allowed),
+		"ratelimit_denied":  atomic.LoadUint64(&l.stats.Denied),
+	}
+}
@@ -0,0 +1,172 @@
+package ratelimit
+
+import (
+	"golang-memory-cache/cache"
+	"sync"
+	"testing"
+	"time"
+)
+
+var algorithms = []Algorithm{GCRA, TokenBucket, FixedWindow, SlidingLog}
+
+// Will create a Limiter whose clock only moves with the returned advance function.
+// The clock starts at the beginning of the next minute, so fixed windows of a minute or less start with it, and the
+// states it writes don't expire in real time during the test. The algorithms must ignore them once stale.
+func newTestLimiter(t *testing.T, a Algorithm) (*Limiter, func(d time.Duration)) {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	l := NewLimiterWithOptions(c, Options{Algorithm: a})
+	var mu sync.Mutex
+	now := time.Now().Truncate(time.Minute).Add(time.Minute).UnixNano()
+	l.now = func() int64 {
+		mu.Lock()
+		defer mu.Unlock()
+		return now
+	}
+	return l, func(d time.Duration) {
+		mu.Lock()
+		now += int64(d)
+		mu.Unlock()
+	}
+}
+
+func allowed(t *testing.T, l *Limiter, n int, limit int, window time.Duration) int {
+	t.Helper()
+	count := 0
+	for i := 0; i < n; i++ {
+		r, err := l.Allow("k", limit, window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Allowed {
+			count++
+		}
+	}
+	return count
+}
+
+// Will test that every algorithm allows a burst of limit requests, denies the next one with a retry-after
+// that is long enough, and allows requests again once the window passed
+func TestLimit(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(a.String(), func(t *testing.T) {
+			l, advance := newTestLimiter(t, a)
+			first, _ := l.Allow("k", 5, time.Second)
+			if !first.Allowed || first.Remaining != 4 || first.Limit != 5 {
+				t.Errorf("Unexpected first result: %+v", first)
+			}
+			if n := allowed(t, l, 4, 5, time.Second); n != 4 {
+				t.Errorf("Expected the whole burst to be allowed, got %d", n)
+			}
+
+			denied, _ := l.Allow("k", 5, time.Second)
+			if denied.Allowed || denied.Remaining != 0 || denied.RetryAfter <= 0 || denied.RetryAfter > time.Second {
+				t.Fatalf("Unexpected result over the limit: %+v", denied)
+			}
+			advance(denied.RetryAfter - time.Millisecond)
+			if r, _ := l.Allow("k", 5, time.Second); r.Allowed {
+				t.Errorf("Expected a request before retry-after to be denied: %+v", r)
+			}
+			advance(time.Millisecond)
+			if r, _ := l.Allow("k", 5, time.Second); !r.Allowed {
+				t.Errorf("Expected a request at retry-after to be allowed: %+v", r)
+			}
+
+			advance(time.Second)
+			if n := allowed(t, l, 10, 5, time.Second); n != 5 {
+				t.Errorf("Expected the limit to be available again after a window, got %d", n)
+			}
+			if r, _ := l.Allow("other", 5, time.Second); !r.Allowed {
+				t.Errorf("Expected keys to have limits of their own: %+v", r)
+			}
+		})
+	}
+}
+
+// Will test how many requests each algorithm allows over a long run of steady traffic, at twice the limit
+func TestSteadyRate(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(a.String(), func(t *testing.T) {
+			l, advance := newTestLimiter(t, a)
+			total := 0
+			for i := 0; i < 200; i++ { // 10 windows, 20 requests per window
+				total += allowed(t, l, 1, 10, time.Second)
+				advance(50 * time.Millisecond)
+			}
+			if total < 100 || total > 110 {
+				t.Errorf("Expected about 10 requests per window, got %d in 10 windows", total)
+			}
+		})
+	}
+}
+
+// Will test the burst at the boundary of two fixed windows, which the other algorithms don't allow
+func TestWindowBoundary(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(a.String(), func(t *testing.T) {
+			l, advance := newTestLimiter(t, a)
+			advance(900 * time.Millisecond)
+			n := allowed(t, l, 10, 10, time.Second)
+			advance(200 * time.Millisecond)
+			n += allowed(t, l, 10, 10, time.Second)
+
+			max := 12 // The burst, and the 2 tokens or slots that came back in 200ms
+			if a == FixedWindow {
+				max = 20
+			}
+			if n > max {
+				t.Errorf("Expected at most %d requests in 200ms, got %d", max, n)
+			}
+		})
+	}
+}
+
+func TestConcurrentRequests(t *testing.T) {
+	for _, a := range algorithms {
+		t.Run(a.String(), func(t *testing.T) {
+			l, _ := newTestLimiter(t, a)
+			var wg sync.WaitGroup
+			var mu sync.Mutex
+			total := 0
+			for i := 0; i < 10; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					n := allowed(t, l, 20, 50, time.Minute)
+					mu.Lock()
+					total += n
+					mu.Unlock()
+				}()
+			}
+			wg.Wait()
+			if total != 50 {
+				t.Errorf("Expected exactly 50 of 200 concurrent requests to be allowed, got %d", total)
+			}
+		})
+	}
+}
+
+func TestInvalid(t *testing.T) {
+	l, _ := newTestLimiter(t, GCRA)
+	if _, err := l.Allow("k", 0, time.Second); err != ErrInvalidLimit {
+		t.Errorf("Expected ErrInvalidLimit, got %v", err)
+	}
+	if _, err := l.withAlgorithm(Algorithm(42)).Allow("k", 1, time.Second); err != ErrUnknownAlgorithm {
+		t.Errorf("Expected ErrUnknownAlgorithm, got %v", err)
+	}
+	for _, a := range algorithms {
+		if parsed, ok := ParseAlgorithm(a.String()); !ok || parsed != a {
+			t.Errorf("Expected %s to parse back", a)
+		}
+	}
+
+	// Switching algorithms starts the key over
+	l.Allow("k", 1, time.Minute)
+	if r, _ := l.withAlgorithm(TokenBucket).Allow("k", 1, time.Minute); !r.Allowed {
+		t.Errorf("Expected a fresh limit after switching algorithms: %+v", r)
+	}
+	if stats := l.GetStats(); stats["ratelimit_allowed"] != 2 {
+		t.Errorf("Unexpected stats: %v", stats)
+	}
+}
@@ -0,0 +1,288 @@
+package replication
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings used when creating a Follower
+type FollowerOptions struct {
+	HTTPClient  *http.Client  // Used to reach the primary, http.DefaultClient when nil
+	IdleTimeout time.Duration // The stream is reconnected when nothing (not even a heartbeat) arrived for this long
+
+	// Wait before reconnecting to the primary, doubled after every failed attempt up to MaxReconnectBackoff
+	ReconnectBackoff    time.Duration
+	MaxReconnectBackoff time.Duration
+}
+
+// Options used by NewFollower
+var DefaultFollowerOptions = FollowerOptions{
+	IdleTimeout:         5 * time.Second,
+	ReconnectBackoff:    100 * time.Millisecond,
+	MaxReconnectBackoff: 10 * time.Second,
+}
+
+// Returned by sync when the stream can't continue and a full sync is needed
+var errResync = errors.New("replication: full sync needed")
+
+type Follower struct {
+	cache   *cache.Cache
+	primary string
+	client  *http.Client
+	options FollowerOptions
+
+	// Only used by the follow goroutine
+	id  string // ID of the primary the cache is a copy of, empty until the first full sync
+	seq uint64 // Last change of the primary applied to the cache
+
+	applied     atomic.Uint64 // Same as seq, for GetStats
+	primarySeq  atomic.Uint64 // Latest change on the primary, as of the last frame
+	lastContact atomic.Int64  // Unix nanoseconds of the last frame
+	connected   atomic.Bool
+	fullSyncs   atomic.Uint64
+	reconnects  atomic.Uint64
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	ready  chan struct{} // Closed after the first full sync
+	once   sync.Once
+}
+
+// Creates a Follower with DefaultFollowerOptions. See NewFollowerWithOptions.
+func NewFollower(c *cache.Cache, primary string) (*Follower, error) {
+	return NewFollowerWithOptions(c, primary, DefaultFollowerOptions)
+}
+
+// Creates a Follower that keeps c a copy of the cache served at primary (i.e. "http://10.0.0.1:8080"),
+// and starts replicating right away. Every key of c is replaced by the primary's keys on the first sync.
+// Call Close to stop replicating.
+func NewFollowerWithOptions(c *cache.Cache, primary string, opts FollowerOptions) (*Follower, error) {
+	primary = strings.TrimRight(primary, "/")
+	u, err := url.Parse(primary)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("replication: invalid primary URL %q", primary)
+	}
+	if opts.IdleTimeout <= 0 {
+		opts.IdleTimeout = DefaultFollowerOptions.IdleTimeout
+	}
+	if opts.ReconnectBackoff <= 0 {
+		opts.ReconnectBackoff = DefaultFollowerOptions.ReconnectBackoff
+	}
+	client := opts.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f := &Follower{
+		cache:   c,
+		primary: primary,
+		client:  client,
+		options: opts,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+	go f.follow(ctx)
+	return f, nil
+}
+
+// Will block until the first full sync finished, or until ctx is done
+func (f *Follower) WaitReady(ctx context.Context) error {
+	select {
+	case <-f.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Will stop replicating. The cache keeps the keys it has.
+func (f *Follower) Close() {
+	f.cancel()
+	<-f.done
+}
+
+func (f *Follower) follow(ctx context.Context) {
+	defer close(f.done)
+
+	backoff := f.options.ReconnectBackoff
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			f.reconnects.Add(1)
+		}
+
+		err := f.sync(ctx, func() { backoff = f.options.ReconnectBackoff })
+		f.connected.Store(false)
+		if ctx.Err() != nil {
+			return
+		}
+		if errors.Is(err, errResync) {
+			f.id = "" // Start over with a snapshot, right away
+			backoff = f.options.ReconnectBackoff
+			attempt = -1
+			continue
+		}
+		log.Printf("replication: %v, reconnecting in %s", err, backoff)
+		backoff = f.nextBackoff(backoff)
+	}
+}
+
+func (f *Follower) nextBackoff(d time.Duration) time.Duration {
+	d *= 2
+	if f.options.MaxReconnectBackoff > 0 && d > f.options.MaxReconnectBackoff {
+		d = f.options.MaxReconnectBackoff
+	}
+	return d
+}
+
+// Will do a full sync when needed, then apply the stream until it ends. connected is called once the stream is up.
+func (f *Follower) sync(ctx context.Context, connected func()) error {
+	if f.id == "" {
+		if err := f.fullSync(ctx); err != nil {
+			return err
+		}
+	}
+
+	// The request is cancelled when the primary goes quiet for longer than IdleTimeout
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	idle := time.AfterFunc(f.options.IdleTimeout, cancel)
+	defer idle.Stop()
+
+	resp, err := f.get(ctx, fmt.Sprintf("/replication/stream?id=%s&seq=%d", url.QueryEscape(f.id), f.seq))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusGone:
+		return errResync
+	default:
+		return fmt.Errorf("replication: stream returned status %d", resp.StatusCode)
+	}
+	f.connected.Store(true)
+	connected()
+
+	dec := gob.NewDecoder(resp.Body)
+	for {
+		var fr frame
+		if err := dec.Decode(&fr); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("replication: nothing from %s for %s", f.primary, f.options.IdleTimeout)
+			}
+			if errors.Is(err, io.EOF) {
+				return errors.New("replication: stream closed by the primary")
+			}
+			return fmt.Errorf("replication: reading stream: %w", err)
+		}
+		idle.Reset(f.options.IdleTimeout)
+		f.lastContact.Store(time.Now().UnixNano())
+		if fr.Resync {
+			return errResync
+		}
+
+		for _, ch := range fr.Changes {
+			if ch.Seq != f.seq+1 {
+				return errResync // A gap, should never happen
+			}
+			f.cache.Apply(ch)
+			f.seq = ch.Seq
+		}
+		f.applied.Store(f.seq)
+		f.primarySeq.Store(fr.Seq)
+	}
+}
+
+// Will replace every key of the cache with a snapshot of the primary
+func (f *Follower) fullSync(ctx context.Context) error {
+	resp, err := f.get(ctx, "/replication/snapshot")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("replication: snapshot returned status %d", resp.StatusCode)
+	}
+	id := resp.Header.Get(IDHeader)
+	if id == "" {
+		return fmt.Errorf("replication: %s did not send a replication ID", f.primary)
+	}
+
+	loaded, seq, err := f.cache.ReplaceWithSnapshot(resp.Body)
+	if err != nil {
+		return fmt.Errorf("replication: %w", err)
+	}
+	f.id, f.seq = id, seq
+	f.applied.Store(seq)
+	f.primarySeq.Store(seq)
+	f.lastContact.Store(time.Now().UnixNano())
+	f.fullSyncs.Add(1)
+	log.Printf("replication: full sync from %s, %d keys at change %d", f.primary, loaded, seq)
+	f.once.Do(func() { close(f.ready) })
+	return nil
+}
+
+func (f *Follower) get(ctx context.Context, path string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", f.primary+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return f.client.Do(req)
+}
+
+// Will return "replication_seq" (the last change applied), "replication_primary_seq", "replication_lag"
+// (changes not applied yet), "replication_connected", "replication_last_contact_ms" (since the last frame),
+// "replication_full_syncs" and "replication_reconnects"
+func (f *Follower) GetStats() map[string]uint64 {
+	applied, primarySeq := f.applied.Load(), f.primarySeq.Load()
+	var lag, connected, sinceContact uint64
+	if primarySeq > applied {
+		lag = primarySeq - applied
+	}
+	if f.connected.Load() {
+		connected = 1
+	}
+	if last := f.lastContact.Load(); last > 0 {
+		sinceContact = uint64(time.Since(time.Unix(0, last)).Milliseconds())
+	}
+	return map[string]uint64{
+		"replication_seq":             applied,
+		"replication_primary_seq":     primarySeq,
+		"replication_lag":             lag,
+		"replication_connected":       connected,
+		"replication_last_contact_ms": sinceContact,
+		"replication_full_syncs":      f.fullSyncs.Load(),
+		"replication_reconnects":      f.reconnects.Load(),
+	}
+}
+
+// Will wrap the API of a follower so it only serves reads. Writes would be overwritten by the primary
+// (or worse, never be), so they are refused with 403 and must go to the primary instead.
+func ReadOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" && r.Method != "HEAD" {
+			api.WriteError(w, http.StatusForbidden, "Read-only replica, send writes to the primary")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,243 @@
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/gob"
+	"encoding/hex"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Leader-follower replication. A Primary records every change to its cache.Cache (sets, deletes, expirations
+// and flushes) in a backlog, and serves it over HTTP next to the normal API. A Follower keeps a read-only copy
+// of the primary's cache:
+//
+//  1. GET /replication/snapshot gives a full snapshot, which the follower loads in place of its own keys.
+//     The snapshot includes the sequence number of the last change it contains.
+//  2. GET /replication/stream?id=&seq= streams every change after seq, followed by new changes as they happen.
+//
+// A follower that reconnects continues from the last change it applied, as long as that change is still in the
+// backlog and the primary was not restarted in between (every Primary has a random ID). Otherwise the stream
+// answers 410 Gone and the follower starts over with a snapshot.
+
+// Response header with the ID of the primary that wrote a snapshot
+const IDHeader = "X-Replication-ID"
+
+// Settings used when creating a Primary
+type PrimaryOptions struct {
+	Backlog   int           // How many recent changes are kept for followers that reconnect
+	Heartbeat time.Duration // How often an idle stream sends a frame, so followers notice a dead primary
+	MaxFrame  int           // Most changes sent in a single frame
+}
+
+// Options used by NewPrimary
+var DefaultPrimaryOptions = PrimaryOptions{
+	Backlog:   100000,
+	Heartbeat: time.Second,
+	MaxFrame:  1000,
+}
+
+// What the stream is made of, encoded with encoding/gob one after the other
+type frame struct {
+	Changes []cache.Change
+	Seq     uint64 // Latest change on the primary when the frame was sent, so followers know their lag
+	Resync  bool   // The follower fell out of the backlog and must start over with a snapshot
+}
+
+type Primary struct {
+	cache   *cache.Cache
+	options PrimaryOptions
+	id      string
+
+	mu      sync.Mutex
+	backlog []cache.Change // Contiguous changes, ending with the change numbered last
+	last    uint64
+	notify  chan struct{} // Closed and replaced whenever a change is recorded
+
+	followers sync.Map // *http.Request -> *atomic.Uint64, the last change sent to each connected follower
+	fullSyncs atomic.Uint64
+}
+
+// Creates a Primary with DefaultPrimaryOptions, and starts recording the changes of c
+func NewPrimary(c *cache.Cache) *Primary {
+	return NewPrimaryWithOptions(c, DefaultPrimaryOptions)
+}
+
+// Creates a Primary with custom options. Zero values fall back to DefaultPrimaryOptions.
+// A cache has a single journal, so only one Primary can record a cache at a time.
+func NewPrimaryWithOptions(c *cache.Cache, opts PrimaryOptions) *Primary {
+	if opts.Backlog <= 0 {
+		opts.Backlog = DefaultPrimaryOptions.Backlog
+	}
+	if opts.Heartbeat <= 0 {
+		opts.Heartbeat = DefaultPrimaryOptions.Heartbeat
+	}
+	if opts.MaxFrame <= 0 {
+		opts.MaxFrame = DefaultPrimaryOptions.MaxFrame
+	}
+
+	p := &Primary{
+		cache:   c,
+		options: opts,
+		id:      newID(),
+		notify:  make(chan struct{}),
+	}
+	p.last = c.SetJournal(p)
+	return p
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Will return the random ID of this primary
+func (p *Primary) ID() string { return p.id }
+
+// Will stop recording changes. Connected followers keep their stream, but get no new changes.
+func (p *Primary) Close() {
+	p.cache.SetJournal(nil)
+}
+
+// Called by the cache, with the cache locked, for every change
+func (p *Primary) Record(ch cache.Change) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// Drop the oldest half at once when full, instead of shifting on every change
+	if len(p.backlog) >= p.options.Backlog {
+		keep := p.options.Backlog / 2
+		p.backlog = append(p.backlog[:0], p.backlog[len(p.backlog)-keep:]...)
+	}
+	p.backlog = append(p.backlog, ch)
+	p.last = ch.Seq
+	close(p.notify)
+	p.notify = make(chan struct{})
+}
+
+// Will return up to max changes after seq, the latest change number, and a channel closed by the next change.
+// ok is false when the changes after seq are no longer (or not yet) in the backlog.
+func (p *Primary) changesAfter(seq uint64, max int) (changes []cache.Change, last uint64, notify <-chan struct{}, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	first := p.last - uint64(len(p.backlog)) + 1 // Number of the oldest change in the backlog
+	if seq > p.last || seq+1 < first {
+		return nil, p.last, p.notify, false
+	}
+	start := int(seq + 1 - first)
+	end := min(len(p.backlog), start+max)
+	changes = append([]cache.Change(nil), p.backlog[start:end]...)
+	return changes, p.last, p.notify, true
+}
+
+// Will mount the replication endpoints on mux
+func (p *Primary) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("GET /replication/snapshot", p.SnapshotHandler)
+	mux.HandleFunc("GET /replication/stream", p.StreamHandler)
+}
+
+// * GET /replication/snapshot
+// Will write a full snapshot, in the same format as cache.SaveSnapshot
+func (p *Primary) SnapshotHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set(IDHeader, p.id)
+	p.fullSyncs.Add(1)
+	p.cache.SaveSnapshot(w)
+}
+
+// * GET /replication/stream?id=3f2a9c01d4e5b6a7&seq=1200
+// Will stream every change after seq until the client goes away.
+// Answers 410 Gone when id is not this primary's ID or seq is out of the backlog.
+func (p *Primary) StreamHandler(w http.ResponseWriter, r *http.Request) {
+	seq, err := strconv.ParseUint(r.URL.Query().Get("seq"), 10, 64)
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid seq")
+		return
+	}
+	if r.URL.Query().Get("id") != p.id {
+		api.WriteError(w, http.StatusGone, "Unknown replication ID, a full sync is needed")
+		return
+	}
+	if _, _, _, ok := p.changesAfter(seq, 0); !ok {
+		api.WriteError(w, http.StatusGone, "Sequence no longer in the backlog, a full sync is needed")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.WriteError(w, http.StatusInternalServerError, "Streaming not supported")
+		return
+	}
+
+	sent := new(atomic.Uint64)
+	sent.Store(seq)
+	p.followers.Store(r, sent)
+	defer p.followers.Delete(r)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := gob.NewEncoder(w)
+	heartbeat := time.NewTicker(p.options.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		changes, last, notify, ok := p.changesAfter(seq, p.options.MaxFrame)
+		if !ok {
+			// Fell behind further than the backlog reaches
+			enc.Encode(frame{Seq: last, Resync: true})
+			flusher.Flush()
+			return
+		}
+		if len(changes) > 0 {
+			if err := enc.Encode(frame{Changes: changes, Seq: last}); err != nil {
+				return
+			}
+			flusher.Flush()
+			seq = changes[len(changes)-1].Seq
+			sent.Store(seq)
+			continue
+		}
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-notify:
+		case <-heartbeat.C:
+			if err := enc.Encode(frame{Seq: last}); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// Will return "replication_seq" (the latest change), "replication_backlog", "replication_followers",
+// "replication_full_syncs" and "replication_max_lag", how many changes the slowest follower has not been sent yet
+func (p *Primary) GetStats() map[string]uint64 {
+	p.mu.Lock()
+	last, backlog := p.last, len(p.backlog)
+	p.mu.Unlock()
+
+	var followers, maxLag uint64
+	p.followers.Range(func(_, v any) bool {
+		followers++
+		if sent := v.(*atomic.Uint64).Load(); sent < last && last-sent > maxLag {
+			maxLag = last - sent
+		}
+		return true
+	})
+	return map[string]uint64{
+		"replication_seq":        last,
+		"replication_backlog":    uint64(backlog),
+		"replication_followers":  followers,
+		"replication_full_syncs": p.fullSyncs.Load(),
+		"replication_max_lag":    maxLag,
+	}
+}
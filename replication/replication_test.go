@@ -0,0 +1,210 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// A primary served in-process, with a switch to make its stream unavailable
+type testPrimary struct {
+	cache   *cache.Cache
+	primary *Primary
+	server  *httptest.Server
+	down    atomic.Bool
+}
+
+func newTestPrimary(t *testing.T, opts PrimaryOptions) *testPrimary {
+	t.Helper()
+	p := &testPrimary{cache: cache.NewCache()}
+	p.primary = NewPrimaryWithOptions(p.cache, opts)
+	mux := (&api.Handler{Cache: p.cache}).Routes()
+	p.primary.RegisterRoutes(mux)
+	p.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p.down.Load() {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(func() {
+		p.server.Close()
+		p.cache.Stop()
+	})
+	return p
+}
+
+var testFollowerOptions = FollowerOptions{
+	IdleTimeout:         time.Second,
+	ReconnectBackoff:    5 * time.Millisecond,
+	MaxReconnectBackoff: 20 * time.Millisecond,
+}
+
+func newTestFollower(t *testing.T, primaryURL string) (*Follower, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	f, err := NewFollowerWithOptions(c, primaryURL, testFollowerOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Registered after the primary's cleanup, so the follower is closed first
+	t.Cleanup(func() {
+		f.Close()
+		c.Stop()
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := f.WaitReady(ctx); err != nil {
+		t.Fatalf("Follower never synced: %v", err)
+	}
+	return f, c
+}
+
+// Will poll cond until it is true, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func hasValue(c *cache.Cache, key string, want interface{}) func() bool {
+	return func() bool {
+		value, found := c.Get(key)
+		return found && value == want
+	}
+}
+
+func missing(c *cache.Cache, key string) func() bool {
+	return func() bool {
+		_, found := c.Get(key)
+		return !found
+	}
+}
+
+func TestReplication(t *testing.T) {
+	p := newTestPrimary(t, DefaultPrimaryOptions)
+	p.cache.SetWithMetadata("existing", "x", time.Hour, map[string]string{"source": "test"})
+	p.cache.Set("doomed", 1, cache.NoExpiration)
+
+	f, replica := newTestFollower(t, p.server.URL)
+
+	// Bootstrapped from the snapshot, with the same item
+	want, _ := p.cache.GetItem("existing")
+	got, found := replica.GetItem("existing")
+	if !found || got.Value != want.Value || got.Expiration != want.Expiration || got.Version != want.Version || got.Metadata["source"] != "test" {
+		t.Errorf("Expected %+v on the follower, got %+v", want, got)
+	}
+
+	// Then every change is streamed
+	p.cache.Set("new", "y", cache.NoExpiration)
+	waitFor(t, "a set", hasValue(replica, "new", "y"))
+	p.cache.Delete("doomed")
+	waitFor(t, "a delete", missing(replica, "doomed"))
+	p.cache.Increment("counter", 5)
+	waitFor(t, "an increment", hasValue(replica, "counter", int64(5)))
+	p.cache.Expire("new", time.Hour)
+	waitFor(t, "an expire", func() bool {
+		item, _ := replica.GetItem("new")
+		return item.Expiration != 0
+	})
+	p.cache.Flush()
+	waitFor(t, "a flush", func() bool { return replica.Len() == 0 })
+
+	waitFor(t, "no lag", func() bool {
+		stats := f.GetStats()
+		return stats["replication_lag"] == 0 && stats["replication_seq"] == p.cache.Seq()
+	})
+	stats := f.GetStats()
+	if stats["replication_connected"] != 1 || stats["replication_full_syncs"] != 1 {
+		t.Errorf("Unexpected follower stats: %v", stats)
+	}
+	if stats := p.primary.GetStats(); stats["replication_followers"] != 1 || stats["replication_seq"] != p.cache.Seq() {
+		t.Errorf("Unexpected primary stats: %v", stats)
+	}
+}
+
+// Will test that a follower that loses its stream continues where it left off, without a new snapshot
+func TestResume(t *testing.T) {
+	p := newTestPrimary(t, DefaultPrimaryOptions)
+	f, replica := newTestFollower(t, p.server.URL)
+
+	p.cache.Set("a", 1, cache.NoExpiration)
+	waitFor(t, "the first set", hasValue(replica, "a", 1))
+
+	p.down.Store(true)
+	p.server.CloseClientConnections()
+	p.cache.Set("b", 2, cache.NoExpiration) // Written while the follower is disconnected
+	waitFor(t, "the follower to notice", func() bool { return f.GetStats()["replication_connected"] == 0 })
+	p.down.Store(false)
+
+	waitFor(t, "the missed set", hasValue(replica, "b", 2))
+	if stats := f.GetStats(); stats["replication_full_syncs"] != 1 || stats["replication_reconnects"] == 0 {
+		t.Errorf("Expected a reconnect without a full sync, got %v", stats)
+	}
+}
+
+// Will test that a follower that fell out of the backlog starts over with a snapshot
+func TestResyncOutsideBacklog(t *testing.T) {
+	p := newTestPrimary(t, PrimaryOptions{Backlog: 4})
+	f, replica := newTestFollower(t, p.server.URL)
+
+	p.down.Store(true)
+	p.server.CloseClientConnections()
+	waitFor(t, "the follower to notice", func() bool { return f.GetStats()["replication_connected"] == 0 })
+	for i := 0; i < 20; i++ {
+		p.cache.Set(fmt.Sprintf("k%d", i), i, cache.NoExpiration)
+	}
+	p.down.Store(false)
+
+	waitFor(t, "the full sync", func() bool { return replica.Len() == 20 })
+	if stats := f.GetStats(); stats["replication_full_syncs"] != 2 {
+		t.Errorf("Expected a second full sync, got %v", stats)
+	}
+
+	p.cache.Set("after", true, cache.NoExpiration)
+	waitFor(t, "streaming after the full sync", hasValue(replica, "after", true))
+}
+
+// Will test that the stream refuses followers of another primary, i.e. one that restarted
+func TestStreamUnknownID(t *testing.T) {
+	p := newTestPrimary(t, DefaultPrimaryOptions)
+	resp, err := http.Get(p.server.URL + "/replication/stream?id=other&seq=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusGone {
+		t.Errorf("Expected 410, got %d", resp.StatusCode)
+	}
+}
+
+func TestReadOnly(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	c.Set("a", "x", cache.NoExpiration)
+	handler := ReadOnly((&api.Handler{Cache: c}).Routes())
+
+	req := httptest.NewRequest("PUT", "/v2/keys/a", nil)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Errorf("Expected 403 for a write, got %d", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest("GET", "/v2/keys/a", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected reads to be served, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,460 @@
+package resp
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang-memory-cache/cache"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A command handler. args[0] is the command name.
+type commandFunc func(sess *session, args []string)
+
+type command struct {
+	// Number of arguments including the command name, like Redis: N means exactly N, -N means at least N
+	arity int
+	run   commandFunc
+	write bool // Rejected with READONLY when the server is read-only
+}
+
+// Every supported command, keyed by lowercase name
+var commands map[string]command
+
+// Filled in init, because some handlers refer back to the commands map (i.e. COMMAND COUNT)
+func init() {
+	commands = map[string]command{
+		"ping":     {-1, cmdPing, false},
+		"echo":     {2, cmdEcho, false},
+		"hello":    {-1, cmdHello, false},
+		"select":   {2, cmdSelect, false},
+		"quit":     {1, cmdQuit, false},
+		"command":  {-1, cmdCommand, false},
+		"get":      {2, cmdGet, false},
+		"set":      {-3, cmdSet, true},
+		"del":      {-2, cmdDel, true},
+		"exists":   {-2, cmdExists, false},
+		"expire":   {3, cmdExpire, true},
+		"pexpire":  {3, cmdExpire, true},
+		"ttl":      {2, cmdTTL, false},
+		"pttl":     {2, cmdTTL, false},
+		"persist":  {2, cmdPersist, true},
+		"incr":     {2, cmdIncr, true},
+		"decr":     {2, cmdIncr, true},
+		"incrby":   {3, cmdIncr, true},
+		"decrby":   {3, cmdIncr, true},
+		"mget":     {-2, cmdMGet, false},
+		"mset":     {-3, cmdMSet, true},
+		"keys":     {2, cmdKeys, false},
+		"scan":     {-2, cmdScan, false},
+		"dbsize":   {1, cmdDBSize, false},
+		"flushdb":  {-1, cmdFlush, true},
+		"flushall": {-1, cmdFlush, true},
+		"info":     {-1, cmdInfo, false},
+	}
+}
+
+// Will look up and run a command, checking the number of arguments first
+func (sess *session) execute(args []string) {
+	name := strings.ToLower(args[0])
+	cmd, ok := commands[name]
+	if !ok {
+		sess.w.WriteError(fmt.Sprintf("ERR unknown command '%s'", args[0]))
+		return
+	}
+	if (cmd.arity > 0 && len(args) != cmd.arity) || (cmd.arity < 0 && len(args) < -cmd.arity) {
+		sess.w.WriteError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", name))
+		return
+	}
+	if cmd.write && sess.server.ReadOnly {
+		sess.w.WriteError("READONLY You can't write against a read only replica.")
+		return
+	}
+	cmd.run(sess, args)
+}
+
+const (
+	errSyntax     = "ERR syntax error"
+	errNotInteger = "ERR value is not an integer or out of range"
+	errInvalidTTL = "ERR invalid expire time in '%s' command"
+	errWrongType  = "WRONGTYPE Operation against a key holding the wrong kind of value"
+)
+
+// Will report whether value is one of the structures of cache/types.go, which string commands don't work on
+func isStructure(value interface{}) bool {
+	switch value.(type) {
+	case cache.List, cache.Hash, cache.Set, cache.SortedSet:
+		return true
+	}
+	return false
+}
+
+// Will turn a cached value into the string sent to the client.
+// Strings and bytes are sent as-is, integers as decimals and anything else (i.e. values set through the JSON API) as JSON.
+func formatValue(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(data)
+	}
+}
+
+func cmdPing(sess *session, args []string) {
+	switch len(args) {
+	case 1:
+		sess.w.WriteSimpleString("PONG")
+	case 2:
+		sess.w.WriteBulkString(args[1])
+	default:
+		sess.w.WriteError("ERR wrong number of arguments for 'ping' command")
+	}
+}
+
+func cmdEcho(sess *session, args []string) {
+	sess.w.WriteBulkString(args[1])
+}
+
+// HELLO [protover] switches between RESP2 and RESP3 and returns a map describing the server.
+// AUTH and SETNAME options are accepted and ignored.
+func cmdHello(sess *session, args []string) {
+	if len(args) > 1 {
+		version, err := strconv.Atoi(args[1])
+		if err != nil {
+			sess.w.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if version != 2 && version != 3 {
+			sess.w.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		sess.w.Protocol = version
+	}
+
+	sess.w.WriteMapHeader(5)
+	sess.w.WriteBulkString("server")
+	sess.w.WriteBulkString("golang-memory-cache")
+	sess.w.WriteBulkString("version")
+	sess.w.WriteBulkString("7.0.0")
+	sess.w.WriteBulkString("proto")
+	sess.w.WriteInteger(int64(sess.w.Protocol))
+	sess.w.WriteBulkString("mode")
+	sess.w.WriteBulkString("standalone")
+	sess.w.WriteBulkString("modules")
+	sess.w.WriteArrayHeader(0)
+}
+
+// There is a single database, so only SELECT 0 works
+func cmdSelect(sess *session, args []string) {
+	if args[1] != "0" {
+		sess.w.WriteError("ERR DB index is out of range")
+		return
+	}
+	sess.w.WriteSimpleString("OK")
+}
+
+func cmdQuit(sess *session, args []string) {
+	sess.quit = true
+	sess.w.WriteSimpleString("OK")
+}
+
+// redis-cli sends COMMAND DOCS on start, an empty reply makes it fall back to its built-in help
+func cmdCommand(sess *session, args []string) {
+	if len(args) > 1 && strings.EqualFold(args[1], "count") {
+		sess.w.WriteInteger(int64(len(commands)))
+		return
+	}
+	sess.w.WriteArrayHeader(0)
+}
+
+// Lists, hashes, sets and sorted sets are not strings, and get WRONGTYPE
+func cmdGet(sess *session, args []string) {
+	value, found := sess.server.Cache.Get(args[1])
+	if !found {
+		sess.w.WriteNull()
+		return
+	}
+	if isStructure(value) {
+		sess.w.WriteError(errWrongType)
+		return
+	}
+	sess.w.WriteBulkString(formatValue(value))
+}
+
+// SET key value [EX seconds | PX milliseconds] [NX | XX]
+// Without EX or PX the key never expires. With NX or XX a failed condition replies with null.
+func cmdSet(sess *session, args []string) {
+	key, value := args[1], args[2]
+	duration := cache.NoExpiration
+	var cond cache.Condition
+
+	for i := 3; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "ex", "px":
+			if i+1 >= len(args) || duration != cache.NoExpiration {
+				sess.w.WriteError(errSyntax)
+				return
+			}
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil {
+				sess.w.WriteError(errNotInteger)
+				return
+			}
+			unit := time.Second
+			if strings.EqualFold(args[i], "px") {
+				unit = time.Millisecond
+			}
+			if n <= 0 || n > math.MaxInt64/int64(unit) {
+				sess.w.WriteError(fmt.Sprintf(errInvalidTTL, "set"))
+				return
+			}
+			duration = time.Duration(n) * unit
+			i++
+		case "nx":
+			if cond != nil {
+				sess.w.WriteError(errSyntax)
+				return
+			}
+			cond = cache.IfMissing
+		case "xx":
+			if cond != nil {
+				sess.w.WriteError(errSyntax)
+				return
+			}
+			cond = cache.IfExists
+		default:
+			sess.w.WriteError(errSyntax)
+			return
+		}
+	}
+
+	if _, ok := sess.server.Cache.SetIf(key, value, duration, nil, cond); !ok {
+		sess.w.WriteNull()
+		return
+	}
+	sess.w.WriteSimpleString("OK")
+}
+
+// Replies with the number of keys that existed and were deleted
+func cmdDel(sess *session, args []string) {
+	var deleted int64
+	for _, key := range args[1:] {
+		if sess.server.Cache.DeleteIf(key, nil) {
+			deleted++
+		}
+	}
+	sess.w.WriteInteger(deleted)
+}
+
+// Replies with how many of the keys exist, counting a key again every time it is repeated (like Redis)
+func cmdExists(sess *session, args []string) {
+	var count int64
+	for _, key := range args[1:] {
+		if _, found := sess.server.Cache.Peek(key); found {
+			count++
+		}
+	}
+	sess.w.WriteInteger(count)
+}
+
+// EXPIRE key seconds / PEXPIRE key milliseconds. A TTL of zero or less deletes the key, like Redis.
+func cmdExpire(sess *session, args []string) {
+	n, err := strconv.ParseInt(args[2], 10, 64)
+	if err != nil {
+		sess.w.WriteError(errNotInteger)
+		return
+	}
+	unit := time.Second
+	if strings.EqualFold(args[0], "pexpire") {
+		unit = time.Millisecond
+	}
+	if n > math.MaxInt64/int64(unit) {
+		sess.w.WriteError(fmt.Sprintf(errInvalidTTL, strings.ToLower(args[0])))
+		return
+	}
+
+	if n <= 0 {
+		sess.w.WriteInteger(boolToInt(sess.server.Cache.DeleteIf(args[1], nil)))
+		return
+	}
+	sess.w.WriteInteger(boolToInt(sess.server.Cache.Expire(args[1], time.Duration(n)*unit)))
+}
+
+// TTL / PTTL reply with -2 for a missing key and -1 for a key without expiration
+func cmdTTL(sess *session, args []string) {
+	item, found := sess.server.Cache.Peek(args[1])
+	if !found {
+		sess.w.WriteInteger(-2)
+		return
+	}
+	ttl, expires := item.TTL()
+	if !expires {
+		sess.w.WriteInteger(-1)
+		return
+	}
+	if strings.EqualFold(args[0], "pttl") {
+		sess.w.WriteInteger(ttl.Milliseconds())
+		return
+	}
+	// Round up like Redis, so a key with 1.5s left reports 2 instead of 1
+	sess.w.WriteInteger(int64((ttl + time.Second - 1) / time.Second))
+}
+
+func cmdPersist(sess *session, args []string) {
+	sess.w.WriteInteger(boolToInt(sess.server.Cache.Persist(args[1])))
+}
+
+// INCR, DECR, INCRBY and DECRBY
+func cmdIncr(sess *session, args []string) {
+	delta := int64(1)
+	if len(args) == 3 {
+		n, err := strconv.ParseInt(args[2], 10, 64)
+		if err != nil {
+			sess.w.WriteError(errNotInteger)
+			return
+		}
+		delta = n
+	}
+	name := strings.ToLower(args[0])
+	if name == "decr" || name == "decrby" {
+		if delta == math.MinInt64 {
+			sess.w.WriteError("ERR decrement would overflow")
+			return
+		}
+		delta = -delta
+	}
+
+	n, err := sess.server.Cache.Increment(args[1], delta)
+	switch err {
+	case nil:
+		sess.w.WriteInteger(n)
+	case cache.ErrOverflow:
+		sess.w.WriteError("ERR increment or decrement would overflow")
+	default:
+		// Like GET, a structure is the wrong type, while any other value is just not an integer
+		if item, found := sess.server.Cache.Peek(args[1]); found && isStructure(item.Value) {
+			sess.w.WriteError(errWrongType)
+			return
+		}
+		sess.w.WriteError(errNotInteger)
+	}
+}
+
+func cmdMGet(sess *session, args []string) {
+	sess.w.WriteArrayHeader(len(args) - 1)
+	for _, key := range args[1:] {
+		// Like Redis, keys that don't hold strings are reported as missing
+		if value, found := sess.server.Cache.Get(key); found && !isStructure(value) {
+			sess.w.WriteBulkString(formatValue(value))
+		} else {
+			sess.w.WriteNull()
+		}
+	}
+}
+
+// MSET key value [key value ...]. Each key is set on its own, other clients can see a partially applied MSET.
+func cmdMSet(sess *session, args []string) {
+	if len(args)%2 != 1 {
+		sess.w.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	for i := 1; i < len(args); i += 2 {
+		sess.server.Cache.Set(args[i], args[i+1], cache.NoExpiration)
+	}
+	sess.w.WriteSimpleString("OK")
+}
+
+func cmdKeys(sess *session, args []string) {
+	sess.w.WriteStrings(sess.server.Cache.Keys(args[1]))
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count]
+// See cache.Scan for the cursor. Like in Redis, a key that exists during the whole scan is returned once, and keys
+// added or removed between calls may or may not be.
+func cmdScan(sess *session, args []string) {
+	cursor, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		sess.w.WriteError("ERR invalid cursor")
+		return
+	}
+
+	pattern, count := "", 10
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			sess.w.WriteError(errSyntax)
+			return
+		}
+		switch strings.ToLower(args[i]) {
+		case "match":
+			pattern = args[i+1]
+		case "count":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				sess.w.WriteError(errSyntax)
+				return
+			}
+			count = n
+		default:
+			sess.w.WriteError(errSyntax)
+			return
+		}
+	}
+
+	// COUNT limits how many keys are looked at, not how many are returned, so the pattern is applied to the page
+	keys, next := sess.server.Cache.Scan(cursor, count)
+	page := keys[:0]
+	for _, key := range keys {
+		if cache.MatchPattern(pattern, key) {
+			page = append(page, key)
+		}
+	}
+
+	sess.w.WriteArrayHeader(2)
+	sess.w.WriteBulkString(strconv.FormatUint(next, 10))
+	sess.w.WriteStrings(page)
+}
+
+// Like in Redis, expired keys count until the janitor removes them
+func cmdDBSize(sess *session, args []string) {
+	sess.w.WriteInteger(int64(sess.server.Cache.Len()))
+}
+
+// FLUSHDB / FLUSHALL, the ASYNC and SYNC options are accepted but flushing is always synchronous
+func cmdFlush(sess *session, args []string) {
+	sess.server.Cache.Flush()
+	sess.w.WriteSimpleString("OK")
+}
+
+// INFO replies with Redis style "field:value" lines built from the cache stats
+func cmdInfo(sess *session, args []string) {
+	stats := sess.server.Cache.GetStats()
+
+	var b strings.Builder
+	b.WriteString("# Server\r\n")
+	b.WriteString("redis_version:7.0.0\r\n")
+	b.WriteString("server_name:golang-memory-cache\r\n")
+	b.WriteString("\r\n# Stats\r\n")
+	fmt.Fprintf(&b, "keyspace_hits:%d\r\n", stats["hits"])
+	fmt.Fprintf(&b, "keyspace_misses:%d\r\n", stats["misses"])
+	fmt.Fprintf(&b, "expired_keys:%d\r\n", stats["expirations"])
+	fmt.Fprintf(&b, "total_sets:%d\r\n", stats["sets"])
+	fmt.Fprintf(&b, "total_deletes:%d\r\n", stats["deletes"])
+	b.WriteString("\r\n# Keyspace\r\n")
+	fmt.Fprintf(&b, "db0:keys=%d\r\n", len(sess.server.Cache.Keys("")))
+
+	sess.w.WriteBulkString(b.String())
+}
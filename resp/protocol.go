@@ -0,0 +1,324 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// RESP (REdis Serialization Protocol) framing. Every value starts with a type byte and ends with \r\n.
+// RESP2 has simple strings, errors, integers, bulk strings and arrays. RESP3 adds nulls, maps, booleans and doubles,
+// and is only used after a client switches to it with HELLO 3.
+
+// Type bytes
+const (
+	TypeSimpleString = '+'
+	TypeError        = '-'
+	TypeInteger      = ':'
+	TypeBulkString   = '$'
+	TypeArray        = '*'
+	TypeNull         = '_' // RESP3
+	TypeBoolean      = '#' // RESP3
+	TypeDouble       = ',' // RESP3
+	TypeMap          = '%' // RESP3
+)
+
+// Limits that protect the server from clients announcing huge payloads
+const (
+	maxBulkLength  = 512 << 20 // Same as Redis' proto-max-bulk-len default
+	maxArrayLength = 1 << 20
+	maxInlineSize  = 64 << 10
+	maxDepth       = 32 // Arrays and maps nested deeper than this are rejected, instead of recursing without end
+
+	// Most memory set aside for a value before its data arrives. Past it, buffers grow with the data actually received,
+	// so announcing a huge length without sending it costs the client as much as the server.
+	maxPrealloc = 64 << 10
+)
+
+var ErrProtocol = errors.New("resp: protocol error")
+
+// A single parsed RESP value. Which fields are used depends on Type.
+type Value struct {
+	Type  byte
+	Str   string  // Simple strings, errors, bulk strings and doubles
+	Int   int64   // Integers, and booleans (0 or 1)
+	Array []Value // Arrays, and maps as alternating keys and values
+	Null  bool    // Null bulk strings and arrays in RESP2, or the RESP3 null type
+}
+
+// Reads RESP values from a buffered connection
+type Reader struct {
+	br *bufio.Reader
+}
+
+func NewReader(r io.Reader) *Reader {
+	if br, ok := r.(*bufio.Reader); ok {
+		return &Reader{br: br}
+	}
+	return &Reader{br: bufio.NewReader(r)}
+}
+
+// Will report how many bytes are already buffered, used to batch pipelined replies into a single flush
+func (r *Reader) Buffered() int {
+	return r.br.Buffered()
+}
+
+// Will read one line without the trailing \r\n
+func (r *Reader) readLine() (string, error) {
+	line, err := r.br.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return "", fmt.Errorf("%w: line too long", ErrProtocol)
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", fmt.Errorf("%w: line not terminated by CRLF", ErrProtocol)
+	}
+	return string(line[:len(line)-2]), nil
+}
+
+// Will read any RESP value
+func (r *Reader) ReadValue() (Value, error) {
+	return r.readValue(0)
+}
+
+// Will read a value nested in depth arrays or maps
+func (r *Reader) readValue(depth int) (Value, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return Value{}, err
+	}
+	if len(line) == 0 {
+		return Value{}, fmt.Errorf("%w: empty line", ErrProtocol)
+	}
+
+	typ, rest := line[0], line[1:]
+	switch typ {
+	case TypeSimpleString, TypeError, TypeDouble:
+		return Value{Type: typ, Str: rest}, nil
+	case TypeInteger:
+		n, err := strconv.ParseInt(rest, 10, 64)
+		if err != nil {
+			return Value{}, fmt.Errorf("%w: invalid integer", ErrProtocol)
+		}
+		return Value{Type: typ, Int: n}, nil
+	case TypeNull:
+		return Value{Type: typ, Null: true}, nil
+	case TypeBoolean:
+		return Value{Type: typ, Int: boolToInt(rest == "t")}, nil
+	case TypeBulkString:
+		return r.readBulk(rest)
+	case TypeArray, TypeMap:
+		if depth >= maxDepth {
+			return Value{}, fmt.Errorf("%w: too many nested aggregates", ErrProtocol)
+		}
+		return r.readAggregate(typ, rest, depth+1)
+	default:
+		return Value{}, fmt.Errorf("%w: unknown type byte %q", ErrProtocol, typ)
+	}
+}
+
+func (r *Reader) readBulk(lengthStr string) (Value, error) {
+	length, err := strconv.Atoi(lengthStr)
+	if err != nil || length > maxBulkLength {
+		return Value{}, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	if length < 0 {
+		return Value{Type: TypeBulkString, Null: true}, nil
+	}
+
+	var sb strings.Builder
+	sb.Grow(min(length, maxPrealloc))
+	if _, err := io.CopyN(&sb, r.br, int64(length)); err != nil {
+		return Value{}, unexpectedEOF(err)
+	}
+	var crlf [2]byte
+	if _, err := io.ReadFull(r.br, crlf[:]); err != nil {
+		return Value{}, unexpectedEOF(err)
+	}
+	if crlf[0] != '\r' || crlf[1] != '\n' {
+		return Value{}, fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return Value{Type: TypeBulkString, Str: sb.String()}, nil
+}
+
+func (r *Reader) readAggregate(typ byte, lengthStr string, depth int) (Value, error) {
+	length, err := strconv.Atoi(lengthStr)
+	if err != nil || length > maxArrayLength {
+		return Value{}, fmt.Errorf("%w: invalid aggregate length", ErrProtocol)
+	}
+	if length < 0 {
+		return Value{Type: typ, Null: true}, nil
+	}
+	if typ == TypeMap {
+		length *= 2 // Maps are sent as key, value, key, value...
+	}
+
+	values := make([]Value, 0, min(length, maxPrealloc/64))
+	for i := 0; i < length; i++ {
+		v, err := r.readValue(depth)
+		if err != nil {
+			return Value{}, unexpectedEOF(err)
+		}
+		values = append(values, v)
+	}
+	return Value{Type: typ, Array: values}, nil
+}
+
+// Will turn io.EOF in the middle of a value into io.ErrUnexpectedEOF. io.EOF means the connection was closed
+// between commands.
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Will read a command sent by a client. Clients normally send a flat array of bulk strings, anything else in the
+// array is a protocol error. "Inline" commands (plain text like "PING" typed into telnet) are supported too.
+func (r *Reader) ReadCommand() ([]string, error) {
+	first, err := r.br.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] != TypeArray {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > maxInlineSize {
+			return nil, fmt.Errorf("%w: inline command too long", ErrProtocol)
+		}
+		return strings.Fields(line), nil
+	}
+
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length > maxArrayLength {
+		return nil, fmt.Errorf("%w: invalid aggregate length", ErrProtocol)
+	}
+	args := make([]string, 0, min(max(length, 0), maxPrealloc/16))
+	for i := 0; i < length; i++ {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		if len(line) == 0 || line[0] != TypeBulkString {
+			return nil, fmt.Errorf("%w: expected bulk string arguments", ErrProtocol)
+		}
+		arg, err := r.readBulk(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if arg.Null {
+			return nil, fmt.Errorf("%w: expected bulk string arguments", ErrProtocol)
+		}
+		args = append(args, arg.Str)
+	}
+	return args, nil
+}
+
+// Writes RESP replies. Protocol is 2 or 3, and decides how nulls and maps are encoded.
+type Writer struct {
+	bw       *bufio.Writer
+	Protocol int
+}
+
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{bw: bufio.NewWriter(w), Protocol: 2}
+}
+
+func (w *Writer) Flush() error { return w.bw.Flush() }
+
+func (w *Writer) writeLine(typ byte, s string) {
+	w.bw.WriteByte(typ)
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+func (w *Writer) WriteSimpleString(s string) { w.writeLine(TypeSimpleString, s) }
+
+// Will write an error. By convention the message starts with an error code like ERR or WRONGTYPE.
+func (w *Writer) WriteError(msg string) { w.writeLine(TypeError, msg) }
+
+func (w *Writer) WriteInteger(n int64) { w.writeLine(TypeInteger, strconv.FormatInt(n, 10)) }
+
+func (w *Writer) WriteBulkString(s string) {
+	w.writeLine(TypeBulkString, strconv.Itoa(len(s)))
+	w.bw.WriteString(s)
+	w.bw.WriteString("\r\n")
+}
+
+// Will write a null, "$-1" in RESP2 or "_" in RESP3
+func (w *Writer) WriteNull() {
+	if w.Protocol >= 3 {
+		w.writeLine(TypeNull, "")
+		return
+	}
+	w.writeLine(TypeBulkString, "-1")
+}
+
+// Will write the header of an array with n elements, the caller writes the elements after it
+func (w *Writer) WriteArrayHeader(n int) { w.writeLine(TypeArray, strconv.Itoa(n)) }
+
+// Will write the header of a map with n key/value pairs. RESP2 has no maps, so a flat array of 2n elements is used.
+func (w *Writer) WriteMapHeader(n int) {
+	if w.Protocol >= 3 {
+		w.writeLine(TypeMap, strconv.Itoa(n))
+		return
+	}
+	w.WriteArrayHeader(n * 2)
+}
+
+// Will write an array of bulk strings
+func (w *Writer) WriteStrings(values []string) {
+	w.WriteArrayHeader(len(values))
+	for _, v := range values {
+		w.WriteBulkString(v)
+	}
+}
+
+// Will write any Value, used to send commands from clients and tests
+func (w *Writer) WriteValue(v Value) {
+	switch v.Type {
+	case TypeBulkString:
+		if v.Null {
+			w.WriteNull()
+			return
+		}
+		w.WriteBulkString(v.Str)
+	case TypeArray:
+		w.WriteArrayHeader(len(v.Array))
+		for _, el := range v.Array {
+			w.WriteValue(el)
+		}
+	case TypeInteger:
+		w.WriteInteger(v.Int)
+	case TypeError:
+		w.WriteError(v.Str)
+	case TypeNull:
+		w.WriteNull()
+	default:
+		w.WriteSimpleString(v.Str)
+	}
+}
+
+// Will write a command as an array of bulk strings, the way clients send them
+func (w *Writer) WriteCommand(args ...string) {
+	w.WriteStrings(args)
+}
+
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,120 @@
+package resp
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestReadCommand(t *testing.T) {
+	input := "*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$12\r\nhello\r\nworld\r\n" + // Bulk strings are binary safe
+		"PING inline\r\n"
+	r := NewReader(strings.NewReader(input))
+
+	args, err := r.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"SET", "key", "hello\r\nworld"}) {
+		t.Errorf("unexpected args: %q", args)
+	}
+
+	args, err = r.ReadCommand()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"PING", "inline"}) {
+		t.Errorf("unexpected inline args: %q", args)
+	}
+}
+
+func TestReadCommandProtocolError(t *testing.T) {
+	for _, input := range []string{
+		"*1\r\n:1\r\n",          // Arguments must be bulk strings
+		"*1\r\n$5\r\nab\r\n",    // Bulk shorter than announced
+		"*x\r\n",                // Invalid length
+		"*1\r\n$3\r\nabcXX\r\n", // Missing CRLF after the bulk
+	} {
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		if err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+		if strings.HasPrefix(input, "*1\r\n$5") {
+			continue // Runs out of input instead, which is an io error
+		}
+		if !errors.Is(err, ErrProtocol) {
+			t.Errorf("%q: expected ErrProtocol, got %v", input, err)
+		}
+	}
+}
+
+// Will test that announcing a huge bulk string or array without sending it doesn't make the reader allocate it
+func TestReadHugeLengths(t *testing.T) {
+	for _, input := range []string{
+		"*1\r\n$500000000\r\nabc",
+		"*1000000\r\n$1\r\na\r\n",
+	} {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		runtime.ReadMemStats(&after)
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%q: expected io.ErrUnexpectedEOF, got %v", input, err)
+		}
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+			t.Errorf("%q: expected a small allocation, got %d bytes", input, allocated)
+		}
+	}
+}
+
+// Will test that deeply nested arrays are rejected with a protocol error instead of overflowing the stack
+func TestReadNestedArrays(t *testing.T) {
+	nested := strings.Repeat("*1\r\n", 1<<20) + ":1\r\n"
+	if _, err := NewReader(strings.NewReader(nested)).ReadCommand(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("ReadCommand: expected ErrProtocol, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader(nested)).ReadValue(); !errors.Is(err, ErrProtocol) {
+		t.Errorf("ReadValue: expected ErrProtocol, got %v", err)
+	}
+	if v, err := NewReader(strings.NewReader("*1\r\n*1\r\n:1\r\n")).ReadValue(); err != nil || v.Array[0].Array[0].Int != 1 {
+		t.Errorf("ReadValue: expected a shallow nested array to be read, got %+v %v", v, err)
+	}
+}
+
+// Will test that nulls and maps are encoded differently in RESP2 and RESP3, and that the reader understands both
+func TestWriterProtocols(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteNull()
+	w.WriteMapHeader(1)
+	w.WriteBulkString("k")
+	w.WriteInteger(1)
+	w.Flush()
+	if got := buf.String(); got != "$-1\r\n*2\r\n$1\r\nk\r\n:1\r\n" {
+		t.Errorf("unexpected RESP2 output: %q", got)
+	}
+
+	buf.Reset()
+	w.Protocol = 3
+	w.WriteNull()
+	w.WriteMapHeader(1)
+	w.WriteBulkString("k")
+	w.WriteInteger(1)
+	w.Flush()
+	if got := buf.String(); got != "_\r\n%1\r\n$1\r\nk\r\n:1\r\n" {
+		t.Errorf("unexpected RESP3 output: %q", got)
+	}
+
+	r := NewReader(&buf)
+	if v, err := r.ReadValue(); err != nil || !v.Null {
+		t.Errorf("expected null, got %+v (%v)", v, err)
+	}
+	v, err := r.ReadValue()
+	if err != nil || v.Type != TypeMap || len(v.Array) != 2 || v.Array[0].Str != "k" || v.Array[1].Int != 1 {
+		t.Errorf("expected map {k: 1}, got %+v (%v)", v, err)
+	}
+}
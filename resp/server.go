@@ -0,0 +1,155 @@
+package resp
+
+import (
+	"bufio"
+	"errors"
+	"golang-memory-cache/cache"
+	"io"
+	"log"
+	"net"
+	"sync"
+)
+
+// Returned by Serve after Close was called
+var ErrServerClosed = errors.New("resp: server closed")
+
+// Serves a cache.Cache over the Redis protocol, so redis-cli and Redis client libraries can talk to it
+type Server struct {
+	Cache *cache.Cache
+	// When set, commands that write answer READONLY instead, i.e. on a replication follower
+	ReadOnly bool
+
+	mu        sync.Mutex
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	closed    bool
+	wg        sync.WaitGroup // Tracks connection goroutines, so Close can wait for them
+}
+
+func NewServer(c *cache.Cache) *Server {
+	return &Server{
+		Cache:     c,
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[net.Conn]struct{}),
+	}
+}
+
+// Will listen on addr and serve connections until Close is called
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Will accept connections on l and handle each one in its own goroutine.
+// Always returns a non-nil error, ErrServerClosed after Close.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listeners[l] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+// Will stop every listener, close every open connection and wait for their goroutines to finish
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for l := range s.listeners {
+		l.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return nil
+}
+
+// State kept for a single client connection
+type session struct {
+	server *Server
+	r      *Reader
+	w      *Writer
+	quit   bool // Set by QUIT, the connection is closed after the reply is flushed
+}
+
+// Will read commands and write replies until the client disconnects.
+// Replies are only flushed when no more pipelined commands are waiting in the read buffer,
+// so a pipeline of N commands costs one write instead of N.
+func (s *Server) serveConn(conn net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+		s.wg.Done()
+	}()
+
+	sess := &session{
+		server: s,
+		r:      NewReader(bufio.NewReaderSize(conn, 64<<10)),
+		w:      NewWriter(conn),
+	}
+
+	for !sess.quit {
+		args, err := sess.r.ReadCommand()
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				sess.w.WriteError("ERR Protocol error: " + err.Error())
+				sess.w.Flush()
+			} else if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				log.Printf("resp: %v", err)
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue // Empty inline command, i.e. a blank line in telnet
+		}
+
+		sess.execute(args)
+
+		if sess.r.Buffered() == 0 || sess.quit {
+			if err := sess.w.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
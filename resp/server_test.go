@@ -0,0 +1,320 @@
+package resp
+
+import (
+	"bufio"
+	"golang-memory-cache/cache"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// A tiny client for the tests, sending commands and reading replies over a real TCP connection
+type testClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *Reader
+	w    *Writer
+}
+
+// Will start a server on a loopback port and connect a client to it
+func newTestServer(t *testing.T) (*cache.Cache, *testClient) {
+	t.Helper()
+	c := cache.NewCache()
+	return c, serveTest(t, NewServer(c))
+}
+
+// Will serve srv on a loopback port and connect a client to it. The server and its cache are stopped with the test.
+func serveTest(t *testing.T, srv *Server) *testClient {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(l)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Close()
+		srv.Close()
+		srv.Cache.Stop()
+	})
+	return &testClient{t: t, conn: conn, r: NewReader(bufio.NewReader(conn)), w: NewWriter(conn)}
+}
+
+// Will send a command and return its reply
+func (tc *testClient) do(args ...string) Value {
+	tc.t.Helper()
+	tc.w.WriteCommand(args...)
+	if err := tc.w.Flush(); err != nil {
+		tc.t.Fatal(err)
+	}
+	return tc.read()
+}
+
+func (tc *testClient) read() Value {
+	tc.t.Helper()
+	tc.conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	v, err := tc.r.ReadValue()
+	if err != nil {
+		tc.t.Fatal(err)
+	}
+	return v
+}
+
+func expectString(t *testing.T, v Value, want string) {
+	t.Helper()
+	if v.Null || (v.Type != TypeBulkString && v.Type != TypeSimpleString) || v.Str != want {
+		t.Errorf("expected %q, got %+v", want, v)
+	}
+}
+
+func expectInt(t *testing.T, v Value, want int64) {
+	t.Helper()
+	if v.Type != TypeInteger || v.Int != want {
+		t.Errorf("expected :%d, got %+v", want, v)
+	}
+}
+
+func expectNull(t *testing.T, v Value) {
+	t.Helper()
+	if !v.Null {
+		t.Errorf("expected null, got %+v", v)
+	}
+}
+
+func expectError(t *testing.T, v Value, prefix string) {
+	t.Helper()
+	if v.Type != TypeError || !strings.HasPrefix(v.Str, prefix) {
+		t.Errorf("expected error starting with %q, got %+v", prefix, v)
+	}
+}
+
+func TestStringCommands(t *testing.T) {
+	c, client := newTestServer(t)
+
+	expectString(t, client.do("PING"), "PONG")
+	expectString(t, client.do("SET", "key", "value"), "OK")
+	expectString(t, client.do("get", "key"), "value")
+	expectNull(t, client.do("GET", "missing"))
+
+	// NX only sets missing keys, XX only existing ones
+	expectNull(t, client.do("SET", "key", "other", "NX"))
+	expectString(t, client.do("SET", "new", "v", "NX"), "OK")
+	expectNull(t, client.do("SET", "nope", "v", "XX"))
+	expectString(t, client.do("SET", "key", "updated", "XX"), "OK")
+	expectString(t, client.do("GET", "key"), "updated")
+	expectError(t, client.do("SET", "key", "v", "NX", "XX"), "ERR syntax")
+	expectError(t, client.do("SET", "key", "v", "EX", "0"), "ERR invalid expire")
+	expectError(t, client.do("SET", "key", "v", "EX", "10", "PX", "100"), "ERR syntax")
+
+	// Lists, hashes, sets and sorted sets are not strings
+	c.RPush("list", "a")
+	expectError(t, client.do("GET", "list"), "WRONGTYPE")
+	expectError(t, client.do("INCR", "list"), "WRONGTYPE")
+	expectError(t, client.do("DECRBY", "list", "2"), "WRONGTYPE")
+	if v := client.do("MGET", "list", "key"); len(v.Array) != 2 || !v.Array[0].Null || v.Array[1].Str != "updated" {
+		t.Errorf("expected MGET to report the list as missing, got %+v", v)
+	}
+	c.Delete("list")
+
+	expectInt(t, client.do("EXISTS", "key", "new", "missing", "key"), 3)
+	expectInt(t, client.do("DEL", "key", "missing"), 1)
+	expectInt(t, client.do("EXISTS", "key"), 0)
+
+	// Values written over RESP are visible through the cache API
+	if v, _ := c.Get("new"); v != "v" {
+		t.Errorf("expected value in cache, got %v", v)
+	}
+
+	expectError(t, client.do("GET"), "ERR wrong number of arguments")
+	expectError(t, client.do("NOSUCHCOMMAND"), "ERR unknown command")
+}
+
+// Will test that a read-only server, like on a replication follower, answers READONLY to writes but still serves reads
+func TestReadOnly(t *testing.T) {
+	c := cache.NewCache()
+	c.Set("key", "value", cache.NoExpiration)
+	srv := NewServer(c)
+	srv.ReadOnly = true
+	client := serveTest(t, srv)
+
+	expectString(t, client.do("GET", "key"), "value")
+	for _, args := range [][]string{
+		{"SET", "key", "other"},
+		{"DEL", "key"},
+		{"INCR", "counter"},
+		{"EXPIRE", "key", "10"},
+		{"MSET", "a", "1"},
+		{"FLUSHDB"},
+	} {
+		expectError(t, client.do(args...), "READONLY")
+	}
+	if v, _ := c.Get("key"); v != "value" || c.Len() != 1 {
+		t.Errorf("expected the cache to be unchanged, got %v and %d keys", v, c.Len())
+	}
+}
+
+func TestExpirationCommands(t *testing.T) {
+	_, client := newTestServer(t)
+
+	expectString(t, client.do("SET", "key", "value", "EX", "100"), "OK")
+	expectInt(t, client.do("TTL", "key"), 100)
+	if v := client.do("PTTL", "key"); v.Int <= 99000 || v.Int > 100000 {
+		t.Errorf("expected PTTL close to 100000, got %d", v.Int)
+	}
+
+	expectInt(t, client.do("PERSIST", "key"), 1)
+	expectInt(t, client.do("TTL", "key"), -1)
+	expectInt(t, client.do("TTL", "missing"), -2)
+
+	expectInt(t, client.do("EXPIRE", "key", "50"), 1)
+	expectInt(t, client.do("TTL", "key"), 50)
+	expectInt(t, client.do("EXPIRE", "missing", "50"), 0)
+
+	expectString(t, client.do("SET", "short", "value", "PX", "20"), "OK")
+	time.Sleep(50 * time.Millisecond)
+	expectNull(t, client.do("GET", "short"))
+
+	// A TTL of zero deletes the key
+	expectInt(t, client.do("EXPIRE", "key", "0"), 1)
+	expectInt(t, client.do("EXISTS", "key"), 0)
+}
+
+func TestCounterCommands(t *testing.T) {
+	_, client := newTestServer(t)
+
+	expectInt(t, client.do("INCR", "counter"), 1)
+	expectInt(t, client.do("INCRBY", "counter", "10"), 11)
+	expectInt(t, client.do("DECR", "counter"), 10)
+	expectInt(t, client.do("DECRBY", "counter", "15"), -5)
+	expectString(t, client.do("GET", "counter"), "-5")
+
+	client.do("SET", "text", "hello")
+	expectError(t, client.do("INCR", "text"), "ERR value is not an integer")
+	expectError(t, client.do("INCRBY", "counter", "abc"), "ERR value is not an integer")
+}
+
+func TestMultiKeyCommands(t *testing.T) {
+	_, client := newTestServer(t)
+
+	expectString(t, client.do("MSET", "user:1", "a", "user:2", "b", "session:1", "s"), "OK")
+	expectError(t, client.do("MSET", "k1", "v1", "k2"), "ERR wrong number of arguments")
+
+	v := client.do("MGET", "user:1", "missing", "user:2")
+	if len(v.Array) != 3 || v.Array[0].Str != "a" || !v.Array[1].Null || v.Array[2].Str != "b" {
+		t.Errorf("unexpected MGET reply: %+v", v)
+	}
+
+	keys := client.do("KEYS", "user:*")
+	if len(keys.Array) != 2 || keys.Array[0].Str != "user:1" || keys.Array[1].Str != "user:2" {
+		t.Errorf("unexpected KEYS reply: %+v", keys)
+	}
+
+	// Walk the whole keyspace with SCAN, two keys at a time
+	var scanned []string
+	cursor := "0"
+	for {
+		v := client.do("SCAN", cursor, "COUNT", "2")
+		for _, key := range v.Array[1].Array {
+			scanned = append(scanned, key.Str)
+		}
+		cursor = v.Array[0].Str
+		if cursor == "0" {
+			break
+		}
+	}
+	sort.Strings(scanned)
+	if !reflect.DeepEqual(scanned, []string{"session:1", "user:1", "user:2"}) {
+		t.Errorf("unexpected SCAN result: %v", scanned)
+	}
+
+	v = client.do("SCAN", "0", "MATCH", "session:*", "COUNT", "100")
+	if len(v.Array[1].Array) != 1 || v.Array[1].Array[0].Str != "session:1" {
+		t.Errorf("unexpected SCAN MATCH reply: %+v", v)
+	}
+
+	expectInt(t, client.do("DBSIZE"), 3)
+	expectString(t, client.do("FLUSHDB"), "OK")
+	expectInt(t, client.do("DBSIZE"), 0)
+}
+
+func TestInfoAndHello(t *testing.T) {
+	_, client := newTestServer(t)
+
+	client.do("SET", "key", "value")
+	client.do("GET", "key")
+	info := client.do("INFO")
+	if !strings.Contains(info.Str, "keyspace_hits:1") || !strings.Contains(info.Str, "db0:keys=1") {
+		t.Errorf("unexpected INFO reply: %q", info.Str)
+	}
+
+	// After HELLO 3 nulls use the RESP3 null type
+	hello := client.do("HELLO", "3")
+	if hello.Type != TypeMap {
+		t.Errorf("expected a map from HELLO 3, got %+v", hello)
+	}
+	if v := client.do("GET", "missing"); v.Type != TypeNull {
+		t.Errorf("expected RESP3 null, got %+v", v)
+	}
+	expectError(t, client.do("HELLO", "4"), "NOPROTO")
+}
+
+// Will send many commands in a single write and check every reply comes back in order
+func TestPipelining(t *testing.T) {
+	_, client := newTestServer(t)
+
+	const n = 100
+	for i := 0; i < n; i++ {
+		client.w.WriteCommand("INCR", "counter")
+	}
+	client.w.Flush()
+
+	for i := 1; i <= n; i++ {
+		expectInt(t, client.read(), int64(i))
+	}
+}
+
+func TestInlineCommands(t *testing.T) {
+	_, client := newTestServer(t)
+
+	client.conn.Write([]byte("SET key value\r\nGET key\r\n"))
+	expectString(t, client.read(), "OK")
+	expectString(t, client.read(), "value")
+}
+
+// Will test that Close disconnects clients and makes Serve return
+func TestServerClose(t *testing.T) {
+	c := cache.NewCache()
+	defer c.Stop()
+	srv := NewServer(c)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(l) }()
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := &testClient{t: t, conn: conn, r: NewReader(conn), w: NewWriter(conn)}
+	expectString(t, client.do("PING"), "PONG")
+
+	srv.Close()
+	if err := <-served; err != ErrServerClosed {
+		t.Errorf("expected ErrServerClosed, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := client.r.ReadValue(); err == nil {
+		t.Error("expected the connection to be closed")
+	}
+}
@@ -0,0 +1,158 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+)
+
+// A Bloom filter tests whether an item was added, using a fixed number of bits however many items are added.
+// It never misses an added item, but reports items that were not added as present with a small probability,
+// which grows past the error rate it was sized for once more than its capacity was added.
+type BloomFilter struct {
+	mu       sync.RWMutex
+	capacity uint64 // Items it was sized for
+	hashes   uint64
+	bits     []uint64
+	count    uint64 // Items added that were not present yet, an estimate of the number of distinct items
+}
+
+// Most bits a filter can be created with, so a single filter never takes more than 16MB like a Count-Min Sketch.
+// Every add that changes a filter sends the whole filter to replicas (see Sketches).
+const maxBloomBits = 1 << 27
+
+// Most bits a decoded filter can hold, the limit filters were created with before maxBloomBits was lowered, so
+// snapshots holding them still load
+const maxDecodedBloomBits = 1 << 32
+
+// Creates a Bloom filter sized to hold capacity items with a false positive rate of errorRate (i.e. 0.01)
+func NewBloomFilter(capacity uint64, errorRate float64) (*BloomFilter, error) {
+	if capacity == 0 || errorRate <= 0 || errorRate >= 1 {
+		return nil, errors.New("sketch: capacity must be positive and the error rate between 0 and 1")
+	}
+	m := math.Ceil(-float64(capacity) * math.Log(errorRate) / (math.Ln2 * math.Ln2))
+	if m > maxBloomBits {
+		return nil, errors.New("sketch: capacity and error rate need more than 2^27 bits, use a larger error rate or a smaller capacity")
+	}
+	k := math.Max(1, math.Round(m/float64(capacity)*math.Ln2))
+	return &BloomFilter{
+		capacity: capacity,
+		hashes:   uint64(k),
+		bits:     make([]uint64, (uint64(m)+63)/64),
+	}, nil
+}
+
+// Will call fn with the bit positions of item
+func (f *BloomFilter) positions(item string, fn func(word int, mask uint64)) {
+	h1, h2 := hashPair(item)
+	m := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < f.hashes; i++ {
+		bit := (h1 + i*h2) % m
+		fn(int(bit/64), 1<<(bit%64))
+	}
+}
+
+// Will add item, and report whether it was not present yet (false may also be a false positive)
+func (f *BloomFilter) Add(item string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	added := false
+	f.positions(item, func(word int, mask uint64) {
+		if f.bits[word]&mask == 0 {
+			f.bits[word] |= mask
+			added = true
+		}
+	})
+	if added {
+		f.count++
+	}
+	return added
+}
+
+// Will report whether item may have been added. false is always right, true is wrong at about the error rate.
+func (f *BloomFilter) Test(item string) bool {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	present := true
+	f.positions(item, func(word int, mask uint64) {
+		if f.bits[word]&mask == 0 {
+			present = false
+		}
+	})
+	return present
+}
+
+// Will add every item of other, which must have been created with the same capacity and error rate
+func (f *BloomFilter) Merge(other *BloomFilter) error {
+	if f == other {
+		return nil
+	}
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.bits) != len(other.bits) || f.hashes != other.hashes {
+		return ErrIncompatible
+	}
+	for i, word := range other.bits {
+		f.bits[i] |= word
+	}
+	f.count += other.count // An upper bound, items added to both are counted twice
+	return nil
+}
+
+// Will return the number of distinct items added, as counted by Add (merged filters may count items twice)
+func (f *BloomFilter) Count() uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.count
+}
+
+// Will return an independent copy of the filter
+func (f *BloomFilter) clone() *BloomFilter {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return &BloomFilter{capacity: f.capacity, hashes: f.hashes, bits: append([]uint64(nil), f.bits...), count: f.count}
+}
+
+// Used by encoding/gob, so filters can be stored in snapshots
+func (f *BloomFilter) MarshalBinary() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	b := encodeHeader(f.capacity, f.hashes, f.count, uint64(len(f.bits)))
+	for _, word := range f.bits {
+		b = binary.LittleEndian.AppendUint64(b, word)
+	}
+	return b, nil
+}
+
+func (f *BloomFilter) UnmarshalBinary(b []byte) error {
+	var capacity, hashes, count, words uint64
+	data, err := decodeHeader(b, &capacity, &hashes, &count, &words)
+	if err != nil || words == 0 || words > maxDecodedBloomBits/64 || uint64(len(data)) != words*8 || hashes == 0 {
+		return ErrCorrupt
+	}
+	bits := make([]uint64, words)
+	for i := range bits {
+		bits[i] = binary.LittleEndian.Uint64(data[i*8:])
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.capacity, f.hashes, f.count, f.bits = capacity, hashes, count, bits
+	return nil
+}
+
+// Describes the filter, its bits are only kept in the binary encoding
+func (f *BloomFilter) MarshalJSON() ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return json.Marshal(map[string]interface{}{
+		"type":     "bloom",
+		"capacity": f.capacity,
+		"bits":     len(f.bits) * 64,
+		"hashes":   f.hashes,
+		"count":    f.count,
+	})
+}
@@ -0,0 +1,87 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	f, err := NewBloomFilter(1000, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Add(fmt.Sprint("in-", i)) {
+			t.Logf("in-%d was a false positive", i)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		if !f.Test(fmt.Sprint("in-", i)) {
+			t.Fatalf("Expected in-%d to be present", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if f.Test(fmt.Sprint("out-", i)) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / 10000; rate > 0.02 {
+		t.Errorf("Expected about 1%% false positives, got %.2f%%", rate*100)
+	}
+	if f.Add("in-1") {
+		t.Error("Expected adding an item twice to report it as present")
+	}
+	if count := f.Count(); count < 990 || count > 1000 {
+		t.Errorf("Expected about 1000 items counted, got %d", count)
+	}
+}
+
+func TestBloomFilterMerge(t *testing.T) {
+	a, _ := NewBloomFilter(100, 0.01)
+	b, _ := NewBloomFilter(100, 0.01)
+	a.Add("a")
+	b.Add("b")
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if !a.Test("a") || !a.Test("b") {
+		t.Error("Expected the merged filter to hold both items")
+	}
+
+	other, _ := NewBloomFilter(1000, 0.01)
+	if err := a.Merge(other); err != ErrIncompatible {
+		t.Errorf("Expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestBloomFilterBinary(t *testing.T) {
+	f, _ := NewBloomFilter(100, 0.01)
+	f.Add("a")
+	b, _ := f.MarshalBinary()
+
+	var decoded BloomFilter
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if !decoded.Test("a") || decoded.Count() != 1 {
+		t.Error("Expected the decoded filter to hold a")
+	}
+	if err := decoded.UnmarshalBinary(b[:len(b)-1]); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt for a truncated encoding, got %v", err)
+	}
+}
+
+func TestNewBloomFilterInvalid(t *testing.T) {
+	for _, rate := range []float64{0, 1, -0.5} {
+		if _, err := NewBloomFilter(100, rate); err == nil {
+			t.Errorf("Expected an error for rate %v", rate)
+		}
+	}
+	if _, err := NewBloomFilter(0, 0.01); err == nil {
+		t.Error("Expected an error for no capacity")
+	}
+	if _, err := NewBloomFilter(100000000, 0.01); err == nil {
+		t.Error("Expected an error for a filter above 2^27 bits")
+	}
+}
@@ -0,0 +1,157 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"math"
+	"sync"
+)
+
+// A Count-Min Sketch estimates how many times each item was added, in a fixed amount of memory (depth rows of
+// width counters). Estimates are never below the real count. They are above it by at most 2/width of the total
+// of every count, except with a probability of 1/2^depth.
+type CountMinSketch struct {
+	mu     sync.RWMutex
+	width  uint64
+	depth  uint64
+	counts []uint64 // depth rows of width counters
+	total  uint64
+}
+
+// Most counters a sketch can be created with, so a single sketch never takes more than 16MB. Every add sends the
+// whole sketch to replicas (see Sketches).
+const maxCounters = 1 << 21
+
+// Most counters a decoded sketch can hold, the limit sketches were created with before maxCounters was lowered,
+// so snapshots holding them still load
+const maxDecodedCounters = 1 << 26
+
+// Will report whether a sketch with depth rows of width counters fits in limit counters. The factors are checked
+// one at a time, since their product can overflow.
+func validDimensions(width, depth, limit uint64) bool {
+	return width > 0 && depth > 0 && width <= limit/depth
+}
+
+// Creates a sketch with depth rows of width counters
+func NewCountMinSketch(width, depth uint64) (*CountMinSketch, error) {
+	if !validDimensions(width, depth, maxCounters) {
+		return nil, errors.New("sketch: width and depth must be positive, and hold at most 2^21 counters together")
+	}
+	return &CountMinSketch{width: width, depth: depth, counts: make([]uint64, width*depth)}, nil
+}
+
+// Will call fn with the index of the counter of item in every row
+func (s *CountMinSketch) cells(item string, fn func(i int)) {
+	h1, h2 := hashPair(item)
+	for row := uint64(0); row < s.depth; row++ {
+		fn(int(row*s.width + (h1+row*h2)%s.width))
+	}
+}
+
+// Will add count to item, and return its new estimate
+func (s *CountMinSketch) Add(item string, count uint64) uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	estimate := uint64(math.MaxUint64)
+	s.cells(item, func(i int) {
+		s.counts[i] = saturatingAdd(s.counts[i], count)
+		estimate = min(estimate, s.counts[i])
+	})
+	s.total = saturatingAdd(s.total, count)
+	return estimate
+}
+
+// Will return the estimated count of item
+func (s *CountMinSketch) Count(item string) uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	estimate := uint64(math.MaxUint64)
+	s.cells(item, func(i int) {
+		estimate = min(estimate, s.counts[i])
+	})
+	return estimate
+}
+
+// Will return the sum of every count added
+func (s *CountMinSketch) Total() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.total
+}
+
+// Will add the counts of other, which must have the same width and depth
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if s == other {
+		return nil
+	}
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.width != other.width || s.depth != other.depth {
+		return ErrIncompatible
+	}
+	for i, count := range other.counts {
+		s.counts[i] = saturatingAdd(s.counts[i], count)
+	}
+	s.total = saturatingAdd(s.total, other.total)
+	return nil
+}
+
+func saturatingAdd(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// Will return an independent copy of the sketch
+func (s *CountMinSketch) clone() *CountMinSketch {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return &CountMinSketch{width: s.width, depth: s.depth, counts: append([]uint64(nil), s.counts...), total: s.total}
+}
+
+// Used by encoding/gob, so sketches can be stored in snapshots
+func (s *CountMinSketch) MarshalBinary() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	b := encodeHeader(s.width, s.depth, s.total)
+	for _, count := range s.counts {
+		b = binary.AppendUvarint(b, count) // Most counters are small or zero
+	}
+	return b, nil
+}
+
+func (s *CountMinSketch) UnmarshalBinary(b []byte) error {
+	var width, depth, total uint64
+	data, err := decodeHeader(b, &width, &depth, &total)
+	if err != nil || !validDimensions(width, depth, maxDecodedCounters) {
+		return ErrCorrupt
+	}
+	counts := make([]uint64, width*depth)
+	for i := range counts {
+		count, n := binary.Uvarint(data)
+		if n <= 0 {
+			return ErrCorrupt
+		}
+		counts[i], data = count, data[n:]
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.width, s.depth, s.total, s.counts = width, depth, total, counts
+	return nil
+}
+
+// Describes the sketch, its counters are only kept in the binary encoding
+func (s *CountMinSketch) MarshalJSON() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return json.Marshal(map[string]interface{}{
+		"type":  "cms",
+		"width": s.width,
+		"depth": s.depth,
+		"total": s.total,
+	})
+}
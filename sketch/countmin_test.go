@@ -0,0 +1,78 @@
+package sketch
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCountMinSketch(t *testing.T) {
+	s, err := NewCountMinSketch(1000, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		s.Add(fmt.Sprint("item-", i), uint64(i%10+1))
+	}
+	if got := s.Add("heavy", 1000); got < 1000 {
+		t.Errorf("Expected an estimate of at least 1000, got %d", got)
+	}
+	if s.Total() != 3750 {
+		t.Errorf("Expected a total of 3750, got %d", s.Total())
+	}
+	// Estimates never go below the real count, and stay within 2/width of the total here
+	for i := 0; i < 500; i++ {
+		real := uint64(i%10 + 1)
+		if got := s.Count(fmt.Sprint("item-", i)); got < real || got > real+8 {
+			t.Errorf("item-%d: expected about %d, got %d", i, real, got)
+		}
+	}
+	if got := s.Count("missing"); got > 8 {
+		t.Errorf("Expected about 0 for a missing item, got %d", got)
+	}
+}
+
+func TestNewCountMinSketchInvalid(t *testing.T) {
+	for _, size := range [][2]uint64{{0, 5}, {5, 0}, {1 << 15, 1 << 7}, {1 << 32, 1 << 32}} {
+		if _, err := NewCountMinSketch(size[0], size[1]); err == nil {
+			t.Errorf("Expected an error for width %d and depth %d", size[0], size[1])
+		}
+	}
+	if _, err := NewCountMinSketch(1<<15, 1<<6); err != nil {
+		t.Errorf("Expected 2^21 counters to be allowed, got %v", err)
+	}
+}
+
+func TestCountMinSketchMerge(t *testing.T) {
+	a, _ := NewCountMinSketch(100, 4)
+	b, _ := NewCountMinSketch(100, 4)
+	a.Add("x", 3)
+	b.Add("x", 4)
+	if err := a.Merge(b); err != nil {
+		t.Fatal(err)
+	}
+	if got := a.Count("x"); got != 7 {
+		t.Errorf("Expected 7 after the merge, got %d", got)
+	}
+
+	other, _ := NewCountMinSketch(100, 3)
+	if err := a.Merge(other); err != ErrIncompatible {
+		t.Errorf("Expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestCountMinSketchBinary(t *testing.T) {
+	s, _ := NewCountMinSketch(100, 4)
+	s.Add("x", 300)
+	b, _ := s.MarshalBinary()
+
+	var decoded CountMinSketch
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count("x") != 300 || decoded.Total() != 300 {
+		t.Errorf("Unexpected decoded sketch: %d %d", decoded.Count("x"), decoded.Total())
+	}
+	if err := decoded.UnmarshalBinary(b[:20]); err != ErrCorrupt {
+		t.Errorf("Expected ErrCorrupt for a truncated encoding, got %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package sketch
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// Every structure hashes its items the same way on every process, so structures built on different nodes
+// (or saved in a snapshot) can be merged
+
+var (
+	ErrIncompatible = errors.New("sketch: structures have different sizes and can't be merged")
+	ErrCorrupt      = errors.New("sketch: invalid encoding")
+)
+
+// Will hash item to 64 bits: FNV-1a, followed by the finalizer of MurmurHash3 so every bit depends on every input bit
+func hash64(item string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(item))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// Will return two independent hashes of item, combined as h1 + i*h2 to get as many hash functions as needed
+// (Kirsch and Mitzenmacher). h2 is odd, so the combinations don't repeat early for power of two sizes.
+func hashPair(item string) (uint64, uint64) {
+	h1 := hash64(item)
+	h2 := hash64(string(binary.LittleEndian.AppendUint64(nil, h1))) | 1
+	return h1, h2
+}
+
+// Encodings start with a format byte and the uint64 parameters of the structure, followed by its data
+const encodingVersion = 1
+
+func encodeHeader(params ...uint64) []byte {
+	b := []byte{encodingVersion}
+	for _, p := range params {
+		b = binary.LittleEndian.AppendUint64(b, p)
+	}
+	return b
+}
+
+// Will read the header written by encodeHeader into params, and return the data after it
+func decodeHeader(b []byte, params ...*uint64) ([]byte, error) {
+	if len(b) < 1+8*len(params) || b[0] != encodingVersion {
+		return nil, ErrCorrupt
+	}
+	b = b[1:]
+	for _, p := range params {
+		*p = binary.LittleEndian.Uint64(b)
+		b = b[8:]
+	}
+	return b, nil
+}
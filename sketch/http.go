@@ -0,0 +1,302 @@
+package sketch
+
+import (
+	"encoding/json"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Largest JSON body accepted by the add routes
+const maxBodySize = 1 << 20
+
+// Will mount the sketch routes on mux:
+// * PUT /v2/bloom/{key}?capacity=&error_rate=&ttl=
+// * POST /v2/bloom/{key}?ttl=
+// * GET /v2/bloom/{key}?item=
+// * POST /v2/bloom/{key}/merge?from=&ttl=
+// * PUT /v2/cms/{key}?width=&depth=&ttl=
+// * POST /v2/cms/{key}?ttl=
+// * GET /v2/cms/{key}?item=
+// * POST /v2/cms/{key}/merge?from=&ttl=
+// * PUT /v2/hll/{key}?precision=&ttl=
+// * POST /v2/hll/{key}?ttl=
+// * GET /v2/hll/{key}?union=
+// * POST /v2/hll/{key}/merge?from=&ttl=
+func (s *Sketches) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("PUT /v2/bloom/{key}", s.BloomCreateHandler)
+	mux.HandleFunc("POST /v2/bloom/{key}", s.BloomAddHandler)
+	mux.HandleFunc("GET /v2/bloom/{key}", s.BloomTestHandler)
+	mux.HandleFunc("POST /v2/bloom/{key}/merge", s.mergeHandler(s.BloomMerge))
+	mux.HandleFunc("PUT /v2/cms/{key}", s.CountMinCreateHandler)
+	mux.HandleFunc("POST /v2/cms/{key}", s.CountMinAddHandler)
+	mux.HandleFunc("GET /v2/cms/{key}", s.CountMinQueryHandler)
+	mux.HandleFunc("POST /v2/cms/{key}/merge", s.mergeHandler(s.CountMinMerge))
+	mux.HandleFunc("PUT /v2/hll/{key}", s.HyperLogLogCreateHandler)
+	mux.HandleFunc("POST /v2/hll/{key}", s.PFAddHandler)
+	mux.HandleFunc("GET /v2/hll/{key}", s.PFCountHandler)
+	mux.HandleFunc("POST /v2/hll/{key}/merge", s.mergeHandler(s.PFMerge))
+}
+
+// * PUT /v2/bloom/{key}?capacity=100000&error_rate=0.01&ttl=3600
+// Creates a Bloom filter sized for capacity items at error_rate false positives. Parameters left out come from
+// the Options. Answers 201, or 409 when the key exists.
+func (s *Sketches) BloomCreateHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	capacity, ok := uintParam(w, r, "capacity", s.options.BloomCapacity)
+	if !ok {
+		return
+	}
+	errorRate := s.options.BloomErrorRate
+	if v := query.Get("error_rate"); v != "" {
+		var err error
+		if errorRate, err = strconv.ParseFloat(v, 64); err != nil {
+			api.WriteError(w, http.StatusBadRequest, "error_rate must be a number between 0 and 1")
+			return
+		}
+	}
+	ttl, ok := ttlParam(w, r)
+	if !ok {
+		return
+	}
+	finishCreate(w, r, s.CreateBloom(r.PathValue("key"), capacity, errorRate, ttl))
+}
+
+// * POST /v2/bloom/{key}?ttl=3600
+// Adds the items of a JSON array of strings, creating the filter when missing. Answers with whether each item
+// was new.
+func (s *Sketches) BloomAddHandler(w http.ResponseWriter, r *http.Request) {
+	var items []string
+	ttl, ok := decodeWrite(w, r, &items)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	added, err := s.BloomAdd(key, items...)
+	s.finishWrite(w, key, ttl, err, map[string]interface{}{"added": zip(items, added)})
+}
+
+// * GET /v2/bloom/{key}?item=a&item=b
+// Answers with whether each item may have been added, and a description of the filter
+func (s *Sketches) BloomTestHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	items := r.URL.Query()["item"]
+	present, err := s.BloomTest(key, items...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+	response := map[string]interface{}{"key": key, "items": zip(items, present)}
+	if f, found, _ := view[*BloomFilter](s.cache, key); found {
+		response["filter"] = f
+	}
+	api.WriteJSON(w, http.StatusOK, response)
+}
+
+// * PUT /v2/cms/{key}?width=2000&depth=5&ttl=3600
+// Creates a Count-Min Sketch with depth rows of width counters. Parameters left out come from the Options.
+// Answers 201, or 409 when the key exists.
+func (s *Sketches) CountMinCreateHandler(w http.ResponseWriter, r *http.Request) {
+	width, ok := uintParam(w, r, "width", s.options.CMSWidth)
+	if !ok {
+		return
+	}
+	depth, ok := uintParam(w, r, "depth", s.options.CMSDepth)
+	if !ok {
+		return
+	}
+	ttl, ok := ttlParam(w, r)
+	if !ok {
+		return
+	}
+	finishCreate(w, r, s.CreateCountMin(r.PathValue("key"), width, depth, ttl))
+}
+
+// * POST /v2/cms/{key}?ttl=3600
+// Adds the counts of a JSON object of items to counts, creating the sketch when missing. Answers with the new
+// estimates of the items.
+func (s *Sketches) CountMinAddHandler(w http.ResponseWriter, r *http.Request) {
+	var counts map[string]uint64
+	ttl, ok := decodeWrite(w, r, &counts)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	estimates, err := s.CountMinAdd(key, counts)
+	s.finishWrite(w, key, ttl, err, map[string]interface{}{"counts": estimates})
+}
+
+// * GET /v2/cms/{key}?item=a&item=b
+// Answers with the estimated count of each item, and a description of the sketch
+func (s *Sketches) CountMinQueryHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	items := r.URL.Query()["item"]
+	estimates, err := s.CountMinQuery(key, items...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+	response := map[string]interface{}{"key": key, "counts": zip(items, estimates)}
+	if cms, found, _ := view[*CountMinSketch](s.cache, key); found {
+		response["sketch"] = cms
+	}
+	api.WriteJSON(w, http.StatusOK, response)
+}
+
+// * PUT /v2/hll/{key}?precision=14&ttl=3600
+// Creates a HyperLogLog with 2^precision registers, precision coming from the Options when left out.
+// Answers 201, or 409 when the key exists.
+func (s *Sketches) HyperLogLogCreateHandler(w http.ResponseWriter, r *http.Request) {
+	precision, ok := uintParam(w, r, "precision", uint64(s.options.HLLPrecision))
+	if !ok {
+		return
+	}
+	if precision > 255 {
+		precision = 255 // Rejected by NewHyperLogLog, without wrapping around first
+	}
+	ttl, ok := ttlParam(w, r)
+	if !ok {
+		return
+	}
+	finishCreate(w, r, s.CreateHyperLogLog(r.PathValue("key"), uint8(precision), ttl))
+}
+
+// * POST /v2/hll/{key}?ttl=3600
+// Adds the items of a JSON array of strings, creating the HyperLogLog when missing. Answers with whether the
+// estimate changed and the new estimate.
+func (s *Sketches) PFAddHandler(w http.ResponseWriter, r *http.Request) {
+	var items []string
+	ttl, ok := decodeWrite(w, r, &items)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	changed, err := s.PFAdd(key, items...)
+	var count uint64
+	if err == nil {
+		count, err = s.PFCount(key)
+	}
+	s.finishWrite(w, key, ttl, err, map[string]interface{}{"changed": changed, "count": count})
+}
+
+// * GET /v2/hll/{key}?union=other&union=another
+// Answers with the estimated number of distinct items, in the union with the other keys when given
+func (s *Sketches) PFCountHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	count, err := s.PFCount(append([]string{key}, r.URL.Query()["union"]...)...)
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "count": count})
+}
+
+// * POST /v2/{bloom,cms,hll}/{key}/merge?from=a&from=b&ttl=3600
+// Merges the structures at the from keys into the one at key, creating it when missing. Answers 409 when the
+// structures have different sizes.
+func (s *Sketches) mergeHandler(mergeFn func(dest string, sources ...string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sources := r.URL.Query()["from"]
+		if len(sources) == 0 {
+			api.WriteError(w, http.StatusBadRequest, "Missing from keys")
+			return
+		}
+		ttl, ok := ttlParam(w, r)
+		if !ok {
+			return
+		}
+		key := r.PathValue("key")
+		s.finishWrite(w, key, ttl, mergeFn(key, sources...), map[string]interface{}{"merged": sources})
+	}
+}
+
+// Will pair items with their results, for responses keyed by item
+func zip[T any](items []string, results []T) map[string]T {
+	m := make(map[string]T, len(items))
+	for i, item := range items {
+		m[item] = results[i]
+	}
+	return m
+}
+
+// Will read an optional positive integer query parameter
+func uintParam(w http.ResponseWriter, r *http.Request, name string, fallback uint64) (uint64, bool) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return fallback, true
+	}
+	n, err := strconv.ParseUint(v, 10, 64)
+	if err != nil || n == 0 {
+		api.WriteError(w, http.StatusBadRequest, name+" must be a positive integer")
+		return 0, false
+	}
+	return n, true
+}
+
+// Will read the optional ttl query parameter, in seconds ("60", "0.5") or as a Go duration ("1m").
+// cache.NoExpiration when missing.
+func ttlParam(w http.ResponseWriter, r *http.Request) (time.Duration, bool) {
+	v := r.URL.Query().Get("ttl")
+	if v == "" {
+		return cache.NoExpiration, true
+	}
+	d, err := time.ParseDuration(v)
+	if seconds, numErr := strconv.ParseFloat(v, 64); numErr == nil {
+		d, err = time.Duration(seconds*float64(time.Second)), nil
+	}
+	if err != nil || d <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "ttl must be a positive number of seconds or a duration")
+		return 0, false
+	}
+	return d, true
+}
+
+// Will read the ttl and decode the JSON body of a write into v, before anything is written
+func decodeWrite(w http.ResponseWriter, r *http.Request, v interface{}) (time.Duration, bool) {
+	ttl, ok := ttlParam(w, r)
+	if !ok {
+		return 0, false
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return 0, false
+	}
+	return ttl, true
+}
+
+// Will apply the ttl of a write once the key was written, and answer with response
+func (s *Sketches) finishWrite(w http.ResponseWriter, key string, ttl time.Duration, err error, response map[string]interface{}) {
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+	if ttl != cache.NoExpiration {
+		s.cache.Expire(key, ttl)
+	}
+	response["key"] = key
+	api.WriteJSON(w, http.StatusOK, response)
+}
+
+func finishCreate(w http.ResponseWriter, r *http.Request, err error) {
+	if err != nil {
+		writeSketchError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, map[string]interface{}{"key": r.PathValue("key")})
+}
+
+func writeSketchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrExists):
+		api.WriteError(w, http.StatusConflict, "Key already exists")
+	case errors.Is(err, cache.ErrWrongType):
+		api.WriteError(w, http.StatusConflict, "Key holds another type")
+	case errors.Is(err, ErrIncompatible):
+		api.WriteError(w, http.StatusConflict, "Structures have different sizes and can't be merged")
+	default:
+		api.WriteError(w, http.StatusBadRequest, err.Error()) // Invalid sizes given to a create
+	}
+}
@@ -0,0 +1,82 @@
+package sketch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestHandlers(t *testing.T) {
+	s, c := newTestSketches(t)
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	do := func(method, target, body string) (int, map[string]interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var got map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+			t.Fatalf("%s %s: invalid JSON response %q", method, target, w.Body.String())
+		}
+		return w.Code, got
+	}
+
+	if code, _ := do("PUT", "/v2/bloom/seen?capacity=500&error_rate=0.001&ttl=60", ""); code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d", code)
+	}
+	if code, _ := do("PUT", "/v2/bloom/seen", ""); code != http.StatusConflict {
+		t.Errorf("Expected 409 for an existing key, got %d", code)
+	}
+	if _, got := do("POST", "/v2/bloom/seen", `["a", "b"]`); !reflect.DeepEqual(got["added"], map[string]interface{}{"a": true, "b": true}) {
+		t.Errorf("Unexpected adds: %v", got)
+	}
+	_, got := do("GET", "/v2/bloom/seen?item=a&item=z", "")
+	if !reflect.DeepEqual(got["items"], map[string]interface{}{"a": true, "z": false}) {
+		t.Errorf("Unexpected tests: %v", got)
+	}
+	if filter := got["filter"].(map[string]interface{}); filter["capacity"] != float64(500) || filter["count"] != float64(2) {
+		t.Errorf("Unexpected filter: %v", filter)
+	}
+	if item, _ := c.Peek("seen"); item.Expiration == 0 {
+		t.Error("Expected the ttl to set an expiration")
+	}
+
+	do("POST", "/v2/cms/day1", `{"/": 3}`)
+	do("POST", "/v2/cms/day2?ttl=1h", `{"/": 4}`)
+	if code, _ := do("POST", "/v2/cms/week/merge?from=day1&from=day2", ""); code != http.StatusOK {
+		t.Errorf("Expected 200 for a merge, got %d", code)
+	}
+	if _, got := do("GET", "/v2/cms/week?item=/", ""); !reflect.DeepEqual(got["counts"], map[string]interface{}{"/": float64(7)}) {
+		t.Errorf("Unexpected counts: %v", got)
+	}
+
+	if _, got := do("POST", "/v2/hll/mon", `["alice", "bob"]`); got["changed"] != true || got["count"] != float64(2) {
+		t.Errorf("Unexpected response to an add: %v", got)
+	}
+	do("PUT", "/v2/hll/tue?precision=10", "")
+	do("POST", "/v2/hll/tue", `["carol"]`)
+	if _, got := do("GET", "/v2/hll/mon?union=tue", ""); got["count"] != float64(3) {
+		t.Errorf("Expected 3 in the union, got %v", got)
+	}
+
+	for _, req := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{"POST", "/v2/hll/seen", `["a"]`, http.StatusConflict},
+		{"PUT", "/v2/hll/x?precision=2", "", http.StatusBadRequest},
+		{"PUT", "/v2/cms/x?width=abc", "", http.StatusBadRequest},
+		{"PUT", "/v2/bloom/x?capacity=100000000000&error_rate=0.0001", "", http.StatusBadRequest},
+		{"POST", "/v2/bloom/x?ttl=-1", `["a"]`, http.StatusBadRequest},
+		{"POST", "/v2/bloom/x", `{"not": "an array"}`, http.StatusBadRequest},
+		{"POST", "/v2/hll/x/merge", "", http.StatusBadRequest},
+	} {
+		if code, _ := do(req.method, req.target, req.body); code != req.status {
+			t.Errorf("%s %s: expected %d, got %d", req.method, req.target, req.status, code)
+		}
+	}
+}
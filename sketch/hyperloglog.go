@@ -0,0 +1,148 @@
+package sketch
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// A HyperLogLog estimates the number of distinct items added, in 2^precision bytes. The standard error of the
+// estimate is 1.04/sqrt(2^precision), about 0.8% with the default precision of 14 (16KB).
+type HyperLogLog struct {
+	mu        sync.RWMutex
+	precision uint8
+	registers []uint8 // The longest run of leading zeros seen, plus one, for the items of each register
+}
+
+// Precision used when none is given, as in Redis
+const DefaultPrecision = 14
+
+// Creates a HyperLogLog with 2^precision registers, precision being between 4 and 18
+func NewHyperLogLog(precision uint8) (*HyperLogLog, error) {
+	if precision < 4 || precision > 18 {
+		return nil, errors.New("sketch: precision must be between 4 and 18")
+	}
+	return &HyperLogLog{precision: precision, registers: make([]uint8, 1<<precision)}, nil
+}
+
+// Will add item, and report whether the estimate may have changed
+func (h *HyperLogLog) Add(item string) bool {
+	x := hash64(item)
+	index := x >> (64 - h.precision)
+	// The remaining bits, with a sentinel so the count stops at 64 - precision
+	rank := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if rank > h.registers[index] {
+		h.registers[index] = rank
+		return true
+	}
+	return false
+}
+
+// Will return the estimated number of distinct items added
+func (h *HyperLogLog) Count() uint64 {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return estimate(h.registers)
+}
+
+func estimate(registers []uint8) uint64 {
+	m := float64(len(registers))
+	var sum float64
+	zeros := 0
+	for _, r := range registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+	alpha := 0.7213 / (1 + 1.079/m)
+	switch len(registers) {
+	case 16:
+		alpha = 0.673
+	case 32:
+		alpha = 0.697
+	case 64:
+		alpha = 0.709
+	}
+	e := alpha * m * m / sum
+	// Small cardinalities are estimated better by counting the empty registers (linear counting)
+	if e <= 2.5*m && zeros > 0 {
+		e = m * math.Log(m/float64(zeros))
+	}
+	return uint64(e + 0.5)
+}
+
+// Will add every item of other, which must have the same precision
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h == other {
+		return nil
+	}
+	other.mu.RLock()
+	defer other.mu.RUnlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.precision != other.precision {
+		return ErrIncompatible
+	}
+	for i, r := range other.registers {
+		h.registers[i] = max(h.registers[i], r)
+	}
+	return nil
+}
+
+// Will return an independent copy of the HyperLogLog
+func (h *HyperLogLog) clone() *HyperLogLog {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return &HyperLogLog{precision: h.precision, registers: append([]uint8(nil), h.registers...)}
+}
+
+// Will return the estimated number of distinct items added to any of hlls, without changing them.
+// They must have the same precision.
+func CountUnion(hlls ...*HyperLogLog) (uint64, error) {
+	if len(hlls) == 0 {
+		return 0, nil
+	}
+	union := hlls[0].clone()
+	for _, h := range hlls[1:] {
+		if err := union.Merge(h); err != nil {
+			return 0, err
+		}
+	}
+	return union.Count(), nil
+}
+
+// Used by encoding/gob, so HyperLogLogs can be stored in snapshots
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return append(encodeHeader(uint64(h.precision)), h.registers...), nil
+}
+
+func (h *HyperLogLog) UnmarshalBinary(b []byte) error {
+	var precision uint64
+	data, err := decodeHeader(b, &precision)
+	if err != nil || precision < 4 || precision > 18 || len(data) != 1<<precision {
+		return ErrCorrupt
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.precision, h.registers = uint8(precision), append([]uint8(nil), data...)
+	return nil
+}
+
+// Describes the HyperLogLog with its estimate, its registers are only kept in the binary encoding
+func (h *HyperLogLog) MarshalJSON() ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return json.Marshal(map[string]interface{}{
+		"type":      "hll",
+		"precision": h.precision,
+		"count":     estimate(h.registers),
+	})
+}
@@ -0,0 +1,67 @@
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestHyperLogLog(t *testing.T) {
+	for _, n := range []int{0, 10, 1000, 100000} {
+		h, _ := NewHyperLogLog(DefaultPrecision)
+		for i := 0; i < n; i++ {
+			h.Add(fmt.Sprint("visitor-", i))
+			h.Add(fmt.Sprint("visitor-", i)) // Duplicates don't count
+		}
+		got := float64(h.Count())
+		if math.Abs(got-float64(n)) > 0.03*float64(n)+1 {
+			t.Errorf("Expected about %d distinct items, got %v", n, got)
+		}
+	}
+}
+
+func TestHyperLogLogMerge(t *testing.T) {
+	a, _ := NewHyperLogLog(12)
+	b, _ := NewHyperLogLog(12)
+	for i := 0; i < 2000; i++ {
+		a.Add(fmt.Sprint(i))
+		b.Add(fmt.Sprint(i + 1000))
+	}
+	union, err := CountUnion(a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(float64(union)-3000) > 150 {
+		t.Errorf("Expected a union of about 3000, got %d", union)
+	}
+	if count := a.Count(); math.Abs(float64(count)-2000) > 100 {
+		t.Errorf("Expected CountUnion to leave a unchanged, got %d", count)
+	}
+	if err := a.Merge(b); err != nil || a.Count() != union {
+		t.Errorf("Expected the merge to give the union, got %d %v", a.Count(), err)
+	}
+
+	other, _ := NewHyperLogLog(10)
+	if err := a.Merge(other); err != ErrIncompatible {
+		t.Errorf("Expected ErrIncompatible, got %v", err)
+	}
+}
+
+func TestHyperLogLogBinary(t *testing.T) {
+	h, _ := NewHyperLogLog(8)
+	for i := 0; i < 100; i++ {
+		h.Add(fmt.Sprint(i))
+	}
+	b, _ := h.MarshalBinary()
+
+	var decoded HyperLogLog
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Count() != h.Count() {
+		t.Errorf("Expected %d after decoding, got %d", h.Count(), decoded.Count())
+	}
+	if _, err := NewHyperLogLog(3); err == nil {
+		t.Error("Expected an error for a precision below 4")
+	}
+}
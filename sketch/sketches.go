@@ -0,0 +1,311 @@
+package sketch
+
+import (
+	"encoding/gob"
+	"errors"
+	"golang-memory-cache/cache"
+	"time"
+)
+
+// Bloom filters, Count-Min Sketches and HyperLogLogs stored as the value of a cache key.
+//
+// Unlike lists and sets, these structures are changed in place: copying a filter of a few megabytes on every
+// add would cost more than the structure saves. Every type guards itself with its own lock, so reading one
+// returned by Get, or encoding it in a snapshot, is safe while it is being written. Writes still go through
+// cache.Update, which bumps the key's version, records it in the journal and notifies watchers, so replicas
+// receive the whole structure after every write that changed it. Those values are the live structure, so they
+// may already include later writes.
+//
+// Sending the whole structure is what an add costs on a primary with replicas: a 1MB filter updated 1000 times a
+// second streams 1GB a second to each replica. Structures are therefore limited to 16MB, and should be kept much
+// smaller (the defaults are at most 120KB) when they are written often and replicated.
+//
+// An add on a missing key creates it with the sizes in Options and without expiration, a write on an existing
+// key keeps its expiration. Using a key holding another type fails with cache.ErrWrongType, reading a missing
+// key gives an empty result.
+
+// Sizes of structures created by an add on a missing key
+type Options struct {
+	BloomCapacity  uint64  // Items a Bloom filter holds before its false positive rate grows past BloomErrorRate
+	BloomErrorRate float64 // Wanted false positive rate, i.e. 0.01
+	CMSWidth       uint64  // Counters in each row of a Count-Min Sketch
+	CMSDepth       uint64  // Rows of a Count-Min Sketch
+	HLLPrecision   uint8   // A HyperLogLog has 2^HLLPrecision registers
+}
+
+// Options used by NewSketches: about 120KB Bloom filters, 80KB Count-Min Sketches and 16KB HyperLogLogs
+var DefaultOptions = Options{
+	BloomCapacity:  100000,
+	BloomErrorRate: 0.01,
+	CMSWidth:       2000,
+	CMSDepth:       5,
+	HLLPrecision:   DefaultPrecision,
+}
+
+// Returned when creating a structure at a key that already exists
+var ErrExists = errors.New("sketch: key already exists")
+
+// The structures end up in snapshots and replication streams, encoded with MarshalBinary
+func init() {
+	gob.Register(&BloomFilter{})
+	gob.Register(&CountMinSketch{})
+	gob.Register(&HyperLogLog{})
+}
+
+type Sketches struct {
+	cache   *cache.Cache
+	options Options
+}
+
+// Creates Sketches keeping their structures in c, with DefaultOptions
+func NewSketches(c *cache.Cache) *Sketches {
+	return NewSketchesWithOptions(c, DefaultOptions)
+}
+
+// Creates Sketches with custom options. Zero values fall back to DefaultOptions.
+func NewSketchesWithOptions(c *cache.Cache, opts Options) *Sketches {
+	if opts.BloomCapacity == 0 {
+		opts.BloomCapacity = DefaultOptions.BloomCapacity
+	}
+	if opts.BloomErrorRate == 0 {
+		opts.BloomErrorRate = DefaultOptions.BloomErrorRate
+	}
+	if opts.CMSWidth == 0 {
+		opts.CMSWidth = DefaultOptions.CMSWidth
+	}
+	if opts.CMSDepth == 0 {
+		opts.CMSDepth = DefaultOptions.CMSDepth
+	}
+	if opts.HLLPrecision == 0 {
+		opts.HLLPrecision = DefaultOptions.HLLPrecision
+	}
+	return &Sketches{cache: c, options: opts}
+}
+
+// Will run fn on the structure at key under the cache lock, creating it with create when the key is missing.
+// fn reports whether it changed the structure; a new or changed structure is stored as a new version.
+func update[T any](c *cache.Cache, key string, create func() (T, error), fn func(v T) (bool, error)) error {
+	var err error
+	c.Update(key, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+		var v T
+		if found {
+			var ok bool
+			if v, ok = item.Value.(T); !ok {
+				err = cache.ErrWrongType
+				return item, false
+			}
+		} else {
+			if v, err = create(); err != nil {
+				return item, false
+			}
+			item = cache.CacheItem{Value: v}
+		}
+		var changed bool
+		if changed, err = fn(v); err != nil {
+			return item, false
+		}
+		return item, changed || !found
+	})
+	return err
+}
+
+// Will return the structure at key, and false when the key is missing
+func view[T any](c *cache.Cache, key string) (T, bool, error) {
+	var zero T
+	value, found := c.Get(key)
+	if !found {
+		return zero, false, nil
+	}
+	v, ok := value.(T)
+	if !ok {
+		return zero, false, cache.ErrWrongType
+	}
+	return v, true, nil
+}
+
+// Will store a new structure at key, expiring after ttl (cache.NoExpiration for never). Fails with ErrExists
+// when the key exists.
+func (s *Sketches) create(key string, value interface{}, ttl time.Duration) error {
+	if _, ok := s.cache.SetIf(key, value, ttl, nil, cache.IfMissing); !ok {
+		return ErrExists
+	}
+	return nil
+}
+
+// Will merge the structures at sources into the one at dest, creating dest when missing. Missing sources are
+// skipped. The merge is done on a copy stored once every source merged, so a failed merge leaves dest unchanged.
+func merge[T interface{ clone() T }](c *cache.Cache, dest string, sources []string, mergeFn func(dst, src T) error) error {
+	var values []T
+	for _, source := range sources {
+		v, found, err := view[T](c, source)
+		if err != nil {
+			return err
+		}
+		if found {
+			values = append(values, v)
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	var err error
+	c.Update(dest, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+		var merged T
+		if found {
+			current, ok := item.Value.(T)
+			if !ok {
+				err = cache.ErrWrongType
+				return item, false
+			}
+			merged = current.clone()
+		} else {
+			merged, values = values[0].clone(), values[1:]
+			item = cache.CacheItem{}
+		}
+		for _, source := range values {
+			if err = mergeFn(merged, source); err != nil {
+				return item, false
+			}
+		}
+		item.Value = merged
+		return item, true
+	})
+	return err
+}
+
+// Will create a Bloom filter at key sized for capacity items at errorRate false positives, expiring after ttl
+func (s *Sketches) CreateBloom(key string, capacity uint64, errorRate float64, ttl time.Duration) error {
+	f, err := NewBloomFilter(capacity, errorRate)
+	if err != nil {
+		return err
+	}
+	return s.create(key, f, ttl)
+}
+
+// Will add items to the Bloom filter at key, and report for each whether it was not present yet
+func (s *Sketches) BloomAdd(key string, items ...string) ([]bool, error) {
+	added := make([]bool, len(items))
+	create := func() (*BloomFilter, error) {
+		return NewBloomFilter(s.options.BloomCapacity, s.options.BloomErrorRate)
+	}
+	err := update(s.cache, key, create, func(f *BloomFilter) (bool, error) {
+		changed := false
+		for i, item := range items {
+			added[i] = f.Add(item)
+			changed = changed || added[i]
+		}
+		return changed, nil
+	})
+	return added, err
+}
+
+// Will report for each item whether it may have been added to the Bloom filter at key
+func (s *Sketches) BloomTest(key string, items ...string) ([]bool, error) {
+	present := make([]bool, len(items))
+	f, found, err := view[*BloomFilter](s.cache, key)
+	if !found {
+		return present, err
+	}
+	for i, item := range items {
+		present[i] = f.Test(item)
+	}
+	return present, nil
+}
+
+// Will add the items of the Bloom filters at sources to the one at dest. They must have the same sizes.
+func (s *Sketches) BloomMerge(dest string, sources ...string) error {
+	return merge(s.cache, dest, sources, (*BloomFilter).Merge)
+}
+
+// Will create a Count-Min Sketch at key with depth rows of width counters, expiring after ttl
+func (s *Sketches) CreateCountMin(key string, width, depth uint64, ttl time.Duration) error {
+	cms, err := NewCountMinSketch(width, depth)
+	if err != nil {
+		return err
+	}
+	return s.create(key, cms, ttl)
+}
+
+// Will add counts to the items of the Count-Min Sketch at key, and return their new estimates
+func (s *Sketches) CountMinAdd(key string, counts map[string]uint64) (map[string]uint64, error) {
+	estimates := make(map[string]uint64, len(counts))
+	create := func() (*CountMinSketch, error) {
+		return NewCountMinSketch(s.options.CMSWidth, s.options.CMSDepth)
+	}
+	err := update(s.cache, key, create, func(cms *CountMinSketch) (bool, error) {
+		changed := false
+		for item, count := range counts {
+			estimates[item] = cms.Add(item, count)
+			changed = changed || count > 0
+		}
+		return changed, nil
+	})
+	return estimates, err
+}
+
+// Will return the estimated counts of items in the Count-Min Sketch at key
+func (s *Sketches) CountMinQuery(key string, items ...string) ([]uint64, error) {
+	estimates := make([]uint64, len(items))
+	cms, found, err := view[*CountMinSketch](s.cache, key)
+	if !found {
+		return estimates, err
+	}
+	for i, item := range items {
+		estimates[i] = cms.Count(item)
+	}
+	return estimates, nil
+}
+
+// Will add the counts of the Count-Min Sketches at sources to the one at dest. They must have the same sizes.
+func (s *Sketches) CountMinMerge(dest string, sources ...string) error {
+	return merge(s.cache, dest, sources, (*CountMinSketch).Merge)
+}
+
+// Will create a HyperLogLog at key with 2^precision registers, expiring after ttl
+func (s *Sketches) CreateHyperLogLog(key string, precision uint8, ttl time.Duration) error {
+	h, err := NewHyperLogLog(precision)
+	if err != nil {
+		return err
+	}
+	return s.create(key, h, ttl)
+}
+
+// Will add items to the HyperLogLog at key (like PFADD), and report whether its estimate may have changed
+func (s *Sketches) PFAdd(key string, items ...string) (bool, error) {
+	changed := false
+	create := func() (*HyperLogLog, error) {
+		return NewHyperLogLog(s.options.HLLPrecision)
+	}
+	err := update(s.cache, key, create, func(h *HyperLogLog) (bool, error) {
+		for _, item := range items {
+			if h.Add(item) {
+				changed = true
+			}
+		}
+		return changed, nil
+	})
+	return changed, err
+}
+
+// Will return the estimated number of distinct items added to any of the HyperLogLogs at keys (like PFCOUNT).
+// Missing keys count as empty.
+func (s *Sketches) PFCount(keys ...string) (uint64, error) {
+	var hlls []*HyperLogLog
+	for _, key := range keys {
+		h, found, err := view[*HyperLogLog](s.cache, key)
+		if err != nil {
+			return 0, err
+		}
+		if found {
+			hlls = append(hlls, h)
+		}
+	}
+	return CountUnion(hlls...)
+}
+
+// Will add the items of the HyperLogLogs at sources to the one at dest (like PFMERGE). They must have the
+// same precision.
+func (s *Sketches) PFMerge(dest string, sources ...string) error {
+	return merge(s.cache, dest, sources, (*HyperLogLog).Merge)
+}
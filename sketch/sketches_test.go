@@ -0,0 +1,171 @@
+package sketch
+
+import (
+	"bytes"
+	"golang-memory-cache/cache"
+	"testing"
+	"time"
+)
+
+func newTestSketches(t *testing.T) (*Sketches, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	return NewSketchesWithOptions(c, Options{BloomCapacity: 1000, CMSWidth: 100, HLLPrecision: 10}), c
+}
+
+func TestBloomAdd(t *testing.T) {
+	s, c := newTestSketches(t)
+
+	added, err := s.BloomAdd("seen", "a", "b", "a")
+	if err != nil || !added[0] || !added[1] || added[2] {
+		t.Fatalf("Unexpected adds: %v %v", added, err)
+	}
+	item, _ := c.Peek("seen")
+	if _, err := s.BloomAdd("seen", "b"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := c.Peek("seen"); again.Version != item.Version {
+		t.Error("Expected adding present items to leave the version unchanged")
+	}
+	if present, _ := s.BloomTest("seen", "a", "c"); !present[0] || present[1] {
+		t.Errorf("Unexpected tests: %v", present)
+	}
+	if present, err := s.BloomTest("missing", "a"); err != nil || present[0] {
+		t.Errorf("Expected a missing filter to hold nothing, got %v %v", present, err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	s, c := newTestSketches(t)
+
+	if err := s.CreateBloom("f", 50, 0.001, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.CreateBloom("f", 50, 0.001, time.Minute); err != ErrExists {
+		t.Errorf("Expected ErrExists, got %v", err)
+	}
+	if item, _ := c.Peek("f"); item.Expiration == 0 {
+		t.Error("Expected the filter to expire")
+	}
+	s.BloomAdd("f", "a")
+	if item, _ := c.Peek("f"); item.Expiration == 0 {
+		t.Error("Expected an add to keep the expiration")
+	}
+	if err := s.CreateCountMin("c", 0, 5, cache.NoExpiration); err == nil {
+		t.Error("Expected an error for a width of 0")
+	}
+}
+
+func TestWrongType(t *testing.T) {
+	s, c := newTestSketches(t)
+	c.Set("plain", "value", cache.NoExpiration)
+	s.PFAdd("visitors", "a")
+
+	if _, err := s.BloomAdd("plain", "a"); err != cache.ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if _, err := s.CountMinQuery("visitors", "a"); err != cache.ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if err := s.PFMerge("plain", "visitors"); err != cache.ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+}
+
+func TestCountMinAdd(t *testing.T) {
+	s, _ := newTestSketches(t)
+
+	s.CountMinAdd("hits", map[string]uint64{"/": 3, "/about": 1})
+	estimates, err := s.CountMinAdd("hits", map[string]uint64{"/": 2})
+	if err != nil || estimates["/"] != 5 {
+		t.Fatalf("Expected 5 hits, got %v %v", estimates, err)
+	}
+	if counts, _ := s.CountMinQuery("hits", "/about", "/missing"); counts[0] != 1 || counts[1] != 0 {
+		t.Errorf("Unexpected counts: %v", counts)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	s, c := newTestSketches(t)
+
+	s.CountMinAdd("day1", map[string]uint64{"a": 1})
+	s.CountMinAdd("day2", map[string]uint64{"a": 2})
+	if err := s.CountMinMerge("week", "day1", "day2", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if counts, _ := s.CountMinQuery("week", "a"); counts[0] != 3 {
+		t.Errorf("Expected 3 in the merged sketch, got %v", counts)
+	}
+	if counts, _ := s.CountMinQuery("day1", "a"); counts[0] != 1 {
+		t.Errorf("Expected the sources to be unchanged, got %v", counts)
+	}
+
+	s.CreateCountMin("wide", 500, 5, cache.NoExpiration)
+	before, _ := c.Peek("week")
+	if err := s.CountMinMerge("week", "day1", "wide"); err != ErrIncompatible {
+		t.Errorf("Expected ErrIncompatible, got %v", err)
+	}
+	if counts, _ := s.CountMinQuery("week", "a"); counts[0] != 3 {
+		t.Errorf("Expected a failed merge to leave the sketch unchanged, got %v", counts)
+	}
+	if after, _ := c.Peek("week"); after.Version != before.Version {
+		t.Error("Expected a failed merge to leave the version unchanged")
+	}
+
+	s.BloomAdd("b1", "x")
+	s.BloomAdd("b2", "y")
+	s.BloomMerge("b1", "b2")
+	if present, _ := s.BloomTest("b1", "x", "y"); !present[0] || !present[1] {
+		t.Errorf("Expected both items in the merged filter, got %v", present)
+	}
+}
+
+func TestPFCount(t *testing.T) {
+	s, _ := newTestSketches(t)
+
+	if changed, _ := s.PFAdd("mon", "alice", "bob"); !changed {
+		t.Error("Expected new items to change the estimate")
+	}
+	if changed, _ := s.PFAdd("mon", "alice"); changed {
+		t.Error("Expected a known item to leave the estimate unchanged")
+	}
+	s.PFAdd("tue", "bob", "carol")
+	if count, _ := s.PFCount("mon", "tue", "missing"); count != 3 {
+		t.Errorf("Expected 3 distinct visitors, got %d", count)
+	}
+	s.PFMerge("week", "mon", "tue")
+	if count, _ := s.PFCount("week"); count != 3 {
+		t.Errorf("Expected 3 distinct visitors in the merge, got %d", count)
+	}
+	if count, err := s.PFCount("missing"); count != 0 || err != nil {
+		t.Errorf("Expected 0 for a missing key, got %d %v", count, err)
+	}
+}
+
+func TestSketchesSurviveSnapshot(t *testing.T) {
+	s, c := newTestSketches(t)
+	s.BloomAdd("seen", "a")
+	s.CountMinAdd("hits", map[string]uint64{"/": 7})
+	s.PFAdd("visitors", "alice", "bob")
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := cache.NewCache()
+	defer c2.Stop()
+	if _, err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s2 := NewSketches(c2)
+	if present, _ := s2.BloomTest("seen", "a"); !present[0] {
+		t.Error("Expected the restored filter to hold a")
+	}
+	if counts, _ := s2.CountMinQuery("hits", "/"); counts[0] != 7 {
+		t.Errorf("Expected 7 hits after the restore, got %v", counts)
+	}
+	if count, _ := s2.PFCount("visitors"); count != 2 {
+		t.Errorf("Expected 2 visitors after the restore, got %d", count)
+	}
+}
@@ -0,0 +1,357 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"golang-memory-cache/api"
+	"golang-memory-cache/cache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Longest a read may block waiting for entries, so a forgotten wait can't hold a connection forever
+const maxWait = 5 * time.Minute
+
+// Largest JSON body accepted by the add and ack routes
+const maxBodySize = 1 << 20
+
+// Will mount the stream routes on mux:
+// * POST /v2/streams/{key}?ttl=
+// * GET /v2/streams/{key}?start=&end=&count=
+// * GET /v2/streams/{key}?after=&count=&wait=
+// * POST /v2/streams/{key}/trim?maxlen=&maxage=
+// * GET /v2/streams/{key}/groups
+// * PUT /v2/streams/{key}/groups/{group}?start=
+// * DELETE /v2/streams/{key}/groups/{group}
+// * POST /v2/streams/{key}/groups/{group}/read?consumer=&count=&wait=
+// * POST /v2/streams/{key}/groups/{group}/ack
+// * GET /v2/streams/{key}/groups/{group}/pending
+// * POST /v2/streams/{key}/groups/{group}/claim?consumer=&min_idle=&count=
+func (s *Streams) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /v2/streams/{key}", s.AddHandler)
+	mux.HandleFunc("GET /v2/streams/{key}", s.RangeHandler)
+	mux.HandleFunc("POST /v2/streams/{key}/trim", s.TrimHandler)
+	mux.HandleFunc("GET /v2/streams/{key}/groups", s.GroupsHandler)
+	mux.HandleFunc("PUT /v2/streams/{key}/groups/{group}", s.GroupCreateHandler)
+	mux.HandleFunc("DELETE /v2/streams/{key}/groups/{group}", s.GroupDestroyHandler)
+	mux.HandleFunc("POST /v2/streams/{key}/groups/{group}/read", s.ReadGroupHandler)
+	mux.HandleFunc("POST /v2/streams/{key}/groups/{group}/ack", s.AckHandler)
+	mux.HandleFunc("GET /v2/streams/{key}/groups/{group}/pending", s.PendingHandler)
+	mux.HandleFunc("POST /v2/streams/{key}/groups/{group}/claim", s.ClaimHandler)
+}
+
+// * POST /v2/streams/{key}?ttl=3600
+// Appends the fields of a JSON object of strings as an entry. Answers 201 with its ID. ttl sets the key's
+// expiration, otherwise it keeps the expiration it had.
+func (s *Streams) AddHandler(w http.ResponseWriter, r *http.Request) {
+	ttl, err := parseDuration(r.URL.Query().Get("ttl"), 0)
+	if err != nil || ttl < 0 {
+		api.WriteError(w, http.StatusBadRequest, "ttl must be a positive number of seconds or a duration")
+		return
+	}
+	var fields map[string]string
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&fields); err != nil || len(fields) == 0 {
+		api.WriteError(w, http.StatusBadRequest, "The body must be a JSON object of string fields")
+		return
+	}
+	key := r.PathValue("key")
+	id, err := s.XAdd(key, fields)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	if ttl > 0 {
+		s.cache.Expire(key, ttl)
+	}
+	api.WriteJSON(w, http.StatusCreated, map[string]interface{}{"key": key, "id": id})
+}
+
+// * GET /v2/streams/{key}?start=-&end=+&count=100
+// * GET /v2/streams/{key}?after=1700000000000-0&count=100&wait=30s
+// Answers with the entries from start to end, both included. With after, answers with the entries after that
+// ID instead, waiting up to wait (none by default) for new ones when there are none yet.
+func (s *Streams) RangeHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	count, ok := countParam(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	var entries []Entry
+	var err error
+	if after := query.Get("after"); after != "" {
+		var id ID
+		if after == "$" {
+			id, err = s.lastID(key)
+		} else {
+			id, err = ParseBound(after, true)
+		}
+		if err != nil {
+			writeStreamError(w, err)
+			return
+		}
+		ctx, cancel, ok := waitContext(w, r)
+		if !ok {
+			return
+		}
+		defer cancel()
+		entries, err = s.XReadWait(ctx, key, id, count)
+	} else {
+		start, startErr := ParseBound(defaultString(query.Get("start"), "-"), false)
+		end, endErr := ParseBound(defaultString(query.Get("end"), "+"), true)
+		if startErr != nil || endErr != nil {
+			api.WriteError(w, http.StatusBadRequest, "start and end must be entry IDs, - or +")
+			return
+		}
+		entries, err = s.XRange(key, start, end, count)
+	}
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	writeEntries(w, key, entries)
+}
+
+// Will return the last ID of the stream at key, for reads of new entries only
+func (s *Streams) lastID(key string) (ID, error) {
+	st, err := s.get(key)
+	if st == nil {
+		return MinID, err
+	}
+	return st.LastID(), nil
+}
+
+// * POST /v2/streams/{key}/trim?maxlen=1000
+// * POST /v2/streams/{key}/trim?maxage=24h
+// Removes the oldest entries beyond maxlen, or older than maxage. Answers with how many were removed.
+func (s *Streams) TrimHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	key := r.PathValue("key")
+	var removed int
+	var err error
+	switch {
+	case query.Get("maxlen") != "":
+		maxLen, convErr := strconv.Atoi(query.Get("maxlen"))
+		if convErr != nil || maxLen < 0 {
+			api.WriteError(w, http.StatusBadRequest, "maxlen must be a positive integer")
+			return
+		}
+		removed, err = s.XTrim(key, maxLen)
+	case query.Get("maxage") != "":
+		maxAge, parseErr := parseDuration(query.Get("maxage"), 0)
+		if parseErr != nil || maxAge <= 0 {
+			api.WriteError(w, http.StatusBadRequest, "maxage must be a positive number of seconds or a duration")
+			return
+		}
+		removed, err = s.XTrimAge(key, maxAge)
+	default:
+		api.WriteError(w, http.StatusBadRequest, "Missing maxlen or maxage")
+		return
+	}
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "removed": removed})
+}
+
+// * GET /v2/streams/{key}/groups
+// Answers with the consumer groups of the stream
+func (s *Streams) GroupsHandler(w http.ResponseWriter, r *http.Request) {
+	key := r.PathValue("key")
+	groups, err := s.XInfoGroups(key)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	if groups == nil {
+		groups = []GroupInfo{}
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "groups": groups})
+}
+
+// * PUT /v2/streams/{key}/groups/{group}?start=$
+// Creates a consumer group getting the entries after start: $ (the default) for new entries only, 0 for every
+// entry, or an entry ID. Answers 201, or 409 when the group exists.
+func (s *Streams) GroupCreateHandler(w http.ResponseWriter, r *http.Request) {
+	start := MaxID
+	if v := r.URL.Query().Get("start"); v != "" && v != "$" {
+		var err error
+		if start, err = ParseID(v); err != nil {
+			writeStreamError(w, err)
+			return
+		}
+	}
+	key, group := r.PathValue("key"), r.PathValue("group")
+	if err := s.XGroupCreate(key, group, start); err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusCreated, map[string]interface{}{"key": key, "group": group})
+}
+
+// * DELETE /v2/streams/{key}/groups/{group}
+// Removes a consumer group, 404 when it does not exist
+func (s *Streams) GroupDestroyHandler(w http.ResponseWriter, r *http.Request) {
+	destroyed, err := s.XGroupDestroy(r.PathValue("key"), r.PathValue("group"))
+	if err == nil && !destroyed {
+		err = ErrNoGroup
+	}
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// * POST /v2/streams/{key}/groups/{group}/read?consumer=worker-1&count=10&wait=30s
+// Hands consumer the next entries the group has not seen, waiting up to wait (none by default) for new ones.
+// The entries stay pending until acked.
+func (s *Streams) ReadGroupHandler(w http.ResponseWriter, r *http.Request) {
+	consumer := r.URL.Query().Get("consumer")
+	if consumer == "" {
+		writeStreamError(w, ErrNoConsumer)
+		return
+	}
+	count, ok := countParam(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel, ok := waitContext(w, r)
+	if !ok {
+		return
+	}
+	defer cancel()
+	key := r.PathValue("key")
+	entries, err := s.XReadGroupWait(ctx, key, r.PathValue("group"), consumer, count)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	writeEntries(w, key, entries)
+}
+
+// * POST /v2/streams/{key}/groups/{group}/ack
+// Acks the entries of a JSON array of IDs. Answers with how many were pending.
+func (s *Streams) AckHandler(w http.ResponseWriter, r *http.Request) {
+	var ids []ID
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(&ids); err != nil {
+		api.WriteError(w, http.StatusBadRequest, "The body must be a JSON array of entry IDs")
+		return
+	}
+	acked, err := s.XAck(r.PathValue("key"), r.PathValue("group"), ids...)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"acked": acked})
+}
+
+// * GET /v2/streams/{key}/groups/{group}/pending
+// Answers with the entries delivered to the group's consumers and not acked yet
+func (s *Streams) PendingHandler(w http.ResponseWriter, r *http.Request) {
+	pending, err := s.XPending(r.PathValue("key"), r.PathValue("group"))
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"pending": pending})
+}
+
+// * POST /v2/streams/{key}/groups/{group}/claim?consumer=worker-2&min_idle=1m&count=10
+// Hands consumer the pending entries delivered at least min_idle ago (0 by default), i.e. those of a consumer
+// that died
+func (s *Streams) ClaimHandler(w http.ResponseWriter, r *http.Request) {
+	consumer := r.URL.Query().Get("consumer")
+	if consumer == "" {
+		writeStreamError(w, ErrNoConsumer)
+		return
+	}
+	minIdle, err := parseDuration(r.URL.Query().Get("min_idle"), 0)
+	if err != nil || minIdle < 0 {
+		api.WriteError(w, http.StatusBadRequest, "min_idle must be a number of seconds or a duration")
+		return
+	}
+	count, ok := countParam(w, r)
+	if !ok {
+		return
+	}
+	key := r.PathValue("key")
+	entries, err := s.XAutoClaim(key, r.PathValue("group"), consumer, minIdle, count)
+	if err != nil {
+		writeStreamError(w, err)
+		return
+	}
+	writeEntries(w, key, entries)
+}
+
+// Will read the optional count query parameter, 0 (no limit) when missing
+func countParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("count")
+	if v == "" {
+		return 0, true
+	}
+	count, err := strconv.Atoi(v)
+	if err != nil || count <= 0 {
+		api.WriteError(w, http.StatusBadRequest, "count must be a positive integer")
+		return 0, false
+	}
+	return count, true
+}
+
+// Will return a context ending after the optional wait query parameter, right away when missing
+func waitContext(w http.ResponseWriter, r *http.Request) (context.Context, context.CancelFunc, bool) {
+	wait, err := parseDuration(r.URL.Query().Get("wait"), 0)
+	if err != nil || wait < 0 {
+		api.WriteError(w, http.StatusBadRequest, "wait must be a number of seconds or a duration")
+		return nil, nil, false
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), min(wait, maxWait))
+	return ctx, cancel, true
+}
+
+// Will parse a number of seconds ("30", "0.5") or a Go duration ("30s"), returning missing when s is empty
+func parseDuration(s string, missing time.Duration) (time.Duration, error) {
+	if s == "" {
+		return missing, nil
+	}
+	if seconds, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(seconds * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+func defaultString(s, fallback string) string {
+	if s == "" {
+		return fallback
+	}
+	return s
+}
+
+func writeEntries(w http.ResponseWriter, key string, entries []Entry) {
+	if entries == nil {
+		entries = []Entry{}
+	}
+	api.WriteJSON(w, http.StatusOK, map[string]interface{}{"key": key, "entries": entries})
+}
+
+func writeStreamError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, cache.ErrWrongType):
+		api.WriteError(w, http.StatusConflict, "Key holds another type")
+	case errors.Is(err, ErrGroupExists):
+		api.WriteError(w, http.StatusConflict, "Consumer group already exists")
+	case errors.Is(err, ErrNoGroup):
+		api.WriteError(w, http.StatusNotFound, "No such consumer group")
+	case errors.Is(err, ErrInvalidID), errors.Is(err, ErrNoConsumer):
+		api.WriteError(w, http.StatusBadRequest, err.Error())
+	default:
+		api.WriteError(w, http.StatusInternalServerError, err.Error())
+	}
+}
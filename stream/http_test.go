@@ -0,0 +1,90 @@
+package stream
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTP(t *testing.T) {
+	s, c := newTestStreams(t, Options{})
+	mux := http.NewServeMux()
+	s.RegisterRoutes(mux)
+
+	do := func(method, target, body string) (int, map[string]interface{}) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(method, target, strings.NewReader(body)))
+		var got map[string]interface{}
+		json.Unmarshal(w.Body.Bytes(), &got)
+		return w.Code, got
+	}
+	entries := func(got map[string]interface{}) []interface{} {
+		list, _ := got["entries"].([]interface{})
+		return list
+	}
+
+	if code, _ := do("PUT", "/v2/streams/events/groups/workers?start=0", ""); code != http.StatusCreated {
+		t.Fatalf("Expected 201 for a new group, got %d", code)
+	}
+	code, got := do("POST", "/v2/streams/events?ttl=60", `{"type": "signup"}`)
+	if code != http.StatusCreated || got["id"] == "" {
+		t.Fatalf("Unexpected response to an add: %d %v", code, got)
+	}
+	id := got["id"].(string)
+	do("POST", "/v2/streams/events", `{"type": "login"}`)
+	if item, _ := c.Peek("events"); item.Expiration == 0 {
+		t.Error("Expected the ttl to set an expiration")
+	}
+
+	if _, got := do("GET", "/v2/streams/events?start="+id+"&count=1", ""); len(entries(got)) != 1 {
+		t.Errorf("Unexpected range: %v", got)
+	}
+	if _, got := do("GET", "/v2/streams/events?after="+id, ""); len(entries(got)) != 1 {
+		t.Errorf("Expected the entry after %s, got %v", id, got)
+	}
+	if _, got := do("GET", "/v2/streams/events?after=$&wait=0.02", ""); len(entries(got)) != 0 {
+		t.Errorf("Expected no new entries, got %v", got)
+	}
+
+	if _, got := do("POST", "/v2/streams/events/groups/workers/read?consumer=w1&count=1", ""); len(entries(got)) != 1 {
+		t.Errorf("Unexpected group read: %v", got)
+	}
+	if _, got := do("GET", "/v2/streams/events/groups/workers/pending", ""); len(got["pending"].([]interface{})) != 1 {
+		t.Errorf("Expected 1 pending entry, got %v", got)
+	}
+	if _, got := do("POST", "/v2/streams/events/groups/workers/claim?consumer=w2", ""); len(entries(got)) != 1 {
+		t.Errorf("Expected w2 to claim the entry, got %v", got)
+	}
+	if _, got := do("POST", "/v2/streams/events/groups/workers/ack", `["`+id+`"]`); got["acked"] != float64(1) {
+		t.Errorf("Unexpected ack: %v", got)
+	}
+	if _, got := do("GET", "/v2/streams/events/groups", ""); len(got["groups"].([]interface{})) != 1 {
+		t.Errorf("Unexpected groups: %v", got)
+	}
+	if _, got := do("POST", "/v2/streams/events/trim?maxlen=1", ""); got["removed"] != float64(1) {
+		t.Errorf("Unexpected trim: %v", got)
+	}
+	if code, _ := do("DELETE", "/v2/streams/events/groups/workers", ""); code != http.StatusNoContent {
+		t.Errorf("Expected 204 for a destroy, got %d", code)
+	}
+
+	for _, req := range []struct {
+		method, target, body string
+		status               int
+	}{
+		{"PUT", "/v2/streams/events/groups/audit?start=abc", "", http.StatusBadRequest},
+		{"POST", "/v2/streams/events", `["not", "an object"]`, http.StatusBadRequest},
+		{"GET", "/v2/streams/events?start=x", "", http.StatusBadRequest},
+		{"POST", "/v2/streams/events/trim", "", http.StatusBadRequest},
+		{"POST", "/v2/streams/events/groups/workers/read", "", http.StatusBadRequest},
+		{"POST", "/v2/streams/events/groups/workers/read?consumer=w1", "", http.StatusNotFound},
+		{"DELETE", "/v2/streams/events/groups/workers", "", http.StatusNotFound},
+	} {
+		if code, _ := do(req.method, req.target, req.body); code != req.status {
+			t.Errorf("%s %s: expected %d, got %d", req.method, req.target, req.status, code)
+		}
+	}
+}
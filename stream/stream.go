@@ -0,0 +1,381 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidID   = errors.New("stream: invalid entry ID")
+	ErrNoGroup     = errors.New("stream: no such consumer group")
+	ErrGroupExists = errors.New("stream: consumer group already exists")
+	ErrNoConsumer  = errors.New("stream: missing consumer name")
+)
+
+// The ID of an entry: the unix time in milliseconds it was added at, and a sequence number for entries added
+// in the same millisecond. IDs only increase within a stream, even when the clock goes back.
+type ID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinID = ID{}
+	MaxID = ID{math.MaxUint64, math.MaxUint64}
+)
+
+func (id ID) String() string {
+	return strconv.FormatUint(id.Ms, 10) + "-" + strconv.FormatUint(id.Seq, 10)
+}
+
+func (id ID) Less(other ID) bool {
+	return id.Ms < other.Ms || (id.Ms == other.Ms && id.Seq < other.Seq)
+}
+
+// Will return the ID right after id, to read the entries after it
+func (id ID) Next() ID {
+	if id.Seq == math.MaxUint64 {
+		return ID{id.Ms + 1, 0}
+	}
+	return ID{id.Ms, id.Seq + 1}
+}
+
+func (id ID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+func (id *ID) UnmarshalText(b []byte) error {
+	parsed, err := ParseID(string(b))
+	*id = parsed
+	return err
+}
+
+// Will parse "1700000000000-3", or "1700000000000" for the first ID of that millisecond
+func ParseID(s string) (ID, error) {
+	ms, seq, hasSeq := strings.Cut(s, "-")
+	var id ID
+	var err error
+	if id.Ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return ID{}, ErrInvalidID
+	}
+	if hasSeq {
+		if id.Seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return ID{}, ErrInvalidID
+		}
+	}
+	return id, nil
+}
+
+// Will parse a bound of a range like XRANGE: "-" and "+" are the smallest and largest IDs, and a bound without
+// a sequence number covers the whole millisecond (its first ID as a start, its last as an end)
+func ParseBound(s string, end bool) (ID, error) {
+	switch s {
+	case "-":
+		return MinID, nil
+	case "+":
+		return MaxID, nil
+	}
+	id, err := ParseID(s)
+	if err == nil && end && !strings.Contains(s, "-") {
+		id.Seq = math.MaxUint64
+	}
+	return id, err
+}
+
+// An entry of a stream. Its fields must not be changed once added.
+type Entry struct {
+	ID     ID                `json:"id"`
+	Fields map[string]string `json:"fields"`
+}
+
+// An entry delivered to a consumer of a group and not acked yet
+type PendingEntry struct {
+	ID         ID        `json:"id"`
+	Consumer   string    `json:"consumer"`
+	Delivered  time.Time `json:"delivered_at"` // Time of the latest delivery
+	Deliveries int       `json:"deliveries"`
+}
+
+// Describes a consumer group
+type GroupInfo struct {
+	Name          string `json:"name"`
+	LastDelivered ID     `json:"last_delivered"` // Entries after this one are new to the group
+	Pending       int    `json:"pending"`
+}
+
+type group struct {
+	LastDelivered ID
+	Pending       map[ID]*PendingEntry
+}
+
+// An append-only log of entries, read by range or through consumer groups. A consumer group hands every entry
+// to one of its consumers, and tracks it as pending until that consumer acks it. Entries that stayed pending
+// too long, i.e. because their consumer died, can be claimed by another consumer.
+//
+// A Stream guards itself with its own lock, so it is safe for concurrent use.
+type Stream struct {
+	mu      sync.RWMutex
+	entries []Entry // Sorted by ID
+	lastID  ID      // Kept when the entries are trimmed, so IDs never go back
+	groups  map[string]*group
+}
+
+func NewStream() *Stream {
+	return &Stream{groups: make(map[string]*group)}
+}
+
+// Will append an entry with fields, its ID taken from now, and return its ID
+func (s *Stream) Add(fields map[string]string, now time.Time) ID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := ID{Ms: uint64(now.UnixMilli())}
+	if !s.lastID.Less(id) {
+		id = s.lastID.Next()
+	}
+	s.entries = append(s.entries, Entry{ID: id, Fields: fields})
+	s.lastID = id
+	return id
+}
+
+// Will return the number of entries
+func (s *Stream) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Will return the ID of the latest entry ever added, MinID when none was
+func (s *Stream) LastID() ID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastID
+}
+
+// Will return the index of the first entry at or after id. Must be called while holding s.mu.
+func (s *Stream) search(id ID) int {
+	return sort.Search(len(s.entries), func(i int) bool {
+		return !s.entries[i].ID.Less(id)
+	})
+}
+
+// Will return the entries from start to end, both included, at most count of them (all when count <= 0)
+func (s *Stream) Range(start, end ID, count int) []Entry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var entries []Entry
+	for i := s.search(start); i < len(s.entries) && !end.Less(s.entries[i].ID); i++ {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entries = append(entries, s.entries[i])
+	}
+	return entries
+}
+
+// Will remove the oldest entries until at most maxLen are left, and return how many were removed
+func (s *Stream) TrimLen(maxLen int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if maxLen < 0 || len(s.entries) <= maxLen {
+		return 0
+	}
+	removed := len(s.entries) - maxLen
+	s.entries = slices.Clone(s.entries[removed:])
+	return removed
+}
+
+// Will remove the entries before minID, and return how many were removed
+func (s *Stream) TrimBefore(minID ID) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	removed := s.search(minID)
+	if removed > 0 {
+		s.entries = slices.Clone(s.entries[removed:])
+	}
+	return removed
+}
+
+// Will create a consumer group that gets the entries after start (use LastID for only new entries)
+func (s *Stream) CreateGroup(name string, start ID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.groups[name]; ok {
+		return ErrGroupExists
+	}
+	s.groups[name] = &group{LastDelivered: start, Pending: make(map[ID]*PendingEntry)}
+	return nil
+}
+
+// Will remove a consumer group and its pending entries, and report whether it existed
+func (s *Stream) DestroyGroup(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.groups[name]
+	delete(s.groups, name)
+	return ok
+}
+
+// Will return the consumer groups, sorted by name
+func (s *Stream) Groups() []GroupInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	groups := make([]GroupInfo, 0, len(s.groups))
+	for name, g := range s.groups {
+		groups = append(groups, GroupInfo{Name: name, LastDelivered: g.LastDelivered, Pending: len(g.Pending)})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Name < groups[j].Name })
+	return groups
+}
+
+// Will hand the next count entries the group has not seen yet to consumer (all when count <= 0), and track
+// them as pending until they are acked
+func (s *Stream) ReadGroup(name, consumer string, count int, now time.Time) ([]Entry, error) {
+	if consumer == "" {
+		return nil, ErrNoConsumer
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	var entries []Entry
+	for i := s.search(g.LastDelivered.Next()); i < len(s.entries); i++ {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		entry := s.entries[i]
+		entries = append(entries, entry)
+		g.LastDelivered = entry.ID
+		g.Pending[entry.ID] = &PendingEntry{ID: entry.ID, Consumer: consumer, Delivered: now, Deliveries: 1}
+	}
+	return entries, nil
+}
+
+// Will stop tracking ids as pending in the group, and return how many were pending
+func (s *Stream) Ack(name string, ids ...ID) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[name]
+	if !ok {
+		return 0, ErrNoGroup
+	}
+	acked := 0
+	for _, id := range ids {
+		if _, pending := g.Pending[id]; pending {
+			delete(g.Pending, id)
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// Will return the pending entries of the group, sorted by ID
+func (s *Stream) Pending(name string) ([]PendingEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	pending := make([]PendingEntry, 0, len(g.Pending))
+	for _, p := range g.Pending {
+		pending = append(pending, *p)
+	}
+	sort.Slice(pending, func(i, j int) bool { return pending[i].ID.Less(pending[j].ID) })
+	return pending, nil
+}
+
+// Will hand to consumer the pending entries of the group that were delivered at least minIdle ago, oldest ID
+// first and at most count of them (all when count <= 0). Pending entries that were trimmed from the stream
+// are dropped from the group instead.
+func (s *Stream) Claim(name, consumer string, minIdle time.Duration, count int, now time.Time) ([]Entry, error) {
+	if consumer == "" {
+		return nil, ErrNoConsumer
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	g, ok := s.groups[name]
+	if !ok {
+		return nil, ErrNoGroup
+	}
+	stale := make([]*PendingEntry, 0)
+	for _, p := range g.Pending {
+		if now.Sub(p.Delivered) >= minIdle {
+			stale = append(stale, p)
+		}
+	}
+	sort.Slice(stale, func(i, j int) bool { return stale[i].ID.Less(stale[j].ID) })
+
+	var entries []Entry
+	for _, p := range stale {
+		if count > 0 && len(entries) == count {
+			break
+		}
+		i := s.search(p.ID)
+		if i == len(s.entries) || s.entries[i].ID != p.ID {
+			delete(g.Pending, p.ID)
+			continue
+		}
+		p.Consumer, p.Delivered = consumer, now
+		p.Deliveries++
+		entries = append(entries, s.entries[i])
+	}
+	return entries, nil
+}
+
+// The state of a Stream, as encoded by gob
+type streamState struct {
+	Entries []Entry
+	LastID  ID
+	Groups  map[string]*group
+}
+
+// Used by encoding/gob, so streams can be stored in snapshots
+func (s *Stream) MarshalBinary() ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(streamState{Entries: s.entries, LastID: s.lastID, Groups: s.groups})
+	return buf.Bytes(), err
+}
+
+func (s *Stream) UnmarshalBinary(b []byte) error {
+	var state streamState
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&state); err != nil {
+		return fmt.Errorf("stream: %w", err)
+	}
+	if state.Groups == nil {
+		state.Groups = make(map[string]*group)
+	}
+	for _, g := range state.Groups {
+		if g.Pending == nil {
+			g.Pending = make(map[ID]*PendingEntry) // gob leaves empty maps out
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries, s.lastID, s.groups = state.Entries, state.LastID, state.Groups
+	return nil
+}
+
+// Describes the stream, its entries are read with Range
+func (s *Stream) MarshalJSON() ([]byte, error) {
+	length, lastID, groups := s.Len(), s.LastID(), s.Groups()
+	return json.Marshal(map[string]interface{}{
+		"type":    "stream",
+		"length":  length,
+		"last_id": lastID,
+		"groups":  groups,
+	})
+}
@@ -0,0 +1,150 @@
+package stream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBound(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		end  bool
+		want ID
+	}{
+		{"-", false, MinID},
+		{"+", true, MaxID},
+		{"5-3", false, ID{5, 3}},
+		{"5", false, ID{5, 0}},
+		{"5", true, ID{5, MaxID.Seq}},
+	} {
+		if got, err := ParseBound(tt.s, tt.end); err != nil || got != tt.want {
+			t.Errorf("ParseBound(%q, %v) = %v %v, expected %v", tt.s, tt.end, got, err, tt.want)
+		}
+	}
+	for _, s := range []string{"", "a", "5-", "5-x"} {
+		if _, err := ParseID(s); err != ErrInvalidID {
+			t.Errorf("Expected ErrInvalidID for %q, got %v", s, err)
+		}
+	}
+}
+
+func TestAddRange(t *testing.T) {
+	st := NewStream()
+	now := time.UnixMilli(1000)
+	a := st.Add(map[string]string{"n": "a"}, now)
+	b := st.Add(map[string]string{"n": "b"}, now)
+	c := st.Add(map[string]string{"n": "c"}, now.Add(-time.Second)) // The clock went back
+	if a != (ID{1000, 0}) || b != (ID{1000, 1}) || c != (ID{1000, 2}) {
+		t.Fatalf("Expected increasing IDs, got %v %v %v", a, b, c)
+	}
+
+	if entries := st.Range(b, MaxID, 0); len(entries) != 2 || entries[0].Fields["n"] != "b" {
+		t.Errorf("Unexpected range: %v", entries)
+	}
+	if entries := st.Range(MinID, MaxID, 1); len(entries) != 1 || entries[0].ID != a {
+		t.Errorf("Expected the count to limit the range, got %v", entries)
+	}
+	if entries := st.Range(ID{1001, 0}, MaxID, 0); len(entries) != 0 {
+		t.Errorf("Expected nothing after the last entry, got %v", entries)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	st := NewStream()
+	for i := 0; i < 5; i++ {
+		st.Add(map[string]string{"i": "x"}, time.UnixMilli(int64(i*1000)))
+	}
+	if removed := st.TrimLen(3); removed != 2 || st.Len() != 3 {
+		t.Errorf("Expected 2 entries removed, got %d, %d left", removed, st.Len())
+	}
+	if removed := st.TrimBefore(ID{Ms: 4000}); removed != 2 || st.Len() != 1 {
+		t.Errorf("Expected 2 entries removed, got %d, %d left", removed, st.Len())
+	}
+	if id := st.Add(nil, time.UnixMilli(0)); id != (ID{4000, 1}) {
+		t.Errorf("Expected IDs to keep increasing after a trim, got %v", id)
+	}
+}
+
+func TestConsumerGroup(t *testing.T) {
+	st := NewStream()
+	now := time.UnixMilli(1000)
+	old := st.Add(map[string]string{"n": "old"}, now)
+	if err := st.CreateGroup("workers", st.LastID()); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.CreateGroup("workers", MinID); err != ErrGroupExists {
+		t.Errorf("Expected ErrGroupExists, got %v", err)
+	}
+	st.CreateGroup("audit", MinID)
+
+	a := st.Add(map[string]string{"n": "a"}, now)
+	b := st.Add(map[string]string{"n": "b"}, now)
+	entries, err := st.ReadGroup("workers", "w1", 1, now)
+	if err != nil || len(entries) != 1 || entries[0].ID != a {
+		t.Fatalf("Expected only the new entry a, got %v %v", entries, err)
+	}
+	if entries, _ := st.ReadGroup("workers", "w2", 0, now); len(entries) != 1 || entries[0].ID != b {
+		t.Errorf("Expected w2 to get b, got %v", entries)
+	}
+	if entries, _ := st.ReadGroup("audit", "w1", 0, now); len(entries) != 3 || entries[0].ID != old {
+		t.Errorf("Expected every group to get every entry, got %v", entries)
+	}
+	if _, err := st.ReadGroup("missing", "w1", 0, now); err != ErrNoGroup {
+		t.Errorf("Expected ErrNoGroup, got %v", err)
+	}
+
+	if acked, _ := st.Ack("workers", b, ID{9, 9}); acked != 1 {
+		t.Errorf("Expected 1 entry acked, got %d", acked)
+	}
+	pending, _ := st.Pending("workers")
+	if len(pending) != 1 || pending[0].ID != a || pending[0].Consumer != "w1" {
+		t.Fatalf("Expected a to be pending for w1, got %v", pending)
+	}
+
+	// w1 died: w2 claims its entry once it was idle long enough
+	if claimed, _ := st.Claim("workers", "w2", time.Minute, 0, now.Add(time.Second)); len(claimed) != 0 {
+		t.Errorf("Expected nothing idle for a minute yet, got %v", claimed)
+	}
+	claimed, _ := st.Claim("workers", "w2", time.Minute, 0, now.Add(2*time.Minute))
+	if len(claimed) != 1 || claimed[0].ID != a {
+		t.Fatalf("Expected w2 to claim a, got %v", claimed)
+	}
+	if pending, _ := st.Pending("workers"); pending[0].Consumer != "w2" || pending[0].Deliveries != 2 {
+		t.Errorf("Expected a to be pending for w2, got %v", pending)
+	}
+
+	st.TrimLen(0)
+	if claimed, _ := st.Claim("workers", "w3", 0, 0, now.Add(3*time.Minute)); len(claimed) != 0 {
+		t.Errorf("Expected trimmed entries not to be claimed, got %v", claimed)
+	}
+	if pending, _ := st.Pending("workers"); len(pending) != 0 {
+		t.Errorf("Expected trimmed entries to be dropped from the pending entries, got %v", pending)
+	}
+}
+
+func TestStreamBinary(t *testing.T) {
+	st := NewStream()
+	st.Add(map[string]string{"n": "a"}, time.UnixMilli(1000))
+	st.CreateGroup("empty", MinID)
+	st.CreateGroup("workers", MinID)
+	st.ReadGroup("workers", "w1", 0, time.UnixMilli(1000))
+	b, err := st.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded := NewStream()
+	if err := decoded.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Len() != 1 || decoded.LastID() != st.LastID() || len(decoded.Groups()) != 2 {
+		t.Errorf("Unexpected decoded stream: %d %v %v", decoded.Len(), decoded.LastID(), decoded.Groups())
+	}
+	if pending, _ := decoded.Pending("workers"); len(pending) != 1 {
+		t.Errorf("Expected the pending entry to be decoded, got %v", pending)
+	}
+	decoded.Add(nil, time.UnixMilli(1000))
+	if _, err := decoded.ReadGroup("empty", "w1", 0, time.UnixMilli(1000)); err != nil {
+		t.Errorf("Expected a group without pending entries to work after decoding, got %v", err)
+	}
+}
@@ -0,0 +1,294 @@
+package stream
+
+import (
+	"context"
+	"encoding/gob"
+	"golang-memory-cache/cache"
+	"strings"
+	"time"
+)
+
+// Streams stored as the value of a cache key, like Redis streams.
+//
+// As with the sketches, a Stream is changed in place rather than copied on every add. Writes, including
+// consumer group reads that change the pending entries, go through cache.Update: they bump the key's version,
+// are recorded in the journal and notify watchers, so replicas receive the whole stream after every change.
+//
+// An add on a missing key creates the stream without expiration, and later writes keep the key's expiration,
+// so a stream expires with its key like any other value (use Expire to change it). Using a key holding another
+// type fails with cache.ErrWrongType, reading a missing key gives no entries.
+
+// Settings used when creating Streams
+type Options struct {
+	MaxLen int           // Entries kept by every stream, the oldest are trimmed on add. 0 keeps them all.
+	MaxAge time.Duration // Age after which entries are trimmed on add. 0 keeps them all.
+}
+
+// Options used by NewStreams: streams are only trimmed on demand
+var DefaultOptions = Options{}
+
+// Streams end up in snapshots and replication streams, encoded with MarshalBinary
+func init() {
+	gob.Register(&Stream{})
+}
+
+type Streams struct {
+	cache   *cache.Cache
+	options Options
+	now     func() time.Time
+}
+
+// Creates Streams keeping their entries in c, with DefaultOptions
+func NewStreams(c *cache.Cache) *Streams {
+	return NewStreamsWithOptions(c, DefaultOptions)
+}
+
+// Creates Streams with custom options. Zero values fall back to DefaultOptions.
+func NewStreamsWithOptions(c *cache.Cache, opts Options) *Streams {
+	if opts.MaxLen <= 0 {
+		opts.MaxLen = DefaultOptions.MaxLen
+	}
+	if opts.MaxAge <= 0 {
+		opts.MaxAge = DefaultOptions.MaxAge
+	}
+	return &Streams{cache: c, options: opts, now: time.Now}
+}
+
+// Will run fn on the stream at key under the cache lock. A missing stream is created when create is true,
+// otherwise fn gets nil. fn reports whether it changed the stream, which is then stored as a new version.
+func (s *Streams) update(key string, create bool, fn func(st *Stream) (bool, error)) error {
+	var err error
+	s.cache.Update(key, func(item cache.CacheItem, found bool) (cache.CacheItem, bool) {
+		var st *Stream
+		if found {
+			var ok bool
+			if st, ok = item.Value.(*Stream); !ok {
+				err = cache.ErrWrongType
+				return item, false
+			}
+		} else if create {
+			st = NewStream()
+			item = cache.CacheItem{Value: st}
+		}
+		var changed bool
+		if changed, err = fn(st); err != nil || st == nil {
+			return item, false
+		}
+		return item, changed || !found
+	})
+	return err
+}
+
+// Will return the stream at key, nil when the key is missing
+func (s *Streams) get(key string) (*Stream, error) {
+	value, found := s.cache.Get(key)
+	if !found {
+		return nil, nil
+	}
+	st, ok := value.(*Stream)
+	if !ok {
+		return nil, cache.ErrWrongType
+	}
+	return st, nil
+}
+
+// Will append an entry with fields to the stream at key (like XADD with an auto ID), and return its ID.
+// The stream is then trimmed to MaxLen and MaxAge.
+func (s *Streams) XAdd(key string, fields map[string]string) (ID, error) {
+	var id ID
+	err := s.update(key, true, func(st *Stream) (bool, error) {
+		now := s.now()
+		id = st.Add(fields, now)
+		if s.options.MaxLen > 0 {
+			st.TrimLen(s.options.MaxLen)
+		}
+		if s.options.MaxAge > 0 {
+			st.TrimBefore(ID{Ms: uint64(now.Add(-s.options.MaxAge).UnixMilli())})
+		}
+		return true, nil
+	})
+	return id, err
+}
+
+// Will return the number of entries of the stream at key
+func (s *Streams) XLen(key string) (int, error) {
+	st, err := s.get(key)
+	if st == nil {
+		return 0, err
+	}
+	return st.Len(), nil
+}
+
+// Will return the entries of the stream at key from start to end, both included, at most count of them
+// (all when count <= 0)
+func (s *Streams) XRange(key string, start, end ID, count int) ([]Entry, error) {
+	st, err := s.get(key)
+	if st == nil {
+		return nil, err
+	}
+	return st.Range(start, end, count), nil
+}
+
+// Will remove the oldest entries of the stream at key until at most maxLen are left (like XTRIM MAXLEN), and
+// return how many were removed
+func (s *Streams) XTrim(key string, maxLen int) (int, error) {
+	removed := 0
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		if st != nil {
+			removed = st.TrimLen(maxLen)
+		}
+		return removed > 0, nil
+	})
+	return removed, err
+}
+
+// Will remove the entries of the stream at key added more than maxAge ago (like XTRIM MINID), and return how
+// many were removed
+func (s *Streams) XTrimAge(key string, maxAge time.Duration) (int, error) {
+	removed := 0
+	minID := ID{Ms: uint64(s.now().Add(-maxAge).UnixMilli())}
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		if st != nil {
+			removed = st.TrimBefore(minID)
+		}
+		return removed > 0, nil
+	})
+	return removed, err
+}
+
+// Will create a consumer group on the stream at key, creating the stream when missing. The group gets the
+// entries after start; pass the stream's last ID (or MaxID) for new entries only.
+func (s *Streams) XGroupCreate(key, group string, start ID) error {
+	return s.update(key, true, func(st *Stream) (bool, error) {
+		if start == MaxID {
+			start = st.LastID()
+		}
+		return true, st.CreateGroup(group, start)
+	})
+}
+
+// Will remove a consumer group from the stream at key, and report whether it existed
+func (s *Streams) XGroupDestroy(key, group string) (bool, error) {
+	destroyed := false
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		destroyed = st != nil && st.DestroyGroup(group)
+		return destroyed, nil
+	})
+	return destroyed, err
+}
+
+// Will return the consumer groups of the stream at key
+func (s *Streams) XInfoGroups(key string) ([]GroupInfo, error) {
+	st, err := s.get(key)
+	if st == nil {
+		return nil, err
+	}
+	return st.Groups(), nil
+}
+
+// Will hand consumer the next count entries its group has not seen (like XREADGROUP with ">"), tracked as
+// pending until acked. Fails with ErrNoGroup when the stream or the group is missing.
+func (s *Streams) XReadGroup(key, group, consumer string, count int) ([]Entry, error) {
+	var entries []Entry
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		if st == nil {
+			return false, ErrNoGroup
+		}
+		var err error
+		entries, err = st.ReadGroup(group, consumer, count, s.now())
+		return len(entries) > 0, err
+	})
+	return entries, err
+}
+
+// Will acknowledge entries of a group (like XACK), and return how many were pending
+func (s *Streams) XAck(key, group string, ids ...ID) (int, error) {
+	acked := 0
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		if st == nil {
+			return false, ErrNoGroup
+		}
+		var err error
+		acked, err = st.Ack(group, ids...)
+		return acked > 0, err
+	})
+	return acked, err
+}
+
+// Will return the entries of a group delivered and not acked yet (like XPENDING)
+func (s *Streams) XPending(key, group string) ([]PendingEntry, error) {
+	st, err := s.get(key)
+	if st == nil {
+		if err == nil {
+			err = ErrNoGroup
+		}
+		return nil, err
+	}
+	return st.Pending(group)
+}
+
+// Will hand consumer the pending entries of a group delivered at least minIdle ago, at most count of them
+// (like XAUTOCLAIM). Use it to take over the entries of a consumer that died.
+func (s *Streams) XAutoClaim(key, group, consumer string, minIdle time.Duration, count int) ([]Entry, error) {
+	var entries []Entry
+	err := s.update(key, false, func(st *Stream) (bool, error) {
+		if st == nil {
+			return false, ErrNoGroup
+		}
+		var err error
+		entries, err = st.Claim(group, consumer, minIdle, count, s.now())
+		return len(entries) > 0, err
+	})
+	return entries, err
+}
+
+// Will call read until it returns entries, an error, or ctx is done, calling it again whenever the key changes.
+// Returns no entries and no error when ctx is done.
+func (s *Streams) wait(ctx context.Context, key string, read func() ([]Entry, error)) ([]Entry, error) {
+	// Subscribed before the first read, so an add right after it still wakes us up
+	events, stop := s.cache.WatchWithOptions(escapePattern(key), cache.WatchOptions{BufferSize: 1})
+	defer stop()
+	for {
+		entries, err := read()
+		if len(entries) > 0 || err != nil {
+			return entries, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, nil
+		case _, ok := <-events:
+			if !ok {
+				return nil, nil
+			}
+		}
+	}
+}
+
+// Same as XRange from the entry after after, but waits for entries to be added when there are none yet
+func (s *Streams) XReadWait(ctx context.Context, key string, after ID, count int) ([]Entry, error) {
+	return s.wait(ctx, key, func() ([]Entry, error) {
+		if after == MaxID {
+			return nil, nil
+		}
+		return s.XRange(key, after.Next(), MaxID, count)
+	})
+}
+
+// Same as XReadGroup, but waits for entries to be added when the group has seen them all
+func (s *Streams) XReadGroupWait(ctx context.Context, key, group, consumer string, count int) ([]Entry, error) {
+	return s.wait(ctx, key, func() ([]Entry, error) {
+		return s.XReadGroup(key, group, consumer, count)
+	})
+}
+
+// Will escape the characters MatchPattern treats specially, to watch a single key
+func escapePattern(key string) string {
+	var b strings.Builder
+	for _, r := range key {
+		if strings.ContainsRune(`*?[\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"golang-memory-cache/cache"
+	"testing"
+	"time"
+)
+
+func newTestStreams(t *testing.T, opts Options) (*Streams, *cache.Cache) {
+	t.Helper()
+	c := cache.NewCache()
+	t.Cleanup(c.Stop)
+	return NewStreamsWithOptions(c, opts), c
+}
+
+func TestXAdd(t *testing.T) {
+	s, c := newTestStreams(t, Options{MaxLen: 2})
+
+	first, err := s.XAdd("events", map[string]string{"type": "signup"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item, _ := c.Peek("events")
+	s.XAdd("events", map[string]string{"type": "login"})
+	s.XAdd("events", map[string]string{"type": "logout"})
+	if n, _ := s.XLen("events"); n != 2 {
+		t.Errorf("Expected MaxLen to keep 2 entries, got %d", n)
+	}
+	if entries, _ := s.XRange("events", first, MaxID, 0); entries[0].Fields["type"] != "login" {
+		t.Errorf("Expected the oldest entry to be trimmed, got %v", entries)
+	}
+	if again, _ := c.Peek("events"); again.Version <= item.Version {
+		t.Error("Expected every add to bump the version")
+	}
+
+	c.Set("plain", "value", cache.NoExpiration)
+	if _, err := s.XAdd("plain", map[string]string{"a": "b"}); err != cache.ErrWrongType {
+		t.Errorf("Expected ErrWrongType, got %v", err)
+	}
+	if entries, err := s.XRange("missing", MinID, MaxID, 0); entries != nil || err != nil {
+		t.Errorf("Expected no entries for a missing key, got %v %v", entries, err)
+	}
+}
+
+func TestStreamTTL(t *testing.T) {
+	s, c := newTestStreams(t, Options{})
+	s.XAdd("events", map[string]string{"n": "1"})
+	c.Expire("events", 50*time.Millisecond)
+	s.XAdd("events", map[string]string{"n": "2"})
+	if item, _ := c.Peek("events"); item.Expiration == 0 {
+		t.Error("Expected an add to keep the key's expiration")
+	}
+	time.Sleep(70 * time.Millisecond)
+	if n, _ := s.XLen("events"); n != 0 {
+		t.Errorf("Expected the stream to expire with its key, got %d entries", n)
+	}
+}
+
+func TestXTrimAge(t *testing.T) {
+	s, _ := newTestStreams(t, Options{})
+	now := time.Now()
+	s.now = func() time.Time { return now.Add(-time.Hour) }
+	s.XAdd("events", map[string]string{"n": "old"})
+	s.now = func() time.Time { return now }
+	s.XAdd("events", map[string]string{"n": "new"})
+
+	if removed, err := s.XTrimAge("events", time.Minute); removed != 1 || err != nil {
+		t.Errorf("Expected the old entry to be removed, got %d %v", removed, err)
+	}
+	if removed, _ := s.XTrim("events", 0); removed != 1 {
+		t.Errorf("Expected the last entry to be removed, got %d", removed)
+	}
+}
+
+func TestXReadGroup(t *testing.T) {
+	s, _ := newTestStreams(t, Options{})
+	if _, err := s.XReadGroup("events", "workers", "w1", 0); err != ErrNoGroup {
+		t.Errorf("Expected ErrNoGroup for a missing stream, got %v", err)
+	}
+	if err := s.XGroupCreate("events", "workers", MaxID); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := s.XAdd("events", map[string]string{"n": "1"})
+
+	entries, err := s.XReadGroup("events", "workers", "w1", 10)
+	if err != nil || len(entries) != 1 || entries[0].ID != id {
+		t.Fatalf("Unexpected entries: %v %v", entries, err)
+	}
+	if claimed, _ := s.XAutoClaim("events", "workers", "w2", 0, 10); len(claimed) != 1 {
+		t.Errorf("Expected w2 to claim the entry, got %v", claimed)
+	}
+	if acked, _ := s.XAck("events", "workers", id); acked != 1 {
+		t.Errorf("Expected 1 entry acked, got %d", acked)
+	}
+	if pending, _ := s.XPending("events", "workers"); len(pending) != 0 {
+		t.Errorf("Expected nothing pending, got %v", pending)
+	}
+	if destroyed, _ := s.XGroupDestroy("events", "workers"); !destroyed {
+		t.Error("Expected the group to be destroyed")
+	}
+}
+
+func TestXReadWait(t *testing.T) {
+	s, _ := newTestStreams(t, Options{})
+	s.XGroupCreate("events", "workers", MaxID)
+
+	done := make(chan []Entry, 2)
+	for _, read := range []func(ctx context.Context) ([]Entry, error){
+		func(ctx context.Context) ([]Entry, error) { return s.XReadWait(ctx, "events", MinID, 0) },
+		func(ctx context.Context) ([]Entry, error) { return s.XReadGroupWait(ctx, "events", "workers", "w1", 0) },
+	} {
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			entries, err := read(ctx)
+			if err != nil {
+				t.Error(err)
+			}
+			done <- entries
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	s.XAdd("events", map[string]string{"n": "1"})
+	for i := 0; i < 2; i++ {
+		if entries := <-done; len(entries) != 1 {
+			t.Errorf("Expected the add to wake up the read, got %v", entries)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	last, _ := s.lastID("events")
+	if entries, err := s.XReadWait(ctx, "events", last, 0); entries != nil || err != nil {
+		t.Errorf("Expected no entries once the wait is over, got %v %v", entries, err)
+	}
+}
+
+func TestStreamsSurviveSnapshot(t *testing.T) {
+	s, c := newTestStreams(t, Options{})
+	s.XGroupCreate("events", "workers", MinID)
+	s.XAdd("events", map[string]string{"n": "1"})
+	s.XReadGroup("events", "workers", "w1", 0)
+
+	var buf bytes.Buffer
+	if err := c.SaveSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	c2 := cache.NewCache()
+	defer c2.Stop()
+	if _, err := c2.LoadSnapshot(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s2 := NewStreams(c2)
+	if n, _ := s2.XLen("events"); n != 1 {
+		t.Errorf("Expected 1 entry after the restore, got %d", n)
+	}
+	if pending, _ := s2.XPending("events", "workers"); len(pending) != 1 {
+		t.Errorf("Expected the pending entry after the restore, got %v", pending)
+	}
+}